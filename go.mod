@@ -4,6 +4,9 @@ go 1.22.1
 
 require (
 	github.com/BurntSushi/toml v1.4.0
+	github.com/boombuler/barcode v1.0.2
+	golang.org/x/image v0.24.0
+	golang.org/x/text v0.22.0
 	tinygo.org/x/bluetooth v0.14.0
 )
 
@@ -49,6 +49,11 @@ type Client struct {
 	mu        sync.Mutex
 	dev       bluetooth.Device
 	connected bool
+	address   string
+	// notify receives what the printer sends on notifyUUID, once Query
+	// has subscribed to it on the current connection.
+	notify     chan []byte
+	notifyUUID string
 }
 
 func Enable() error { return Adapter.Enable() }
@@ -135,6 +140,8 @@ func (c *Client) Connect(address string) error {
 	}
 	c.dev = dev
 	c.connected = true
+	c.address = cleanAddress
+	c.notify = nil
 	return nil
 }
 
@@ -174,6 +181,14 @@ func (c *Client) IsConnected() bool {
 	return c.connected
 }
 
+// Address returns the address of the printer last connected to, or "" if
+// none has been.
+func (c *Client) Address() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.address
+}
+
 func (c *Client) Disconnect() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -185,6 +200,7 @@ func (c *Client) Disconnect() error {
 		return err
 	}
 	c.connected = false
+	c.notify = nil
 	return nil
 }
 
@@ -222,6 +238,13 @@ func (c *Client) Describe() (*DescribeResult, error) {
 }
 
 func (c *Client) Print(serviceUUID, charUUID string, data []byte, chunkSize int, withResponse bool) error {
+	return c.PrintProgress(serviceUUID, charUUID, data, chunkSize, withResponse, nil)
+}
+
+// PrintProgress is Print, calling progress (when non-nil) with the total
+// bytes written after each chunk. An error from progress stops the
+// transfer and is returned.
+func (c *Client) PrintProgress(serviceUUID, charUUID string, data []byte, chunkSize int, withResponse bool, progress func(sent int) error) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -232,31 +255,10 @@ func (c *Client) Print(serviceUUID, charUUID string, data []byte, chunkSize int,
 		chunkSize = 180
 	}
 
-	su, err := bluetooth.ParseUUID(serviceUUID)
-	if err != nil {
-		return err
-	}
-	cu, err := bluetooth.ParseUUID(charUUID)
-	if err != nil {
-		return err
-	}
-
-	services, err := c.dev.DiscoverServices([]bluetooth.UUID{su})
-	if err != nil {
-		return err
-	}
-	if len(services) == 0 {
-		return errors.New("service not found")
-	}
-
-	chars, err := services[0].DiscoverCharacteristics([]bluetooth.UUID{cu})
+	ch, err := c.characteristic(serviceUUID, charUUID)
 	if err != nil {
 		return err
 	}
-	if len(chars) == 0 {
-		return errors.New("characteristic not found")
-	}
-	ch := chars[0]
 
 	for i := 0; i < len(data); i += chunkSize {
 		end := i + chunkSize
@@ -272,7 +274,85 @@ func (c *Client) Print(serviceUUID, charUUID string, data []byte, chunkSize int,
 		if err != nil {
 			return err
 		}
+		if progress != nil {
+			if err := progress(end); err != nil {
+				return err
+			}
+		}
 		time.Sleep(10 * time.Millisecond)
 	}
 	return nil
 }
+
+// Query writes req to the printer and returns the first notification that
+// follows on notifyUUID, or an error after timeout. The characteristic is
+// subscribed to on first use on each connection.
+func (c *Client) Query(serviceUUID, writeUUID, notifyUUID string, req []byte, timeout time.Duration) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.connected {
+		return nil, errors.New("not connected")
+	}
+	if c.notify == nil || c.notifyUUID != notifyUUID {
+		ch, err := c.characteristic(serviceUUID, notifyUUID)
+		if err != nil {
+			return nil, err
+		}
+		notify := make(chan []byte, 8)
+		err = ch.EnableNotifications(func(buf []byte) {
+			select {
+			case notify <- append([]byte(nil), buf...):
+			default:
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		c.notify, c.notifyUUID = notify, notifyUUID
+	}
+	// Drop answers that came too late for an earlier query.
+	for len(c.notify) > 0 {
+		<-c.notify
+	}
+	w, err := c.characteristic(serviceUUID, writeUUID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.WriteWithoutResponse(req); err != nil {
+		return nil, err
+	}
+	select {
+	case buf := <-c.notify:
+		return buf, nil
+	case <-time.After(timeout):
+		return nil, fmt.Errorf("no answer from the printer within %s", timeout)
+	}
+}
+
+// characteristic finds a characteristic of a service. c.mu must be held.
+func (c *Client) characteristic(serviceUUID, charUUID string) (bluetooth.DeviceCharacteristic, error) {
+	su, err := bluetooth.ParseUUID(serviceUUID)
+	if err != nil {
+		return bluetooth.DeviceCharacteristic{}, err
+	}
+	cu, err := bluetooth.ParseUUID(charUUID)
+	if err != nil {
+		return bluetooth.DeviceCharacteristic{}, err
+	}
+	services, err := c.dev.DiscoverServices([]bluetooth.UUID{su})
+	if err != nil {
+		return bluetooth.DeviceCharacteristic{}, err
+	}
+	if len(services) == 0 {
+		return bluetooth.DeviceCharacteristic{}, errors.New("service not found")
+	}
+	chars, err := services[0].DiscoverCharacteristics([]bluetooth.UUID{cu})
+	if err != nil {
+		return bluetooth.DeviceCharacteristic{}, err
+	}
+	if len(chars) == 0 {
+		return bluetooth.DeviceCharacteristic{}, errors.New("characteristic not found")
+	}
+	return chars[0], nil
+}
@@ -1,9 +1,12 @@
 package config
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/BurntSushi/toml"
+
+	"ble-printer-bridge/internal/printing"
 )
 
 type Config struct {
@@ -14,6 +17,10 @@ type Config struct {
 
 	Auth struct {
 		ApiKey string `toml:"api_key"`
+		// RawPolicy applies to /print/raw for api_key and for keys without
+		// their own policy.
+		RawPolicy RawPolicy `toml:"raw_policy"`
+		Keys      []APIKey  `toml:"keys"`
 	} `toml:"auth"`
 
 	BLE struct {
@@ -21,10 +28,108 @@ type Config struct {
 		PrinterAddress          string `toml:"printer_address"`
 		ServiceUUID             string `toml:"service_uuid"`
 		WriteCharacteristicUUID string `toml:"write_characteristic_uuid"`
-		ChunkSize               int    `toml:"chunk_size"`
-		WriteWithResponse       bool   `toml:"write_with_response"`
+		// NotifyCharacteristicUUID, when set, is where the printer answers
+		// status queries.
+		NotifyCharacteristicUUID string `toml:"notify_characteristic_uuid"`
+		ChunkSize                int    `toml:"chunk_size"`
+		WriteWithResponse        bool   `toml:"write_with_response"`
 	} `toml:"ble"`
 
+	Printer struct {
+		PaperWidthMM    int    `toml:"paper_width_mm"`
+		Font            string `toml:"font"`
+		CommandLanguage string `toml:"command_language"`
+		CatEnergy       int    `toml:"cat_energy"`
+		CatSpeed        int    `toml:"cat_speed"`
+		CodePage        string `toml:"code_page"`
+		// Fonts are TTF/OTF/TTC files used, in order, to rasterize text
+		// the code page cannot represent.
+		Fonts          []string `toml:"fonts"`
+		RasterFontSize int      `toml:"raster_font_size"`
+		// Receipt finishing: feed before the cut, cut type, copies, the
+		// ESC @ reset and the cash drawer kick.
+		FeedLines   int    `toml:"feed_lines"`
+		Cut         string `toml:"cut"`
+		Copies      int    `toml:"copies"`
+		Reset       *bool  `toml:"reset"`
+		DrawerKick  bool   `toml:"drawer_kick"`
+		DrawerPin   int    `toml:"drawer_pin"`
+		DrawerOnMS  int    `toml:"drawer_on_ms"`
+		DrawerOffMS int    `toml:"drawer_off_ms"`
+		// LogoCommand is how logos are stored in NV memory: "graphics"
+		// (GS ( L) or "bit-image" (FS q, older models).
+		LogoCommand string `toml:"logo_command"`
+		// PageMode prints document page blocks with ESC/POS page mode;
+		// when false they are printed as raster images.
+		PageMode *bool `toml:"page_mode"`
+		// Head settings sent after connecting and with every receipt:
+		// density -6..6 (unset keeps the printer's), speed 1..9 and the
+		// ESC 7 heating parameters of ESC/POS clones.
+		Density      *int `toml:"density"`
+		Speed        int  `toml:"speed"`
+		HeatDots     int  `toml:"heat_dots"`
+		HeatTime     int  `toml:"heat_time"`
+		HeatInterval int  `toml:"heat_interval"`
+	} `toml:"printer"`
+
+	Templates struct {
+		Dir string `toml:"dir"`
+	} `toml:"templates"`
+
+	Logos struct {
+		Dir string `toml:"dir"`
+	} `toml:"logos"`
+
+	// Jobs tunes the print queue: where it is journaled, how often a job
+	// is tried when the printer cannot be reached, how many finished jobs
+	// /jobs/{id} remembers and for how long, how long an Idempotency-Key
+	// answers with its job, and how fast a waiting job gains priority.
+	// With HoldOffline, the jobs of a printer that is offline are held
+	// until it reconnects, for at most HoldMaxAgeSeconds and HoldMaxJobs
+	// per printer, and the bridge tries to reconnect to it every
+	// ReconnectSeconds.
+	Jobs struct {
+		Dir                    string `toml:"dir"`
+		MaxAttempts            int    `toml:"max_attempts"`
+		RetryDelayMS           int    `toml:"retry_delay_ms"`
+		KeepFinished           int    `toml:"keep_finished"`
+		RetentionHours         int    `toml:"retention_hours"`
+		IdempotencyWindowHours int    `toml:"idempotency_window_hours"`
+		PriorityAgingSeconds   int    `toml:"priority_aging_seconds"`
+		HoldOffline            bool   `toml:"hold_offline"`
+		HoldMaxAgeSeconds      int    `toml:"hold_max_age_seconds"`
+		HoldMaxJobs            int    `toml:"hold_max_jobs"`
+		ReconnectSeconds       int    `toml:"reconnect_seconds"`
+	} `toml:"jobs"`
+
+	// Printers names printers besides the one /ble/connect drives, so
+	// print requests and groups can address them. They share the
+	// [printer] profile.
+	Printers []NamedPrinter `toml:"printers"`
+	// Groups spread or fail over jobs across printers.
+	Groups []PrinterGroup `toml:"groups"`
+	// Routes send print requests that name no printer to the printer or
+	// group of the first route they match.
+	Routes []Route `toml:"routes"`
+
+	// Webhooks posts job and printer events to the subscriptions and to
+	// the callback_url of print requests, retrying from an outbox
+	// journaled in dir.
+	Webhooks struct {
+		Dir                  string `toml:"dir"`
+		Secret               string `toml:"secret"`
+		MaxAttempts          int    `toml:"max_attempts"`
+		RetryDelayMS         int    `toml:"retry_delay_ms"`
+		MaxRetryDelaySeconds int    `toml:"max_retry_delay_seconds"`
+		TimeoutMS            int    `toml:"timeout_ms"`
+		KeepFinished         int    `toml:"keep_finished"`
+		Concurrency          int    `toml:"concurrency"`
+		// AllowHosts may be posted to although they are on a loopback,
+		// private or link-local network; other such hosts are refused.
+		AllowHosts    []string              `toml:"allow_hosts"`
+		Subscriptions []WebhookSubscription `toml:"subscriptions"`
+	} `toml:"webhooks"`
+
 	Logging struct {
 		FilePath       string `toml:"file_path"`
 		ConsoleVerbose bool   `toml:"console_verbose"`
@@ -36,6 +141,80 @@ type Config struct {
 	} `toml:"cors"`
 }
 
+// APIKey is an additional API key with its own raw payload policy.
+type APIKey struct {
+	Name string `toml:"name"`
+	Key  string `toml:"key"`
+	// Admin keys may bypass the raw policy with ?bypass_policy=true.
+	Admin     bool       `toml:"admin"`
+	RawPolicy *RawPolicy `toml:"raw_policy"`
+}
+
+// NamedPrinter is a printer the bridge connects to on its own when it has
+// a job for it.
+type NamedPrinter struct {
+	Name    string `toml:"name"`
+	Address string `toml:"address"`
+}
+
+// PrinterGroup sends each job to one of Printers, the names of
+// NamedPrinters or "current", chosen by Policy: "failover" (the first
+// healthy one, in order; the default), "round-robin" or "least-queued".
+// A job that fails on one member moves to the next it has not tried.
+type PrinterGroup struct {
+	Name     string   `toml:"name"`
+	Printers []string `toml:"printers"`
+	Policy   string   `toml:"policy"`
+}
+
+// Route sends the print requests it matches to Printer: the name of a
+// NamedPrinter, a PrinterGroup or "current". A condition left empty
+// matches any request: Tags any of the job's tags, Keys the name of the
+// API key, Origins the request's Origin ("*" is a wildcard), and Hours a
+// local time of day such as "11:00-15:00" or "22:00-06:00".
+type Route struct {
+	Name    string   `toml:"name"`
+	Tags    []string `toml:"tags"`
+	Keys    []string `toml:"keys"`
+	Origins []string `toml:"origins"`
+	Hours   string   `toml:"hours"`
+	Printer string   `toml:"printer"`
+}
+
+// WebhookSubscription posts the listed events, or all of them when Events
+// is empty, to URL.
+type WebhookSubscription struct {
+	URL    string   `toml:"url"`
+	Events []string `toml:"events"`
+}
+
+// RawPolicy limits what /print/raw payloads may contain. See
+// printing.RawPolicy for the meaning of each field.
+type RawPolicy struct {
+	Mode            string   `toml:"mode"`
+	Families        []string `toml:"families"`
+	MaxBytes        int      `toml:"max_bytes"`
+	MaxRasterHeight int      `toml:"max_raster_height"`
+	Action          string   `toml:"action"`
+}
+
+// applyRawPolicyDefaults fills in an unset policy: deny commands that
+// change printer memory or settings, and reject violations.
+func applyRawPolicyDefaults(p *RawPolicy) {
+	if p.Mode == "" {
+		p.Mode = "denylist"
+		if p.Families == nil {
+			p.Families = []string{"nv", "settings", "realtime", "vendor", "unknown"}
+		}
+	}
+	if p.MaxBytes == 0 {
+		p.MaxBytes = 262144
+	}
+	if p.Action == "" {
+		p.Action = "reject"
+	}
+}
+
 func Load(path string) (*Config, error) {
 	var cfg Config
 	_, err := toml.DecodeFile(path, &cfg)
@@ -44,9 +223,23 @@ func Load(path string) (*Config, error) {
 	}
 	ApplyDefaults(&cfg)
 	applyEnvOverrides(&cfg)
+	if err := Validate(&cfg); err != nil {
+		return nil, err
+	}
 	return &cfg, nil
 }
 
+// Validate rejects settings that would be sent to the printer out of
+// range, such as a head speed the printer would misread.
+func Validate(cfg *Config) error {
+	p := cfg.Printer
+	head := printing.HeadSettings{Density: p.Density, Speed: p.Speed, HeatDots: p.HeatDots, HeatTime: p.HeatTime, HeatInterval: p.HeatInterval}
+	if err := head.Validate(); err != nil {
+		return fmt.Errorf("printer: %w", err)
+	}
+	return nil
+}
+
 func ApplyDefaults(cfg *Config) {
 	if cfg.Server.Host == "" {
 		cfg.Server.Host = "127.0.0.1"
@@ -54,12 +247,116 @@ func ApplyDefaults(cfg *Config) {
 	if cfg.Server.Port == 0 {
 		cfg.Server.Port = 17800
 	}
+	applyRawPolicyDefaults(&cfg.Auth.RawPolicy)
+	for _, k := range cfg.Auth.Keys {
+		if k.RawPolicy != nil {
+			applyRawPolicyDefaults(k.RawPolicy)
+		}
+	}
 	if cfg.BLE.ChunkSize == 0 {
 		cfg.BLE.ChunkSize = 180
 	}
 	if cfg.BLE.PrinterAddress == "" {
 		cfg.BLE.PrinterAddress = "66:22:B6:5C:5C:3C"
 	}
+	if cfg.Printer.PaperWidthMM == 0 {
+		cfg.Printer.PaperWidthMM = 58
+	}
+	if cfg.Printer.Font == "" {
+		cfg.Printer.Font = "A"
+	}
+	if cfg.Printer.CommandLanguage == "" {
+		cfg.Printer.CommandLanguage = "escpos"
+	}
+	if cfg.Printer.CodePage == "" {
+		cfg.Printer.CodePage = "cp437"
+	}
+	if cfg.Printer.RasterFontSize == 0 {
+		cfg.Printer.RasterFontSize = 24
+	}
+	if cfg.Printer.Cut == "" {
+		cfg.Printer.Cut = "full"
+	}
+	if cfg.Printer.Copies == 0 {
+		cfg.Printer.Copies = 1
+	}
+	if cfg.Printer.Reset == nil {
+		reset := true
+		cfg.Printer.Reset = &reset
+	}
+	if cfg.Printer.PageMode == nil {
+		pageMode := true
+		cfg.Printer.PageMode = &pageMode
+	}
+	if cfg.Printer.DrawerPin == 0 {
+		cfg.Printer.DrawerPin = 2
+	}
+	if cfg.Printer.DrawerOnMS == 0 {
+		cfg.Printer.DrawerOnMS = 100
+	}
+	if cfg.Printer.DrawerOffMS == 0 {
+		cfg.Printer.DrawerOffMS = 200
+	}
+	if cfg.Printer.LogoCommand == "" {
+		cfg.Printer.LogoCommand = "graphics"
+	}
+	if cfg.Templates.Dir == "" {
+		cfg.Templates.Dir = "templates"
+	}
+	if cfg.Logos.Dir == "" {
+		cfg.Logos.Dir = "logos"
+	}
+	if cfg.Jobs.Dir == "" {
+		cfg.Jobs.Dir = "jobs"
+	}
+	if cfg.Jobs.MaxAttempts == 0 {
+		cfg.Jobs.MaxAttempts = 3
+	}
+	if cfg.Jobs.RetryDelayMS == 0 {
+		cfg.Jobs.RetryDelayMS = 2000
+	}
+	if cfg.Jobs.KeepFinished == 0 {
+		cfg.Jobs.KeepFinished = 500
+	}
+	if cfg.Jobs.RetentionHours == 0 {
+		cfg.Jobs.RetentionHours = 24
+	}
+	if cfg.Jobs.IdempotencyWindowHours == 0 {
+		cfg.Jobs.IdempotencyWindowHours = 24
+	}
+	if cfg.Jobs.PriorityAgingSeconds == 0 {
+		cfg.Jobs.PriorityAgingSeconds = 30
+	}
+	if cfg.Jobs.HoldMaxAgeSeconds == 0 {
+		cfg.Jobs.HoldMaxAgeSeconds = 3600
+	}
+	if cfg.Jobs.HoldMaxJobs == 0 {
+		cfg.Jobs.HoldMaxJobs = 100
+	}
+	if cfg.Jobs.ReconnectSeconds == 0 {
+		cfg.Jobs.ReconnectSeconds = 15
+	}
+	if cfg.Webhooks.Dir == "" {
+		cfg.Webhooks.Dir = "webhooks"
+	}
+	if cfg.Webhooks.MaxAttempts == 0 {
+		cfg.Webhooks.MaxAttempts = 10
+	}
+	if cfg.Webhooks.RetryDelayMS == 0 {
+		cfg.Webhooks.RetryDelayMS = 5000
+	}
+	if cfg.Webhooks.MaxRetryDelaySeconds == 0 {
+		cfg.Webhooks.MaxRetryDelaySeconds = 600
+	}
+	if cfg.Webhooks.TimeoutMS == 0 {
+		cfg.Webhooks.TimeoutMS = 10000
+	}
+	if cfg.Webhooks.KeepFinished == 0 {
+		cfg.Webhooks.KeepFinished = 200
+	}
+	if cfg.Webhooks.Concurrency == 0 {
+		cfg.Webhooks.Concurrency = 4
+	}
 	if cfg.Logging.FilePath == "" {
 		cfg.Logging.FilePath = "logs/app.log"
 	}
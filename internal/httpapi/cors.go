@@ -12,7 +12,7 @@ import (
 
 const (
 	corsAllowMethods = "GET,POST,PUT,PATCH,DELETE,OPTIONS"
-	corsAllowHeaders = "content-type,authorization,x-api-key"
+	corsAllowHeaders = "content-type,authorization,x-api-key,idempotency-key"
 	corsMaxAge       = "600"
 )
 
@@ -0,0 +1,39 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"ble-printer-bridge/internal/jobs"
+	"ble-printer-bridge/internal/printing"
+)
+
+func (s *Server) printDocument(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := s.configSnapshot()
+	var doc printing.Document
+	if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+		http.Error(w, `invalid body: {"blocks":[...]}`, http.StatusBadRequest)
+		return
+	}
+	driver, ok := s.receiptDriver(w, r, cfg, "print/document")
+	if !ok {
+		return
+	}
+
+	refs := s.newLogoJob(r, cfg, driver, nil)
+	data, err := printing.EncodeDocument(driver, &doc, cfg.Printer.PaperWidthMM, printing.ParseFont(cfg.Printer.Font), refs.logo)
+	if err != nil {
+		s.log.Warn("print/document rejected: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	job, ok := s.uploadLogos(w, "print/document", refs, jobs.Job{Printer: s.printerID(cfg), Tag: "print/document", Data: data})
+	if !ok {
+		return
+	}
+	s.deliverJob(w, r, cfg, job)
+}
@@ -0,0 +1,58 @@
+package httpapi
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"ble-printer-bridge/internal/config"
+	"ble-printer-bridge/internal/logging"
+	"ble-printer-bridge/internal/printing"
+)
+
+// fontCache keeps the raster font set for the configured font files so
+// they are parsed once rather than on every request.
+type fontCache struct {
+	mu  sync.Mutex
+	key string
+	set *printing.FontSet
+}
+
+// get returns the font set for cfg. Fonts that fail to load are logged
+// and skipped in favour of the bundled font.
+func (c *fontCache) get(cfg config.Config, log *logging.Logger) (*printing.FontSet, error) {
+	key := fmt.Sprintf("%d|%s", cfg.Printer.RasterFontSize, strings.Join(cfg.Printer.Fonts, "|"))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.set != nil && c.key == key {
+		return c.set, nil
+	}
+	size := float64(cfg.Printer.RasterFontSize)
+	set, err := printing.LoadFontSet(size, cfg.Printer.Fonts...)
+	if err != nil {
+		log.Warn("raster fonts: %v; using the bundled font only", err)
+		if set, err = printing.LoadFontSet(size); err != nil {
+			return nil, err
+		}
+	}
+	c.key, c.set = key, set
+	return set, nil
+}
+
+// textOptions returns how /print/text encodes text for cfg: in the
+// configured code page, rasterizing lines it cannot represent.
+func (s *Server) textOptions(cfg config.Config) (printing.TextOptions, error) {
+	cp, ok := printing.LookupCodePage(cfg.Printer.CodePage)
+	if !ok {
+		return printing.TextOptions{}, fmt.Errorf("unknown code page %q (want one of %s)", cfg.Printer.CodePage, strings.Join(printing.CodePageNames(), ", "))
+	}
+	fonts, err := s.fonts.get(cfg, s.log)
+	if err != nil {
+		return printing.TextOptions{}, err
+	}
+	return printing.TextOptions{
+		CodePage:  &cp,
+		Fonts:     fonts,
+		WidthDots: printing.PrintableDots(cfg.Printer.PaperWidthMM),
+	}, nil
+}
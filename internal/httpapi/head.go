@@ -0,0 +1,144 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"ble-printer-bridge/internal/config"
+	"ble-printer-bridge/internal/jobs"
+	"ble-printer-bridge/internal/printing"
+)
+
+// initHead sends the profile's head settings to a freshly connected
+// printer, so jobs that do not reset it (raw payloads) print with them too.
+// Failures are logged: the connection itself is fine.
+func (s *Server) initHead(cfg config.Config) {
+	lang, err := printing.ParseLanguage(cfg.Printer.CommandLanguage)
+	if err != nil {
+		return
+	}
+	data := printing.EncodeHead(lang, headSettings(cfg))
+	if len(data) == 0 {
+		return
+	}
+	if err := s.sendToPrinter(cfg, data); err != nil {
+		s.log.Warn("head settings not sent: %v", err)
+		return
+	}
+	s.log.Info("head settings sent: bytes=%d", len(data))
+}
+
+// labelHead prefixes a TSPL or CPCL job with the profile's head settings.
+func labelHead(cfg config.Config, lang printing.Language, data []byte) []byte {
+	return append(printing.EncodeHead(lang, headSettings(cfg)), data...)
+}
+
+// calibrate prints a density test strip: one band per density level, so an
+// operator can pick printer.density for the paper in use.
+func (s *Server) calibrate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := s.configSnapshot()
+	printer, ok := s.pathPrinter(w, r, cfg)
+	if !ok {
+		return
+	}
+	req := struct {
+		From  int `json:"from"`
+		To    int `json:"to"`
+		Speed int `json:"speed"`
+	}{From: -3, To: 3}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, `invalid body: {"from":-3,"to":3,"speed":0}`, http.StatusBadRequest)
+		return
+	}
+	if req.From < -6 || req.To > 6 || req.From > req.To {
+		http.Error(w, "from and to must satisfy -6 <= from <= to <= 6", http.StatusBadRequest)
+		return
+	}
+	lang, err := printing.ParseLanguage(cfg.Printer.CommandLanguage)
+	if err != nil {
+		s.log.Error("calibrate: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	profile := headSettings(cfg)
+	if req.Speed != 0 {
+		profile.Speed = req.Speed
+	}
+	if err := profile.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	width := printing.PrintableDots(cfg.Printer.PaperWidthMM)
+	var levels []int
+	var strips []*printing.Bitmap
+	for level := req.From; level <= req.To; level++ {
+		label := fmt.Sprintf("density %+d", level)
+		if lang == printing.LanguageCat {
+			label += fmt.Sprintf(" (energy %d)", printing.CatEnergy(level))
+		}
+		levels = append(levels, level)
+		strips = append(strips, printing.DensityStrip(label, width))
+	}
+	// Leave the printer at the profile's density, or its standard one.
+	restore := profile
+	if restore.Density == nil {
+		restore.Density = new(int)
+	}
+	var b bytes.Buffer
+	switch {
+	case lang == printing.LanguageCat:
+		opts := catOptions(cfg)
+		for i, level := range levels {
+			opts.Energy = printing.CatEnergy(level)
+			b.Write(printing.EncodeCatRaster(strips[i], opts))
+		}
+	case lang.IsReceipt():
+		driver, err := printing.NewDriver(lang)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var body bytes.Buffer
+		for i, level := range levels {
+			body.Write(printing.EncodeHead(lang, profile.Merge(printing.HeadSettings{Density: &level})))
+			body.Write(driver.Raster(strips[i]))
+			body.Write(driver.Feed(1))
+		}
+		body.Write(printing.EncodeHead(lang, restore))
+		finish, err := finishRequest{}.finish(cfg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		finish.Copies = 1
+		finish.Head = profile
+		b.Write(finish.Apply(driver, body.Bytes()))
+	default:
+		for i, level := range levels {
+			data, err := printing.EncodeLabelBitmap(lang, printing.Label{}, strips[i])
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			b.Write(printing.EncodeHead(lang, profile.Merge(printing.HeadSettings{Density: &level})))
+			b.Write(data)
+		}
+		b.Write(printing.EncodeHead(lang, restore))
+	}
+
+	extra := map[string]any{"levels": levels}
+	if isPreview(r) {
+		s.writeRendered(w, r, b.Bytes(), stackBitmaps(strips), nil, extra)
+		return
+	}
+	s.submit(w, r, jobs.Job{Printer: printer, Tag: "calibrate", Data: b.Bytes(), Result: extra})
+}
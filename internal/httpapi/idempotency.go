@@ -0,0 +1,82 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+
+	"ble-printer-bridge/internal/jobs"
+)
+
+// maxIdempotencyKey bounds the length of an Idempotency-Key header.
+const maxIdempotencyKey = 255
+
+type idempotencyCtx struct{}
+
+// idempotent makes a print endpoint answer a repeated request carrying the
+// same Idempotency-Key with the job the first one submitted, instead of
+// printing again. A key sent with a different request is rejected with
+// 409, as is a repeat that arrives while the first is still being
+// handled. An order's key is answered with all its tickets. Keys are scoped to the API key and remembered for
+// jobs.idempotency_window_hours, across restarts.
+func (s *Server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || isPreview(r) {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			http.Error(w, "Idempotency-Key is longer than 255 characters", http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		scoped := requestKey(r).Name + "/" + key
+		job, found, err := s.jobs.Claim(scoped, requestHash(r, body))
+		switch {
+		case errors.Is(err, jobs.ErrKeyReused), errors.Is(err, jobs.ErrKeyInFlight):
+			s.log.Warn("%s %s rejected: key=%q %v", r.Method, r.URL.Path, key, err)
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		case found:
+			s.log.Info("%s %s replayed: key=%q job=%s", r.Method, r.URL.Path, key, job.ID)
+			if batch := s.jobs.BatchJobs(scoped); batch != nil {
+				s.writeTickets(w, r, batch, map[string]any{"replayed": true})
+				return
+			}
+			s.writeJob(w, r, job, map[string]any{"replayed": true})
+			return
+		}
+		defer s.jobs.Release(scoped)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next(w, r.WithContext(context.WithValue(r.Context(), idempotencyCtx{}, scoped)))
+	}
+}
+
+// idempotencyKey returns the scoped key idempotent claimed for r, if any.
+func idempotencyKey(r *http.Request) string {
+	key, _ := r.Context().Value(idempotencyCtx{}).(string)
+	return key
+}
+
+// requestHash identifies a print request by its endpoint, query and body.
+// ?wait is left out: a retry may wait where the first request did not.
+func requestHash(r *http.Request, body []byte) string {
+	query := r.URL.Query()
+	query.Del("wait")
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"?"+query.Encode()+"\n")
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,385 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ble-printer-bridge/internal/config"
+	"ble-printer-bridge/internal/jobs"
+	"ble-printer-bridge/internal/printing"
+)
+
+// newJobQueue opens the print queue journaled in jobs.dir. If the journal
+// cannot be opened the bridge still prints, with a queue that is lost on
+// restart.
+func (s *Server) newJobQueue(cfg *config.Config) *jobs.Queue {
+	opts := jobs.Options{
+		MaxAttempts:  cfg.Jobs.MaxAttempts,
+		RetryDelay:   time.Duration(cfg.Jobs.RetryDelayMS) * time.Millisecond,
+		KeepFinished: cfg.Jobs.KeepFinished,
+		Retention:    time.Duration(cfg.Jobs.RetentionHours) * time.Hour,
+		KeyWindow:    time.Duration(cfg.Jobs.IdempotencyWindowHours) * time.Hour,
+		Aging:        time.Duration(cfg.Jobs.PriorityAgingSeconds) * time.Second,
+		Logf:         s.log.Error,
+		OnFinish:     s.jobFinished,
+		Reroute:      s.reroute,
+		Hold:         cfg.Jobs.HoldOffline,
+		HoldAge:      time.Duration(cfg.Jobs.HoldMaxAgeSeconds) * time.Second,
+		HoldMax:      cfg.Jobs.HoldMaxJobs,
+	}
+	q, err := jobs.OpenQueue(cfg.Jobs.Dir, s.sendJob, opts)
+	if err != nil {
+		s.log.Error("%v; queued jobs will not survive a restart", err)
+		return jobs.NewQueue(s.sendJob, opts)
+	}
+	s.log.Info("job queue opened: dir=%s", cfg.Jobs.Dir)
+	return q
+}
+
+// sendJob is the queue's sender: it writes a job to its printer with the
+// BLE settings current when the job starts.
+func (s *Server) sendJob(job jobs.Job, progress func(sent int) error) error {
+	cfg := s.configSnapshot()
+	s.log.Info("%s: job=%s printer=%s bytes=%d attempt=%d chunk=%d with_response=%v", job.Tag, job.ID, job.Printer, len(job.Data), job.Attempts, cfg.BLE.ChunkSize, cfg.BLE.WriteWithResponse)
+	client, err := s.clientFor(cfg, job.Printer)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("%w: %w", jobs.ErrOffline, err)
+	case !client.IsConnected():
+		err = fmt.Errorf("%w: not connected", jobs.ErrOffline)
+	default:
+		err = client.PrintProgress(
+			cfg.BLE.ServiceUUID,
+			cfg.BLE.WriteCharacteristicUUID,
+			job.Data,
+			cfg.BLE.ChunkSize,
+			cfg.BLE.WriteWithResponse,
+			progress,
+		)
+	}
+	if errors.Is(err, jobs.ErrCancelled) {
+		s.log.Info("%s cancelled: job=%s", job.Tag, job.ID)
+		return err
+	}
+	s.noteSend(job.Printer, client, err)
+	if err != nil {
+		s.log.Error("%s error: job=%s printer=%s %v", job.Tag, job.ID, job.Printer, err)
+		return err
+	}
+	s.log.Info("%s ok: job=%s printer=%s", job.Tag, job.ID, job.Printer)
+	s.checkPaper(cfg, job.Printer, client)
+	return nil
+}
+
+// writeJob answers a print request with its job: queued or held for an
+// offline printer (202), or, with ?wait=true or when the job has already
+// finished, printed (200) or not (500); a job held while the caller waits
+// is answered 202. A job known only by its ID is answered 200. extra fields are
+// merged into the JSON response after the job's result.
+func (s *Server) writeJob(w http.ResponseWriter, r *http.Request, job jobs.Job, extra map[string]any) {
+	status := http.StatusOK
+	switch {
+	case job.State == "":
+	case wantsWait(r) || job.State.Finished():
+		var err error
+		job, err = s.jobs.Wait(r.Context(), job.ID)
+		if err != nil {
+			s.log.Warn("%s: job=%s caller gone while %s: %v", job.Tag, job.ID, job.State, err)
+			return
+		}
+		if job.State == jobs.Held {
+			status = http.StatusAccepted
+			break
+		}
+		if job.State != jobs.Done {
+			msg := job.Error
+			if msg == "" {
+				msg = "job " + string(job.State)
+			}
+			http.Error(w, msg, 500)
+			return
+		}
+	default:
+		status = http.StatusAccepted
+	}
+	resp := map[string]any{"ok": true, "job_id": job.ID}
+	if job.State != "" {
+		resp["state"] = job.State
+		resp["printer"] = job.Printer
+	}
+	if job.Group != "" {
+		resp["group"] = job.Group
+	}
+	if job.Route != "" {
+		resp["route"] = job.Route
+	}
+	for k, v := range job.Result {
+		resp[k] = v
+	}
+	for k, v := range extra {
+		resp[k] = v
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	writeJSON(w, resp)
+}
+
+// wantsWait reports whether the caller asked to be answered once the job
+// has printed (?wait=true) rather than when it is queued.
+func wantsWait(r *http.Request) bool {
+	wait, _ := strconv.ParseBool(r.URL.Query().Get("wait"))
+	return wait
+}
+
+// maxReference bounds the length of a job's ?reference.
+const maxReference = 255
+
+// priorities names the levels ?priority accepts besides a number.
+var priorities = map[string]int{"low": -10, "normal": 0, "high": 10, "urgent": 20}
+
+// jobSchedule reads a print request's ?priority, a number or one of
+// priorities, and ?not_before, an RFC 3339 time before which the job is
+// held.
+func jobSchedule(r *http.Request) (priority int, notBefore *time.Time, err error) {
+	q := r.URL.Query()
+	if v := q.Get("priority"); v != "" {
+		p, ok := priorities[strings.ToLower(v)]
+		if !ok {
+			if p, err = strconv.Atoi(v); err != nil {
+				return 0, nil, fmt.Errorf("invalid priority %q", v)
+			}
+		}
+		priority = p
+	}
+	if v := q.Get("not_before"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return 0, nil, fmt.Errorf("invalid not_before %q: want an RFC 3339 time", v)
+		}
+		notBefore = &t
+	}
+	return priority, notBefore, nil
+}
+
+// listJobs lists the jobs the queue holds and remembers, filtered by
+// ?state, ?printer, ?reference and ?since (RFC 3339), in submission order;
+// ?limit keeps the most recent.
+func (s *Server) listJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	f := jobs.Filter{
+		State:     jobs.State(query.Get("state")),
+		Printer:   query.Get("printer"),
+		Reference: query.Get("reference"),
+	}
+	switch f.State {
+	case "", jobs.Scheduled, jobs.Queued, jobs.Held, jobs.Sending, jobs.Interrupted, jobs.Done, jobs.Failed, jobs.Cancelled:
+	default:
+		http.Error(w, fmt.Sprintf("unknown state %q", f.State), http.StatusBadRequest)
+		return
+	}
+	if v := query.Get("since"); v != "" {
+		since, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid since %q: want an RFC 3339 time", v), http.StatusBadRequest)
+			return
+		}
+		f.Since = since
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			http.Error(w, fmt.Sprintf("invalid limit %q", v), http.StatusBadRequest)
+			return
+		}
+		f.Limit = limit
+	}
+	writeJSON(w, map[string]any{"ok": true, "jobs": s.jobs.List(f)})
+}
+
+// reprintJob prints a job that has printed again, as a new job, from the
+// payload kept for jobs.retention_hours. With ?banner=true the copy starts
+// with a "COPY / REPRINT" heading and the time of the reprint.
+func (s *Server) reprintJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := s.configSnapshot()
+	orig, err := s.jobs.Get(r.PathValue("id"))
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	if orig.State != jobs.Done || orig.Data == nil {
+		http.Error(w, fmt.Sprintf("job %s is %s; only printed jobs can be reprinted", orig.ID, orig.State), http.StatusConflict)
+		return
+	}
+	data := orig.Data
+	banner, _ := strconv.ParseBool(r.URL.Query().Get("banner"))
+	if banner {
+		heading, err := reprintBanner(cfg, time.Now())
+		if err != nil {
+			s.log.Warn("reprint rejected: job=%s %v", orig.ID, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data = append(heading, data...)
+	}
+	s.log.Info("reprint: job=%s banner=%v", orig.ID, banner)
+	s.submit(w, r, jobs.Job{
+		Printer:   orig.Printer,
+		Group:     orig.Group,
+		Tag:       orig.Tag,
+		Tags:      orig.Tags,
+		Data:      data,
+		Result:    orig.Result,
+		Reference: orig.Reference,
+		ReprintOf: orig.ID,
+	})
+}
+
+// reprintBanner encodes the heading of a reprinted receipt. Label and cat
+// printers cannot have one put in front of a stored job.
+func reprintBanner(cfg config.Config, at time.Time) ([]byte, error) {
+	lang, err := printing.ParseLanguage(cfg.Printer.CommandLanguage)
+	if err != nil {
+		return nil, err
+	}
+	if !lang.IsReceipt() || lang == printing.LanguageCat {
+		return nil, fmt.Errorf("printer command language is %s; a reprint banner needs a receipt printer", lang)
+	}
+	d, err := printing.NewDriver(lang)
+	if err != nil {
+		return nil, err
+	}
+	cut := false
+	doc := &printing.Document{Cut: &cut, Blocks: []printing.Block{
+		{Type: printing.BlockText, Align: "center", Text: "COPY / REPRINT", Bold: true, Width: 2, Height: 2},
+		{Type: printing.BlockText, Align: "center", Text: at.Format("2006-01-02 15:04:05")},
+		{Type: printing.BlockDivider},
+	}}
+	return printing.EncodeDocument(d, doc, cfg.Printer.PaperWidthMM, printing.ParseFont(cfg.Printer.Font), nil)
+}
+
+func (s *Server) jobHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getJob(w, r)
+	case http.MethodDelete:
+		s.cancelJob(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// getJob reports a job's state, attempts, progress and timings.
+func (s *Server) getJob(w http.ResponseWriter, r *http.Request) {
+	job, err := s.jobs.Get(r.PathValue("id"))
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	writeJSON(w, map[string]any{"ok": true, "job": job})
+}
+
+// cancelJob cancels a job that has not finished. A job being sent stops
+// after the chunk in flight; with ?cut=true the printer then feeds and
+// cuts so the paper ends cleanly. The response carries the job once it
+// has stopped.
+func (s *Server) cancelJob(w http.ResponseWriter, r *http.Request) {
+	var tail []byte
+	if cut, _ := strconv.ParseBool(r.URL.Query().Get("cut")); cut {
+		tail = cancelTail(s.configSnapshot())
+	}
+	job, err := s.jobs.Cancel(r.PathValue("id"), tail)
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	if !job.State.Finished() {
+		if job, err = s.jobs.Wait(r.Context(), job.ID); err != nil {
+			return
+		}
+	}
+	s.log.Info("job cancelled: job=%s bytes_sent=%d/%d", job.ID, job.BytesSent, job.Bytes)
+	writeJSON(w, map[string]any{"ok": true, "job": job})
+}
+
+// cancelTail returns the commands that end a cancelled receipt: a feed
+// past the cutter and a full cut. Label and cat printers get nothing. If
+// the job was stopped inside a command's data, the printer may take them
+// as part of it.
+func cancelTail(cfg config.Config) []byte {
+	lang, err := printing.ParseLanguage(cfg.Printer.CommandLanguage)
+	if err != nil || !lang.IsReceipt() || lang == printing.LanguageCat {
+		return nil
+	}
+	d, err := printing.NewDriver(lang)
+	if err != nil {
+		return nil
+	}
+	return append(d.Feed(4), d.Cut(printing.CutFull)...)
+}
+
+// pausePrinter holds the printer's queue, for example while the roll is
+// changed; resumePrinter releases it. The pause survives restarts.
+func (s *Server) pausePrinter(w http.ResponseWriter, r *http.Request) {
+	s.setPrinterPaused(w, r, true)
+}
+
+func (s *Server) resumePrinter(w http.ResponseWriter, r *http.Request) {
+	s.setPrinterPaused(w, r, false)
+}
+
+func (s *Server) setPrinterPaused(w http.ResponseWriter, r *http.Request, paused bool) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	printer, ok := s.pathPrinter(w, r, s.configSnapshot())
+	if !ok {
+		return
+	}
+	var st jobs.PrinterStatus
+	if paused {
+		st = s.jobs.Pause(printer)
+	} else {
+		st = s.jobs.Resume(printer)
+	}
+	s.log.Info("printer queue: printer=%s paused=%v queued=%d", printer, st.Paused, st.Queued)
+	writeJSON(w, map[string]any{"ok": true, "queue": st})
+}
+
+// requeueJob sends a job held as interrupted again, from the start.
+func (s *Server) requeueJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	job, err := s.jobs.Requeue(r.PathValue("id"))
+	if err != nil {
+		writeJobError(w, err)
+		return
+	}
+	s.log.Info("job requeued: job=%s", job.ID)
+	writeJSON(w, map[string]any{"ok": true, "job": job})
+}
+
+func writeJobError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, jobs.ErrState):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,55 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"ble-printer-bridge/internal/printing"
+)
+
+func (s *Server) printLabel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := s.configSnapshot()
+	var label printing.Label
+	if err := json.NewDecoder(r.Body).Decode(&label); err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	lang, err := printing.ParseLanguage(cfg.Printer.CommandLanguage)
+	if err != nil {
+		s.log.Error("print/label: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var data []byte
+	if lang == printing.LanguageCat {
+		data, err = printing.EncodeCatLabel(&label, catOptions(cfg))
+	} else {
+		data, err = printing.EncodeLabel(lang, &label)
+		data = labelHead(cfg, lang, data)
+	}
+	if err != nil {
+		var unsupported *printing.UnsupportedError
+		if errors.As(err, &unsupported) {
+			s.log.Warn("print/label rejected: %v", err)
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if isPreview(r) {
+		bitmap, err := printing.RenderLabel(&label)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.writeRendered(w, r, data, bitmap, nil, map[string]any{"language": lang})
+		return
+	}
+	s.send(w, r, cfg, "print/label", data)
+}
@@ -0,0 +1,460 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+
+	"ble-printer-bridge/internal/ble"
+	"ble-printer-bridge/internal/config"
+	"ble-printer-bridge/internal/jobs"
+	"ble-printer-bridge/internal/logos"
+	"ble-printer-bridge/internal/printing"
+)
+
+// printer.logo_command values.
+const (
+	logoGraphics = "graphics"
+	logoBitImage = "bit-image"
+)
+
+// logoStatus is a stored logo and whether the current printer holds it.
+type logoStatus struct {
+	logos.Info
+	Stored bool `json:"stored"`
+}
+
+func (s *Server) logosHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := s.configSnapshot()
+	printer := s.printerID(cfg)
+	list, err := s.logos.List()
+	if err != nil {
+		s.log.Error("logos list error: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	held, err := s.logos.Stored(printer)
+	if err != nil {
+		s.log.Error("logos list error: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	out := make([]logoStatus, len(list))
+	for i, info := range list {
+		rec, ok := held[info.Key]
+		out[i] = logoStatus{Info: info, Stored: ok && rec.Hash == info.Hash}
+	}
+	writeJSON(w, map[string]any{"ok": true, "printer": printer, "logos": out})
+}
+
+func (s *Server) logoHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getLogo(w, r)
+	case http.MethodPut:
+		s.requireAdmin(s.putLogo)(w, r)
+	case http.MethodDelete:
+		s.requireAdmin(s.deleteLogo)(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) getLogo(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	img, info, err := s.logos.Get(key)
+	if err != nil {
+		s.writeLogoError(w, key, err)
+		return
+	}
+	if r.URL.Query().Get("format") == "png" {
+		data, err := img.PNG()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("content-type", "image/png")
+		_, _ = w.Write(data)
+		return
+	}
+	held, err := s.logos.Stored(s.printerID(s.configSnapshot()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rec, ok := held[key]
+	writeJSON(w, map[string]any{"ok": true, "logo": logoStatus{Info: info, Stored: ok && rec.Hash == info.Hash}})
+}
+
+// putLogo stores an image under a key and, when the printer is connected,
+// queues its upload to NV memory unless the printer already holds it.
+func (s *Server) putLogo(w http.ResponseWriter, r *http.Request) {
+	cfg := s.configSnapshot()
+	key := r.PathValue("key")
+	var req struct {
+		Image  string `json:"image"`
+		Width  int    `json:"width"`
+		Dither bool   `json:"dither"`
+		Upload *bool  `json:"upload"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Image == "" {
+		http.Error(w, `invalid body: {"image":"<base64 PNG>","width":0,"dither":false,"upload":true}`, http.StatusBadRequest)
+		return
+	}
+	if err := printing.ValidateLogoKey(key); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	raw, err := base64.StdEncoding.DecodeString(req.Image)
+	if err != nil {
+		http.Error(w, "invalid base64 image", http.StatusBadRequest)
+		return
+	}
+	src, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		http.Error(w, "invalid image: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	maxWidth := printing.PrintableDots(cfg.Printer.PaperWidthMM)
+	width := req.Width
+	if width <= 0 || width > maxWidth {
+		width = min(src.Bounds().Dx(), maxWidth)
+	}
+	img := printing.BitmapFromImage(src, width, req.Dither)
+	if cfg.Printer.LogoCommand == logoGraphics {
+		// Validate the size now rather than at the first print.
+		if _, err := printing.DefineNVGraphics(key, img); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	info, err := s.logos.Save(key, img)
+	if err != nil {
+		s.writeLogoError(w, key, err)
+		return
+	}
+	s.log.Info("logo saved: key=%q size=%dx%d", key, info.Width, info.Height)
+
+	resp := map[string]any{"ok": true, "logo": info}
+	lang, _ := printing.ParseLanguage(cfg.Printer.CommandLanguage)
+	if (req.Upload == nil || *req.Upload) && lang == printing.LanguageESCPOS && s.client.IsConnected() {
+		refs := s.logoJobFor(cfg, printing.ESCPOSDriver{}, s.printerID(cfg), false)
+		if _, err := refs.logo(key); err != nil {
+			s.writeLogoError(w, key, err)
+			return
+		}
+		job, ok := s.uploadLogos(w, "logos", refs, jobs.Job{Printer: refs.printer, Tag: "logos"})
+		if !ok {
+			return
+		}
+		// The upload is queued like any job, behind what the printer is
+		// already printing, and is not routed.
+		if len(job.Data) > 0 {
+			if job, err = s.jobs.Submit(job); err != nil {
+				s.log.Error("logos error: %v", err)
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			s.log.Info("logos: job=%s bytes=%d printer=%s %s", job.ID, job.Bytes, job.Printer, job.State)
+			resp["upload_job"] = job
+		}
+	}
+	writeJSON(w, resp)
+}
+
+// deleteLogo removes a logo from the bridge and, when the connected printer
+// holds it as NV graphics, from the printer. NV bit images cannot be
+// deleted one by one; the remaining set is redefined on its next use.
+func (s *Server) deleteLogo(w http.ResponseWriter, r *http.Request) {
+	cfg := s.configSnapshot()
+	key := r.PathValue("key")
+	printer := s.printerID(cfg)
+	held, err := s.logos.Stored(printer)
+	if err != nil {
+		s.writeLogoError(w, key, err)
+		return
+	}
+	removed := false
+	lang, _ := printing.ParseLanguage(cfg.Printer.CommandLanguage)
+	if rec, ok := held[key]; ok && rec.Command == logoGraphics && lang == printing.LanguageESCPOS && s.client.IsConnected() {
+		if err := s.sendToPrinter(cfg, printing.DeleteNVGraphics(key)); err != nil {
+			s.log.Error("logo delete error: key=%q err=%v", key, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		removed = true
+	}
+	if err := s.logos.Delete(key); err != nil {
+		s.writeLogoError(w, key, err)
+		return
+	}
+	s.log.Info("logo deleted: key=%q removed_from_printer=%v", key, removed)
+	writeJSON(w, map[string]any{"ok": true, "removed_from_printer": removed})
+}
+
+func (s *Server) writeLogoError(w http.ResponseWriter, key string, err error) {
+	switch {
+	case errors.Is(err, logos.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case printing.ValidateLogoKey(key) != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		s.log.Error("logo error: key=%q err=%v", key, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// printerID names the printer logo records are kept for: the one last
+// connected to, or the configured address.
+func (s *Server) printerID(cfg config.Config) string {
+	if addr := s.client.Address(); addr != "" {
+		return addr
+	}
+	if addr, err := ble.NormalizeAddress(cfg.BLE.PrinterAddress); err == nil {
+		return addr
+	}
+	return cfg.BLE.PrinterAddress
+}
+
+// pathPrinter resolves the {id} of a /printers/{id} route: "current" or
+// the address of the printer the bridge drives, or the name of a
+// configured printer.
+func (s *Server) pathPrinter(w http.ResponseWriter, r *http.Request, cfg config.Config) (string, bool) {
+	id, printer := r.PathValue("id"), s.printerID(cfg)
+	if _, ok := findPrinter(cfg, id); ok {
+		return id, true
+	}
+	if id != "current" && id != printer {
+		http.Error(w, fmt.Sprintf("unknown printer %q (the bridge drives %s)", id, printer), http.StatusNotFound)
+		return "", false
+	}
+	return printer, true
+}
+
+// logoJob resolves the logos one print job references. ESC/POS printers
+// print them from NV memory; logos they do not hold yet are collected and
+// defined by the job itself, ahead of its content. Previews, Star and cat
+// printers get the bridge's copy as a raster image.
+type logoJob struct {
+	s       *Server
+	cfg     config.Config
+	driver  printing.Driver
+	raster  bool
+	printer string
+
+	// graphics: logos to define
+	define map[string]logoUpload
+	// bit-image: the set defined with FS q, and whether the printer's set
+	// differs from it
+	set      []logos.Info
+	redefine bool
+}
+
+type logoUpload struct {
+	img  *printing.Bitmap
+	hash string
+}
+
+// newLogoJob resolves the logos of a request's job with tags for the
+// printer its ?printer or the routes send it to. Logos are rasterized for
+// any printer but the current one, whose NV logos the bridge does not
+// track, and for groups, whose job may go to any member.
+func (s *Server) newLogoJob(r *http.Request, cfg config.Config, d printing.Driver, tags []string) *logoJob {
+	current := s.printerID(cfg)
+	printer, group := "", ""
+	if name, _, _, err := routeTarget(r, cfg, slices.Concat(tags, queryTags(r))); err == nil {
+		printer, group, _ = s.resolvePrinter(cfg, name)
+	}
+	lang, _ := printing.ParseLanguage(cfg.Printer.CommandLanguage)
+	raster := isPreview(r) || lang != printing.LanguageESCPOS || group != "" || printer != current
+	return s.logoJobFor(cfg, d, current, raster)
+}
+
+// logoJobFor returns a logoJob for printer, the current one.
+func (s *Server) logoJobFor(cfg config.Config, d printing.Driver, printer string, raster bool) *logoJob {
+	return &logoJob{
+		s:       s,
+		cfg:     cfg,
+		driver:  d,
+		raster:  raster,
+		printer: printer,
+		define:  map[string]logoUpload{},
+	}
+}
+
+// logo is a printing.LogoFunc.
+func (j *logoJob) logo(key string) ([]byte, error) {
+	img, info, err := j.s.logos.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	if j.raster {
+		return j.driver.Raster(img), nil
+	}
+	held, err := j.s.logos.Stored(j.printer)
+	if err != nil {
+		return nil, err
+	}
+	if j.cfg.Printer.LogoCommand == logoBitImage {
+		if j.set == nil {
+			if j.set, err = j.s.logos.List(); err != nil {
+				return nil, err
+			}
+			j.redefine = len(held) != len(j.set)
+			for i, l := range j.set {
+				rec, ok := held[l.Key]
+				if !ok || rec.Command != logoBitImage || rec.Hash != l.Hash || rec.Slot != i+1 {
+					j.redefine = true
+				}
+			}
+		}
+		for i, l := range j.set {
+			if l.Key == key {
+				return printing.PrintNVBitImage(i + 1), nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %q", logos.ErrNotFound, key)
+	}
+	if rec, ok := held[key]; !ok || rec.Command != logoGraphics || rec.Hash != info.Hash {
+		j.define[key] = logoUpload{img: img, hash: info.Hash}
+	}
+	return printing.PrintNVGraphics(key), nil
+}
+
+// nvLogosResult is the job result field that lists the logos a job
+// stores in its printer's NV memory.
+const nvLogosResult = "nv_logos"
+
+// nvLogos is what a job stores in NV memory. It is recorded for the job's
+// printer once the job has printed.
+type nvLogos struct {
+	// Replace is set when the job redefines every NV bit image, which
+	// replaces all the printer held.
+	Replace bool                    `json:"replace,omitempty"`
+	Logos   map[string]logos.Record `json:"logos"`
+}
+
+func (n *nvLogos) keys() []string {
+	keys := make([]string, 0, len(n.Logos))
+	for key := range n.Logos {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// flush encodes the definitions of the logos the printer is missing, to
+// be sent ahead of the job that prints them, and returns what they store.
+// Both are empty when the printer holds every logo.
+func (j *logoJob) flush() ([]byte, *nvLogos, error) {
+	if j.redefine {
+		imgs := make([]*printing.Bitmap, len(j.set))
+		stored := &nvLogos{Replace: true, Logos: map[string]logos.Record{}}
+		for i, l := range j.set {
+			img, _, err := j.s.logos.Get(l.Key)
+			if err != nil {
+				return nil, nil, err
+			}
+			imgs[i] = img
+			stored.Logos[l.Key] = logos.Record{Hash: l.Hash, Command: logoBitImage, Slot: i + 1}
+		}
+		data, err := printing.DefineNVBitImages(imgs)
+		if err != nil {
+			return nil, nil, err
+		}
+		return data, stored, nil
+	}
+	if len(j.define) == 0 {
+		return nil, nil, nil
+	}
+
+	keys := make([]string, 0, len(j.define))
+	for key := range j.define {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	stored := &nvLogos{Logos: map[string]logos.Record{}}
+	for _, key := range keys {
+		up := j.define[key]
+		data, err := printing.DefineNVGraphics(key, up.img)
+		if err != nil {
+			return nil, nil, err
+		}
+		b.Write(data)
+		stored.Logos[key] = logos.Record{Hash: up.hash, Command: logoGraphics}
+	}
+	return b.Bytes(), stored, nil
+}
+
+// uploadLogos puts the definitions of the logos the printer is missing in
+// front of job, which prints them, and notes them in its result. It writes
+// the HTTP error response when a logo cannot be encoded.
+func (s *Server) uploadLogos(w http.ResponseWriter, tag string, refs *logoJob, job jobs.Job) (jobs.Job, bool) {
+	data, stored, err := refs.flush()
+	if err != nil {
+		s.log.Error("%s logo upload error: %v", tag, err)
+		http.Error(w, "logo upload failed: "+err.Error(), http.StatusInternalServerError)
+		return job, false
+	}
+	if stored == nil {
+		return job, true
+	}
+	job.Data = append(data, job.Data...)
+	job.Result = maps.Clone(job.Result)
+	if job.Result == nil {
+		job.Result = map[string]any{}
+	}
+	job.Result[nvLogosResult] = stored
+	s.log.Info("%s: logos to upload to %s: %v", tag, refs.printer, stored.keys())
+	return job, true
+}
+
+// recordLogos records the logos a printed job stored in its printer's NV
+// memory. Jobs that did not print may have stored part of them, so
+// nothing is recorded and the logos are sent again with the next job that
+// prints them.
+func (s *Server) recordLogos(job jobs.Job) {
+	v, ok := job.Result[nvLogosResult]
+	if !ok || job.State != jobs.Done {
+		return
+	}
+	// A job restored from the journal holds the result as decoded JSON.
+	var stored nvLogos
+	data, err := json.Marshal(v)
+	if err == nil {
+		err = json.Unmarshal(data, &stored)
+	}
+	if err != nil {
+		s.log.Error("logo records: job=%s %v", job.ID, err)
+		return
+	}
+	for key, rec := range stored.Logos {
+		rec.Stored = *job.FinishedAt
+		stored.Logos[key] = rec
+		if !stored.Replace {
+			if err = s.logos.SetStored(job.Printer, key, &rec); err != nil {
+				break
+			}
+		}
+	}
+	if stored.Replace {
+		err = s.logos.ReplaceStored(job.Printer, stored.Logos)
+	}
+	if err != nil {
+		s.log.Error("logo records: job=%s printer=%s %v", job.ID, job.Printer, err)
+		return
+	}
+	s.log.Info("logos stored: job=%s printer=%s %v", job.ID, job.Printer, stored.keys())
+}
@@ -0,0 +1,76 @@
+package httpapi
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ble-printer-bridge/internal/ble"
+	"ble-printer-bridge/internal/config"
+	"ble-printer-bridge/internal/jobs"
+	"ble-printer-bridge/internal/logos"
+	"ble-printer-bridge/internal/printing"
+)
+
+func TestLogoUploadIsQueuedWithTheJob(t *testing.T) {
+	cfg := config.Config{}
+	config.ApplyDefaults(&cfg)
+	s := &Server{cfg: &cfg, log: newTestLogger(t), client: &ble.Client{}, logos: logos.NewStore(t.TempDir())}
+	img := printing.NewBitmap(8, 8)
+	img.FillRect(0, 0, 8, 8, true)
+	if _, err := s.logos.Save("LG", img); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	define, err := printing.DefineNVGraphics("LG", img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendErr := errors.New("paper jam")
+	var sent [][]byte
+	finished := make(chan jobs.Job, 1)
+	s.jobs = jobs.NewQueue(func(job jobs.Job, progress func(int) error) error {
+		sent = append(sent, job.Data)
+		return sendErr
+	}, jobs.Options{MaxAttempts: 1, OnFinish: func(job jobs.Job) {
+		s.recordLogos(job)
+		finished <- job
+	}})
+	print := func() jobs.Job {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "http://127.0.0.1/print/document?wait=true", strings.NewReader(`{"blocks":[{"type":"logo","key":"LG"}]}`))
+		s.printDocument(httptest.NewRecorder(), req)
+		select {
+		case job := <-finished:
+			return job
+		case <-time.After(time.Second):
+			t.Fatal("job did not finish")
+		}
+		return jobs.Job{}
+	}
+
+	// The logo is defined by the job itself, and only recorded as stored
+	// once the job has printed.
+	if job := print(); job.State != jobs.Failed || job.Result[nvLogosResult] == nil || !bytes.HasPrefix(sent[0], define) {
+		t.Fatalf("first job = %s %v, data % X; want it to define the logo", job.State, job.Result, sent[0])
+	}
+	if held, _ := s.logos.Stored(s.printerID(cfg)); len(held) != 0 {
+		t.Fatalf("failed job recorded %v", held)
+	}
+
+	sendErr = nil
+	if job := print(); job.State != jobs.Done || !bytes.HasPrefix(sent[1], define) {
+		t.Fatalf("second job = %s, data % X; want it to define the logo again", job.State, sent[1])
+	}
+	if held, _ := s.logos.Stored(s.printerID(cfg)); held["LG"].Command != logoGraphics {
+		t.Fatalf("stored = %v, want LG recorded", held)
+	}
+
+	if job := print(); job.Result[nvLogosResult] != nil || bytes.Contains(sent[2], define) {
+		t.Fatalf("third job = %v, data % X; want the stored logo printed without defining it", job.Result, sent[2])
+	}
+}
@@ -0,0 +1,61 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+
+	"ble-printer-bridge/internal/printing"
+)
+
+// printMarkdown prints a Markdown receipt. The body is either JSON
+// ({"markdown": "..."} plus copies, cut and feed) or the Markdown itself.
+func (s *Server) printMarkdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := s.configSnapshot()
+	var req struct {
+		Markdown string `json:"markdown"`
+		finishRequest
+	}
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("content-type")); ct == "application/json" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `invalid body: {"markdown":"# Title"}`, http.StatusBadRequest)
+			return
+		}
+	} else {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		req.Markdown = string(body)
+	}
+	finish, err := req.finish(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	driver, ok := s.receiptDriver(w, r, cfg, "print/markdown")
+	if !ok {
+		return
+	}
+	opts, err := s.textOptions(cfg)
+	if err != nil {
+		s.log.Error("print/markdown: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	opts.Finish = finish
+
+	data, err := printing.EncodeMarkdown(driver, req.Markdown, cfg.Printer.PaperWidthMM, printing.ParseFont(cfg.Printer.Font), opts)
+	if err != nil {
+		s.log.Warn("print/markdown rejected: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.deliver(w, r, cfg, "print/markdown", data)
+}
@@ -0,0 +1,80 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+
+	"ble-printer-bridge/internal/config"
+	"ble-printer-bridge/internal/printing"
+)
+
+type previewKey struct{}
+
+// previewOnly marks the request so print handlers render instead of print.
+func previewOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, withPreview(r))
+	}
+}
+
+// withPreview returns r marked as a preview request.
+func withPreview(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), previewKey{}, true))
+}
+
+// isPreview reports whether the request came through a /preview route or
+// asked for one with ?preview=true on a print endpoint.
+func isPreview(r *http.Request) bool {
+	if v, _ := r.Context().Value(previewKey{}).(bool); v {
+		return true
+	}
+	v, _ := strconv.ParseBool(r.URL.Query().Get("preview"))
+	return v
+}
+
+// writePreview decodes the encoded ESC/POS job and responds with the
+// rendered page. extra fields are merged into the JSON response.
+func (s *Server) writePreview(w http.ResponseWriter, r *http.Request, cfg config.Config, data []byte, extra map[string]any) {
+	preview := printing.PreviewESCPOS(data, printing.PrintableDots(cfg.Printer.PaperWidthMM))
+	if extra == nil {
+		extra = map[string]any{}
+	}
+	extra["page"] = preview.Page
+	s.writeRendered(w, r, data, preview.Bitmap, preview.Page.Warnings, extra)
+}
+
+// writeRendered responds with a rendered job: a PNG when ?format=png,
+// otherwise JSON with the PNG, the encoded bytes and any warnings.
+func (s *Server) writeRendered(w http.ResponseWriter, r *http.Request, data []byte, bitmap *printing.Bitmap, warnings []printing.DecodeWarning, extra map[string]any) {
+	img, err := bitmap.PNG()
+	if err != nil {
+		s.log.Error("preview encode error: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if warnings == nil {
+		warnings = []printing.DecodeWarning{}
+	}
+	s.log.Info("preview: bytes=%d height=%d warnings=%d", len(data), bitmap.Height, len(warnings))
+
+	if r.URL.Query().Get("format") == "png" {
+		w.Header().Set("content-type", "image/png")
+		w.Header().Set("x-preview-warnings", strconv.Itoa(len(warnings)))
+		_, _ = w.Write(img)
+		return
+	}
+	resp := map[string]any{
+		"ok":       true,
+		"width":    bitmap.Width,
+		"height":   bitmap.Height,
+		"png":      base64.StdEncoding.EncodeToString(img),
+		"base64":   base64.StdEncoding.EncodeToString(data),
+		"warnings": warnings,
+	}
+	for k, v := range extra {
+		resp[k] = v
+	}
+	writeJSON(w, resp)
+}
@@ -0,0 +1,67 @@
+package httpapi
+
+import (
+	"net/http"
+	"strconv"
+
+	"ble-printer-bridge/internal/config"
+	"ble-printer-bridge/internal/printing"
+)
+
+// rawPolicy converts a configured policy to its printing form.
+func rawPolicy(p config.RawPolicy) printing.RawPolicy {
+	return printing.RawPolicy{
+		Mode:            p.Mode,
+		Families:        p.Families,
+		MaxBytes:        p.MaxBytes,
+		MaxRasterHeight: p.MaxRasterHeight,
+		Action:          p.Action,
+	}
+}
+
+// filterRaw applies the request key's raw policy to data, which is in the
+// configured printer's language. Admin keys skip it with
+// ?bypass_policy=true. On rejection it writes a 403 with the violations
+// and returns false; stripped violations are returned in extra for the
+// response.
+func (s *Server) filterRaw(w http.ResponseWriter, r *http.Request, cfg config.Config, data []byte) ([]byte, map[string]any, bool) {
+	key := requestKey(r)
+	if bypass, _ := strconv.ParseBool(r.URL.Query().Get("bypass_policy")); bypass {
+		if !key.Admin {
+			s.log.Warn("print/raw: key %q may not bypass the raw policy", key.Name)
+			http.Error(w, "forbidden: only admin keys may bypass the raw policy", http.StatusForbidden)
+			return nil, nil, false
+		}
+		s.log.Warn("print/raw: raw policy bypassed by key %q", key.Name)
+		return data, nil, true
+	}
+
+	policy := rawPolicy(key.RawPolicy)
+	if err := policy.Validate(); err != nil {
+		s.log.Error("print/raw: key %q: %v", key.Name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	lang, err := printing.ParseLanguage(cfg.Printer.CommandLanguage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	result := policy.Apply(lang, data)
+	if result.Rejected {
+		s.log.Warn("print/raw rejected: key=%q violations=%d", key.Name, len(result.Violations))
+		w.Header().Set("content-type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		writeJSON(w, map[string]any{
+			"ok":         false,
+			"error":      "payload violates the raw policy",
+			"violations": result.Violations,
+		})
+		return nil, nil, false
+	}
+	if len(result.Violations) > 0 {
+		s.log.Warn("print/raw stripped: key=%q violations=%d bytes=%d->%d", key.Name, len(result.Violations), len(data), len(result.Data))
+		return result.Data, map[string]any{"stripped": result.Violations}, true
+	}
+	return result.Data, nil, true
+}
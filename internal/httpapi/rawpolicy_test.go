@@ -0,0 +1,63 @@
+package httpapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ble-printer-bridge/internal/ble"
+	"ble-printer-bridge/internal/config"
+	"ble-printer-bridge/internal/printing"
+)
+
+func TestPrintRawFiltersOnlyESCPOS(t *testing.T) {
+	// A cat printer row whose bitmap bytes look like ESC/POS commands.
+	catJob := printing.EncodeCatRaster(printing.NewBitmap(8, 1), printing.CatOptions{})
+	catJob = append(catJob, 0x51, 0x78, 0xA2, 0x00, 0x02, 0x00, 0x1b, 0x1d, 0x00, 0xFF)
+	tspl := []byte("BITMAP 0,0,2,1,0,\x1b\x10\r\nPRINT 1\r\n")
+
+	tests := []struct {
+		name       string
+		language   string
+		data       []byte
+		wantStatus int
+	}{
+		{"cat passes through", "cat", catJob, http.StatusOK},
+		{"tspl passes through", "tspl", tspl, http.StatusOK},
+		{"escpos is filtered", "escpos", tspl, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{}
+			config.ApplyDefaults(&cfg)
+			cfg.Printer.CommandLanguage = tt.language
+			s := &Server{cfg: &cfg, log: newTestLogger(t), client: &ble.Client{}}
+
+			body := `{"base64":"` + base64.StdEncoding.EncodeToString(tt.data) + `"}`
+			req := httptest.NewRequest(http.MethodPost, "http://127.0.0.1/print/raw?preview=true", strings.NewReader(body))
+			req = req.WithContext(context.WithValue(req.Context(), apiKeyCtx{}, apiKey{Name: "default", RawPolicy: cfg.Auth.RawPolicy}))
+			rec := httptest.NewRecorder()
+			s.printRaw(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			var resp struct {
+				Base64 string `json:"base64"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if got, _ := base64.StdEncoding.DecodeString(resp.Base64); !bytes.Equal(got, tt.data) {
+				t.Fatalf("payload = % X, want it unchanged", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package httpapi
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"ble-printer-bridge/internal/ble"
+	"ble-printer-bridge/internal/config"
+	"ble-printer-bridge/internal/jobs"
+)
+
+// Group policies.
+const (
+	policyFailover    = "failover"
+	policyRoundRobin  = "round-robin"
+	policyLeastQueued = "least-queued"
+)
+
+// printerCooldown is how long a printer that failed a job is passed over
+// when a group picks a member, unless every member has failed.
+const printerCooldown = 30 * time.Second
+
+// printerSet tracks the printers jobs are sent to, by queue name: the
+// address of the current printer or the name of a configured one.
+type printerSet struct {
+	mu sync.Mutex
+	// clients are the connections to configured printers, by address.
+	clients map[string]*ble.Client
+	states  map[string]*printerState
+	// next is each round-robin group's next member.
+	next map[string]int
+}
+
+type printerState struct {
+	// up is whether the printer was last seen connected, once known.
+	known, up bool
+	// failedAt is when the printer last failed a job, until it prints
+	// one.
+	failedAt time.Time
+	// paperOut is whether the paper sensor last reported no paper.
+	paperOut bool
+}
+
+// state returns a printer's state. p.mu must be held.
+func (p *printerSet) state(printer string) *printerState {
+	if p.states == nil {
+		p.states = map[string]*printerState{}
+	}
+	st := p.states[printer]
+	if st == nil {
+		st = &printerState{}
+		p.states[printer] = st
+	}
+	return st
+}
+
+// healthy reports whether printer has not failed a job lately.
+func (p *printerSet) healthy(printer string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return time.Since(p.state(printer).failedAt) > printerCooldown
+}
+
+func findPrinter(cfg config.Config, name string) (config.NamedPrinter, bool) {
+	for _, p := range cfg.Printers {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return config.NamedPrinter{}, false
+}
+
+func findGroup(cfg config.Config, name string) (config.PrinterGroup, bool) {
+	for _, g := range cfg.Groups {
+		if g.Name == name {
+			return g, true
+		}
+	}
+	return config.PrinterGroup{}, false
+}
+
+// clientFor returns the connection jobs queued for printer are written
+// to. A configured printer is connected to when it is not already; other
+// queues are keyed by address and go to the connection /ble/connect
+// drives only while it is to that address.
+func (s *Server) clientFor(cfg config.Config, printer string) (*ble.Client, error) {
+	named, ok := findPrinter(cfg, printer)
+	if !ok {
+		if addr := s.client.Address(); addr == "" || addr != printer {
+			return nil, fmt.Errorf("printer %q is not the connected printer", printer)
+		}
+		return s.client, nil
+	}
+	addr, err := ble.NormalizeAddress(named.Address)
+	if err != nil {
+		return nil, fmt.Errorf("printer %s: %w", printer, err)
+	}
+	if addr == s.client.Address() && s.client.IsConnected() {
+		return s.client, nil
+	}
+	s.printers.mu.Lock()
+	if s.printers.clients == nil {
+		s.printers.clients = map[string]*ble.Client{}
+	}
+	c := s.printers.clients[addr]
+	if c == nil {
+		c = &ble.Client{}
+		s.printers.clients[addr] = c
+	}
+	s.printers.mu.Unlock()
+	if c.IsConnected() {
+		return c, nil
+	}
+	s.log.Info("ble connect start: printer=%s address=%s", printer, addr)
+	if err := c.Connect(addr); err != nil {
+		return nil, fmt.Errorf("connect to printer %s (%s): %w", printer, addr, err)
+	}
+	s.log.Info("ble connect ok: printer=%s address=%s", printer, addr)
+	s.notePrinter(printer, true)
+	return c, nil
+}
+
+// noteSend records how a job went on printer, for groups picking a
+// healthy member.
+func (s *Server) noteSend(printer string, c *ble.Client, err error) {
+	s.printers.mu.Lock()
+	if err != nil {
+		s.printers.state(printer).failedAt = time.Now()
+	} else {
+		s.printers.state(printer).failedAt = time.Time{}
+	}
+	s.printers.mu.Unlock()
+	s.notePrinter(printer, err == nil || c != nil && c.IsConnected())
+}
+
+// resolvePrinter turns a request's ?printer, the name of a configured
+// printer or group or "current", into the queue the job goes to and its
+// group.
+func (s *Server) resolvePrinter(cfg config.Config, name string) (printer, group string, err error) {
+	if err := checkTarget(cfg, name); err != nil {
+		return "", "", err
+	}
+	if name == "" || name == "current" {
+		return s.printerID(cfg), "", nil
+	}
+	if _, ok := findPrinter(cfg, name); ok {
+		return name, "", nil
+	}
+	g, _ := findGroup(cfg, name)
+	return s.groupMembers(cfg, g, true)[0], g.Name, nil
+}
+
+// checkTarget reports whether jobs can be sent to name: "current", a
+// configured printer, or a group with a known policy and printers.
+func checkTarget(cfg config.Config, name string) error {
+	if name == "" || name == "current" {
+		return nil
+	}
+	if _, ok := findPrinter(cfg, name); ok {
+		return nil
+	}
+	g, ok := findGroup(cfg, name)
+	if !ok {
+		return fmt.Errorf("unknown printer or group %q", name)
+	}
+	switch g.Policy {
+	case "", policyFailover, policyRoundRobin, policyLeastQueued:
+	default:
+		return fmt.Errorf("printer group %q has unknown policy %q (want failover, round-robin or least-queued)", name, g.Policy)
+	}
+	known := func(p string) bool {
+		_, ok := findPrinter(cfg, p)
+		return ok || p == "current"
+	}
+	if !slices.ContainsFunc(g.Printers, known) {
+		return fmt.Errorf("printer group %q has no printers", name)
+	}
+	return nil
+}
+
+// groupMembers returns the queues of g's printers in the order a job
+// should try them: by the group's policy, healthy and running printers
+// first. pick advances a round-robin group to its next member.
+func (s *Server) groupMembers(cfg config.Config, g config.PrinterGroup, pick bool) []string {
+	var members []string
+	for _, name := range g.Printers {
+		if name == "current" {
+			members = append(members, s.printerID(cfg))
+		} else if _, ok := findPrinter(cfg, name); ok {
+			members = append(members, name)
+		}
+	}
+	if len(members) == 0 {
+		return nil
+	}
+	switch g.Policy {
+	case policyRoundRobin:
+		s.printers.mu.Lock()
+		if s.printers.next == nil {
+			s.printers.next = map[string]int{}
+		}
+		n := s.printers.next[g.Name] % len(members)
+		if pick {
+			s.printers.next[g.Name] = n + 1
+		}
+		s.printers.mu.Unlock()
+		members = slices.Concat(members[n:], members[:n])
+	case policyLeastQueued:
+		load := map[string]int{}
+		for _, m := range members {
+			st := s.jobs.Status(m)
+			load[m] = st.Queued
+			if st.Sending != "" {
+				load[m]++
+			}
+		}
+		sort.SliceStable(members, func(a, b int) bool { return load[members[a]] < load[members[b]] })
+	}
+	up := map[string]bool{}
+	for _, m := range members {
+		up[m] = s.available(m)
+	}
+	sort.SliceStable(members, func(a, b int) bool { return up[members[a]] && !up[members[b]] })
+	return members
+}
+
+// available reports whether a group should send a job to printer now.
+func (s *Server) available(printer string) bool {
+	st := s.jobs.Status(printer)
+	return s.printers.healthy(printer) && !st.Paused && !st.Offline
+}
+
+// reconnect tries, every jobs.reconnect_seconds, to connect to the
+// printers that hold jobs because they are offline, so the jobs are sent
+// as soon as a printer is back in range. A printer disconnected on purpose
+// is left alone until a job is held for it.
+func (s *Server) reconnect() {
+	for {
+		cfg := s.configSnapshot()
+		time.Sleep(time.Duration(cfg.Jobs.ReconnectSeconds) * time.Second)
+		if current := s.printerID(cfg); holding(s.jobs.Status(current)) {
+			if addr, err := ble.NormalizeAddress(current); err == nil {
+				if err := s.client.Connect(addr); err == nil {
+					s.log.Info("ble reconnect ok: address=%s", addr)
+					s.notePrinter(addr, true)
+					s.initHead(cfg)
+				}
+			}
+		}
+		for _, p := range cfg.Printers {
+			if !holding(s.jobs.Status(p.Name)) {
+				continue
+			}
+			if _, err := s.clientFor(cfg, p.Name); err != nil {
+				continue
+			}
+			s.log.Info("ble reconnect ok: printer=%s", p.Name)
+			// clientFor only announces a connection it made itself.
+			s.notePrinter(p.Name, true)
+		}
+	}
+}
+
+// adoptUnaddressed moves the jobs queued before any printer address was
+// known, under "", to addr once it is.
+func (s *Server) adoptUnaddressed(addr string) {
+	if n := s.jobs.Move("", addr); n > 0 {
+		s.log.Info("jobs moved to the current printer: address=%s jobs=%d", addr, n)
+	}
+}
+
+// reroute is the queue's Reroute: a job sent to a group moves to the next
+// member it has not failed on.
+func (s *Server) reroute(job jobs.Job, err error) string {
+	if job.Group == "" {
+		return ""
+	}
+	cfg := s.configSnapshot()
+	g, ok := findGroup(cfg, job.Group)
+	if !ok {
+		return ""
+	}
+	for _, m := range s.groupMembers(cfg, g, false) {
+		if !slices.Contains(job.Tried, m) {
+			s.log.Warn("%s failover: job=%s group=%s from=%s to=%s: %v", job.Tag, job.ID, job.Group, job.Printer, m, err)
+			return m
+		}
+	}
+	return ""
+}
+
+// holding reports whether a printer's queue holds jobs for it to come
+// back online.
+func holding(st jobs.PrinterStatus) bool {
+	return st.Offline && st.Held > 0
+}
+
+// listPrinters reports the configured printers and groups: each printer's
+// address, whether it was last seen connected, whether it is passed over
+// after a failure, and its queue.
+func (s *Server) listPrinters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := s.configSnapshot()
+	type printerInfo struct {
+		Name      string             `json:"name"`
+		Address   string             `json:"address"`
+		Connected bool               `json:"connected"`
+		Healthy   bool               `json:"healthy"`
+		Queue     jobs.PrinterStatus `json:"queue"`
+	}
+	info := func(name, queue, addr string) printerInfo {
+		s.printers.mu.Lock()
+		connected := s.printers.state(queue).up
+		s.printers.mu.Unlock()
+		return printerInfo{Name: name, Address: addr, Connected: connected, Healthy: s.printers.healthy(queue), Queue: s.jobs.Status(queue)}
+	}
+	current := s.printerID(cfg)
+	printers := []printerInfo{info("current", current, current)}
+	for _, p := range cfg.Printers {
+		printers = append(printers, info(p.Name, p.Name, p.Address))
+	}
+	type groupInfo struct {
+		Name     string   `json:"name"`
+		Printers []string `json:"printers"`
+		Policy   string   `json:"policy"`
+	}
+	groups := []groupInfo{}
+	for _, g := range cfg.Groups {
+		if g.Policy == "" {
+			g.Policy = policyFailover
+		}
+		groups = append(groups, groupInfo{Name: g.Name, Printers: g.Printers, Policy: g.Policy})
+	}
+	writeJSON(w, map[string]any{"ok": true, "printers": printers, "groups": groups})
+}
@@ -0,0 +1,473 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"ble-printer-bridge/internal/config"
+	"ble-printer-bridge/internal/jobs"
+	"ble-printer-bridge/internal/printing"
+)
+
+// queryTags returns the comma-separated tags of a print request's ?tags.
+func queryTags(r *http.Request) []string {
+	var tags []string
+	for _, tag := range strings.Split(r.URL.Query().Get("tags"), ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// routeJob picks job's printer, as routeTarget finds it, and records the
+// decision in job.Route. A reprint goes back to the original's group, or
+// its printer, unless ?printer names another.
+func (s *Server) routeJob(r *http.Request, job *jobs.Job) (int, error) {
+	cfg := s.configSnapshot()
+	if job.ReprintOf != "" && r.URL.Query().Get("printer") == "" {
+		if job.Group == "" || checkTarget(cfg, job.Group) != nil {
+			job.Group = ""
+			return 0, nil
+		}
+		var err error
+		if job.Printer, job.Group, err = s.resolvePrinter(cfg, job.Group); err != nil {
+			return http.StatusInternalServerError, err
+		}
+		return 0, nil
+	}
+	name, why, status, err := routeTarget(r, cfg, job.Tags)
+	if err != nil {
+		return status, err
+	}
+	if job.Printer, job.Group, err = s.resolvePrinter(cfg, name); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	job.Route = why
+	return 0, nil
+}
+
+// routeTarget returns the printer or group a request's job with tags goes
+// to, and why: the one ?printer names, else the target of the first route
+// the request matches, else "" for the current printer. why is empty when
+// no routes are configured. A request naming an unknown printer is
+// rejected with 400; a route naming one is a configuration error (500).
+func routeTarget(r *http.Request, cfg config.Config, tags []string) (name, why string, status int, err error) {
+	if name := r.URL.Query().Get("printer"); name != "" {
+		if err := checkTarget(cfg, name); err != nil {
+			return "", "", http.StatusBadRequest, err
+		}
+		if len(cfg.Routes) > 0 {
+			why = "?printer=" + name
+		}
+		return name, why, 0, nil
+	}
+	if len(cfg.Routes) == 0 {
+		return "", "", 0, nil
+	}
+	i, why, err := matchRoute(cfg.Routes, routeRequest{
+		tags:   tags,
+		key:    requestKey(r).Name,
+		origin: r.Header.Get("Origin"),
+		at:     time.Now(),
+	})
+	if err != nil {
+		return "", "", http.StatusInternalServerError, err
+	}
+	if i < 0 {
+		return "", "no route matched; current printer", 0, nil
+	}
+	route := cfg.Routes[i]
+	if err := checkTarget(cfg, route.Printer); err != nil {
+		return "", "", http.StatusInternalServerError, fmt.Errorf("route %s: %w", routeName(i, route), err)
+	}
+	return route.Printer, why, 0, nil
+}
+
+// routeRequest is what routes match a print request on.
+type routeRequest struct {
+	tags   []string
+	key    string
+	origin string
+	at     time.Time
+}
+
+// matchRoute returns the index of the first of routes that req matches,
+// or -1, and a description of why it matched.
+func matchRoute(routes []config.Route, req routeRequest) (int, string, error) {
+	for i, rt := range routes {
+		var why []string
+		if len(rt.Tags) > 0 {
+			t := slices.IndexFunc(req.tags, func(tag string) bool {
+				return slices.ContainsFunc(rt.Tags, func(want string) bool { return strings.EqualFold(tag, want) })
+			})
+			if t < 0 {
+				continue
+			}
+			why = append(why, "tag="+req.tags[t])
+		}
+		if len(rt.Keys) > 0 {
+			if !slices.Contains(rt.Keys, req.key) {
+				continue
+			}
+			why = append(why, "key="+req.key)
+		}
+		if len(rt.Origins) > 0 {
+			if !slices.ContainsFunc(rt.Origins, func(o string) bool { return originMatches(o, req.origin) }) {
+				continue
+			}
+			why = append(why, "origin="+normalizeOrigin(req.origin))
+		}
+		if rt.Hours != "" {
+			from, to, err := parseHours(rt.Hours)
+			if err != nil {
+				return -1, "", fmt.Errorf("route %s: %w", routeName(i, rt), err)
+			}
+			if !inHours(from, to, req.at) {
+				continue
+			}
+			why = append(why, "hours="+rt.Hours)
+		}
+		if len(why) == 0 {
+			why = append(why, "any request")
+		}
+		return i, fmt.Sprintf("route %s (%s) -> %s", routeName(i, rt), strings.Join(why, ", "), rt.Printer), nil
+	}
+	return -1, "", nil
+}
+
+// routeName names the i'th route in logs and job routes: its name, quoted,
+// or its place in the table.
+func routeName(i int, rt config.Route) string {
+	if rt.Name != "" {
+		return strconv.Quote(rt.Name)
+	}
+	return "#" + strconv.Itoa(i+1)
+}
+
+// originMatches reports whether origin is pattern, where "*" matches
+// anything, like cors.allow_origin_patterns.
+func originMatches(pattern, origin string) bool {
+	pattern, origin = normalizeOrigin(pattern), normalizeOrigin(origin)
+	if origin == "" {
+		return false
+	}
+	if !strings.Contains(pattern, "*") {
+		return pattern == origin
+	}
+	re, err := regexp.Compile(toRegexPattern(pattern))
+	return err == nil && re.MatchString(origin)
+}
+
+// parseHours parses a time-of-day range "HH:MM-HH:MM" into minutes after
+// midnight. The end may be "24:00"; a range ending before it starts runs
+// past midnight.
+func parseHours(v string) (from, to int, err error) {
+	a, b, ok := strings.Cut(v, "-")
+	if ok {
+		from, err = parseClock(a)
+	}
+	if ok && err == nil {
+		to, err = parseClock(b)
+	}
+	if !ok || err != nil || from == to {
+		return 0, 0, fmt.Errorf("invalid hours %q: want HH:MM-HH:MM", v)
+	}
+	return from, to, nil
+}
+
+func parseClock(v string) (int, error) {
+	h, m, ok := strings.Cut(strings.TrimSpace(v), ":")
+	hour, err1 := strconv.Atoi(h)
+	minute, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("invalid time %q", v)
+	}
+	return hour*60 + minute, nil
+}
+
+// inHours reports whether at's local time of day falls in [from, to).
+func inHours(from, to int, at time.Time) bool {
+	m := at.Hour()*60 + at.Minute()
+	if from < to {
+		return m >= from && m < to
+	}
+	return m >= from || m < to
+}
+
+// checkRoutes logs the routes that can never send a job anywhere.
+func (s *Server) checkRoutes(cfg *config.Config) {
+	for i, rt := range cfg.Routes {
+		if rt.Hours != "" {
+			if _, _, err := parseHours(rt.Hours); err != nil {
+				s.log.Error("route %s: %v", routeName(i, rt), err)
+			}
+		}
+		if err := checkTarget(*cfg, rt.Printer); err != nil {
+			s.log.Error("route %s: %v", routeName(i, rt), err)
+		}
+	}
+}
+
+// submitRequest is the body of POST /submit: a document, or an order to
+// split into one ticket per station.
+type submitRequest struct {
+	// Tags route the document; order tickets are tagged with their
+	// station.
+	Tags     []string           `json:"tags"`
+	Document *printing.Document `json:"document"`
+	Order    *order             `json:"order"`
+}
+
+// order is a kitchen order whose items are tagged with the stations that
+// prepare them.
+type order struct {
+	Number string `json:"number"`
+	// Note is printed on every ticket, such as a table or a name.
+	Note  string      `json:"note"`
+	Items []orderItem `json:"items"`
+}
+
+type orderItem struct {
+	Name     string   `json:"name"`
+	Quantity int      `json:"quantity"`
+	Notes    []string `json:"notes"`
+	Tags     []string `json:"tags"`
+}
+
+// submitJob is the single submit endpoint: it prints a document wherever
+// the routes send it, or fans an order out into one ticket per station,
+// each routed by its station tag.
+func (s *Server) submitJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := s.configSnapshot()
+	var req submitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || (req.Document == nil) == (req.Order == nil) {
+		http.Error(w, `invalid body: {"tags":[...],"document":{...}} or {"order":{...}}`, http.StatusBadRequest)
+		return
+	}
+	driver, ok := s.receiptDriver(w, r, cfg, "submit")
+	if !ok {
+		return
+	}
+	if req.Order != nil {
+		s.submitOrder(w, r, cfg, driver, req.Order)
+		return
+	}
+
+	refs := s.newLogoJob(r, cfg, driver, req.Tags)
+	data, err := printing.EncodeDocument(driver, req.Document, cfg.Printer.PaperWidthMM, printing.ParseFont(cfg.Printer.Font), refs.logo)
+	if err != nil {
+		s.log.Warn("submit rejected: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	job, ok := s.uploadLogos(w, "submit", refs, jobs.Job{Printer: s.printerID(cfg), Tag: "submit", Tags: req.Tags, Data: data})
+	if !ok {
+		return
+	}
+	s.deliverJob(w, r, cfg, job)
+}
+
+// submitOrder queues one ticket per station of o and answers with all of
+// them: 202 while queued or held, or with ?wait=true 200 once all have
+// printed and 500 if any has failed.
+func (s *Server) submitOrder(w http.ResponseWriter, r *http.Request, cfg config.Config, driver printing.Driver, o *order) {
+	if isPreview(r) {
+		http.Error(w, "orders cannot be previewed; preview a ticket as a document", http.StatusBadRequest)
+		return
+	}
+	stations, err := orderStations(o)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now()
+	var tickets []jobs.Job
+	for _, station := range stations {
+		data, err := printing.EncodeDocument(driver, orderTicket(o, station, now), cfg.Printer.PaperWidthMM, printing.ParseFont(cfg.Printer.Font), nil)
+		if err != nil {
+			s.log.Warn("submit order rejected: station=%s %v", station, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tickets = append(tickets, jobs.Job{
+			Printer:   s.printerID(cfg),
+			Tag:       "submit/order",
+			Tags:      []string{station},
+			Reference: o.Number,
+			Data:      receiptPayload(cfg, data),
+			Result:    map[string]any{"station": station},
+		})
+	}
+
+	// Every ticket's route is checked before any is queued, so a request
+	// the routes cannot place prints nothing.
+	for _, t := range tickets {
+		if _, _, status, err := routeTarget(r, cfg, slices.Concat(t.Tags, queryTags(r))); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+	if r.URL.Query().Get("reference") == "" && len(o.Number) > maxReference {
+		http.Error(w, "order number is longer than 255 characters", http.StatusBadRequest)
+		return
+	}
+	// An Idempotency-Key is bound to all the tickets, once they are queued.
+	// If one cannot be queued, those that were are cancelled and the key is
+	// left unbound, for idempotent to release, so a retry submits the whole
+	// order again.
+	key := idempotencyKey(r)
+	s.jobs.Batch(key)
+	for i, t := range tickets {
+		job, status, err := s.enqueue(r, t)
+		if err != nil {
+			s.cancelTickets(tickets[:i])
+			http.Error(w, err.Error(), status)
+			return
+		}
+		tickets[i] = job
+	}
+	s.jobs.Bind(key)
+	s.log.Info("submit/order: number=%q tickets=%d", o.Number, len(tickets))
+	s.writeTickets(w, r, tickets, nil)
+}
+
+// cancelTickets cancels the queued tickets of an order that could not be
+// queued in full.
+func (s *Server) cancelTickets(tickets []jobs.Job) {
+	tail := cancelTail(s.configSnapshot())
+	for _, t := range tickets {
+		if _, err := s.jobs.Cancel(t.ID, tail); err != nil {
+			s.log.Warn("submit/order: cancel ticket job=%s: %v", t.ID, err)
+			continue
+		}
+		s.log.Info("submit/order: ticket cancelled: job=%s", t.ID)
+	}
+}
+
+// writeTickets answers an order with its tickets: 202 while they are
+// queued or held, or, with ?wait=true or once all have finished, 200 when
+// all have printed and 500 if any has failed. Tickets the queue has
+// forgotten are listed by their ID.
+func (s *Server) writeTickets(w http.ResponseWriter, r *http.Request, tickets []jobs.Job, extra map[string]any) {
+	finished := true
+	for _, t := range tickets {
+		if t.State != "" && !t.State.Finished() {
+			finished = false
+		}
+	}
+	status := http.StatusAccepted
+	if wantsWait(r) || finished {
+		status = http.StatusOK
+		held := false
+		for i, t := range tickets {
+			if t.State == "" {
+				continue
+			}
+			job, err := s.jobs.Wait(r.Context(), t.ID)
+			if err != nil {
+				s.log.Warn("submit/order: job=%s caller gone while %s: %v", t.ID, t.State, err)
+				return
+			}
+			switch job.State {
+			case jobs.Done:
+			case jobs.Held:
+				held = true
+			default:
+				status = http.StatusInternalServerError
+			}
+			tickets[i] = job
+		}
+		if held && status == http.StatusOK {
+			status = http.StatusAccepted
+		}
+	}
+	resp := []map[string]any{}
+	for _, job := range tickets {
+		if job.State == "" {
+			resp = append(resp, map[string]any{"job_id": job.ID})
+			continue
+		}
+		ticket := map[string]any{"station": job.Tags[0], "job_id": job.ID, "state": job.State, "printer": job.Printer}
+		if job.Group != "" {
+			ticket["group"] = job.Group
+		}
+		if job.Route != "" {
+			ticket["route"] = job.Route
+		}
+		if job.Error != "" {
+			ticket["error"] = job.Error
+		}
+		resp = append(resp, ticket)
+	}
+	body := map[string]any{"ok": status != http.StatusInternalServerError, "tickets": resp}
+	for k, v := range extra {
+		body[k] = v
+	}
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	writeJSON(w, body)
+}
+
+// orderStations returns the stations o's items are tagged with, in the
+// order they first appear; each gets a ticket.
+func orderStations(o *order) ([]string, error) {
+	var stations []string
+	for i, item := range o.Items {
+		if item.Name == "" || len(item.Tags) == 0 {
+			return nil, fmt.Errorf("order item %d needs a name and a station tag", i+1)
+		}
+		for _, tag := range item.Tags {
+			if !slices.Contains(stations, tag) {
+				stations = append(stations, tag)
+			}
+		}
+	}
+	if len(stations) == 0 {
+		return nil, errors.New("order has no items")
+	}
+	return stations, nil
+}
+
+// orderTicket lays out the items of o that station prepares.
+func orderTicket(o *order, station string, at time.Time) *printing.Document {
+	doc := &printing.Document{Blocks: []printing.Block{
+		{Type: printing.BlockText, Align: "center", Text: strings.ToUpper(station), Bold: true, Width: 2, Height: 2},
+	}}
+	if o.Number != "" {
+		doc.Blocks = append(doc.Blocks, printing.Block{Type: printing.BlockText, Align: "center", Text: "Order " + o.Number, Bold: true, Height: 2})
+	}
+	if o.Note != "" {
+		doc.Blocks = append(doc.Blocks, printing.Block{Type: printing.BlockText, Align: "center", Text: o.Note})
+	}
+	doc.Blocks = append(doc.Blocks,
+		printing.Block{Type: printing.BlockText, Align: "center", Text: at.Format("2006-01-02 15:04")},
+		printing.Block{Type: printing.BlockDivider},
+	)
+	for _, item := range o.Items {
+		if !slices.Contains(item.Tags, station) {
+			continue
+		}
+		qty := item.Quantity
+		if qty < 1 {
+			qty = 1
+		}
+		doc.Blocks = append(doc.Blocks, printing.Block{Type: printing.BlockText, Text: fmt.Sprintf("%d x %s", qty, item.Name), Bold: true, Height: 2})
+		for _, note := range item.Notes {
+			doc.Blocks = append(doc.Blocks, printing.Block{Type: printing.BlockText, Text: "  - " + note})
+		}
+	}
+	return doc
+}
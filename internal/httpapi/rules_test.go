@@ -0,0 +1,233 @@
+package httpapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"ble-printer-bridge/internal/ble"
+	"ble-printer-bridge/internal/config"
+	"ble-printer-bridge/internal/jobs"
+	"ble-printer-bridge/internal/printing"
+)
+
+func TestParseHours(t *testing.T) {
+	tests := []struct {
+		in       string
+		from, to int
+		wantErr  bool
+	}{
+		{in: "11:00-15:00", from: 11 * 60, to: 15 * 60},
+		{in: "22:00-06:00", from: 22 * 60, to: 6 * 60},
+		{in: " 09:30 - 24:00 ", from: 9*60 + 30, to: 24 * 60},
+		{in: "0:00-0:01", from: 0, to: 1},
+		{in: "11:00", wantErr: true},
+		{in: "11:00-11:00", wantErr: true},
+		{in: "11:60-12:00", wantErr: true},
+		{in: "24:01-01:00", wantErr: true},
+		{in: "-1:00-02:00", wantErr: true},
+		{in: "noon-13:00", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		from, to, err := parseHours(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseHours(%q) = %d, %d; want an error", tt.in, from, to)
+			}
+			continue
+		}
+		if err != nil || from != tt.from || to != tt.to {
+			t.Errorf("parseHours(%q) = %d, %d, %v; want %d, %d", tt.in, from, to, err, tt.from, tt.to)
+		}
+	}
+}
+
+func TestInHours(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2024, 3, 1, h, m, 0, 0, time.Local) }
+	tests := []struct {
+		hours string
+		at    time.Time
+		want  bool
+	}{
+		{hours: "11:00-15:00", at: at(11, 0), want: true},
+		{hours: "11:00-15:00", at: at(14, 59), want: true},
+		{hours: "11:00-15:00", at: at(15, 0), want: false},
+		{hours: "11:00-15:00", at: at(10, 59), want: false},
+		{hours: "22:00-06:00", at: at(22, 0), want: true},
+		{hours: "22:00-06:00", at: at(23, 59), want: true},
+		{hours: "22:00-06:00", at: at(0, 0), want: true},
+		{hours: "22:00-06:00", at: at(5, 59), want: true},
+		{hours: "22:00-06:00", at: at(6, 0), want: false},
+		{hours: "22:00-06:00", at: at(12, 0), want: false},
+		{hours: "18:00-24:00", at: at(23, 59), want: true},
+		{hours: "18:00-24:00", at: at(0, 0), want: false},
+	}
+	for _, tt := range tests {
+		from, to, err := parseHours(tt.hours)
+		if err != nil {
+			t.Fatalf("parseHours(%q): %v", tt.hours, err)
+		}
+		if got := inHours(from, to, tt.at); got != tt.want {
+			t.Errorf("inHours(%s, %s) = %v, want %v", tt.hours, tt.at.Format("15:04"), got, tt.want)
+		}
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	routes := []config.Route{
+		{Name: "grill", Tags: []string{"grill", "hot"}, Printer: "kitchen"},
+		{Name: "night", Hours: "22:00-06:00", Printer: "bar"},
+		{Keys: []string{"pos"}, Origins: []string{"https://*.shop.example"}, Printer: "counter"},
+		{Name: "fallback", Printer: "office"},
+	}
+	noon := time.Date(2024, 3, 1, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name    string
+		req     routeRequest
+		want    int
+		wantWhy string
+	}{
+		{
+			name:    "tag, case-insensitive",
+			req:     routeRequest{tags: []string{"cold", "GRILL"}, at: noon},
+			want:    0,
+			wantWhy: `route "grill" (tag=GRILL) -> kitchen`,
+		},
+		{
+			name:    "hours past midnight",
+			req:     routeRequest{at: time.Date(2024, 3, 1, 2, 30, 0, 0, time.Local)},
+			want:    1,
+			wantWhy: `route "night" (hours=22:00-06:00) -> bar`,
+		},
+		{
+			name:    "key and origin",
+			req:     routeRequest{key: "pos", origin: "https://till.shop.example/", at: noon},
+			want:    2,
+			wantWhy: "route #3 (key=pos, origin=https://till.shop.example) -> counter",
+		},
+		{
+			name:    "key without its origin",
+			req:     routeRequest{key: "pos", origin: "https://other.example", at: noon},
+			want:    3,
+			wantWhy: `route "fallback" (any request) -> office`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i, why, err := matchRoute(routes, tt.req)
+			if err != nil || i != tt.want || why != tt.wantWhy {
+				t.Fatalf("matchRoute = %d, %q, %v; want %d, %q", i, why, err, tt.want, tt.wantWhy)
+			}
+		})
+	}
+
+	if i, _, err := matchRoute(routes[:1], routeRequest{tags: []string{"bar"}, at: noon}); i != -1 || err != nil {
+		t.Fatalf("unmatched request = %d, %v; want -1", i, err)
+	}
+	bad := []config.Route{{Name: "broken", Hours: "late", Printer: "bar"}}
+	if _, _, err := matchRoute(bad, routeRequest{at: noon}); err == nil || !strings.Contains(err.Error(), `route "broken"`) {
+		t.Fatalf("invalid hours = %v, want an error naming the route", err)
+	}
+}
+
+func TestOrderFanOut(t *testing.T) {
+	o := &order{Number: "1042", Note: "Table 7", Items: []orderItem{
+		{Name: "Burger", Quantity: 2, Notes: []string{"no onions"}, Tags: []string{"grill"}},
+		{Name: "Mojito", Tags: []string{"bar"}},
+		{Name: "Fries", Tags: []string{"grill", "expo"}},
+	}}
+	stations, err := orderStations(o)
+	if err != nil {
+		t.Fatalf("orderStations: %v", err)
+	}
+	if want := []string{"grill", "bar", "expo"}; !slices.Equal(stations, want) {
+		t.Fatalf("stations = %v, want %v", stations, want)
+	}
+
+	at := time.Date(2024, 3, 1, 19, 5, 0, 0, time.Local)
+	want := map[string][]string{
+		"grill": {"GRILL", "Order 1042", "Table 7", "2024-03-01 19:05", "", "2 x Burger", "  - no onions", "1 x Fries"},
+		"bar":   {"BAR", "Order 1042", "Table 7", "2024-03-01 19:05", "", "1 x Mojito"},
+		"expo":  {"EXPO", "Order 1042", "Table 7", "2024-03-01 19:05", "", "1 x Fries"},
+	}
+	for _, station := range stations {
+		var got []string
+		for _, b := range orderTicket(o, station, at).Blocks {
+			if b.Type == printing.BlockDivider {
+				got = append(got, "")
+				continue
+			}
+			got = append(got, b.Text)
+		}
+		if !slices.Equal(got, want[station]) {
+			t.Errorf("%s ticket = %q, want %q", station, got, want[station])
+		}
+	}
+
+	for _, bad := range []*order{
+		{Items: nil},
+		{Items: []orderItem{{Name: "Burger"}}},
+		{Items: []orderItem{{Tags: []string{"grill"}}}},
+	} {
+		if _, err := orderStations(bad); err == nil {
+			t.Errorf("orderStations(%+v) = nil error", bad.Items)
+		}
+	}
+}
+
+func TestOrderFailingPartwayQueuesNothing(t *testing.T) {
+	cfg := config.Config{}
+	config.ApplyDefaults(&cfg)
+	cfg.Printers = []config.NamedPrinter{{Name: "kitchen", Address: "AA:BB:CC:DD:EE:01"}, {Name: "bar", Address: "AA:BB:CC:DD:EE:02"}}
+	cfg.Routes = []config.Route{
+		{Tags: []string{"grill", "expo"}, Printer: "kitchen"},
+		{Tags: []string{"bar"}, Printer: "bar"},
+	}
+	s := &Server{cfg: &cfg, log: newTestLogger(t), client: &ble.Client{}}
+
+	// Both printers are offline and the bar already holds as many jobs as
+	// it may, so the bar ticket fails as it is queued. The journal is
+	// closed then, which makes queuing the expo ticket after it fail.
+	var q *jobs.Queue
+	q, err := jobs.OpenQueue(t.TempDir(), func(jobs.Job, func(int) error) error { return nil }, jobs.Options{
+		Hold:    true,
+		HoldMax: 1,
+		Logf:    func(string, ...any) {},
+		OnFinish: func(job jobs.Job) {
+			if job.Printer == "bar" && job.State == jobs.Failed {
+				q.Close()
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("open queue: %v", err)
+	}
+	s.jobs = q
+	q.SetOnline("kitchen", false)
+	q.SetOnline("bar", false)
+	if _, err := q.Submit(jobs.Job{Printer: "bar", Tag: "print/raw", Data: []byte("x")}); err != nil {
+		t.Fatalf("submit: %v", err)
+	}
+
+	body := `{"order":{"number":"1042","items":[{"name":"Burger","tags":["grill"]},{"name":"Mojito","tags":["bar"]},{"name":"Fries","tags":["expo"]}]}}`
+	req := httptest.NewRequest(http.MethodPost, "http://127.0.0.1/submit", strings.NewReader(body))
+	req.Header.Set("Idempotency-Key", "order-1042")
+	rec := httptest.NewRecorder()
+	s.idempotent(s.submitJob)(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500: %s", rec.Code, rec.Body)
+	}
+
+	for _, job := range q.List(jobs.Filter{Reference: "1042"}) {
+		if !job.State.Finished() {
+			t.Errorf("ticket %s for %s is %s; want it cancelled", job.ID, job.Printer, job.State)
+		}
+	}
+	if job, found, err := q.Claim("/order-1042", "retry"); found || err != nil {
+		t.Fatalf("Claim after the failed order = %s, %v, %v; want the key released", job.ID, found, err)
+	}
+}
@@ -1,8 +1,10 @@
 package httpapi
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"sync"
@@ -10,17 +12,28 @@ import (
 
 	"ble-printer-bridge/internal/ble"
 	"ble-printer-bridge/internal/config"
+	"ble-printer-bridge/internal/jobs"
 	"ble-printer-bridge/internal/logging"
+	"ble-printer-bridge/internal/logos"
 	"ble-printer-bridge/internal/printing"
+	"ble-printer-bridge/internal/templates"
+	"ble-printer-bridge/internal/webhooks"
 )
 
 type Server struct {
-	cfg     *config.Config
-	cfgPath string
-	log     *logging.Logger
-	client  *ble.Client
-	cors    *corsConfig
-	cfgMu   sync.RWMutex
+	cfg       *config.Config
+	cfgPath   string
+	log       *logging.Logger
+	client    *ble.Client
+	templates *templates.Store
+	logos     *logos.Store
+	fonts     fontCache
+	jobs      *jobs.Queue
+	webhooks  *webhooks.Outbox
+	cors      *corsConfig
+	cfgMu     sync.RWMutex
+
+	printers printerSet
 }
 
 func NewServer(cfg *config.Config, cfgPath string, log *logging.Logger) *Server {
@@ -29,8 +42,24 @@ func NewServer(cfg *config.Config, cfgPath string, log *logging.Logger) *Server
 	} else {
 		log.Info("ble adapter enabled")
 	}
-	srv := &Server{cfg: cfg, cfgPath: cfgPath, log: log, client: &ble.Client{}}
+	srv := &Server{
+		cfg:       cfg,
+		cfgPath:   cfgPath,
+		log:       log,
+		client:    &ble.Client{},
+		templates: templates.NewStore(cfg.Templates.Dir),
+		logos:     logos.NewStore(cfg.Logos.Dir),
+	}
 	srv.cors = newCORSConfig(cfg, log)
+	srv.checkRoutes(cfg)
+	srv.webhooks = srv.newOutbox(cfg)
+	srv.jobs = srv.newJobQueue(cfg)
+	if addr, err := ble.NormalizeAddress(cfg.BLE.PrinterAddress); err == nil {
+		srv.adoptUnaddressed(addr)
+	}
+	if cfg.Jobs.HoldOffline {
+		go srv.reconnect()
+	}
 	return srv
 }
 
@@ -50,11 +79,53 @@ func (s *Server) Run() error {
 	mux.HandleFunc("/ble/describe", s.withRequestLog(s.requireAuth(s.describe)))
 
 	// Print endpoints
-	mux.HandleFunc("/print/text", s.withRequestLog(s.requireAuth(s.printText)))
-	mux.HandleFunc("/print/raw", s.withRequestLog(s.requireAuth(s.printRaw)))
+	mux.HandleFunc("/print/text", s.withRequestLog(s.requireAuth(s.idempotent(s.printText))))
+	mux.HandleFunc("/print/raw", s.withRequestLog(s.requireAuth(s.idempotent(s.printRaw))))
+	mux.HandleFunc("/print/template/{name}", s.withRequestLog(s.requireAuth(s.idempotent(s.printTemplate))))
+	mux.HandleFunc("/print/label", s.withRequestLog(s.requireAuth(s.idempotent(s.printLabel))))
+	mux.HandleFunc("/print/document", s.withRequestLog(s.requireAuth(s.idempotent(s.printDocument))))
+	mux.HandleFunc("/print/zpl", s.withRequestLog(s.requireAuth(s.idempotent(s.printZPL))))
+	mux.HandleFunc("/print/test-page", s.withRequestLog(s.requireAuth(s.idempotent(s.printTestPage))))
+	mux.HandleFunc("/print/markdown", s.withRequestLog(s.requireAuth(s.idempotent(s.printMarkdown))))
+	mux.HandleFunc("/submit", s.withRequestLog(s.requireAuth(s.idempotent(s.submitJob))))
+
+	// Printer endpoints
+	mux.HandleFunc("/printers", s.withRequestLog(s.requireAuth(s.listPrinters)))
+	mux.HandleFunc("/printers/{id}/calibrate", s.withRequestLog(s.requireAuth(s.idempotent(s.calibrate))))
+	mux.HandleFunc("/printers/{id}/pause", s.withRequestLog(s.requireAuth(s.pausePrinter)))
+	mux.HandleFunc("/printers/{id}/resume", s.withRequestLog(s.requireAuth(s.resumePrinter)))
+
+	// Job endpoints
+	mux.HandleFunc("/jobs", s.withRequestLog(s.requireAuth(s.listJobs)))
+	mux.HandleFunc("/jobs/{id}", s.withRequestLog(s.requireAuth(s.jobHandler)))
+	mux.HandleFunc("/jobs/{id}/requeue", s.withRequestLog(s.requireAuth(s.requeueJob)))
+	mux.HandleFunc("/jobs/{id}/reprint", s.withRequestLog(s.requireAuth(s.idempotent(s.reprintJob))))
+
+	// Webhook endpoints
+	mux.HandleFunc("/webhooks/deliveries", s.withRequestLog(s.requireAuth(s.listDeliveries)))
+	mux.HandleFunc("/webhooks/deliveries/{id}", s.withRequestLog(s.requireAuth(s.getDelivery)))
+	mux.HandleFunc("/webhooks/deliveries/{id}/retry", s.withRequestLog(s.requireAuth(s.retryDelivery)))
+
+	// Template endpoints
+	mux.HandleFunc("/templates", s.withRequestLog(s.requireAuth(s.templatesHandler)))
+	mux.HandleFunc("/templates/{name}", s.withRequestLog(s.requireAuth(s.templateHandler)))
+
+	// Logo endpoints; uploads and deletes need an admin key
+	mux.HandleFunc("/logos", s.withRequestLog(s.requireAuth(s.logosHandler)))
+	mux.HandleFunc("/logos/{key}", s.withRequestLog(s.requireAuth(s.logoHandler)))
+
+	// Preview endpoints render the same payloads as PNG without printing
+	mux.HandleFunc("/preview/text", s.withRequestLog(s.requireAuth(previewOnly(s.printText))))
+	mux.HandleFunc("/preview/raw", s.withRequestLog(s.requireAuth(previewOnly(s.printRaw))))
+	mux.HandleFunc("/preview/template/{name}", s.withRequestLog(s.requireAuth(previewOnly(s.printTemplate))))
+	mux.HandleFunc("/preview/label", s.withRequestLog(s.requireAuth(previewOnly(s.printLabel))))
+	mux.HandleFunc("/preview/document", s.withRequestLog(s.requireAuth(previewOnly(s.printDocument))))
+	mux.HandleFunc("/preview/zpl", s.withRequestLog(s.requireAuth(previewOnly(s.printZPL))))
+	mux.HandleFunc("/preview/test-page", s.withRequestLog(s.requireAuth(previewOnly(s.printTestPage))))
+	mux.HandleFunc("/preview/markdown", s.withRequestLog(s.requireAuth(previewOnly(s.printMarkdown))))
 
 	// Config endpoints
-	mux.HandleFunc("/config", s.withRequestLog(s.requireAuth(s.configHandler)))
+	mux.HandleFunc("/config", s.withRequestLog(s.requireAuth(s.requireAdmin(s.configHandler))))
 
 	cfg := s.configSnapshot()
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
@@ -80,11 +151,24 @@ func (s *Server) withRequestLog(next http.HandlerFunc) http.HandlerFunc {
 
 func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get("x-api-key") != s.currentAPIKey() {
+		key, ok := s.lookupAPIKey(r.Header.Get("x-api-key"))
+		if !ok {
 			s.log.Warn("unauthorized %s %s", r.Method, r.URL.Path)
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
 			return
 		}
+		next(w, r.WithContext(context.WithValue(r.Context(), apiKeyCtx{}, key)))
+	}
+}
+
+// requireAdmin rejects requests authenticated with a non-admin key.
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if key := requestKey(r); !key.Admin {
+			s.log.Warn("forbidden %s %s: key %q is not an admin key", r.Method, r.URL.Path, key.Name)
+			http.Error(w, "forbidden: admin key required", http.StatusForbidden)
+			return
+		}
 		next(w, r)
 	}
 }
@@ -151,6 +235,9 @@ func (s *Server) connect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	s.log.Info("ble connect ok: address=%s", normalizedAddress)
+	s.adoptUnaddressed(normalizedAddress)
+	s.notePrinter(normalizedAddress, true)
+	s.initHead(s.configSnapshot())
 	writeJSON(w, map[string]any{"ok": true})
 }
 
@@ -189,9 +276,19 @@ func (s *Server) status(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
+	cfg := s.configSnapshot()
 	connected := s.client.IsConnected()
-	s.log.Info("ble status: connected=%v", connected)
-	writeJSON(w, map[string]any{"ok": true, "connected": connected})
+	printer := s.printerID(cfg)
+	s.notePrinter(printer, connected)
+	queue := s.jobs.Status(printer)
+	resp := map[string]any{"ok": true, "connected": connected, "queue": queue}
+	if connected {
+		if paper := s.checkPaper(cfg, printer, s.client); paper != "" {
+			resp["paper"] = paper
+		}
+	}
+	s.log.Info("ble status: connected=%v paused=%v queued=%d", connected, queue.Paused, queue.Queued)
+	writeJSON(w, resp)
 }
 
 func (s *Server) disconnect(w http.ResponseWriter, r *http.Request) {
@@ -200,12 +297,14 @@ func (s *Server) disconnect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	s.log.Info("ble disconnect start")
+	printer := s.printerID(s.configSnapshot())
 	if err := s.client.Disconnect(); err != nil {
 		s.log.Error("ble disconnect error: %v", err)
 		http.Error(w, err.Error(), 500)
 		return
 	}
 	s.log.Info("ble disconnect ok")
+	s.notePrinter(printer, false)
 	writeJSON(w, map[string]any{"ok": true, "connected": false})
 }
 
@@ -233,28 +332,58 @@ func (s *Server) printText(w http.ResponseWriter, r *http.Request) {
 	cfg := s.configSnapshot()
 	var req struct {
 		Text string `json:"text"`
+		Wrap *bool  `json:"wrap"`
+		// Logo is the key of a stored logo printed centered above the text.
+		Logo string `json:"logo"`
+		finishRequest
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "invalid body", 400)
 		return
 	}
+	finish, err := req.finish(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	driver, ok := s.receiptDriver(w, r, cfg, "print/text")
+	if !ok {
+		return
+	}
 
-	data := printing.TextReceipt(req.Text)
-	s.log.Info("print/text: bytes=%d chunk=%d with_response=%v", len(data), cfg.BLE.ChunkSize, cfg.BLE.WriteWithResponse)
+	opts, err := s.textOptions(cfg)
+	if err != nil {
+		s.log.Error("print/text: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	opts.Finish = finish
 
-	if err := s.client.Print(
-		cfg.BLE.ServiceUUID,
-		cfg.BLE.WriteCharacteristicUUID,
-		data,
-		cfg.BLE.ChunkSize,
-		cfg.BLE.WriteWithResponse,
-	); err != nil {
-		s.log.Error("print/text error: %v", err)
-		http.Error(w, err.Error(), 500)
+	refs := s.newLogoJob(r, cfg, driver, nil)
+	if req.Logo != "" {
+		logo, err := refs.logo(req.Logo)
+		if err != nil {
+			s.writeLogoError(w, req.Logo, err)
+			return
+		}
+		opts.Header = append(append(driver.Align(printing.AlignCenter), logo...), driver.Align(printing.AlignLeft)...)
+	}
+
+	text := req.Text
+	if req.Wrap == nil || *req.Wrap {
+		text = layoutFor(cfg).WrapText(text)
+	}
+	data, err := printing.EncodeText(driver, text, opts)
+	if err != nil {
+		s.log.Warn("print/text rejected: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	s.log.Info("print/text ok")
-	writeJSON(w, map[string]any{"ok": true})
+	job, ok := s.uploadLogos(w, "print/text", refs, jobs.Job{Printer: s.printerID(cfg), Tag: "print/text", Data: data})
+	if !ok {
+		return
+	}
+	s.deliverJob(w, r, cfg, job)
 }
 
 func (s *Server) printRaw(w http.ResponseWriter, r *http.Request) {
@@ -276,21 +405,268 @@ func (s *Server) printRaw(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.log.Info("print/raw: bytes=%d chunk=%d with_response=%v", len(data), cfg.BLE.ChunkSize, cfg.BLE.WriteWithResponse)
+	// Raw payloads are passed through untouched, whatever the printer
+	// speaks, once the key's policy has accepted them. The policy's
+	// command filter only reads ESC/POS.
+	data, extra, ok := s.filterRaw(w, r, cfg, data)
+	if !ok {
+		return
+	}
+	if isPreview(r) {
+		s.writePreview(w, r, cfg, data, extra)
+		return
+	}
+	s.sendWithExtra(w, r, cfg, "print/raw", data, extra)
+}
+
+// deliver prints a receipt job encoded with the driver from receiptDriver,
+// or renders it when the request is a preview, and writes the HTTP
+// response. Raster-only printers receive the rendered receipt in their own
+// protocol.
+func (s *Server) deliver(w http.ResponseWriter, r *http.Request, cfg config.Config, tag string, data []byte) {
+	s.deliverWithExtra(w, r, cfg, tag, data, nil)
+}
+
+// deliverWithExtra is deliver with extra fields merged into the JSON
+// response.
+func (s *Server) deliverWithExtra(w http.ResponseWriter, r *http.Request, cfg config.Config, tag string, data []byte, extra map[string]any) {
+	s.deliverJob(w, r, cfg, jobs.Job{Printer: s.printerID(cfg), Tag: tag, Data: data, Result: extra})
+}
+
+// deliverJob is deliver for a job the caller has filled in further, such
+// as with tags.
+func (s *Server) deliverJob(w http.ResponseWriter, r *http.Request, cfg config.Config, job jobs.Job) {
+	if isPreview(r) {
+		s.writePreview(w, r, cfg, job.Data, job.Result)
+		return
+	}
+	job.Data = receiptPayload(cfg, job.Data)
+	s.submit(w, r, job)
+}
+
+// receiptPayload rasterizes an ESC/POS receipt for cat printers, which
+// cannot read it; other printers get it as is.
+func receiptPayload(cfg config.Config, data []byte) []byte {
+	if lang, _ := printing.ParseLanguage(cfg.Printer.CommandLanguage); lang == printing.LanguageCat {
+		img := printing.PreviewESCPOS(data, printing.PrintableDots(cfg.Printer.PaperWidthMM)).Bitmap
+		return printing.EncodeCatRaster(img, catOptions(cfg))
+	}
+	return data
+}
+
+// send queues an encoded job for the printer and writes the HTTP response.
+func (s *Server) send(w http.ResponseWriter, r *http.Request, cfg config.Config, tag string, data []byte) {
+	s.sendWithExtra(w, r, cfg, tag, data, nil)
+}
+
+// sendWithExtra is send with extra fields merged into the JSON response.
+// The job is answered as queued (202) with its ID; with ?wait=true the
+// response waits until it has printed, as before the queue existed.
+func (s *Server) sendWithExtra(w http.ResponseWriter, r *http.Request, cfg config.Config, tag string, data []byte, extra map[string]any) {
+	s.submit(w, r, jobs.Job{Printer: s.printerID(cfg), Tag: tag, Data: data, Result: extra})
+}
+
+// submit queues job with the request's idempotency key, ?tags, ?printer
+// (a configured printer or group; by default the one the routes pick, or
+// the current printer), ?callback_url, ?reference, ?priority and
+// ?not_before, which set the job's place in the queue, and writes the
+// HTTP response.
+func (s *Server) submit(w http.ResponseWriter, r *http.Request, job jobs.Job) {
+	job, status, err := s.enqueue(r, job)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	s.writeJob(w, r, job, nil)
+}
+
+// enqueue is submit without the response: it queues job with the
+// request's options, or returns the status to reject the request with.
+func (s *Server) enqueue(r *http.Request, job jobs.Job) (jobs.Job, int, error) {
+	var err error
+	job.Priority, job.NotBefore, err = jobSchedule(r)
+	if err != nil {
+		return job, http.StatusBadRequest, err
+	}
+	job.Tags = append(job.Tags, queryTags(r)...)
+	if status, err := s.routeJob(r, &job); err != nil {
+		return job, status, err
+	}
+	if callback := r.URL.Query().Get("callback_url"); callback != "" {
+		if err := webhooks.CheckURL(callback, s.configSnapshot().Webhooks.AllowHosts); err != nil {
+			return job, http.StatusBadRequest, err
+		}
+		job.CallbackURL = callback
+	}
+	if ref := r.URL.Query().Get("reference"); ref != "" {
+		job.Reference = ref
+	}
+	if len(job.Reference) > maxReference {
+		return job, http.StatusBadRequest, errors.New("reference is longer than 255 characters")
+	}
+	job.IdempotencyKey = idempotencyKey(r)
+	queued, err := s.jobs.Submit(job)
+	if err != nil {
+		s.log.Error("%s error: %v", job.Tag, err)
+		return job, http.StatusInternalServerError, err
+	}
+	job = queued
+	s.log.Info("%s: job=%s bytes=%d priority=%d ref=%q printer=%s %s", job.Tag, job.ID, job.Bytes, job.Priority, job.Reference, job.Printer, job.State)
+	return job, 0, nil
+}
 
-	if err := s.client.Print(
+// sendToPrinter writes an encoded job to the connected printer using the
+// BLE settings from cfg.
+func (s *Server) sendToPrinter(cfg config.Config, data []byte) error {
+	return s.client.Print(
 		cfg.BLE.ServiceUUID,
 		cfg.BLE.WriteCharacteristicUUID,
 		data,
 		cfg.BLE.ChunkSize,
 		cfg.BLE.WriteWithResponse,
-	); err != nil {
-		s.log.Error("print/raw error: %v", err)
-		http.Error(w, err.Error(), 500)
-		return
+	)
+}
+
+// receiptDriver returns the driver receipt-style jobs are encoded with:
+// ESC/POS for previews and cat printers (which deliver rasterizes), the
+// printer's own dialect otherwise. Printers configured with a label command
+// language are rejected.
+func (s *Server) receiptDriver(w http.ResponseWriter, r *http.Request, cfg config.Config, tag string) (printing.Driver, bool) {
+	lang, err := printing.ParseLanguage(cfg.Printer.CommandLanguage)
+	if err != nil {
+		s.log.Error("%s: %v", tag, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
 	}
-	s.log.Info("print/raw ok")
-	writeJSON(w, map[string]any{"ok": true})
+	if !lang.IsReceipt() {
+		s.log.Warn("%s rejected: printer command language is %s", tag, lang)
+		http.Error(w, fmt.Sprintf("printer command language is %s; use /print/label or /print/raw", lang), http.StatusBadRequest)
+		return nil, false
+	}
+	if isPreview(r) {
+		return printing.ESCPOSDriver{}, true
+	}
+	driver, err := printing.NewDriver(lang)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	if lang == printing.LanguageESCPOS && !*cfg.Printer.PageMode {
+		driver = printing.ESCPOSDriver{NoPageMode: true}
+	}
+	return driver, true
+}
+
+// maxCopies bounds the copies a single receipt request may ask for.
+const maxCopies = 20
+
+// finishRequest holds per-request overrides of the printer profile's
+// receipt finishing.
+type finishRequest struct {
+	FeedLines   *int    `json:"feed_lines"`
+	Cut         *string `json:"cut"`
+	Copies      *int    `json:"copies"`
+	Reset       *bool   `json:"reset"`
+	OpenDrawer  *bool   `json:"open_drawer"`
+	DrawerPin   *int    `json:"drawer_pin"`
+	DrawerOnMS  *int    `json:"drawer_on_ms"`
+	DrawerOffMS *int    `json:"drawer_off_ms"`
+	// Density and Speed override the profile's head settings for this
+	// job.
+	Density *int `json:"density"`
+	Speed   *int `json:"speed"`
+}
+
+// finish merges the request's overrides over the profile in cfg.
+func (req finishRequest) finish(cfg config.Config) (printing.Finish, error) {
+	p := cfg.Printer
+	pick := func(v *int, def int) int {
+		if v != nil {
+			return *v
+		}
+		return def
+	}
+	cutName := p.Cut
+	if req.Cut != nil {
+		cutName = *req.Cut
+	}
+	cut, err := printing.ParseCutMode(cutName)
+	if err != nil {
+		return printing.Finish{}, err
+	}
+	f := printing.Finish{
+		FeedLines: pick(req.FeedLines, p.FeedLines),
+		Cut:       cut,
+		Copies:    pick(req.Copies, p.Copies),
+		NoReset:   p.Reset != nil && !*p.Reset,
+	}
+	if req.Reset != nil {
+		f.NoReset = !*req.Reset
+	}
+	if f.FeedLines < 0 || f.FeedLines > 255 {
+		return printing.Finish{}, fmt.Errorf("feed_lines must be between 0 and 255")
+	}
+	if f.Copies < 1 || f.Copies > maxCopies {
+		return printing.Finish{}, fmt.Errorf("copies must be between 1 and %d", maxCopies)
+	}
+	f.Head = headSettings(cfg).Merge(printing.HeadSettings{Density: req.Density, Speed: pick(req.Speed, 0)})
+	if err := f.Head.Validate(); err != nil {
+		return printing.Finish{}, err
+	}
+
+	open := p.DrawerKick
+	if req.OpenDrawer != nil {
+		open = *req.OpenDrawer
+	}
+	if open {
+		k := printing.DrawerKick{
+			Pin:   pick(req.DrawerPin, p.DrawerPin),
+			OnMS:  pick(req.DrawerOnMS, p.DrawerOnMS),
+			OffMS: pick(req.DrawerOffMS, p.DrawerOffMS),
+		}
+		if k.Pin != 2 && k.Pin != 5 {
+			return printing.Finish{}, fmt.Errorf("drawer_pin must be 2 or 5")
+		}
+		if k.OnMS <= 0 || k.OffMS <= 0 {
+			return printing.Finish{}, fmt.Errorf("drawer_on_ms and drawer_off_ms must be positive")
+		}
+		f.Drawer = &k
+	}
+	return f, nil
+}
+
+// headSettings returns the printer profile's head settings.
+func headSettings(cfg config.Config) printing.HeadSettings {
+	p := cfg.Printer
+	return printing.HeadSettings{
+		Density:      p.Density,
+		Speed:        p.Speed,
+		HeatDots:     p.HeatDots,
+		HeatTime:     p.HeatTime,
+		HeatInterval: p.HeatInterval,
+	}
+}
+
+// catOptions returns the cat printer head settings from cfg. The energy
+// follows printer.density unless cat_energy sets it outright.
+func catOptions(cfg config.Config) printing.CatOptions {
+	opts := printing.DefaultCatOptions
+	opts.HeadDots = printing.PrintableDots(cfg.Printer.PaperWidthMM)
+	if cfg.Printer.Density != nil {
+		opts.Energy = printing.CatEnergy(*cfg.Printer.Density)
+	}
+	if cfg.Printer.CatEnergy > 0 {
+		opts.Energy = uint16(min(cfg.Printer.CatEnergy, 0xFFFF))
+	}
+	if cfg.Printer.CatSpeed > 0 {
+		opts.Speed = byte(min(cfg.Printer.CatSpeed, 0xFF))
+	}
+	return opts
+}
+
+// layoutFor returns the text layout for the configured paper and font.
+func layoutFor(cfg config.Config) printing.Layout {
+	return printing.NewLayout(cfg.Printer.PaperWidthMM, printing.ParseFont(cfg.Printer.Font))
 }
 
 func (s *Server) configHandler(w http.ResponseWriter, r *http.Request) {
@@ -316,6 +692,10 @@ func (s *Server) setConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	config.ApplyDefaults(&next)
+	if err := config.Validate(&next); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err := config.Save(s.cfgPath, &next); err != nil {
 		s.log.Error("config save error: %v", err)
 		http.Error(w, "config save failed", http.StatusInternalServerError)
@@ -326,10 +706,41 @@ func (s *Server) setConfig(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, map[string]any{"ok": true})
 }
 
-func (s *Server) currentAPIKey() string {
+type apiKeyCtx struct{}
+
+// apiKey is the identity a request authenticated as.
+type apiKey struct {
+	Name      string
+	Admin     bool
+	RawPolicy config.RawPolicy
+}
+
+// lookupAPIKey matches a presented key against auth.api_key, which is an
+// admin key, and the additional auth.keys.
+func (s *Server) lookupAPIKey(presented string) (apiKey, bool) {
 	s.cfgMu.RLock()
 	defer s.cfgMu.RUnlock()
-	return s.cfg.Auth.ApiKey
+	auth := s.cfg.Auth
+	if presented == auth.ApiKey {
+		return apiKey{Name: "default", Admin: true, RawPolicy: auth.RawPolicy}, true
+	}
+	for _, k := range auth.Keys {
+		if k.Key == "" || k.Key != presented {
+			continue
+		}
+		policy := auth.RawPolicy
+		if k.RawPolicy != nil {
+			policy = *k.RawPolicy
+		}
+		return apiKey{Name: k.Name, Admin: k.Admin, RawPolicy: policy}, true
+	}
+	return apiKey{}, false
+}
+
+// requestKey returns the key requireAuth attached to r.
+func requestKey(r *http.Request) apiKey {
+	key, _ := r.Context().Value(apiKeyCtx{}).(apiKey)
+	return key
 }
 
 func (s *Server) configSnapshot() config.Config {
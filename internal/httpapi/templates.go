@@ -0,0 +1,157 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"text/template"
+
+	"ble-printer-bridge/internal/jobs"
+	"ble-printer-bridge/internal/printing"
+	"ble-printer-bridge/internal/templates"
+)
+
+func (s *Server) templatesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		list, err := s.templates.List()
+		if err != nil {
+			s.log.Error("templates list error: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]any{"ok": true, "templates": list})
+	case http.MethodPost:
+		var req struct {
+			Name string `json:"name"`
+			Body string `json:"body"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+			http.Error(w, `invalid body: {"name":"receipt","body":"..."}`, http.StatusBadRequest)
+			return
+		}
+		s.saveTemplate(w, req.Name, req.Body)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) templateHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	switch r.Method {
+	case http.MethodGet:
+		body, err := s.templates.Get(name)
+		if err != nil {
+			s.writeTemplateError(w, name, err)
+			return
+		}
+		writeJSON(w, map[string]any{"ok": true, "name": name, "body": body})
+	case http.MethodPut:
+		var req struct {
+			Body string `json:"body"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `invalid body: {"body":"..."}`, http.StatusBadRequest)
+			return
+		}
+		s.saveTemplate(w, name, req.Body)
+	case http.MethodDelete:
+		if err := s.templates.Delete(name); err != nil {
+			s.writeTemplateError(w, name, err)
+			return
+		}
+		s.log.Info("template deleted: name=%s", name)
+		writeJSON(w, map[string]any{"ok": true})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) saveTemplate(w http.ResponseWriter, name, body string) {
+	if err := s.templates.Save(name, body); err != nil {
+		s.writeTemplateError(w, name, err)
+		return
+	}
+	s.log.Info("template saved: name=%s bytes=%d", name, len(body))
+	writeJSON(w, map[string]any{"ok": true, "name": name})
+}
+
+func (s *Server) printTemplate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := s.configSnapshot()
+	name := r.PathValue("name")
+	var req struct {
+		Data    any  `json:"data"`
+		Preview bool `json:"preview"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, `invalid body: {"data":{...},"preview":false}`, http.StatusBadRequest)
+		return
+	}
+	if req.Preview {
+		r = withPreview(r)
+	}
+	driver, ok := s.receiptDriver(w, r, cfg, "print/template")
+	if !ok {
+		return
+	}
+
+	finish, err := finishRequest{}.finish(cfg)
+	if err != nil {
+		s.log.Error("print/template: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	layout := layoutFor(cfg)
+	refs := s.newLogoJob(r, cfg, driver, nil)
+	text, err := s.templates.Render(name, req.Data, layout, driver, refs.logo)
+	if err != nil {
+		s.writeTemplateError(w, name, err)
+		return
+	}
+	data, err := printing.EncodeText(driver, text, printing.TextOptions{Finish: finish})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if isPreview(r) {
+		plain, err := s.templates.Render(name, req.Data, layout, nil, nil)
+		if err != nil {
+			s.writeTemplateError(w, name, err)
+			return
+		}
+		s.writePreview(w, r, cfg, data, map[string]any{"text": plain})
+		return
+	}
+	job, ok := s.uploadLogos(w, "print/template", refs, jobs.Job{Printer: s.printerID(cfg), Tag: "print/template", Data: data})
+	if !ok {
+		return
+	}
+	s.deliverJob(w, r, cfg, job)
+}
+
+func (s *Server) writeTemplateError(w http.ResponseWriter, name string, err error) {
+	var (
+		compileErr *templates.CompileError
+		execErr    template.ExecError
+	)
+	switch {
+	case errors.Is(err, templates.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, templates.ErrInvalidName):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.As(err, &compileErr):
+		s.log.Warn("template compile error: name=%s err=%v", name, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.As(err, &execErr):
+		s.log.Warn("template render error: name=%s err=%v", name, err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	default:
+		s.log.Error("template error: name=%s err=%v", name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
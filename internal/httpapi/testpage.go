@@ -0,0 +1,222 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+
+	"ble-printer-bridge/internal/config"
+	"ble-printer-bridge/internal/printing"
+)
+
+// Version is the bridge version printed on the test page. Release builds
+// set it with -ldflags "-X ble-printer-bridge/internal/httpapi.Version=1.4.0".
+var Version = ""
+
+// bridgeVersion returns Version, or the VCS revision the binary was built
+// from.
+func bridgeVersion() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" && len(s.Value) >= 7 {
+				return "dev-" + s.Value[:7]
+			}
+		}
+	}
+	return "dev"
+}
+
+// testPageSections are the parts of the test page, in print order.
+var testPageSections = []string{"info", "code_page", "styles", "barcode", "qrcode", "raster", "cut"}
+
+// printTestPage prints a diagnostics receipt that exercises each encoder:
+// the bridge and BLE settings, a code page table, text styles, a barcode,
+// a QR code and a raster gradient, then the profile's cut. The body may
+// name the sections to print; sections the printer's dialect cannot
+// encode are skipped and reported.
+func (s *Server) printTestPage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := s.configSnapshot()
+	var req struct {
+		Sections []string `json:"sections"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, `invalid body: {"sections":["info","styles"]}`, http.StatusBadRequest)
+		return
+	}
+	want := map[string]bool{}
+	for _, name := range req.Sections {
+		if !slices.Contains(testPageSections, name) {
+			http.Error(w, fmt.Sprintf("unknown section %q (want %s)", name, strings.Join(testPageSections, ", ")), http.StatusBadRequest)
+			return
+		}
+		want[name] = true
+	}
+	if len(want) == 0 {
+		for _, name := range testPageSections {
+			want[name] = true
+		}
+	}
+	driver, ok := s.receiptDriver(w, r, cfg, "print/test-page")
+	if !ok {
+		return
+	}
+	finish, err := finishRequest{}.finish(cfg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var body bytes.Buffer
+	sent := []string{}
+	skipped := map[string]string{}
+	for _, name := range testPageSections[:len(testPageSections)-1] {
+		if !want[name] {
+			continue
+		}
+		data, err := s.testPageSection(cfg, driver, name)
+		if err != nil {
+			skipped[name] = err.Error()
+			continue
+		}
+		body.Write(data)
+		body.Write(driver.Feed(1))
+		sent = append(sent, name)
+	}
+	if want["cut"] && finish.Cut != printing.CutNone {
+		sent = append(sent, "cut")
+	} else {
+		finish.Cut = printing.CutNone
+	}
+	finish.Copies = 1
+	s.log.Info("print/test-page: sections=%v skipped=%v", sent, skipped)
+	s.deliverWithExtra(w, r, cfg, "print/test-page", finish.Apply(driver, body.Bytes()), map[string]any{
+		"sections": sent,
+		"skipped":  skipped,
+	})
+}
+
+func (s *Server) testPageSection(cfg config.Config, d printing.Driver, name string) ([]byte, error) {
+	var b bytes.Buffer
+	width := printing.PrintableDots(cfg.Printer.PaperWidthMM)
+	heading := func(title string) {
+		b.Write(d.Style(printing.PlainStyle, printing.TextStyle{Width: 1, Height: 1, Bold: true}))
+		b.WriteString(title + "\n")
+		b.Write(d.Style(printing.TextStyle{Width: 1, Height: 1, Bold: true}, printing.PlainStyle))
+	}
+
+	switch name {
+	case "info":
+		b.Write(d.Align(printing.AlignCenter))
+		b.Write(d.Style(printing.PlainStyle, printing.TextStyle{Width: 2, Height: 2, Bold: true}))
+		b.WriteString("TEST PAGE\n")
+		b.Write(d.Style(printing.TextStyle{Width: 2, Height: 2, Bold: true}, printing.PlainStyle))
+		b.Write(d.Align(printing.AlignLeft))
+		writeMode := "without response"
+		if cfg.BLE.WriteWithResponse {
+			writeMode = "with response"
+		}
+		lines := []string{
+			"Bridge   " + bridgeVersion(),
+			"Printer  " + s.printerID(cfg),
+			"Service  " + cfg.BLE.ServiceUUID,
+			"Write    " + cfg.BLE.WriteCharacteristicUUID,
+			fmt.Sprintf("Chunk    %d bytes, %s", cfg.BLE.ChunkSize, writeMode),
+			fmt.Sprintf("Language %s", d.Language()),
+			fmt.Sprintf("Paper    %d mm, %d dots", cfg.Printer.PaperWidthMM, width),
+			"Printed  " + time.Now().Format("2006-01-02 15:04:05"),
+		}
+		l := layoutFor(cfg)
+		for _, line := range lines {
+			b.WriteString(l.WrapText(line) + "\n")
+		}
+	case "code_page":
+		cp, ok := printing.LookupCodePage(cfg.Printer.CodePage)
+		if !ok {
+			return nil, fmt.Errorf("unknown code page %q", cfg.Printer.CodePage)
+		}
+		cmd, err := d.CodePage(cp)
+		if err != nil {
+			return nil, err
+		}
+		heading(fmt.Sprintf("Code page %s (%d)", cp.Name, cp.Number))
+		b.Write(cmd)
+		for hi := 0x8; hi <= 0xf; hi++ {
+			fmt.Fprintf(&b, "%X_ ", hi)
+			for lo := 0; lo < 16; lo++ {
+				b.WriteByte(byte(hi<<4 | lo))
+			}
+			b.WriteByte('\n')
+		}
+	case "styles":
+		heading("Styles")
+		samples := []struct {
+			name  string
+			style printing.TextStyle
+		}{
+			{"Normal", printing.PlainStyle},
+			{"Bold", printing.TextStyle{Width: 1, Height: 1, Bold: true}},
+			{"Underline", printing.TextStyle{Width: 1, Height: 1, Underline: 1}},
+			{"Inverted", printing.TextStyle{Width: 1, Height: 1, Invert: true}},
+			{"Font B", printing.TextStyle{Font: printing.FontB, Width: 1, Height: 1}},
+			{"Double width", printing.TextStyle{Width: 2, Height: 1}},
+			{"Double height", printing.TextStyle{Width: 1, Height: 2}},
+			{"Upside down", printing.TextStyle{Width: 1, Height: 1, UpsideDown: true}},
+		}
+		for _, sample := range samples {
+			b.Write(d.Style(printing.PlainStyle, sample.style))
+			b.WriteString(sample.name)
+			b.Write(d.Style(sample.style, printing.PlainStyle))
+			b.WriteByte('\n')
+		}
+		b.Write(d.Align(printing.AlignCenter))
+		b.WriteString("Center\n")
+		b.Write(d.Align(printing.AlignRight))
+		b.WriteString("Right\n")
+		b.Write(d.Align(printing.AlignLeft))
+	case "barcode":
+		data, err := d.Barcode(printing.Barcode{Symbology: "code128", Data: "TEST-123", Height: 60, HRI: true})
+		if err != nil {
+			return nil, err
+		}
+		heading("CODE128")
+		b.Write(d.Align(printing.AlignCenter))
+		b.Write(data)
+		b.Write(d.Align(printing.AlignLeft))
+	case "qrcode":
+		data, err := d.QRCode(printing.QRCode{Data: "ble-printer-bridge " + bridgeVersion(), ModuleSize: 5})
+		if err != nil {
+			return nil, err
+		}
+		heading("QR code")
+		b.Write(d.Align(printing.AlignCenter))
+		b.Write(data)
+		b.Write(d.Align(printing.AlignLeft))
+	case "raster":
+		heading("Raster gradient")
+		const height = 48
+		gray := image.NewGray(image.Rect(0, 0, width, height))
+		for x := 0; x < width; x++ {
+			for y := 0; y < height; y++ {
+				gray.SetGray(x, y, color.Gray{Y: uint8(255 * x / max(width-1, 1))})
+			}
+		}
+		b.Write(d.Raster(printing.BitmapFromImage(gray, width, true)))
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,108 @@
+package httpapi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"ble-printer-bridge/internal/ble"
+	"ble-printer-bridge/internal/config"
+	"ble-printer-bridge/internal/printing"
+)
+
+func TestPrintTestPagePreview(t *testing.T) {
+	tests := []struct {
+		name        string
+		codePage    string
+		body        string
+		wantStatus  int
+		wantSent    []string
+		wantSkipped []string
+		wantKinds   []printing.ElementKind
+		notKinds    []printing.ElementKind
+	}{
+		{
+			name:        "all sections",
+			codePage:    "nope",
+			wantStatus:  http.StatusOK,
+			wantSent:    []string{"info", "styles", "barcode", "qrcode", "raster", "cut"},
+			wantSkipped: []string{"code_page"},
+			wantKinds:   []printing.ElementKind{printing.ElementText, printing.ElementBarcode, printing.ElementQRCode, printing.ElementImage, printing.ElementCut},
+		},
+		{
+			name:       "filtered",
+			codePage:   "cp437",
+			body:       `{"sections":["barcode","info"]}`,
+			wantStatus: http.StatusOK,
+			wantSent:   []string{"info", "barcode"},
+			wantKinds:  []printing.ElementKind{printing.ElementText, printing.ElementBarcode},
+			notKinds:   []printing.ElementKind{printing.ElementQRCode, printing.ElementImage, printing.ElementCut},
+		},
+		{
+			name:       "unknown section",
+			codePage:   "cp437",
+			body:       `{"sections":["logo"]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := config.Config{}
+			config.ApplyDefaults(&cfg)
+			cfg.Printer.CodePage = tt.codePage
+			s := &Server{cfg: &cfg, log: newTestLogger(t), client: &ble.Client{}}
+
+			req := httptest.NewRequest(http.MethodPost, "http://127.0.0.1/print/test-page?preview=true", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			s.printTestPage(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Sections []string          `json:"sections"`
+				Skipped  map[string]string `json:"skipped"`
+				Base64   string            `json:"base64"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if !slices.Equal(resp.Sections, tt.wantSent) {
+				t.Fatalf("sections = %v, want %v", resp.Sections, tt.wantSent)
+			}
+			var skipped []string
+			for name := range resp.Skipped {
+				skipped = append(skipped, name)
+			}
+			if !slices.Equal(skipped, tt.wantSkipped) {
+				t.Fatalf("skipped = %v, want %v", resp.Skipped, tt.wantSkipped)
+			}
+
+			data, err := base64.StdEncoding.DecodeString(resp.Base64)
+			if err != nil {
+				t.Fatalf("decode payload: %v", err)
+			}
+			kinds := map[printing.ElementKind]bool{}
+			for _, e := range printing.DecodeESCPOS(data).Elements {
+				kinds[e.Kind] = true
+			}
+			for _, k := range tt.wantKinds {
+				if !kinds[k] {
+					t.Errorf("page has no %s element", k)
+				}
+			}
+			for _, k := range tt.notKinds {
+				if kinds[k] {
+					t.Errorf("page has a %s element from a section that was not asked for", k)
+				}
+			}
+		})
+	}
+}
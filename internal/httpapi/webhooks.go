@@ -0,0 +1,203 @@
+package httpapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"ble-printer-bridge/internal/ble"
+	"ble-printer-bridge/internal/config"
+	"ble-printer-bridge/internal/jobs"
+	"ble-printer-bridge/internal/printing"
+	"ble-printer-bridge/internal/webhooks"
+)
+
+// newOutbox opens the webhook outbox journaled in webhooks.dir, falling
+// back to one kept in memory like newJobQueue.
+func (s *Server) newOutbox(cfg *config.Config) *webhooks.Outbox {
+	opts := webhooks.Options{
+		Secret:        cfg.Webhooks.Secret,
+		MaxAttempts:   cfg.Webhooks.MaxAttempts,
+		RetryDelay:    time.Duration(cfg.Webhooks.RetryDelayMS) * time.Millisecond,
+		MaxRetryDelay: time.Duration(cfg.Webhooks.MaxRetryDelaySeconds) * time.Second,
+		Timeout:       time.Duration(cfg.Webhooks.TimeoutMS) * time.Millisecond,
+		KeepFinished:  cfg.Webhooks.KeepFinished,
+		Concurrency:   cfg.Webhooks.Concurrency,
+		AllowHosts:    cfg.Webhooks.AllowHosts,
+		Logf:          s.log.Warn,
+	}
+	for _, sub := range cfg.Webhooks.Subscriptions {
+		if err := webhooks.CheckURL(sub.URL, cfg.Webhooks.AllowHosts); err != nil {
+			s.log.Error("webhook subscription %q: %v", sub.URL, err)
+		}
+		for _, typ := range sub.Events {
+			if !slices.Contains(webhooks.Types, typ) {
+				s.log.Error("webhook subscription %q: unknown event %q", sub.URL, typ)
+			}
+		}
+	}
+	o, err := webhooks.Open(cfg.Webhooks.Dir, opts)
+	if err != nil {
+		s.log.Error("%v; pending webhooks will not survive a restart", err)
+		return webhooks.New(opts)
+	}
+	s.log.Info("webhook outbox opened: dir=%s subscriptions=%d", cfg.Webhooks.Dir, len(cfg.Webhooks.Subscriptions))
+	return o
+}
+
+// emit posts an event to every subscription that wants it and to
+// callback, if set.
+func (s *Server) emit(typ string, data any, callback string) {
+	urls := []string{}
+	for _, sub := range s.configSnapshot().Webhooks.Subscriptions {
+		if len(sub.Events) == 0 || slices.Contains(sub.Events, typ) {
+			urls = append(urls, sub.URL)
+		}
+	}
+	if callback != "" && !slices.Contains(urls, callback) {
+		urls = append(urls, callback)
+	}
+	for _, u := range urls {
+		d, err := s.webhooks.Add(u, typ, data)
+		if err != nil {
+			s.log.Error("webhook %s to %s: %v", typ, u, err)
+			continue
+		}
+		s.log.Info("webhook queued: event=%s delivery=%s url=%s", typ, d.ID, u)
+	}
+}
+
+// jobFinished is the queue's OnFinish: it records the logos a printed job
+// stored and announces the job as done, failed or cancelled.
+func (s *Server) jobFinished(job jobs.Job) {
+	s.recordLogos(job)
+	typ := webhooks.JobDone
+	switch job.State {
+	case jobs.Failed:
+		typ = webhooks.JobFailed
+	case jobs.Cancelled:
+		typ = webhooks.JobCancelled
+	}
+	s.emit(typ, job, job.CallbackURL)
+}
+
+// notePrinter records whether a printer is connected, for the queue to
+// hold or release its jobs, and announces the change when it differs from
+// what was last seen.
+func (s *Server) notePrinter(printer string, connected bool) {
+	s.jobs.SetOnline(printer, connected)
+	s.printers.mu.Lock()
+	st := s.printers.state(printer)
+	changed := st.known && st.up != connected || !st.known && connected
+	st.known, st.up = true, connected
+	s.printers.mu.Unlock()
+	if !changed {
+		return
+	}
+	typ := webhooks.PrinterDisconnected
+	if connected {
+		typ = webhooks.PrinterConnected
+	}
+	s.emit(typ, map[string]any{"printer": printer, "connected": connected}, "")
+}
+
+// paperQueryTimeout bounds the wait for the printer's paper status.
+const paperQueryTimeout = time.Second
+
+// checkPaper asks an ESC/POS printer for its paper sensor status when
+// ble.notify_characteristic_uuid is set, and announces printer.paper_out
+// when the paper has run out since it was last seen loaded. It returns ""
+// when the status is not known.
+func (s *Server) checkPaper(cfg config.Config, printer string, client *ble.Client) string {
+	if lang, _ := printing.ParseLanguage(cfg.Printer.CommandLanguage); cfg.BLE.NotifyCharacteristicUUID == "" || lang != printing.LanguageESCPOS {
+		return ""
+	}
+	answer, err := client.Query(cfg.BLE.ServiceUUID, cfg.BLE.WriteCharacteristicUUID, cfg.BLE.NotifyCharacteristicUUID, printing.PaperStatusQuery, paperQueryTimeout)
+	if err != nil {
+		s.log.Warn("paper status: printer=%s %v", printer, err)
+		return ""
+	}
+	paper, ok := printing.ParsePaperStatus(answer)
+	if !ok {
+		s.log.Warn("paper status: printer=%s unexpected answer % x", printer, answer)
+		return ""
+	}
+	s.printers.mu.Lock()
+	st := s.printers.state(printer)
+	ranOut := paper == printing.PaperOut && !st.paperOut
+	st.paperOut = paper == printing.PaperOut
+	s.printers.mu.Unlock()
+	if ranOut {
+		s.log.Warn("paper out: printer=%s", printer)
+		s.emit(webhooks.PrinterPaperOut, map[string]any{"printer": printer, "paper": paper}, "")
+	}
+	return paper
+}
+
+// listDeliveries lists webhook deliveries with their attempts, filtered
+// by ?state; ?limit keeps the most recent.
+func (s *Server) listDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	state := webhooks.State(r.URL.Query().Get("state"))
+	switch state {
+	case "", webhooks.Pending, webhooks.Delivered, webhooks.Failed:
+	default:
+		http.Error(w, fmt.Sprintf("unknown state %q", state), http.StatusBadRequest)
+		return
+	}
+	limit := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, fmt.Sprintf("invalid limit %q", v), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+	writeJSON(w, map[string]any{"ok": true, "deliveries": s.webhooks.List(state, limit)})
+}
+
+func (s *Server) getDelivery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d, err := s.webhooks.Get(r.PathValue("id"))
+	if err != nil {
+		writeDeliveryError(w, err)
+		return
+	}
+	writeJSON(w, map[string]any{"ok": true, "delivery": d})
+}
+
+// retryDelivery sends a failed delivery again.
+func (s *Server) retryDelivery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	d, err := s.webhooks.Retry(r.PathValue("id"))
+	if err != nil {
+		writeDeliveryError(w, err)
+		return
+	}
+	s.log.Info("webhook retried: delivery=%s url=%s", d.ID, d.URL)
+	writeJSON(w, map[string]any{"ok": true, "delivery": d})
+}
+
+func writeDeliveryError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, webhooks.ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, webhooks.ErrState):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
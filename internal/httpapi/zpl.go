@@ -0,0 +1,149 @@
+package httpapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"ble-printer-bridge/internal/config"
+	"ble-printer-bridge/internal/printing"
+)
+
+// printZPL renders ZPL II to bitmaps and prints them with the configured
+// printer's raster support. The body is either JSON ({"zpl": "..."} plus
+// media settings for label printers) or the ZPL itself.
+func (s *Server) printZPL(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cfg := s.configSnapshot()
+	var req struct {
+		ZPL string `json:"zpl"`
+		// Media settings for TSPL and CPCL printers, as for /print/label.
+		Sensor       string  `json:"sensor"`
+		GapMM        float64 `json:"gap_mm"`
+		MarkOffsetMM float64 `json:"mark_offset_mm"`
+	}
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("content-type")); ct == "application/json" {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, `invalid body: {"zpl":"^XA...^XZ"}`, http.StatusBadRequest)
+			return
+		}
+	} else {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "invalid body", http.StatusBadRequest)
+			return
+		}
+		req.ZPL = string(body)
+	}
+	lang, err := printing.ParseLanguage(cfg.Printer.CommandLanguage)
+	if err != nil {
+		s.log.Error("print/zpl: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fonts, err := s.fonts.get(cfg, s.log)
+	if err != nil {
+		s.log.Error("print/zpl: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	res, err := printing.RenderZPL(req.ZPL, printing.ZPLOptions{
+		WidthDots: printing.PrintableDots(cfg.Printer.PaperWidthMM),
+		Fonts:     fonts,
+	})
+	if err != nil {
+		s.log.Error("print/zpl: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(res.Labels) == 0 {
+		http.Error(w, "no ^XA..^XZ label format found", http.StatusBadRequest)
+		return
+	}
+	if len(res.Warnings) > 0 {
+		s.log.Warn("print/zpl: %d unsupported commands ignored", len(res.Warnings))
+	}
+	media := printing.Label{Sensor: req.Sensor, GapMM: req.GapMM, MarkOffsetMM: req.MarkOffsetMM}
+	data, err := encodeZPLLabels(cfg, lang, media, res.Labels)
+	if err != nil {
+		s.log.Warn("print/zpl rejected: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	extra := map[string]any{"labels": len(res.Labels), "unsupported": res.Warnings}
+	if res.Warnings == nil {
+		extra["unsupported"] = []printing.DecodeWarning{}
+	}
+	if isPreview(r) {
+		bitmaps := make([]*printing.Bitmap, len(res.Labels))
+		for i, l := range res.Labels {
+			bitmaps[i] = l.Bitmap
+		}
+		s.writeRendered(w, r, data, stackBitmaps(bitmaps), res.Warnings, extra)
+		return
+	}
+	s.sendWithExtra(w, r, cfg, "print/zpl", data, extra)
+}
+
+// encodeZPLLabels encodes rendered labels for lang: as a bitmap label page
+// for TSPL and CPCL, framed raster for cat printers, and a raster receipt
+// finished per the printer profile otherwise.
+func encodeZPLLabels(cfg config.Config, lang printing.Language, media printing.Label, labels []printing.ZPLLabel) ([]byte, error) {
+	var b bytes.Buffer
+	for i, l := range labels {
+		if lang != printing.LanguageTSPL && lang != printing.LanguageCPCL && l.Copies > maxCopies {
+			return nil, fmt.Errorf("label %d: ^PQ asks for %d copies; at most %d are printed on %s printers", i+1, l.Copies, maxCopies, lang)
+		}
+		switch lang {
+		case printing.LanguageTSPL, printing.LanguageCPCL:
+			media.Copies = l.Copies
+			data, err := printing.EncodeLabelBitmap(lang, media, l.Bitmap)
+			if err != nil {
+				return nil, err
+			}
+			b.Write(data)
+		case printing.LanguageCat:
+			job := printing.EncodeCatRaster(l.Bitmap, catOptions(cfg))
+			for n := 0; n < l.Copies; n++ {
+				b.Write(job)
+			}
+		default:
+			driver, err := printing.NewDriver(lang)
+			if err != nil {
+				return nil, err
+			}
+			finish, err := finishRequest{}.finish(cfg)
+			if err != nil {
+				return nil, err
+			}
+			finish.Copies = l.Copies
+			b.Write(finish.Apply(driver, driver.Raster(l.Bitmap)))
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// stackBitmaps draws labels one below the other for a preview.
+func stackBitmaps(labels []*printing.Bitmap) *printing.Bitmap {
+	const gap = 16
+	width, height := 0, 0
+	for _, l := range labels {
+		width = max(width, l.Width)
+		height += l.Height + gap
+	}
+	out := printing.NewBitmap(width, height-gap)
+	y := 0
+	for _, l := range labels {
+		out.Draw(l, 0, y)
+		y += l.Height + gap
+	}
+	return out
+}
@@ -0,0 +1,1035 @@
+// Package jobs queues encoded print jobs and sends them to their printers
+// in the background, one worker per printer. A queue opened on a directory
+// keeps its jobs in a journal there, so they survive restarts.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// State is where a job is in its life.
+type State string
+
+const (
+	// Scheduled jobs wait for their NotBefore time; they are queued
+	// once it has passed.
+	Scheduled State = "scheduled"
+	Queued    State = "queued"
+	// Held jobs wait for their printer, which is offline, to come back;
+	// they are queued again, in order, once it does.
+	Held    State = "held"
+	Sending State = "sending"
+	// Interrupted jobs were being sent when the bridge stopped. They are
+	// held until an operator requeues or cancels them, since the printer
+	// may already have printed part of them.
+	Interrupted State = "interrupted"
+	Done        State = "done"
+	Failed      State = "failed"
+	Cancelled   State = "cancelled"
+)
+
+// Finished reports whether a job in state s will not be sent again.
+func (s State) Finished() bool {
+	return s == Done || s == Failed || s == Cancelled
+}
+
+// keepsData reports whether a job in state s holds its payload: until it
+// finishes, and afterwards if it printed so it can be reprinted.
+func (s State) keepsData() bool {
+	return !s.Finished() || s == Done
+}
+
+var (
+	ErrNotFound = errors.New("job not found")
+	ErrState    = errors.New("job cannot be changed in its state")
+	// ErrKeyReused is returned for an idempotency key sent again with a
+	// different request, ErrKeyInFlight while the key's first request is
+	// still being handled.
+	ErrKeyReused   = errors.New("idempotency key was used with a different request")
+	ErrKeyInFlight = errors.New("a request with this idempotency key is in progress")
+	// ErrCancelled is returned by a progress callback to abort a job
+	// that has been cancelled.
+	ErrCancelled = errors.New("job cancelled")
+	// ErrOffline is wrapped by a SendFunc's error when the printer cannot
+	// be reached at all; with Options.Hold the job is held rather than
+	// retried.
+	ErrOffline = errors.New("printer offline")
+)
+
+// Job is one payload for one printer.
+type Job struct {
+	ID      string `json:"id"`
+	Printer string `json:"printer"`
+	// Group names the printer group the job was sent to; Printer is the
+	// member it is queued on, or the one that printed it, and Tried the
+	// members it has failed on.
+	Group string   `json:"group,omitempty"`
+	Tried []string `json:"tried,omitempty"`
+	// Tags are the caller's labels for the job, such as the station an
+	// order ticket is for, and Route how its printer was chosen.
+	Tags  []string `json:"tags,omitempty"`
+	Route string   `json:"route,omitempty"`
+	// Tag names the endpoint that submitted the job, e.g. "print/text".
+	Tag            string `json:"tag"`
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Reference is the caller's own name for the job, such as an order
+	// number, for finding it in the history.
+	Reference string `json:"reference,omitempty"`
+	// ReprintOf is the ID of the job this one reprints.
+	ReprintOf string `json:"reprint_of,omitempty"`
+	// CallbackURL is told when the job finishes.
+	CallbackURL string `json:"callback_url,omitempty"`
+	State       State  `json:"state"`
+	// Priority orders a printer's queued jobs, highest first; jobs of
+	// equal priority print in submission order.
+	Priority int `json:"priority"`
+	// NotBefore holds the job until the given time.
+	NotBefore *time.Time `json:"not_before,omitempty"`
+	Attempts  int        `json:"attempts"`
+	Bytes     int        `json:"bytes"`
+	BytesSent int        `json:"bytes_sent"`
+	Error     string     `json:"error,omitempty"`
+	// Result holds the endpoint's extra response fields, returned with
+	// the job.
+	Result     map[string]any `json:"result,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	StartedAt  *time.Time     `json:"started_at,omitempty"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+
+	Data []byte `json:"-"`
+}
+
+// SendFunc writes a job's payload to its printer, calling progress with
+// the total bytes written after each chunk. When progress returns an error
+// the sender stops and returns it.
+type SendFunc func(job Job, progress func(sent int) error) error
+
+// Options tune a Queue.
+type Options struct {
+	// MaxAttempts is how often a job is tried. A job is only retried when
+	// nothing of it reached the printer, so a retry never prints half a
+	// receipt twice.
+	MaxAttempts int
+	RetryDelay  time.Duration
+	// KeepFinished is how many finished jobs are kept for lookup and
+	// reprinting, and Retention how long; zero keeps them until
+	// KeepFinished is reached.
+	KeepFinished int
+	Retention    time.Duration
+	// KeyWindow is how long an idempotency key answers with its job.
+	KeyWindow time.Duration
+	// Aging raises a waiting job's priority by one for every Aging it
+	// has been due, so low-priority jobs are not starved; zero disables
+	// it.
+	Aging time.Duration
+	// Logf reports journal write failures; the queue carries on in
+	// memory.
+	Logf func(format string, args ...any)
+	// Reroute, when set, is asked where a job that failed should go
+	// next; Tried already holds the printer it failed on. A printer other
+	// than the job's moves it to the front of that printer's queue, while
+	// "" retries or fails it as usual. It is called without the queue
+	// locked.
+	Reroute func(job Job, err error) string
+	// OnFinish, when set, is called with each job as it finishes. It is
+	// called once the queue is unlocked again, so it may block or call
+	// back into the queue, but it holds up the caller that finished the
+	// job.
+	OnFinish func(job Job)
+	// Hold keeps the jobs of a printer that is offline, as SetOnline or a
+	// send failing with ErrOffline tells, until it is back online instead
+	// of failing them. A job fails once it has waited longer than HoldAge
+	// or when its printer already holds HoldMax jobs; zero is no limit.
+	Hold    bool
+	HoldAge time.Duration
+	HoldMax int
+}
+
+// Queue holds the jobs of every printer.
+type Queue struct {
+	send    SendFunc
+	opts    Options
+	journal *journal
+
+	mu       sync.Mutex
+	jobs     map[string]*entry
+	finished []string
+	printers map[string]*printerQueue
+	keys     map[string]*keyRecord
+
+	// restoring holds back the workers of the printers OpenQueue restores
+	// until their journal has been rewritten.
+	restoring bool
+	// finishing is the jobs finished while q.mu was held, for unlock to
+	// pass to OnFinish.
+	finishing []Job
+}
+
+type entry struct {
+	job  Job
+	done chan struct{}
+	// held is closed while the job is Held.
+	held chan struct{}
+	// cancel is set when the job is cancelled while it is being sent;
+	// tail is then sent after the chunk in flight.
+	cancel bool
+	tail   []byte
+}
+
+// printerQueue is the pending jobs of one printer, in submission order.
+type printerQueue struct {
+	pending []*entry
+	paused  bool
+	// offline holds the printer's jobs, with Options.Hold.
+	offline bool
+	sending *entry
+	wake    chan struct{}
+}
+
+// PrinterStatus describes one printer's queue.
+type PrinterStatus struct {
+	Printer string `json:"printer"`
+	Paused  bool   `json:"paused"`
+	// Offline is set while the printer's jobs are held for it; Held
+	// counts them.
+	Offline bool `json:"offline"`
+	Held    int  `json:"held"`
+	Queued  int  `json:"queued"`
+	// Scheduled counts the queued jobs that are not yet due.
+	Scheduled int `json:"scheduled"`
+	// Sending is the ID of the job being sent, if any.
+	Sending string `json:"sending,omitempty"`
+}
+
+// NewQueue returns a queue that keeps its jobs in memory.
+func NewQueue(send SendFunc, opts Options) *Queue {
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	if opts.KeepFinished < 1 {
+		opts.KeepFinished = 500
+	}
+	if opts.Logf == nil {
+		opts.Logf = func(string, ...any) {}
+	}
+	return &Queue{
+		send:     send,
+		opts:     opts,
+		jobs:     map[string]*entry{},
+		printers: map[string]*printerQueue{},
+		keys:     map[string]*keyRecord{},
+	}
+}
+
+// OpenQueue returns a queue journaled in dir, with the jobs, idempotency
+// keys and paused printers it held when the bridge last stopped: queued
+// jobs are sent again in their order, jobs that were being sent are marked
+// Interrupted.
+func OpenQueue(dir string, send SendFunc, opts Options) (*Queue, error) {
+	j, st, err := openJournal(dir)
+	if err != nil {
+		return nil, fmt.Errorf("job journal: %w", err)
+	}
+	q := NewQueue(send, opts)
+	q.journal = j
+
+	q.mu.Lock()
+	defer q.unlock()
+	q.restoring = true
+	for _, printer := range st.paused {
+		q.printer(printer).paused = true
+	}
+	var finished []Job
+	var offline []string
+	for _, job := range st.jobs {
+		e := &entry{job: job, done: make(chan struct{}), held: make(chan struct{})}
+		q.jobs[job.ID] = e
+		switch {
+		case job.State.Finished():
+			close(e.done)
+			finished = append(finished, job)
+		case job.State == Sending:
+			e.job.State = Interrupted
+			e.job.Error = "the bridge stopped while the job was being sent; requeue or cancel it"
+			q.persist(e, false)
+		case job.State == Held:
+			// The printer is taken to be offline still, until SetOnline
+			// says otherwise.
+			e.job.State = Queued
+			if q.opts.Hold {
+				offline = append(offline, job.Printer)
+			} else {
+				q.persist(e, false)
+			}
+			q.enqueue(e)
+		case job.State == Queued, job.State == Scheduled:
+			q.enqueue(e)
+		}
+	}
+	for _, printer := range offline {
+		q.hold(q.printer(printer))
+	}
+	sort.Slice(finished, func(a, b int) bool { return finished[a].FinishedAt.Before(*finished[b].FinishedAt) })
+	for _, job := range finished {
+		q.finished = append(q.finished, job.ID)
+	}
+	for i := range st.keys {
+		q.keys[st.keys[i].Key] = &st.keys[i]
+	}
+	q.prune()
+	if err := q.rewrite(); err != nil {
+		j.Close()
+		return nil, fmt.Errorf("job journal: %w", err)
+	}
+	q.restoring = false
+	for _, pq := range q.printers {
+		go q.work(pq)
+	}
+	return q, nil
+}
+
+// Close closes the journal.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.unlock()
+	if q.journal == nil {
+		return nil
+	}
+	return q.journal.Close()
+}
+
+// Submit queues job, which names its printer, tag, payload and result and
+// may set its priority and NotBefore, and returns it as queued or
+// scheduled, or held while its printer is offline; a printer that already
+// holds HoldMax jobs fails it. A job with an IdempotencyKey binds the key
+// claimed for its request. Submit fails only when the job cannot be
+// journaled.
+func (q *Queue) Submit(job Job) (Job, error) {
+	job.ID = newID()
+	job.State = Queued
+	job.Bytes = len(job.Data)
+	job.CreatedAt = time.Now()
+	if job.NotBefore != nil && job.NotBefore.After(job.CreatedAt) {
+		job.State = Scheduled
+	} else {
+		job.NotBefore = nil
+	}
+	e := &entry{job: job, done: make(chan struct{}), held: make(chan struct{})}
+
+	q.mu.Lock()
+	defer q.unlock()
+	if q.journal != nil {
+		if err := q.journal.put(e.job, true); err != nil {
+			return Job{}, fmt.Errorf("job journal: %w", err)
+		}
+	}
+	q.jobs[e.job.ID] = e
+	if k := q.keys[job.IdempotencyKey]; k != nil && k.Job == "" {
+		if k.batch {
+			k.Jobs = append(k.Jobs, job.ID)
+		} else {
+			k.Job = job.ID
+			q.bindKey(k)
+		}
+	}
+	if pq := q.printer(job.Printer); pq.offline && q.opts.HoldMax > 0 {
+		if held := q.status(job.Printer, pq).Held; held >= q.opts.HoldMax {
+			q.finish(e, Failed, fmt.Sprintf("printer %s is offline and already holds %d jobs", job.Printer, held))
+			return e.job, nil
+		}
+	}
+	q.enqueue(e)
+	return e.job, nil
+}
+
+// Claim reserves an idempotency key for a request whose content hashes to
+// hash. If the key has already submitted a job for the same request
+// within KeyWindow, that job is returned with found set; once the job
+// itself has been forgotten only its ID is known. A reserved key is bound
+// by Submit, or freed by Release when the request submits nothing.
+func (q *Queue) Claim(key, hash string) (job Job, found bool, err error) {
+	q.mu.Lock()
+	defer q.unlock()
+	k := q.keys[key]
+	if k != nil && q.opts.KeyWindow > 0 && time.Since(k.At) > q.opts.KeyWindow {
+		delete(q.keys, key)
+		k = nil
+	}
+	switch {
+	case k == nil:
+		q.keys[key] = &keyRecord{Key: key, Hash: hash, At: time.Now()}
+		return Job{}, false, nil
+	case k.Hash != hash:
+		return Job{}, false, ErrKeyReused
+	case k.Job == "":
+		return Job{}, false, ErrKeyInFlight
+	}
+	if e, ok := q.jobs[k.Job]; ok {
+		return e.job, true, nil
+	}
+	return Job{ID: k.Job}, true, nil
+}
+
+// Batch makes a key reserved by Claim collect the jobs Submit is given
+// with it, instead of being bound to the first, until Bind.
+func (q *Queue) Batch(key string) {
+	q.mu.Lock()
+	defer q.unlock()
+	if k := q.keys[key]; k != nil && k.Job == "" {
+		k.batch = true
+	}
+}
+
+// Bind binds a batched key to the jobs submitted with it. A key no job
+// was submitted with stays reserved, for Release.
+func (q *Queue) Bind(key string) {
+	q.mu.Lock()
+	defer q.unlock()
+	if k := q.keys[key]; k != nil && k.Job == "" && len(k.Jobs) > 0 {
+		k.Job = k.Jobs[0]
+		k.batch = false
+		q.bindKey(k)
+	}
+}
+
+// BatchJobs returns the jobs a batched key was bound to, or nil for a key
+// bound to a single job. Jobs that have been forgotten have only their ID.
+func (q *Queue) BatchJobs(key string) []Job {
+	q.mu.Lock()
+	defer q.unlock()
+	k := q.keys[key]
+	if k == nil || k.Job == "" {
+		return nil
+	}
+	var jobs []Job
+	for _, id := range k.Jobs {
+		if e, ok := q.jobs[id]; ok {
+			jobs = append(jobs, e.job)
+		} else {
+			jobs = append(jobs, Job{ID: id})
+		}
+	}
+	return jobs
+}
+
+// bindKey journals a key bound to its jobs. q.mu must be held.
+func (q *Queue) bindKey(k *keyRecord) {
+	if q.journal != nil {
+		if err := q.journal.bindKey(*k); err != nil {
+			q.opts.Logf("job journal: key for job=%s %v", k.Job, err)
+		}
+	}
+}
+
+// Release frees a key reserved by Claim that no job was submitted for.
+func (q *Queue) Release(key string) {
+	q.mu.Lock()
+	defer q.unlock()
+	if k := q.keys[key]; k != nil && k.Job == "" {
+		delete(q.keys, key)
+	}
+}
+
+// Requeue sends an interrupted job again, from the start.
+func (q *Queue) Requeue(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.unlock()
+	e, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	if e.job.State != Interrupted {
+		return e.job, fmt.Errorf("%w: %s", ErrState, e.job.State)
+	}
+	e.job.State = Queued
+	e.job.Error = ""
+	q.persist(e, false)
+	q.enqueue(e)
+	return e.job, nil
+}
+
+// Cancel cancels a job that has not finished. A queued, held or
+// interrupted job is dropped at once. A job being sent stops after the chunk in flight and
+// tail, if any, is sent after it so the paper ends cleanly; the returned
+// job is still Sending and finishes as Cancelled shortly after.
+func (q *Queue) Cancel(id string, tail []byte) (Job, error) {
+	q.mu.Lock()
+	defer q.unlock()
+	e, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	switch e.job.State {
+	case Sending:
+		e.cancel = true
+		e.tail = tail
+	case Queued, Scheduled, Held:
+		pq := q.printers[e.job.Printer]
+		if i := slices.Index(pq.pending, e); i >= 0 {
+			pq.pending = slices.Delete(pq.pending, i, i+1)
+			q.finish(e, Cancelled, "")
+		} else {
+			// Waiting to be retried.
+			e.cancel = true
+		}
+	case Interrupted:
+		q.finish(e, Cancelled, "")
+	default:
+		return e.job, fmt.Errorf("%w: %s", ErrState, e.job.State)
+	}
+	return e.job, nil
+}
+
+// Move hands the jobs waiting in printer from's queue to printer to, after
+// the ones it already has, and returns how many it moved. Jobs held for
+// from are queued again, and held if to is offline.
+func (q *Queue) Move(from, to string) int {
+	q.mu.Lock()
+	defer q.unlock()
+	src := q.printers[from]
+	if src == nil || from == to || len(src.pending) == 0 {
+		return 0
+	}
+	moved := src.pending
+	src.pending = nil
+	for _, e := range moved {
+		e.job.Printer = to
+		if e.job.State == Held {
+			e.job.State = Queued
+			e.held = make(chan struct{})
+		}
+		q.persist(e, false)
+		q.enqueue(e)
+	}
+	return len(moved)
+}
+
+// Pause holds a printer's queue: the job being sent finishes, later ones
+// wait until Resume. The pause is journaled and survives restarts.
+func (q *Queue) Pause(printer string) PrinterStatus {
+	return q.setPaused(printer, true)
+}
+
+// Resume sends a paused printer's jobs again.
+func (q *Queue) Resume(printer string) PrinterStatus {
+	return q.setPaused(printer, false)
+}
+
+func (q *Queue) setPaused(printer string, paused bool) PrinterStatus {
+	q.mu.Lock()
+	defer q.unlock()
+	pq := q.printer(printer)
+	if pq.paused != paused {
+		pq.paused = paused
+		if q.journal != nil {
+			if err := q.journal.pause(printer, paused); err != nil {
+				q.opts.Logf("job journal: %v", err)
+			}
+		}
+		wake(pq)
+	}
+	return q.status(printer, pq)
+}
+
+// Status describes a printer's queue.
+func (q *Queue) Status(printer string) PrinterStatus {
+	q.mu.Lock()
+	defer q.unlock()
+	pq := q.printers[printer]
+	if pq == nil {
+		return PrinterStatus{Printer: printer}
+	}
+	return q.status(printer, pq)
+}
+
+func (q *Queue) status(printer string, pq *printerQueue) PrinterStatus {
+	st := PrinterStatus{Printer: printer, Paused: pq.paused, Offline: pq.offline, Queued: len(pq.pending)}
+	for _, e := range pq.pending {
+		switch e.job.State {
+		case Scheduled:
+			st.Scheduled++
+		case Held:
+			st.Held++
+		}
+	}
+	if pq.sending != nil {
+		st.Sending = pq.sending.job.ID
+	}
+	return st
+}
+
+// printer returns a printer's queue, starting its worker when it is first
+// asked for, unless the queue is being restored. q.mu must be held.
+func (q *Queue) printer(printer string) *printerQueue {
+	pq := q.printers[printer]
+	if pq == nil {
+		pq = &printerQueue{wake: make(chan struct{}, 1)}
+		q.printers[printer] = pq
+		if !q.restoring {
+			go q.work(pq)
+		}
+	}
+	return pq
+}
+
+// enqueue appends e to its printer's pending jobs, held if the printer is
+// offline. q.mu must be held.
+func (q *Queue) enqueue(e *entry) {
+	pq := q.printer(e.job.Printer)
+	pq.pending = append(pq.pending, e)
+	if pq.offline && e.job.State == Queued {
+		q.markHeld(e)
+	}
+	wake(pq)
+}
+
+// SetOnline tells the queue whether a printer can be reached. With Hold,
+// an offline printer's jobs are held, and are sent in order once it is
+// online again; without it SetOnline changes nothing.
+func (q *Queue) SetOnline(printer string, online bool) PrinterStatus {
+	q.mu.Lock()
+	defer q.unlock()
+	pq := q.printer(printer)
+	if q.opts.Hold && pq.offline == online {
+		if online {
+			pq.offline = false
+			for _, e := range pq.pending {
+				if e.job.State == Held {
+					e.job.State = Queued
+					e.held = make(chan struct{})
+					q.persist(e, false)
+				}
+			}
+		} else {
+			q.hold(pq)
+		}
+		wake(pq)
+	}
+	return q.status(printer, pq)
+}
+
+// hold marks a printer offline and holds its queued jobs. q.mu must be
+// held.
+func (q *Queue) hold(pq *printerQueue) {
+	pq.offline = true
+	for _, e := range pq.pending {
+		if e.job.State == Queued {
+			q.markHeld(e)
+		}
+	}
+}
+
+// markHeld holds a queued job. q.mu must be held.
+func (q *Queue) markHeld(e *entry) {
+	e.job.State = Held
+	close(e.held)
+	q.persist(e, false)
+}
+
+// expire holds the jobs of an offline printer that have come due and
+// fails those held longer than HoldAge. It returns how long until the
+// next job comes due or expires, or zero. q.mu must be held.
+func (q *Queue) expire(pq *printerQueue) time.Duration {
+	now := time.Now()
+	var until time.Duration
+	soonest := func(d time.Duration) {
+		if until == 0 || d < until {
+			until = d
+		}
+	}
+	for i := 0; i < len(pq.pending); {
+		e := pq.pending[i]
+		dueAt := e.job.CreatedAt
+		if e.job.NotBefore != nil {
+			if wait := e.job.NotBefore.Sub(now); wait > 0 {
+				soonest(wait)
+				i++
+				continue
+			}
+			dueAt = *e.job.NotBefore
+		}
+		if e.job.State != Held {
+			e.job.State = Queued
+			q.markHeld(e)
+		}
+		if q.opts.HoldAge > 0 {
+			left := dueAt.Add(q.opts.HoldAge).Sub(now)
+			if left <= 0 {
+				pq.pending = slices.Delete(pq.pending, i, i+1)
+				q.finish(e, Failed, fmt.Sprintf("printer %s was offline for longer than the %s a job is held", e.job.Printer, q.opts.HoldAge))
+				continue
+			}
+			soonest(left)
+		}
+		i++
+	}
+	return until
+}
+
+// unlock releases q.mu, then calls OnFinish with the jobs that finished
+// while it was held.
+func (q *Queue) unlock() {
+	finished := q.finishing
+	q.finishing = nil
+	q.mu.Unlock()
+	for _, job := range finished {
+		q.opts.OnFinish(job)
+	}
+}
+
+func wake(pq *printerQueue) {
+	select {
+	case pq.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Get returns the job with the given id.
+func (q *Queue) Get(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.unlock()
+	e, ok := q.jobs[id]
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	return e.job, nil
+}
+
+// Wait blocks until the job finishes, is held for its offline printer, or
+// ctx is done, and returns the job as it then is.
+func (q *Queue) Wait(ctx context.Context, id string) (Job, error) {
+	q.mu.Lock()
+	e, ok := q.jobs[id]
+	var held chan struct{}
+	if ok {
+		held = e.held
+	}
+	q.unlock()
+	if !ok {
+		return Job{}, ErrNotFound
+	}
+	select {
+	case <-e.done:
+	case <-held:
+	case <-ctx.Done():
+		job, _ := q.Get(id)
+		return job, ctx.Err()
+	}
+	return q.Get(id)
+}
+
+// work sends a printer's jobs one at a time, while it is not paused or
+// offline, sleeping until the next scheduled job is due when none is.
+// While the printer is offline it expires the jobs held for it.
+func (q *Queue) work(pq *printerQueue) {
+	for {
+		q.mu.Lock()
+		var e *entry
+		var due time.Duration
+		switch {
+		case pq.offline:
+			due = q.expire(pq)
+		case !pq.paused:
+			e, due = q.next(pq)
+		}
+		if e == nil {
+			q.unlock()
+			if due > 0 {
+				timer := time.NewTimer(due)
+				select {
+				case <-pq.wake:
+				case <-timer.C:
+				}
+				timer.Stop()
+			} else {
+				<-pq.wake
+			}
+			continue
+		}
+		pq.sending = e
+		q.unlock()
+		q.run(e)
+		q.mu.Lock()
+		pq.sending = nil
+		q.unlock()
+	}
+}
+
+// next removes and returns the printer's due job with the highest
+// priority, aged by how long it has been due, and the oldest among equals.
+// Scheduled jobs that have come due are marked queued. With no job due it
+// returns how long until the next scheduled one is, or zero. q.mu must be
+// held.
+func (q *Queue) next(pq *printerQueue) (*entry, time.Duration) {
+	now := time.Now()
+	best, bestScore := -1, 0
+	var until time.Duration
+	for i, e := range pq.pending {
+		dueAt := e.job.CreatedAt
+		if e.job.NotBefore != nil {
+			if wait := e.job.NotBefore.Sub(now); wait > 0 {
+				if until == 0 || wait < until {
+					until = wait
+				}
+				continue
+			}
+			dueAt = *e.job.NotBefore
+		}
+		if e.job.State == Scheduled {
+			e.job.State = Queued
+			q.persist(e, false)
+		}
+		score := e.job.Priority
+		if q.opts.Aging > 0 {
+			score += int(now.Sub(dueAt) / q.opts.Aging)
+		}
+		// pending is in submission order, so the first of equal scores is
+		// the oldest.
+		if best < 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	if best < 0 {
+		return nil, until
+	}
+	e := pq.pending[best]
+	pq.pending = slices.Delete(pq.pending, best, best+1)
+	return e, 0
+}
+
+// Filter selects jobs for List; its zero fields match every job.
+type Filter struct {
+	State     State
+	Printer   string
+	Reference string
+	// Since matches jobs submitted at or after it.
+	Since time.Time
+	// Limit keeps only the most recent Limit matching jobs.
+	Limit int
+}
+
+func (f Filter) match(job Job) bool {
+	return (f.State == "" || job.State == f.State) &&
+		(f.Printer == "" || job.Printer == f.Printer) &&
+		(f.Reference == "" || job.Reference == f.Reference) &&
+		!job.CreatedAt.Before(f.Since)
+}
+
+// List returns the jobs f matches, in submission order.
+func (q *Queue) List(f Filter) []Job {
+	q.mu.Lock()
+	defer q.unlock()
+	out := []Job{}
+	for _, e := range q.jobs {
+		if f.match(e.job) {
+			out = append(out, e.job)
+		}
+	}
+	sortByCreated(out)
+	if f.Limit > 0 && len(out) > f.Limit {
+		out = out[len(out)-f.Limit:]
+	}
+	return out
+}
+
+// run sends one job, retrying while nothing of it has been written.
+func (q *Queue) run(e *entry) {
+	for {
+		q.mu.Lock()
+		// Cancelled once taken from the queue, or while waiting to be
+		// retried: nothing has been sent this try.
+		if e.cancel {
+			q.finish(e, Cancelled, "")
+			q.unlock()
+			return
+		}
+		now := time.Now()
+		e.job.State = Sending
+		e.job.Attempts++
+		e.job.BytesSent = 0
+		if e.job.StartedAt == nil {
+			e.job.StartedAt = &now
+		}
+		q.persist(e, false)
+		job := e.job
+		q.unlock()
+
+		err := q.send(job, func(sent int) error {
+			q.mu.Lock()
+			defer q.unlock()
+			e.job.BytesSent = sent
+			if e.cancel {
+				return ErrCancelled
+			}
+			return nil
+		})
+
+		var to string
+		rerouted := err != nil && !errors.Is(err, ErrCancelled) && q.opts.Reroute != nil
+		if rerouted {
+			if !slices.Contains(job.Tried, job.Printer) {
+				job.Tried = append(slices.Clone(job.Tried), job.Printer)
+			}
+			to = q.opts.Reroute(job, err)
+		}
+
+		q.mu.Lock()
+		if e.cancel && err != nil {
+			tail := e.tail
+			if len(tail) > 0 && e.job.BytesSent > 0 {
+				q.unlock()
+				if err := q.send(Job{ID: job.ID, Printer: job.Printer, Tag: job.Tag, Data: tail}, func(int) error { return nil }); err != nil {
+					q.opts.Logf("job=%s: %v", job.ID, err)
+				}
+				q.mu.Lock()
+			}
+			q.finish(e, Cancelled, "")
+			q.unlock()
+			return
+		}
+		if err == nil {
+			e.job.BytesSent = e.job.Bytes
+			q.finish(e, Done, "")
+			q.unlock()
+			return
+		}
+		if rerouted {
+			e.job.Tried = job.Tried
+		}
+		if to != "" && to != job.Printer {
+			e.job.Printer = to
+			e.job.State = Queued
+			e.job.Error = err.Error()
+			q.persist(e, false)
+			pq := q.printer(to)
+			pq.pending = slices.Insert(pq.pending, 0, e)
+			wake(pq)
+			q.unlock()
+			return
+		}
+		if q.opts.Hold && errors.Is(err, ErrOffline) && e.job.BytesSent == 0 {
+			// Tries on a printer that cannot be reached do not count
+			// against MaxAttempts.
+			e.job.Attempts--
+			e.job.State = Queued
+			e.job.Error = err.Error()
+			pq := q.printer(e.job.Printer)
+			pq.pending = slices.Insert(pq.pending, 0, e)
+			q.hold(pq)
+			wake(pq)
+			q.unlock()
+			return
+		}
+		if e.job.BytesSent > 0 || e.job.Attempts >= q.opts.MaxAttempts {
+			q.finish(e, Failed, err.Error())
+			q.unlock()
+			return
+		}
+		e.job.State = Queued
+		e.job.Error = err.Error()
+		q.persist(e, false)
+		q.unlock()
+		time.Sleep(q.opts.RetryDelay)
+	}
+}
+
+// finish records the job's final state and forgets old finished jobs.
+// q.mu must be held.
+func (q *Queue) finish(e *entry, state State, errMsg string) {
+	now := time.Now()
+	e.job.State = state
+	e.job.Error = errMsg
+	e.job.FinishedAt = &now
+	if !state.keepsData() {
+		e.job.Data = nil
+	}
+	close(e.done)
+	q.persist(e, false)
+	if q.opts.OnFinish != nil {
+		q.finishing = append(q.finishing, e.job)
+	}
+	q.finished = append(q.finished, e.job.ID)
+	q.prune()
+}
+
+// prune forgets the oldest finished jobs beyond KeepFinished or older than
+// Retention and idempotency keys older than KeyWindow, and compacts the
+// journal once it has grown. q.mu must be held.
+func (q *Queue) prune() {
+	for len(q.finished) > 0 {
+		oldest := q.jobs[q.finished[0]].job
+		expired := q.opts.Retention > 0 && time.Since(*oldest.FinishedAt) > q.opts.Retention
+		if len(q.finished) <= q.opts.KeepFinished && !expired {
+			break
+		}
+		delete(q.jobs, oldest.ID)
+		q.finished = q.finished[1:]
+		if q.journal != nil {
+			if err := q.journal.forget(oldest.ID); err != nil {
+				q.opts.Logf("job journal: %v", err)
+			}
+		}
+	}
+	for key, k := range q.keys {
+		if k.Job != "" && q.opts.KeyWindow > 0 && time.Since(k.At) > q.opts.KeyWindow {
+			delete(q.keys, key)
+		}
+	}
+	if q.journal == nil || !q.journal.Grown(len(q.jobs)+len(q.keys)+len(q.printers)) {
+		return
+	}
+	if err := q.rewrite(); err != nil {
+		q.opts.Logf("job journal: %v", err)
+	}
+}
+
+// rewrite compacts the journal to the current jobs and bound keys. q.mu
+// must be held.
+func (q *Queue) rewrite() error {
+	all := make([]Job, 0, len(q.jobs))
+	for _, e := range q.jobs {
+		all = append(all, e.job)
+	}
+	sortByCreated(all)
+	st := journalState{jobs: all}
+	for _, k := range q.keys {
+		if k.Job != "" {
+			st.keys = append(st.keys, *k)
+		}
+	}
+	for printer, pq := range q.printers {
+		if pq.paused {
+			st.paused = append(st.paused, printer)
+		}
+	}
+	return q.journal.rewrite(st)
+}
+
+// persist journals e's current state; the payload is written again only
+// with withData. q.mu must be held.
+func (q *Queue) persist(e *entry, withData bool) {
+	if q.journal == nil {
+		return
+	}
+	if err := q.journal.put(e.job, withData); err != nil {
+		q.opts.Logf("job journal: job=%s %v", e.job.ID, err)
+	}
+}
+
+func sortByCreated(jobs []Job) {
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].CreatedAt.Before(jobs[b].CreatedAt) })
+}
+
+func newID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
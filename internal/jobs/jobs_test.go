@@ -0,0 +1,683 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQueueSendsInOrderPerPrinter(t *testing.T) {
+	var mu sync.Mutex
+	var sent []string
+	finished := make(chan State, 3)
+	var q *Queue
+	q = NewQueue(func(job Job, progress func(int) error) error {
+		progress(1)
+		mu.Lock()
+		sent = append(sent, job.Printer+":"+string(job.Data))
+		mu.Unlock()
+		return nil
+	}, Options{OnFinish: func(job Job) {
+		// OnFinish runs with the queue unlocked, so it may look the job up.
+		got, _ := q.Get(job.ID)
+		finished <- got.State
+	}})
+
+	var ids []string
+	for _, data := range []string{"a", "b", "c"} {
+		job, err := q.Submit(Job{Printer: "P1", Tag: "print/raw", Data: []byte(data), Result: map[string]any{"n": data}})
+		if err != nil {
+			t.Fatalf("submit: %v", err)
+		}
+		ids = append(ids, job.ID)
+	}
+	for _, id := range ids {
+		job, err := q.Wait(context.Background(), id)
+		if err != nil {
+			t.Fatalf("wait %s: %v", id, err)
+		}
+		if job.State != Done || job.Attempts != 1 || job.BytesSent != 1 || job.FinishedAt == nil {
+			t.Fatalf("job = %+v", job)
+		}
+	}
+	if got := len(sent); got != 3 || sent[0] != "P1:a" || sent[2] != "P1:c" {
+		t.Fatalf("sent = %v", sent)
+	}
+	for range 3 {
+		select {
+		case state := <-finished:
+			if state != Done {
+				t.Fatalf("OnFinish saw a %s job, want done", state)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("OnFinish was not called for every job")
+		}
+	}
+	if _, err := q.Get("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get(missing) = %v, want ErrNotFound", err)
+	}
+}
+
+func TestQueueRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		partial      bool
+		wantState    State
+		wantAttempts int
+	}{
+		{name: "succeeds after a failed connect", failures: 1, wantState: Done, wantAttempts: 2},
+		{name: "gives up after max attempts", failures: 5, wantState: Failed, wantAttempts: 3},
+		{name: "never retries a partial send", failures: 1, partial: true, wantState: Failed, wantAttempts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			q := NewQueue(func(job Job, progress func(int) error) error {
+				calls++
+				if calls <= tt.failures {
+					if tt.partial {
+						progress(2)
+					}
+					return errors.New("not connected")
+				}
+				return nil
+			}, Options{MaxAttempts: 3, RetryDelay: time.Millisecond})
+
+			job, _ := q.Submit(Job{Printer: "P1", Tag: "print/text", Data: []byte("data")})
+			job, err := q.Wait(context.Background(), job.ID)
+			if err != nil {
+				t.Fatalf("wait: %v", err)
+			}
+			if job.State != tt.wantState || job.Attempts != tt.wantAttempts {
+				t.Fatalf("state=%s attempts=%d, want %s after %d", job.State, job.Attempts, tt.wantState, tt.wantAttempts)
+			}
+			if tt.wantState == Failed && job.Error != "not connected" {
+				t.Fatalf("error = %q", job.Error)
+			}
+		})
+	}
+}
+
+func TestQueueForgetsOldFinishedJobs(t *testing.T) {
+	q := NewQueue(func(Job, func(int) error) error { return nil }, Options{KeepFinished: 2})
+	var ids []string
+	for i := 0; i < 3; i++ {
+		job, _ := q.Submit(Job{Printer: "P1", Tag: "print/raw", Data: []byte{1}})
+		if _, err := q.Wait(context.Background(), job.ID); err != nil {
+			t.Fatalf("wait: %v", err)
+		}
+		ids = append(ids, job.ID)
+	}
+	if _, err := q.Get(ids[0]); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("oldest job kept: %v", err)
+	}
+	if _, err := q.Get(ids[2]); err != nil {
+		t.Fatalf("newest job: %v", err)
+	}
+}
+
+func TestQueueSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	sending := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	var once sync.Once
+	q, err := OpenQueue(dir, func(job Job, progress func(int) error) error {
+		once.Do(func() { close(sending) })
+		<-release
+		return nil
+	}, Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	inFlight, _ := q.Submit(Job{Printer: "P1", Tag: "print/text", Data: []byte("first")})
+	<-sending
+	queued, _ := q.Submit(Job{Printer: "P1", Tag: "print/text", Data: []byte("second"), Result: map[string]any{"n": 2}})
+	q.Close()
+
+	// A crash can tear the last record.
+	f, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("open journal: %v", err)
+	}
+	f.WriteString(`{"job":{"id":"torn"`)
+	f.Close()
+
+	got := make(chan string, 2)
+	q, err = OpenQueue(dir, func(job Job, progress func(int) error) error {
+		got <- string(job.Data)
+		return nil
+	}, Options{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer q.Close()
+	job, err := q.Wait(context.Background(), queued.ID)
+	if err != nil || job.State != Done || job.Result["n"] != 2.0 {
+		t.Fatalf("queued job after restart = %+v, %v", job, err)
+	}
+	if data := <-got; data != "second" {
+		t.Fatalf("sent %q, want the queued job", data)
+	}
+	if job, _ := q.Get(inFlight.ID); job.State != Interrupted {
+		t.Fatalf("in-flight job state = %s, want interrupted", job.State)
+	}
+	if _, err := q.Get("torn"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("torn record replayed: %v", err)
+	}
+
+	if _, err := q.Requeue(queued.ID); !errors.Is(err, ErrState) {
+		t.Fatalf("requeue of a done job = %v, want ErrState", err)
+	}
+	if _, err := q.Requeue(inFlight.ID); err != nil {
+		t.Fatalf("requeue: %v", err)
+	}
+	if job, _ := q.Wait(context.Background(), inFlight.ID); job.State != Done || <-got != "first" {
+		t.Fatalf("requeued job = %+v", job)
+	}
+}
+
+func TestQueueIdempotencyKeys(t *testing.T) {
+	dir := t.TempDir()
+	send := func(Job, func(int) error) error { return nil }
+	q, err := OpenQueue(dir, send, Options{KeyWindow: time.Hour})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, found, err := q.Claim("pos/42", "h1"); found || err != nil {
+		t.Fatalf("first claim = %v, %v", found, err)
+	}
+	if _, _, err := q.Claim("pos/42", "h1"); !errors.Is(err, ErrKeyInFlight) {
+		t.Fatalf("claim while in flight = %v, want ErrKeyInFlight", err)
+	}
+	job, _ := q.Submit(Job{Printer: "P1", Tag: "print/text", Data: []byte("x"), IdempotencyKey: "pos/42"})
+	if got, found, err := q.Claim("pos/42", "h1"); !found || err != nil || got.ID != job.ID {
+		t.Fatalf("repeat claim = %+v, %v, %v", got, found, err)
+	}
+	if _, _, err := q.Claim("pos/42", "h2"); !errors.Is(err, ErrKeyReused) {
+		t.Fatalf("claim with another body = %v, want ErrKeyReused", err)
+	}
+
+	// A released key can be claimed again.
+	q.Claim("pos/43", "h1")
+	q.Release("pos/43")
+	if _, found, err := q.Claim("pos/43", "h3"); found || err != nil {
+		t.Fatalf("claim after release = %v, %v", found, err)
+	}
+	q.Release("pos/43")
+	q.Wait(context.Background(), job.ID)
+	q.Close()
+
+	q, err = OpenQueue(dir, send, Options{KeyWindow: time.Hour})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer q.Close()
+	if got, found, err := q.Claim("pos/42", "h1"); !found || err != nil || got.ID != job.ID || got.State != Done {
+		t.Fatalf("claim after restart = %+v, %v, %v", got, found, err)
+	}
+	if batch := q.BatchJobs("pos/42"); batch != nil {
+		t.Fatalf("batch jobs of a single job's key = %+v", batch)
+	}
+}
+
+func TestQueueBatchIdempotencyKey(t *testing.T) {
+	dir := t.TempDir()
+	send := func(Job, func(int) error) error { return nil }
+	q, err := OpenQueue(dir, send, Options{KeyWindow: time.Hour})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	q.Claim("pos/order-7", "h1")
+	q.Batch("pos/order-7")
+	var ids []string
+	for _, station := range []string{"grill", "bar"} {
+		job, _ := q.Submit(Job{Printer: "P1", Tag: "submit/order", Tags: []string{station}, Data: []byte(station), IdempotencyKey: "pos/order-7"})
+		ids = append(ids, job.ID)
+		// The key is bound only once the whole batch is in.
+		if _, _, err := q.Claim("pos/order-7", "h1"); !errors.Is(err, ErrKeyInFlight) {
+			t.Fatalf("claim during the batch = %v, want ErrKeyInFlight", err)
+		}
+	}
+	q.Bind("pos/order-7")
+	q.Release("pos/order-7")
+	for _, id := range ids {
+		q.Wait(context.Background(), id)
+	}
+	q.Close()
+
+	q, err = OpenQueue(dir, send, Options{KeyWindow: time.Hour})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer q.Close()
+	if got, found, err := q.Claim("pos/order-7", "h1"); !found || err != nil || got.ID != ids[0] {
+		t.Fatalf("repeat claim = %+v, %v, %v", got, found, err)
+	}
+	var got []string
+	for _, job := range q.BatchJobs("pos/order-7") {
+		if job.State != Done {
+			t.Fatalf("batch job = %+v", job)
+		}
+		got = append(got, job.ID)
+	}
+	if !slices.Equal(got, ids) {
+		t.Fatalf("batch jobs = %v, want %v", got, ids)
+	}
+
+	// A batch that submitted nothing leaves the key to Release.
+	q.Claim("pos/order-8", "h1")
+	q.Batch("pos/order-8")
+	q.Bind("pos/order-8")
+	q.Release("pos/order-8")
+	if _, found, err := q.Claim("pos/order-8", "h2"); found || err != nil {
+		t.Fatalf("claim after an empty batch = %v, %v", found, err)
+	}
+}
+
+func TestQueueCancel(t *testing.T) {
+	started := make(chan struct{})
+	var mu sync.Mutex
+	var sent []string
+	q := NewQueue(func(job Job, progress func(int) error) error {
+		mu.Lock()
+		sent = append(sent, string(job.Data))
+		mu.Unlock()
+		if string(job.Data) != "long" {
+			return nil
+		}
+		close(started)
+		for n := 1; ; n++ {
+			if err := progress(n); err != nil {
+				return err
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}, Options{})
+
+	long, _ := q.Submit(Job{Printer: "P1", Tag: "print/raw", Data: []byte("long")})
+	queued, _ := q.Submit(Job{Printer: "P1", Tag: "print/raw", Data: []byte("queued")})
+	<-started
+	if job, err := q.Cancel(queued.ID, nil); err != nil || job.State != Cancelled {
+		t.Fatalf("cancel queued = %+v, %v", job, err)
+	}
+	if job, err := q.Cancel(long.ID, []byte("cut")); err != nil || job.State != Sending {
+		t.Fatalf("cancel in flight = %+v, %v", job, err)
+	}
+	job, _ := q.Wait(context.Background(), long.ID)
+	if job.State != Cancelled || job.BytesSent == 0 {
+		t.Fatalf("in-flight job after cancel = %+v", job)
+	}
+	if _, err := q.Cancel(long.ID, nil); !errors.Is(err, ErrState) {
+		t.Fatalf("second cancel = %v, want ErrState", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(sent) != 2 || sent[1] != "cut" {
+		t.Fatalf("sent = %v, want the long job then the cut", sent)
+	}
+}
+
+func TestQueuePauseSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	sent := make(chan string, 4)
+	send := func(job Job, progress func(int) error) error {
+		sent <- string(job.Data)
+		return nil
+	}
+	q, err := OpenQueue(dir, send, Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if st := q.Pause("P1"); !st.Paused {
+		t.Fatalf("status after pause = %+v", st)
+	}
+	q.Submit(Job{Printer: "P1", Tag: "print/raw", Data: []byte("held")})
+	q.Close()
+
+	q, err = OpenQueue(dir, send, Options{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer q.Close()
+	if st := q.Status("P1"); !st.Paused || st.Queued != 1 {
+		t.Fatalf("status after restart = %+v", st)
+	}
+	select {
+	case data := <-sent:
+		t.Fatalf("paused printer sent %q", data)
+	case <-time.After(20 * time.Millisecond):
+	}
+	q.Resume("P1")
+	select {
+	case data := <-sent:
+		if data != "held" {
+			t.Fatalf("sent %q", data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("resumed printer sent nothing")
+	}
+}
+
+func TestQueuePriorityAndSchedule(t *testing.T) {
+	sent := make(chan string, 8)
+	q := NewQueue(func(job Job, progress func(int) error) error {
+		sent <- string(job.Data)
+		return nil
+	}, Options{})
+	q.Pause("P1")
+	later := time.Now().Add(50 * time.Millisecond)
+	q.Submit(Job{Printer: "P1", Tag: "print/raw", Data: []byte("low"), Priority: -10})
+	scheduled, _ := q.Submit(Job{Printer: "P1", Tag: "print/raw", Data: []byte("scheduled"), Priority: 20, NotBefore: &later})
+	q.Submit(Job{Printer: "P1", Tag: "print/raw", Data: []byte("normal")})
+	q.Submit(Job{Printer: "P1", Tag: "print/raw", Data: []byte("high"), Priority: 10})
+	q.Submit(Job{Printer: "P1", Tag: "print/raw", Data: []byte("normal2")})
+	if scheduled.State != Scheduled {
+		t.Fatalf("future job state = %s, want scheduled", scheduled.State)
+	}
+	if st := q.Status("P1"); st.Queued != 5 || st.Scheduled != 1 {
+		t.Fatalf("status = %+v", st)
+	}
+	if list := q.List(Filter{State: Scheduled}); len(list) != 1 || list[0].ID != scheduled.ID {
+		t.Fatalf("List(scheduled) = %+v", list)
+	}
+	q.Resume("P1")
+
+	var got []string
+	for range 5 {
+		select {
+		case data := <-sent:
+			got = append(got, data)
+		case <-time.After(time.Second):
+			t.Fatalf("sent %v, then nothing", got)
+		}
+	}
+	want := []string{"high", "normal", "normal2", "low", "scheduled"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("sent %v, want %v", got, want)
+	}
+}
+
+func TestQueueAgingPreventsStarvation(t *testing.T) {
+	q := NewQueue(func(Job, func(int) error) error { return nil }, Options{Aging: time.Minute})
+	pq := &printerQueue{}
+	old := &entry{job: Job{Data: []byte("old"), CreatedAt: time.Now().Add(-3 * time.Minute)}}
+	urgent := &entry{job: Job{Data: []byte("urgent"), Priority: 2, CreatedAt: time.Now()}}
+	pq.pending = []*entry{old, urgent}
+	q.mu.Lock()
+	e, _ := q.next(pq)
+	q.mu.Unlock()
+	if e != old {
+		t.Fatalf("next = %s, want the job that has waited three minutes", e.job.Data)
+	}
+}
+
+func TestQueueKeepsPrintedJobsForReprint(t *testing.T) {
+	dir := t.TempDir()
+	send := func(job Job, progress func(int) error) error {
+		if string(job.Data) == "jam" {
+			return errors.New("paper jam")
+		}
+		return nil
+	}
+	q, err := OpenQueue(dir, send, Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	var printed []Job
+	for _, ref := range []string{"order-1", "order-2", "order-2"} {
+		job, _ := q.Submit(Job{Printer: "P1", Tag: "print/text", Data: []byte("receipt " + ref), Reference: ref})
+		job, _ = q.Wait(context.Background(), job.ID)
+		printed = append(printed, job)
+	}
+	failed, _ := q.Submit(Job{Printer: "P1", Tag: "print/raw", Data: []byte("jam")})
+	q.Wait(context.Background(), failed.ID)
+	q.Close()
+
+	q, err = OpenQueue(dir, send, Options{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer q.Close()
+	if job, _ := q.Get(printed[0].ID); string(job.Data) != "receipt order-1" {
+		t.Fatalf("printed job payload after restart = %q", job.Data)
+	}
+	if job, _ := q.Get(failed.ID); job.State != Failed || job.Data != nil {
+		t.Fatalf("failed job = %+v, want no payload", job)
+	}
+
+	tests := []struct {
+		name string
+		f    Filter
+		want []string
+	}{
+		{name: "by reference", f: Filter{Reference: "order-2"}, want: []string{printed[1].ID, printed[2].ID}},
+		{name: "most recent", f: Filter{State: Done, Limit: 1}, want: []string{printed[2].ID}},
+		{name: "since", f: Filter{Printer: "P1", Since: *printed[2].FinishedAt}, want: []string{failed.ID}},
+		{name: "other printer", f: Filter{Printer: "P2"}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, job := range q.List(tt.f) {
+				got = append(got, job.ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("List(%+v) = %v, want %v", tt.f, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueueReroutesFailedJobs(t *testing.T) {
+	tests := []struct {
+		name        string
+		down        map[string]bool
+		wantState   State
+		wantPrinter string
+		wantTried   []string
+	}{
+		{name: "moves to the next member", down: map[string]bool{"A": true}, wantState: Done, wantPrinter: "B", wantTried: []string{"A"}},
+		{name: "fails once every member failed", down: map[string]bool{"A": true, "B": true}, wantState: Failed, wantPrinter: "B", wantTried: []string{"A", "B"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue(func(job Job, progress func(int) error) error {
+				if tt.down[job.Printer] {
+					progress(1)
+					return errors.New("not connected")
+				}
+				return nil
+			}, Options{MaxAttempts: 1, Reroute: func(job Job, err error) string {
+				for _, p := range []string{"A", "B"} {
+					if !slices.Contains(job.Tried, p) {
+						return p
+					}
+				}
+				return ""
+			}})
+			job, _ := q.Submit(Job{Printer: "A", Group: "counter", Tag: "print/text", Data: []byte("x")})
+			job, err := q.Wait(context.Background(), job.ID)
+			if err != nil {
+				t.Fatalf("wait: %v", err)
+			}
+			if job.State != tt.wantState || job.Printer != tt.wantPrinter || !slices.Equal(job.Tried, tt.wantTried) {
+				t.Fatalf("job = %+v", job)
+			}
+		})
+	}
+}
+
+func TestQueueHoldsJobsWhileOffline(t *testing.T) {
+	var mu sync.Mutex
+	online := false
+	var sent []string
+	q := NewQueue(func(job Job, progress func(int) error) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if !online {
+			return fmt.Errorf("%w: not connected", ErrOffline)
+		}
+		sent = append(sent, string(job.Data))
+		return nil
+	}, Options{MaxAttempts: 1, Hold: true})
+
+	// The first job finds the printer offline; the rest are held at once.
+	var ids []string
+	for _, data := range []string{"a", "b", "c"} {
+		job, _ := q.Submit(Job{Printer: "P1", Tag: "print/raw", Data: []byte(data)})
+		job, err := q.Wait(context.Background(), job.ID)
+		if err != nil || job.State != Held {
+			t.Fatalf("job %s = %+v, %v; want held", data, job, err)
+		}
+		ids = append(ids, job.ID)
+	}
+	if st := q.Status("P1"); !st.Offline || st.Held != 3 {
+		t.Fatalf("status = %+v", st)
+	}
+
+	mu.Lock()
+	online = true
+	mu.Unlock()
+	q.SetOnline("P1", true)
+	for _, id := range ids {
+		job, err := q.Wait(context.Background(), id)
+		if err != nil || job.State != Done || job.Attempts != 1 {
+			t.Fatalf("released job = %+v, %v", job, err)
+		}
+	}
+	if !slices.Equal(sent, []string{"a", "b", "c"}) {
+		t.Fatalf("sent = %v", sent)
+	}
+}
+
+func TestOpenQueueStartsNoWorkersWhenRewriteFails(t *testing.T) {
+	dir := t.TempDir()
+	q, err := OpenQueue(dir, func(Job, func(int) error) error { return nil }, Options{Hold: true})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	q.SetOnline("P1", false)
+	q.Submit(Job{Printer: "P1", Tag: "print/raw", Data: []byte("x")})
+	q.Close()
+
+	// The rewrite cannot create its temporary file.
+	if err := os.Mkdir(filepath.Join(dir, journalFile+".tmp"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var sent atomic.Int32
+	q, err = OpenQueue(dir, func(Job, func(int) error) error {
+		sent.Add(1)
+		return nil
+	}, Options{})
+	if err == nil {
+		t.Fatalf("open = %v, want an error", q)
+	}
+	// Without Hold the held job is queued again and a worker would send it.
+	time.Sleep(50 * time.Millisecond)
+	if n := sent.Load(); n != 0 {
+		t.Fatalf("sent %d jobs from a queue that failed to open", n)
+	}
+}
+
+func TestQueueCancelBeforeRun(t *testing.T) {
+	var sent atomic.Int32
+	q := NewQueue(func(Job, func(int) error) error {
+		sent.Add(1)
+		return nil
+	}, Options{})
+	q.Pause("P1")
+	job, _ := q.Submit(Job{Printer: "P1", Tag: "print/raw", Data: []byte("x")})
+
+	// Taken by the worker, then cancelled before it starts sending.
+	q.mu.Lock()
+	e, _ := q.next(q.printers["P1"])
+	q.mu.Unlock()
+	if _, err := q.Cancel(job.ID, nil); err != nil {
+		t.Fatalf("cancel: %v", err)
+	}
+	q.run(e)
+	if got, _ := q.Get(job.ID); got.State != Cancelled || got.Attempts != 0 {
+		t.Fatalf("job = %+v, want cancelled without a try", got)
+	}
+	if n := sent.Load(); n != 0 {
+		t.Fatalf("sent %d times, want 0", n)
+	}
+}
+
+func TestQueueMove(t *testing.T) {
+	var mu sync.Mutex
+	var sent []string
+	q := NewQueue(func(job Job, progress func(int) error) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if job.Printer == "" {
+			return fmt.Errorf("%w: no printer address", ErrOffline)
+		}
+		sent = append(sent, job.Printer+":"+string(job.Data))
+		return nil
+	}, Options{MaxAttempts: 1, Hold: true})
+
+	var ids []string
+	for _, data := range []string{"a", "b"} {
+		job, _ := q.Submit(Job{Tag: "print/raw", Data: []byte(data)})
+		job, err := q.Wait(context.Background(), job.ID)
+		if err != nil || job.State != Held {
+			t.Fatalf("job %s = %+v, %v; want held", data, job, err)
+		}
+		ids = append(ids, job.ID)
+	}
+	if n := q.Move("", "P1"); n != 2 {
+		t.Fatalf("Move = %d, want 2", n)
+	}
+	for _, id := range ids {
+		job, err := q.Wait(context.Background(), id)
+		if err != nil || job.State != Done || job.Printer != "P1" {
+			t.Fatalf("moved job = %+v, %v", job, err)
+		}
+	}
+	if !slices.Equal(sent, []string{"P1:a", "P1:b"}) {
+		t.Fatalf("sent = %v", sent)
+	}
+	if n := q.Move("", "P1"); n != 0 {
+		t.Fatalf("second Move = %d, want 0", n)
+	}
+}
+
+func TestQueueExpiresHeldJobs(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		jobs    int
+		wantErr string
+	}{
+		{name: "too old", opts: Options{Hold: true, HoldAge: 20 * time.Millisecond}, jobs: 1, wantErr: "printer P1 was offline for longer than the 20ms a job is held"},
+		{name: "too many", opts: Options{Hold: true, HoldMax: 2}, jobs: 3, wantErr: "printer P1 is offline and already holds 2 jobs"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewQueue(func(Job, func(int) error) error { return nil }, tt.opts)
+			q.SetOnline("P1", false)
+			var job Job
+			for range tt.jobs {
+				job, _ = q.Submit(Job{Printer: "P1", Tag: "print/raw", Data: []byte{1}})
+			}
+			deadline := time.Now().Add(2 * time.Second)
+			for !job.State.Finished() && time.Now().Before(deadline) {
+				time.Sleep(5 * time.Millisecond)
+				job, _ = q.Get(job.ID)
+			}
+			if job.State != Failed || job.Error != tt.wantErr {
+				t.Fatalf("job = %s %q, want failed %q", job.State, job.Error, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package jobs
+
+import (
+	"sort"
+	"time"
+
+	"ble-printer-bridge/internal/jsonl"
+)
+
+const journalFile = "journal.jsonl"
+
+// journal is the queue's jsonl.Log of job records. Each record replaces
+// the previous one for its job, so replaying the log yields the latest
+// state of every job. Opening rewrites the journal with one record per
+// job, as does compaction once it has grown.
+type journal struct {
+	*jsonl.Log
+}
+
+// record is one journal line: a job, with its payload while it still has
+// to be printed or may be reprinted, the ID of a job to forget, an idempotency key or a
+// printer paused or resumed.
+type record struct {
+	Job    *Job         `json:"job,omitempty"`
+	Data   []byte       `json:"data,omitempty"`
+	Forget string       `json:"forget,omitempty"`
+	Key    *keyRecord   `json:"key,omitempty"`
+	Pause  *pauseRecord `json:"pause,omitempty"`
+}
+
+// keyRecord binds an idempotency key to the job its first request
+// submitted and the hash of that request. A request that submitted a batch
+// of jobs, such as an order's tickets, lists them all in Jobs; Job is the
+// first.
+type keyRecord struct {
+	Key  string    `json:"key"`
+	Hash string    `json:"hash"`
+	Job  string    `json:"job"`
+	Jobs []string  `json:"jobs,omitempty"`
+	At   time.Time `json:"at"`
+
+	// batch is set while a claimed key collects the jobs of a batch.
+	batch bool
+}
+
+type pauseRecord struct {
+	Printer string `json:"printer"`
+	Paused  bool   `json:"paused"`
+}
+
+// journalState is what a journal holds: the jobs in submission order, the
+// idempotency keys and the paused printers.
+type journalState struct {
+	jobs   []Job
+	keys   []keyRecord
+	paused []string
+}
+
+// openJournal replays the journal in dir. The caller rewrites it once it
+// has dropped what it no longer needs.
+func openJournal(dir string) (*journal, journalState, error) {
+	log, err := jsonl.Open(dir, journalFile)
+	if err != nil {
+		return nil, journalState{}, err
+	}
+	j := &journal{log}
+	st, err := j.replay()
+	if err != nil {
+		return nil, journalState{}, err
+	}
+	return j, st, nil
+}
+
+func (j *journal) replay() (journalState, error) {
+	var st journalState
+	byID := map[string]*Job{}
+	paused := map[string]bool{}
+	err := jsonl.Replay(j.Log, func(rec record) {
+		switch {
+		case rec.Forget != "":
+			delete(byID, rec.Forget)
+		case rec.Key != nil:
+			st.keys = append(st.keys, *rec.Key)
+		case rec.Pause != nil:
+			paused[rec.Pause.Printer] = rec.Pause.Paused
+		case rec.Job != nil:
+			job := *rec.Job
+			job.Data = rec.Data
+			if prev, ok := byID[job.ID]; ok && job.Data == nil && job.State.keepsData() {
+				job.Data = prev.Data
+			}
+			byID[job.ID] = &job
+		}
+	})
+	if err != nil {
+		return st, err
+	}
+	for _, job := range byID {
+		st.jobs = append(st.jobs, *job)
+	}
+	sortByCreated(st.jobs)
+	for printer, p := range paused {
+		if p {
+			st.paused = append(st.paused, printer)
+		}
+	}
+	sort.Strings(st.paused)
+	return st, nil
+}
+
+// put records job; its payload is written only when withData is set, and
+// replay keeps the last one written while the job keeps its data.
+func (j *journal) put(job Job, withData bool) error {
+	rec := record{Job: &job}
+	if withData {
+		rec.Data = job.Data
+	}
+	return j.Append(rec)
+}
+
+// bindKey records an idempotency key.
+func (j *journal) bindKey(k keyRecord) error {
+	return j.Append(record{Key: &k})
+}
+
+// pause records a printer paused or resumed.
+func (j *journal) pause(printer string, paused bool) error {
+	return j.Append(record{Pause: &pauseRecord{Printer: printer, Paused: paused}})
+}
+
+// forget records that the job with id is gone.
+func (j *journal) forget(id string) error {
+	return j.Append(record{Forget: id})
+}
+
+// rewrite replaces the journal with one record per job, key and paused
+// printer.
+func (j *journal) rewrite(st journalState) error {
+	var recs []any
+	for i := range st.jobs {
+		rec := record{Job: &st.jobs[i]}
+		if st.jobs[i].State.keepsData() {
+			rec.Data = st.jobs[i].Data
+		}
+		recs = append(recs, rec)
+	}
+	for i := range st.keys {
+		recs = append(recs, record{Key: &st.keys[i]})
+	}
+	for _, printer := range st.paused {
+		recs = append(recs, record{Pause: &pauseRecord{Printer: printer, Paused: true}})
+	}
+	return j.Rewrite(recs)
+}
@@ -0,0 +1,132 @@
+// Package jsonl keeps append-only journals of JSON records, one per line,
+// for the stores that must survive a restart.
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Log is an append-only file of JSON records, one per line. Records are
+// synced before the call that wrote them returns; a line torn by a crash
+// is the last one and is dropped on replay. The owner replays the log,
+// then rewrites it with one record per live item, and again once it has
+// grown.
+type Log struct {
+	path    string
+	f       *os.File
+	records int
+}
+
+// Open returns the log in dir named name, creating dir if need be. The log
+// is replayed with Replay and must be rewritten before it is appended to.
+func Open(dir, name string) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Log{path: filepath.Join(dir, name)}, nil
+}
+
+// Replay decodes each complete line of l as a T, in order, and passes it
+// to fn. Lines that do not decode are skipped. A missing log replays
+// nothing.
+func Replay[T any](l *Log, fn func(T)) error {
+	f, err := os.Open(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// A line without its newline was torn by a crash.
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var rec T
+		if err := json.Unmarshal(line, &rec); err != nil {
+			continue
+		}
+		fn(rec)
+	}
+}
+
+// Append writes rec as a line and syncs it.
+func (l *Log) Append(rec any) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	l.records++
+	return l.f.Sync()
+}
+
+// Grown reports whether the log holds well over one record for each of
+// live items and should be rewritten.
+func (l *Log) Grown(live int) bool {
+	return l.records >= 2*live+100
+}
+
+// Rewrite replaces the log with recs, writing a new file and renaming it
+// over the old one so a crash leaves one or the other.
+func (l *Log) Rewrite(recs []any) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	tmp := l.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if l.f != nil {
+		l.f.Close()
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		l.f, _ = os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+		return err
+	}
+	l.f, err = os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	l.records = len(recs)
+	return nil
+}
+
+// Close closes the log.
+func (l *Log) Close() error {
+	if l.f == nil {
+		return nil
+	}
+	return l.f.Close()
+}
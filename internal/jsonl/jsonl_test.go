@@ -0,0 +1,90 @@
+package jsonl
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+type rec struct {
+	N int `json:"n"`
+}
+
+func replayAll(t *testing.T, l *Log) []int {
+	t.Helper()
+	var got []int
+	if err := Replay(l, func(r rec) { got = append(got, r.N) }); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	return got
+}
+
+func TestLogRewriteAppendReplay(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	l, err := Open(dir, "log.jsonl")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if got := replayAll(t, l); got != nil {
+		t.Fatalf("missing log replayed %v", got)
+	}
+	if err := l.Rewrite([]any{rec{1}, rec{2}}); err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	if err := l.Append(rec{3}); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if got := replayAll(t, l); !slices.Equal(got, []int{1, 2, 3}) {
+		t.Fatalf("replay = %v", got)
+	}
+	if err := l.Rewrite([]any{rec{3}}); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if got := replayAll(t, l); !slices.Equal(got, []int{3}) {
+		t.Fatalf("replay after compaction = %v", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "log.jsonl.tmp")); !os.IsNotExist(err) {
+		t.Fatalf("temporary file left behind: %v", err)
+	}
+}
+
+func TestLogReplaySkipsBadAndTornLines(t *testing.T) {
+	dir := t.TempDir()
+	content := "{\"n\":1}\nnot json\n{\"n\":2}\n{\"n\":3"
+	if err := os.WriteFile(filepath.Join(dir, "log.jsonl"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := Open(dir, "log.jsonl")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if got := replayAll(t, l); !slices.Equal(got, []int{1, 2}) {
+		t.Fatalf("replay = %v, want [1 2]", got)
+	}
+}
+
+func TestLogGrown(t *testing.T) {
+	l, err := Open(t.TempDir(), "log.jsonl")
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer l.Close()
+	if err := l.Rewrite([]any{rec{1}}); err != nil {
+		t.Fatalf("rewrite: %v", err)
+	}
+	for i := range 101 {
+		if l.Grown(1) {
+			t.Fatalf("grown after %d records", i+1)
+		}
+		if err := l.Append(rec{i}); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	if !l.Grown(1) {
+		t.Fatal("not grown at 102 records for 1 live item")
+	}
+}
@@ -0,0 +1,282 @@
+// Package logos keeps the images the bridge stores in printers' NV memory
+// and records which printer holds which version of each.
+package logos
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ble-printer-bridge/internal/printing"
+)
+
+const (
+	fileExt      = ".png"
+	printersFile = "printers.json"
+)
+
+var ErrNotFound = errors.New("logo not found")
+
+// Info describes a stored logo.
+type Info struct {
+	Key    string `json:"key"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// Hash identifies the bitmap, so a changed logo is uploaded again.
+	Hash     string    `json:"hash"`
+	Modified time.Time `json:"modified"`
+}
+
+// Record is a logo a printer holds.
+type Record struct {
+	Hash string `json:"hash"`
+	// Command is the printer.logo_command the logo was stored with; Slot
+	// is its FS p number for "bit-image".
+	Command string    `json:"command"`
+	Slot    int       `json:"slot,omitempty"`
+	Stored  time.Time `json:"stored"`
+}
+
+// Store keeps logos as PNG files in a directory, named by the hex of
+// their key so keys differing only in case do not collide, and the
+// per-printer records in printers.json beside them.
+type Store struct {
+	dir string
+	mu  sync.RWMutex
+}
+
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+func (s *Store) Dir() string { return s.dir }
+
+// List returns the stored logos ordered by key.
+func (s *Store) List() ([]Info, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []Info{}, nil
+		}
+		return nil, err
+	}
+	out := []Info{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), fileExt) {
+			continue
+		}
+		raw, err := hex.DecodeString(strings.TrimSuffix(e.Name(), fileExt))
+		key := string(raw)
+		if err != nil || printing.ValidateLogoKey(key) != nil {
+			continue
+		}
+		_, info, err := s.load(key)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, info)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out, nil
+}
+
+// Get returns the bitmap stored under key.
+func (s *Store) Get(key string) (*printing.Bitmap, Info, error) {
+	if err := printing.ValidateLogoKey(key); err != nil {
+		return nil, Info{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.load(key)
+}
+
+func (s *Store) load(key string) (*printing.Bitmap, Info, error) {
+	path := s.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, Info{}, fmt.Errorf("%w: %q", ErrNotFound, key)
+		}
+		return nil, Info{}, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, Info{}, fmt.Errorf("logo %q: %w", key, err)
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	bitmap := printing.BitmapFromImage(img, 0, false)
+	return bitmap, Info{
+		Key:      key,
+		Width:    bitmap.Width,
+		Height:   bitmap.Height,
+		Hash:     hash(bitmap),
+		Modified: st.ModTime(),
+	}, nil
+}
+
+// Save stores img under key, replacing any logo with the same key.
+func (s *Store) Save(key string, img *printing.Bitmap) (Info, error) {
+	if err := printing.ValidateLogoKey(key); err != nil {
+		return Info{}, err
+	}
+	data, err := img.PNG()
+	if err != nil {
+		return Info{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return Info{}, err
+	}
+	if err := os.WriteFile(s.path(key), data, 0o644); err != nil {
+		return Info{}, err
+	}
+	return Info{Key: key, Width: img.Width, Height: img.Height, Hash: hash(img), Modified: time.Now()}, nil
+}
+
+// Delete removes the logo stored under key and every printer's record of
+// it.
+func (s *Store) Delete(key string) error {
+	if err := printing.ValidateLogoKey(key); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(s.path(key)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%w: %q", ErrNotFound, key)
+		}
+		return err
+	}
+	printers, err := s.readPrinters()
+	if err != nil {
+		return err
+	}
+	for _, held := range printers {
+		delete(held, key)
+	}
+	return s.writePrinters(printers)
+}
+
+// Stored returns the logos printer holds, by key.
+func (s *Store) Stored(printer string) (map[string]Record, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	printers, err := s.readPrinters()
+	if err != nil {
+		return nil, err
+	}
+	held := map[string]Record{}
+	for k, v := range printers[printer] {
+		held[k] = v
+	}
+	return held, nil
+}
+
+// SetStored records that printer holds the logo key; a nil rec forgets it.
+func (s *Store) SetStored(printer, key string, rec *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	printers, err := s.readPrinters()
+	if err != nil {
+		return err
+	}
+	if rec == nil {
+		delete(printers[printer], key)
+	} else {
+		if printers[printer] == nil {
+			printers[printer] = map[string]Record{}
+		}
+		printers[printer][key] = *rec
+	}
+	return s.writePrinters(printers)
+}
+
+// ReplaceStored replaces everything recorded for printer with held.
+func (s *Store) ReplaceStored(printer string, held map[string]Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	printers, err := s.readPrinters()
+	if err != nil {
+		return err
+	}
+	printers[printer] = held
+	return s.writePrinters(printers)
+}
+
+func (s *Store) readPrinters() (map[string]map[string]Record, error) {
+	printers := map[string]map[string]Record{}
+	data, err := os.ReadFile(filepath.Join(s.dir, printersFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return printers, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &printers); err != nil {
+		return nil, fmt.Errorf("%s: %w", printersFile, err)
+	}
+	return printers, nil
+}
+
+func (s *Store) writePrinters(printers map[string]map[string]Record) error {
+	data, err := json.MarshalIndent(printers, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(s.dir, printersFile), data)
+}
+
+// writeFile writes data to a temporary file and renames it over path, so
+// a crash mid-write leaves the old file or the new one, never a torn one.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func (s *Store) path(key string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(key))+fileExt)
+}
+
+func hash(img *printing.Bitmap) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%dx%d:", img.Width, img.Height)
+	h.Write(img.Pix)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
@@ -0,0 +1,82 @@
+package logos
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"ble-printer-bridge/internal/printing"
+)
+
+func TestStoreRoundTrip(t *testing.T) {
+	s := NewStore(t.TempDir())
+	img := printing.NewBitmap(10, 3)
+	img.Set(9, 2, true)
+
+	// Keys differing only in case are distinct logos.
+	info, err := s.Save("LG", img)
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if _, err := s.Save("lg", printing.NewBitmap(8, 1)); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if _, err := s.Save("L", img); err == nil {
+		t.Fatal("expected an error for an invalid key")
+	}
+
+	got, loaded, err := s.Get("LG")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if !got.At(9, 2) || got.Width != 10 || loaded.Hash != info.Hash {
+		t.Fatalf("reloaded logo %+v differs from saved %+v", loaded, info)
+	}
+	list, err := s.List()
+	if err != nil || len(list) != 2 || list[0].Key != "LG" || list[1].Key != "lg" {
+		t.Fatalf("List = %+v, %v", list, err)
+	}
+
+	rec := Record{Hash: info.Hash, Command: "graphics"}
+	if err := s.SetStored("AA:BB", "LG", &rec); err != nil {
+		t.Fatalf("set stored: %v", err)
+	}
+	if held, err := s.Stored("AA:BB"); err != nil || held["LG"].Hash != info.Hash {
+		t.Fatalf("Stored = %+v, %v", held, err)
+	}
+
+	if err := s.Delete("LG"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if held, _ := s.Stored("AA:BB"); len(held) != 0 {
+		t.Fatalf("record survived delete: %+v", held)
+	}
+	if _, _, err := s.Get("LG"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("get after delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSetStoredKeepsRecordsWhenWriteFails(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(dir)
+	rec := Record{Hash: "abc", Command: "graphics"}
+	if err := s.SetStored("AA:BB", "LG", &rec); err != nil {
+		t.Fatalf("set stored: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, printersFile+".tmp")); !os.IsNotExist(err) {
+		t.Fatalf("temporary file left behind: %v", err)
+	}
+
+	// A directory in the temporary file's place makes the next write fail
+	// before printers.json is touched.
+	if err := os.Mkdir(filepath.Join(dir, printersFile+".tmp"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetStored("AA:BB", "LG", nil); err == nil {
+		t.Fatal("expected the write to fail")
+	}
+	if held, err := s.Stored("AA:BB"); err != nil || held["LG"] != rec {
+		t.Fatalf("Stored after failed write = %+v, %v; want the old record", held, err)
+	}
+}
@@ -0,0 +1,121 @@
+package printing
+
+// Arabic letters are stored in logical order as base characters; printers
+// and the raster path need the contextual presentation forms (U+FE70 to
+// U+FEFF) that fonts draw connected.
+
+// arabicForms maps a base letter to its presentation forms: isolated,
+// final, initial and medial. Right-joining letters only have the first two.
+var arabicForms = map[rune][]rune{}
+
+func init() {
+	dual := []rune{0x0626, 0x0628, 0x062A, 0x062B, 0x062C, 0x062D, 0x062E,
+		0x0633, 0x0634, 0x0635, 0x0636, 0x0637, 0x0638, 0x0639, 0x063A,
+		0x0641, 0x0642, 0x0643, 0x0644, 0x0645, 0x0646, 0x0647, 0x064A}
+	right := []rune{0x0622, 0x0623, 0x0624, 0x0625, 0x0627, 0x0629,
+		0x062F, 0x0630, 0x0631, 0x0632, 0x0648, 0x0649}
+	isDual := map[rune]bool{}
+	for _, r := range dual {
+		isDual[r] = true
+	}
+	for _, r := range right {
+		isDual[r] = false
+	}
+	// Presentation forms are laid out in code point order of the base
+	// letters, two or four per letter, starting after isolated hamza.
+	next := rune(0xFE81)
+	for r := rune(0x0622); r <= 0x064A; r++ {
+		d, ok := isDual[r]
+		if !ok {
+			continue
+		}
+		if d {
+			arabicForms[r] = []rune{next, next + 1, next + 2, next + 3}
+			next += 4
+		} else {
+			arabicForms[r] = []rune{next, next + 1}
+			next += 2
+		}
+	}
+	arabicForms[0x0621] = []rune{0xFE80}
+}
+
+// lamAlef maps the alef following a lam to the isolated form of the
+// ligature; the final form is the next code point.
+var lamAlef = map[rune]rune{0x0622: 0xFEF5, 0x0623: 0xFEF7, 0x0625: 0xFEF9, 0x0627: 0xFEFB}
+
+const (
+	arabicLam     = 0x0644
+	arabicTatweel = 0x0640
+)
+
+// arabicTransparent reports marks (harakat) that do not affect joining.
+func arabicTransparent(r rune) bool {
+	return r >= 0x064B && r <= 0x065F || r == 0x0670
+}
+
+// joinsNext reports whether r connects to the following letter.
+func joinsNext(r rune) bool {
+	return r == arabicTatweel || len(arabicForms[r]) == 4
+}
+
+// joinsPrev reports whether r connects to the preceding letter.
+func joinsPrev(r rune) bool {
+	return r == arabicTatweel || len(arabicForms[r]) >= 2
+}
+
+// ShapeArabic replaces Arabic letters with their contextual presentation
+// forms and lam-alef ligatures. Other text is returned unchanged.
+func ShapeArabic(s string) string {
+	in := []rune(s)
+	out := make([]rune, 0, len(in))
+	neighbour := func(i, step int) rune {
+		for j := i + step; j >= 0 && j < len(in); j += step {
+			if !arabicTransparent(in[j]) {
+				return in[j]
+			}
+		}
+		return 0
+	}
+	skip := -1
+	for i, r := range in {
+		if i == skip {
+			continue
+		}
+		forms, ok := arabicForms[r]
+		if !ok {
+			out = append(out, r)
+			continue
+		}
+		prev := joinsNext(neighbour(i, -1))
+		next := neighbour(i, 1)
+		if r == arabicLam {
+			if lig, ok := lamAlef[next]; ok {
+				if prev {
+					lig++
+				}
+				out = append(out, lig)
+				for j := i + 1; j < len(in); j++ {
+					if in[j] == next {
+						skip = j
+						break
+					}
+					out = append(out, in[j])
+				}
+				continue
+			}
+		}
+		nextJoins := joinsPrev(next) && len(forms) == 4
+		switch {
+		case prev && nextJoins:
+			out = append(out, forms[3])
+		case prev && len(forms) >= 2:
+			out = append(out, forms[1])
+		case nextJoins:
+			out = append(out, forms[2])
+		default:
+			out = append(out, forms[0])
+		}
+	}
+	return string(out)
+}
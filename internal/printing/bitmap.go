@@ -0,0 +1,200 @@
+package printing
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// Bitmap is a 1-bit image as printed by a thermal head. Rows are packed
+// MSB-first, Stride bytes per row; a set bit is a black dot.
+type Bitmap struct {
+	Width  int
+	Height int
+	Stride int
+	Pix    []byte
+}
+
+func NewBitmap(width, height int) *Bitmap {
+	if width < 0 {
+		width = 0
+	}
+	if height < 0 {
+		height = 0
+	}
+	stride := (width + 7) / 8
+	return &Bitmap{Width: width, Height: height, Stride: stride, Pix: make([]byte, stride*height)}
+}
+
+// bitmapFromPacked wraps packed row data, padding it if data is short.
+func bitmapFromPacked(widthBytes, height int, data []byte) *Bitmap {
+	b := NewBitmap(widthBytes*8, height)
+	copy(b.Pix, data)
+	return b
+}
+
+func (b *Bitmap) At(x, y int) bool {
+	if x < 0 || y < 0 || x >= b.Width || y >= b.Height {
+		return false
+	}
+	return b.Pix[y*b.Stride+x/8]&(0x80>>uint(x%8)) != 0
+}
+
+func (b *Bitmap) Set(x, y int, black bool) {
+	if x < 0 || y < 0 || x >= b.Width || y >= b.Height {
+		return
+	}
+	mask := byte(0x80 >> uint(x%8))
+	if black {
+		b.Pix[y*b.Stride+x/8] |= mask
+	} else {
+		b.Pix[y*b.Stride+x/8] &^= mask
+	}
+}
+
+// FillRect sets every dot in the rectangle.
+func (b *Bitmap) FillRect(x, y, w, h int, black bool) {
+	for yy := y; yy < y+h; yy++ {
+		for xx := x; xx < x+w; xx++ {
+			b.Set(xx, yy, black)
+		}
+	}
+}
+
+// Draw copies the black dots of src onto b at (x, y).
+func (b *Bitmap) Draw(src *Bitmap, x, y int) {
+	for sy := 0; sy < src.Height; sy++ {
+		for sx := 0; sx < src.Width; sx++ {
+			if src.At(sx, sy) {
+				b.Set(x+sx, y+sy, true)
+			}
+		}
+	}
+}
+
+// Row returns the packed bytes of row y.
+func (b *Bitmap) Row(y int) []byte {
+	return b.Pix[y*b.Stride : (y+1)*b.Stride]
+}
+
+// Image converts the bitmap to a grayscale image (black dots on white).
+func (b *Bitmap) Image() *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, b.Width, b.Height))
+	for y := 0; y < b.Height; y++ {
+		for x := 0; x < b.Width; x++ {
+			if b.At(x, y) {
+				img.SetGray(x, y, color.Gray{Y: 0})
+			} else {
+				img.SetGray(x, y, color.Gray{Y: 255})
+			}
+		}
+	}
+	return img
+}
+
+// PNG encodes the bitmap as a grayscale PNG.
+func (b *Bitmap) PNG() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, b.Image()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// BitmapFromImage converts img to a 1-bit bitmap. When width > 0 the image
+// is scaled to that many dots wide, keeping its aspect ratio. With dither
+// set, Floyd–Steinberg error diffusion is used; otherwise a 50% threshold.
+func BitmapFromImage(img image.Image, width int, dither bool) *Bitmap {
+	bounds := img.Bounds()
+	if width > 0 && width != bounds.Dx() && bounds.Dx() > 0 {
+		height := bounds.Dy() * width / bounds.Dx()
+		if height < 1 {
+			height = 1
+		}
+		scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+		xdraw.ApproxBiLinear.Scale(scaled, scaled.Bounds(), img, bounds, xdraw.Over, nil)
+		img = scaled
+		bounds = scaled.Bounds()
+	}
+
+	w, h := bounds.Dx(), bounds.Dy()
+	lum := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, a := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			// Composite onto white so transparent areas stay unprinted.
+			l := (0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)) / 65535
+			alpha := float64(a) / 65535
+			lum[y*w+x] = l*alpha + (1 - alpha)
+		}
+	}
+
+	out := NewBitmap(w, h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			old := lum[y*w+x]
+			black := old < 0.5
+			out.Set(x, y, black)
+			if !dither {
+				continue
+			}
+			next := 1.0
+			if black {
+				next = 0
+			}
+			e := old - next
+			spread := func(dx, dy int, f float64) {
+				nx, ny := x+dx, y+dy
+				if nx >= 0 && nx < w && ny < h {
+					lum[ny*w+nx] += e * f
+				}
+			}
+			spread(1, 0, 7.0/16)
+			spread(-1, 1, 3.0/16)
+			spread(0, 1, 5.0/16)
+			spread(1, 1, 1.0/16)
+		}
+	}
+	return out
+}
+
+// Rotate returns the bitmap rotated clockwise by 0, 90, 180 or 270 degrees.
+func (b *Bitmap) Rotate(degrees int) *Bitmap {
+	switch ((degrees % 360) + 360) % 360 {
+	case 90:
+		out := NewBitmap(b.Height, b.Width)
+		for y := 0; y < b.Height; y++ {
+			for x := 0; x < b.Width; x++ {
+				if b.At(x, y) {
+					out.Set(b.Height-1-y, x, true)
+				}
+			}
+		}
+		return out
+	case 180:
+		out := NewBitmap(b.Width, b.Height)
+		for y := 0; y < b.Height; y++ {
+			for x := 0; x < b.Width; x++ {
+				if b.At(x, y) {
+					out.Set(b.Width-1-x, b.Height-1-y, true)
+				}
+			}
+		}
+		return out
+	case 270:
+		out := NewBitmap(b.Height, b.Width)
+		for y := 0; y < b.Height; y++ {
+			for x := 0; x < b.Width; x++ {
+				if b.At(x, y) {
+					out.Set(y, b.Width-1-x, true)
+				}
+			}
+		}
+		return out
+	default:
+		return b
+	}
+}
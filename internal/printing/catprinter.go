@@ -0,0 +1,156 @@
+package printing
+
+import "bytes"
+
+// Cat-style mini printers (GB01/GB02/GB03, MX05/MX06/MX10 and clones) accept
+// only framed commands:
+//
+//	0x51 0x78 cmd 0x00 len 0x00 payload... crc8(payload) 0xFF
+//
+// with a little-endian 16-bit payload length (the high byte is usually 0)
+// and a CRC-8 (polynomial 0x07) over the payload only.
+const (
+	catRetractPaper   = 0xA0
+	catFeedPaper      = 0xA1
+	catDrawBitmap     = 0xA2
+	catGetDevState    = 0xA3
+	catSetQuality     = 0xA4
+	catControlLattice = 0xA6
+	catSetEnergy      = 0xAF
+	catSetSpeed       = 0xBD
+	catDrawingMode    = 0xBE
+)
+
+var (
+	catLatticeStart = []byte{0xAA, 0x55, 0x17, 0x38, 0x44, 0x5F, 0x5F, 0x5F, 0x44, 0x38, 0x2C}
+	catLatticeEnd   = []byte{0xAA, 0x55, 0x17, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x17}
+)
+
+// CatOptions controls the head and paper handling of a cat printer job.
+type CatOptions struct {
+	// Energy is the heating energy; higher prints darker (typical 8000-16000).
+	Energy uint16
+	// Speed is the motor speed for image printing; lower is slower and darker.
+	Speed byte
+	// FeedLines is the paper advance after the image, in dot rows.
+	FeedLines int
+	// HeadDots is the head's printable width; labels are padded or
+	// cropped to it. Zero means 384, the width of most models.
+	HeadDots int
+}
+
+// DefaultCatOptions are values that work on most GB01/MX clones.
+var DefaultCatOptions = CatOptions{Energy: 12000, Speed: 32, FeedLines: 80}
+
+// EncodeCatRaster frames a bitmap as a cat printer job. Rows wider than
+// the head are clipped by the printer; pass a bitmap exactly as wide as
+// the head (384 dots on most models).
+func EncodeCatRaster(img *Bitmap, opts CatOptions) []byte {
+	if opts.Energy == 0 {
+		opts.Energy = DefaultCatOptions.Energy
+	}
+	if opts.Speed == 0 {
+		opts.Speed = DefaultCatOptions.Speed
+	}
+
+	var b bytes.Buffer
+	b.Write(catFrame(catGetDevState, []byte{0x00}))
+	b.Write(catFrame(catSetQuality, []byte{0x32})) // 200 dpi
+	b.Write(catFrame(catSetEnergy, []byte{byte(opts.Energy), byte(opts.Energy >> 8)}))
+	b.Write(catFrame(catDrawingMode, []byte{0x00})) // image mode
+	b.Write(catFrame(catSetSpeed, []byte{opts.Speed}))
+	b.Write(catFrame(catControlLattice, catLatticeStart))
+	for y := 0; y < img.Height; y++ {
+		b.Write(catFrame(catDrawBitmap, catRow(img.Row(y))))
+	}
+	b.Write(catFrame(catSetSpeed, []byte{0x19}))
+	b.Write(catFeed(opts.FeedLines))
+	b.Write(catFrame(catControlLattice, catLatticeEnd))
+	b.Write(catFrame(catGetDevState, []byte{0x00}))
+	return b.Bytes()
+}
+
+// EncodeCatLabel validates and draws a label, then frames it as a cat
+// printer job. The label is drawn from the left edge of the head: a
+// narrower one is padded with white and a wider one cropped, so every row
+// is as wide as the head.
+func EncodeCatLabel(l *Label, opts CatOptions) ([]byte, error) {
+	if err := l.Validate(); err != nil {
+		return nil, err
+	}
+	img, err := RenderLabel(l)
+	if err != nil {
+		return nil, err
+	}
+	head := opts.HeadDots
+	if head <= 0 {
+		head = PrintableDots(58)
+	}
+	if img.Width != head {
+		fitted := NewBitmap(head, img.Height)
+		fitted.Draw(img, 0, 0)
+		img = fitted
+	}
+	return EncodeCatRaster(img, opts), nil
+}
+
+// catFeed advances the paper, splitting long feeds into frames the
+// printer accepts.
+func catFeed(lines int) []byte {
+	var b bytes.Buffer
+	for lines > 0 {
+		n := min(lines, 0xFF)
+		b.Write(catFrame(catFeedPaper, []byte{byte(n), 0x00}))
+		lines -= n
+	}
+	return b.Bytes()
+}
+
+// catRow converts an MSB-first packed row to the printer's LSB-first order.
+func catRow(row []byte) []byte {
+	out := make([]byte, len(row))
+	for i, v := range row {
+		out[i] = reverseBits(v)
+	}
+	return out
+}
+
+func reverseBits(v byte) byte {
+	v = v>>4 | v<<4
+	v = (v&0xCC)>>2 | (v&0x33)<<2
+	v = (v&0xAA)>>1 | (v&0x55)<<1
+	return v
+}
+
+func catFrame(cmd byte, payload []byte) []byte {
+	frame := make([]byte, 0, len(payload)+8)
+	frame = append(frame, 0x51, 0x78, cmd, 0x00, byte(len(payload)), byte(len(payload)>>8))
+	frame = append(frame, payload...)
+	frame = append(frame, crc8(payload), 0xFF)
+	return frame
+}
+
+var crc8Table = func() [256]byte {
+	var t [256]byte
+	for i := range t {
+		c := byte(i)
+		for bit := 0; bit < 8; bit++ {
+			if c&0x80 != 0 {
+				c = c<<1 ^ 0x07
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return t
+}()
+
+// crc8 is CRC-8/SMBUS (polynomial 0x07, initial value 0).
+func crc8(data []byte) byte {
+	var c byte
+	for _, v := range data {
+		c = crc8Table[c^v]
+	}
+	return c
+}
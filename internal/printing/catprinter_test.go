@@ -0,0 +1,128 @@
+package printing
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Frames captured from the vendor app talking to a GB01.
+var (
+	capturedGetDevState  = []byte{0x51, 0x78, 0xA3, 0x00, 0x01, 0x00, 0x00, 0x00, 0xFF}
+	capturedQuality200   = []byte{0x51, 0x78, 0xA4, 0x00, 0x01, 0x00, 0x32, 0x9E, 0xFF}
+	capturedLatticeStart = []byte{0x51, 0x78, 0xA6, 0x00, 0x0B, 0x00, 0xAA, 0x55, 0x17, 0x38, 0x44, 0x5F, 0x5F, 0x5F, 0x44, 0x38, 0x2C, 0xA1, 0xFF}
+	capturedLatticeEnd   = []byte{0x51, 0x78, 0xA6, 0x00, 0x0B, 0x00, 0xAA, 0x55, 0x17, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x17, 0x11, 0xFF}
+	capturedSetPaper     = []byte{0x51, 0x78, 0xA1, 0x00, 0x02, 0x00, 0x30, 0x00, 0xF9, 0xFF}
+	capturedTextMode     = []byte{0x51, 0x78, 0xBE, 0x00, 0x01, 0x00, 0x01, 0x07, 0xFF}
+	capturedEnergy12000  = []byte{0x51, 0x78, 0xAF, 0x00, 0x02, 0x00, 0xE0, 0x2E, 0x89, 0xFF}
+)
+
+func TestCRC8(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want byte
+	}{
+		{nil, 0x00},
+		{[]byte{0x00}, 0x00},
+		{[]byte{0x01}, 0x07},
+		{[]byte{0x32}, 0x9E},
+		{[]byte{0x30, 0x00}, 0xF9},
+		{[]byte("123456789"), 0xF4}, // CRC-8/SMBUS check value
+	}
+	for _, tt := range tests {
+		if got := crc8(tt.data); got != tt.want {
+			t.Fatalf("crc8(% X) = %02X, want %02X", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestCatFrameMatchesCaptures(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     byte
+		payload []byte
+		want    []byte
+	}{
+		{"get dev state", catGetDevState, []byte{0x00}, capturedGetDevState},
+		{"quality 200 dpi", catSetQuality, []byte{0x32}, capturedQuality200},
+		{"lattice start", catControlLattice, catLatticeStart, capturedLatticeStart},
+		{"lattice end", catControlLattice, catLatticeEnd, capturedLatticeEnd},
+		{"feed 48 rows", catFeedPaper, []byte{0x30, 0x00}, capturedSetPaper},
+		{"text mode", catDrawingMode, []byte{0x01}, capturedTextMode},
+		{"energy 12000", catSetEnergy, []byte{0xE0, 0x2E}, capturedEnergy12000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := catFrame(tt.cmd, tt.payload); !bytes.Equal(got, tt.want) {
+				t.Fatalf("frame = % X, want % X", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCatRowIsLSBFirst(t *testing.T) {
+	img := NewBitmap(16, 1)
+	img.Set(0, 0, true)
+	img.Set(9, 0, true)
+	want := []byte{0x51, 0x78, 0xA2, 0x00, 0x02, 0x00, 0x01, 0x02}
+	want = append(want, crc8([]byte{0x01, 0x02}), 0xFF)
+	if got := catFrame(catDrawBitmap, catRow(img.Row(0))); !bytes.Equal(got, want) {
+		t.Fatalf("row frame = % X, want % X", got, want)
+	}
+}
+
+func TestEncodeCatRaster(t *testing.T) {
+	img := NewBitmap(384, 3)
+	img.FillRect(0, 1, 8, 1, true)
+	job := EncodeCatRaster(img, CatOptions{FeedLines: 300})
+
+	if !bytes.HasPrefix(job, append(append([]byte{}, capturedGetDevState...), capturedQuality200...)) {
+		t.Fatalf("job should start with device state and quality frames: % X", job[:18])
+	}
+	if !bytes.Contains(job, capturedEnergy12000) {
+		t.Fatalf("job should set default energy")
+	}
+	start := bytes.Index(job, capturedLatticeStart)
+	end := bytes.Index(job, capturedLatticeEnd)
+	if start < 0 || end < start {
+		t.Fatalf("lattice markers missing or out of order: start=%d end=%d", start, end)
+	}
+	if n := bytes.Count(job, []byte{0x51, 0x78, catDrawBitmap, 0x00, 48, 0x00}); n != 3 {
+		t.Fatalf("expected 3 bitmap rows of 48 bytes, got %d", n)
+	}
+	if n := bytes.Count(job, []byte{0x51, 0x78, catFeedPaper, 0x00, 0x02, 0x00}); n != 2 {
+		t.Fatalf("feed of 300 rows should be split into 2 frames, got %d", n)
+	}
+	if !bytes.HasSuffix(job, capturedGetDevState) {
+		t.Fatalf("job should end with a device state query")
+	}
+}
+
+func TestEncodeCatLabelFitsHead(t *testing.T) {
+	tests := []struct {
+		name     string
+		widthMM  float64
+		headDots int
+		wantRow  int
+	}{
+		{"narrow label padded", 30, 0, 48},
+		{"wide label cropped", 60, 0, 48},
+		{"exact width", 48, 384, 48},
+		{"80 mm head", 50, 576, 72},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &Label{WidthMM: tt.widthMM, HeightMM: 10, Elements: []LabelElement{
+				{Type: LabelBox, X: 0, Y: 0, Width: int(tt.widthMM * 8), Height: 80},
+			}}
+			job, err := EncodeCatLabel(l, CatOptions{HeadDots: tt.headDots})
+			if err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+			rows := bytes.Count(job, []byte{0x51, 0x78, catDrawBitmap, 0x00})
+			want := []byte{0x51, 0x78, catDrawBitmap, 0x00, byte(tt.wantRow), 0x00}
+			if n := bytes.Count(job, want); n != l.HeightDots() || n != rows {
+				t.Fatalf("%d of %d bitmap rows are %d bytes wide, want all %d", n, rows, tt.wantRow, l.HeightDots())
+			}
+		})
+	}
+}
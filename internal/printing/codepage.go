@@ -0,0 +1,117 @@
+package printing
+
+import (
+	"sort"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// CodePage is a single-byte character table selectable with ESC t n.
+type CodePage struct {
+	Name   string
+	Number byte // ESC t n
+	table  *charmap.Charmap
+}
+
+// codePages lists the Epson ESC t tables that have a standard mapping.
+var codePages = []CodePage{
+	{Name: "cp437", Number: 0, table: charmap.CodePage437},
+	{Name: "cp850", Number: 2, table: charmap.CodePage850},
+	{Name: "cp860", Number: 3, table: charmap.CodePage860},
+	{Name: "cp863", Number: 4, table: charmap.CodePage863},
+	{Name: "cp865", Number: 5, table: charmap.CodePage865},
+	{Name: "cp1252", Number: 16, table: charmap.Windows1252},
+	{Name: "cp866", Number: 17, table: charmap.CodePage866},
+	{Name: "cp852", Number: 18, table: charmap.CodePage852},
+	{Name: "cp858", Number: 19, table: charmap.CodePage858},
+	{Name: "iso8859-7", Number: 21, table: charmap.ISO8859_7},
+	{Name: "cp1251", Number: 46, table: charmap.Windows1251},
+	{Name: "cp1253", Number: 47, table: charmap.Windows1253},
+	{Name: "cp1254", Number: 48, table: charmap.Windows1254},
+	{Name: "cp1255", Number: 49, table: charmap.Windows1255},
+	{Name: "cp1256", Number: 50, table: charmap.Windows1256},
+	{Name: "cp1257", Number: 51, table: charmap.Windows1257},
+	{Name: "cp1258", Number: 52, table: charmap.Windows1258},
+	{Name: "iso8859-2", Number: 39, table: charmap.ISO8859_2},
+	{Name: "iso8859-15", Number: 40, table: charmap.ISO8859_15},
+}
+
+// DefaultCodePage is the table printers select after ESC @.
+var DefaultCodePage = codePages[0]
+
+// LookupCodePage finds a code page by name ("cp858", "CP-858", "858").
+func LookupCodePage(name string) (CodePage, bool) {
+	n := strings.ToLower(strings.TrimSpace(name))
+	n = strings.ReplaceAll(n, "-", "")
+	n = strings.ReplaceAll(n, "_", "")
+	for _, cp := range codePages {
+		candidate := strings.ReplaceAll(cp.Name, "-", "")
+		if n == candidate || "cp"+n == candidate || n == strings.TrimPrefix(candidate, "cp") {
+			return cp, true
+		}
+	}
+	return CodePage{}, false
+}
+
+// CodePageByNumber finds the code page selected by ESC t n.
+func CodePageByNumber(n byte) (CodePage, bool) {
+	for _, cp := range codePages {
+		if cp.Number == n {
+			return cp, true
+		}
+	}
+	return CodePage{}, false
+}
+
+// CodePageNames lists the supported code page names.
+func CodePageNames() []string {
+	names := make([]string, 0, len(codePages))
+	for _, cp := range codePages {
+		names = append(names, cp.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Decode converts a byte from this table to a rune.
+func (cp CodePage) Decode(b byte) rune {
+	if b < 0x80 || cp.table == nil {
+		return rune(b)
+	}
+	return cp.table.DecodeByte(b)
+}
+
+// Encode converts a rune to a byte in this table.
+func (cp CodePage) Encode(r rune) (byte, bool) {
+	if r < 0x80 {
+		return byte(r), true
+	}
+	if cp.table == nil {
+		return 0, false
+	}
+	return cp.table.EncodeRune(r)
+}
+
+// CanEncode reports whether every rune of s exists in this table.
+func (cp CodePage) CanEncode(s string) bool {
+	for _, r := range s {
+		if _, ok := cp.Encode(r); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// EncodeString converts s to this table, replacing unknown runes with '?'.
+func (cp CodePage) EncodeString(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		b, ok := cp.Encode(r)
+		if !ok {
+			b = '?'
+		}
+		out = append(out, b)
+	}
+	return out
+}
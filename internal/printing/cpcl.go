@@ -0,0 +1,123 @@
+package printing
+
+import (
+	"bytes"
+	"fmt"
+)
+
+var cpclSymbologies = map[string]string{
+	"CODE128": "128",
+	"CODE39":  "39",
+	"CODE93":  "93",
+	"EAN13":   "EAN13",
+	"EAN8":    "EAN8",
+	"UPC-A":   "UPCA",
+	"UPC-E":   "UPCE",
+	"ITF":     "I2OF5",
+	"CODABAR": "CODABAR",
+}
+
+// encodeCPCL encodes a validated label as a CPCL (Comtec/Zebra mobile) page.
+func encodeCPCL(l *Label) ([]byte, error) {
+	var b bytes.Buffer
+	line := func(format string, args ...any) {
+		fmt.Fprintf(&b, format, args...)
+		b.WriteString("\r\n")
+	}
+	unsupported := func(i int, e LabelElement, reason string) error {
+		return &UnsupportedError{Language: LanguageCPCL, Index: i, Type: e.Type, Reason: reason}
+	}
+
+	line("! 0 200 200 %d %d", l.HeightDots(), l.Copies)
+	line("PAGE-WIDTH %d", l.WidthDots())
+
+	for i, e := range l.Elements {
+		switch e.Type {
+		case LabelText:
+			cmd := "TEXT"
+			if e.Rotation != 0 {
+				cmd = fmt.Sprintf("TEXT%d", e.Rotation)
+			}
+			font, mul := e.Font, 1
+			if font == "" {
+				font, mul = "7", textMultiplier(e.Size)
+			}
+			if mul > 1 {
+				line("SETMAG %d %d", mul, mul)
+			}
+			line("%s %s 0 %d %d %s", cmd, font, e.X, e.Y, e.Text)
+			if mul > 1 {
+				line("SETMAG 0 0")
+			}
+		case LabelBarcode:
+			sym, ok := cpclSymbologies[e.Symbology]
+			if !ok {
+				return nil, unsupported(i, e, "symbology "+e.Symbology+" is not available")
+			}
+			cmd := "BARCODE"
+			switch e.Rotation {
+			case 0:
+			case 90:
+				cmd = "VBARCODE"
+			default:
+				return nil, unsupported(i, e, fmt.Sprintf("barcodes can only be rotated 0 or 90 degrees, not %d", e.Rotation))
+			}
+			if e.HRI {
+				line("BARCODE-TEXT 7 0 5")
+			}
+			line("%s %s %d 1 %d %d %d %s", cmd, sym, e.ModuleWidth, e.Height, e.X, e.Y, e.Data)
+			if e.HRI {
+				line("BARCODE-TEXT OFF")
+			}
+		case LabelQRCode:
+			if e.Rotation != 0 {
+				return nil, unsupported(i, e, "QR codes cannot be rotated")
+			}
+			line("BARCODE QR %d %d M 2 U %d", e.X, e.Y, e.ModuleWidth)
+			line("%sA,%s", e.ECC, e.Data)
+			line("ENDQR")
+		case LabelBitmap:
+			img, err := e.decodeBitmap()
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			if e.Rotation != 0 {
+				img = img.Rotate(e.Rotation)
+			}
+			fmt.Fprintf(&b, "EG %d %d %d %d ", img.Stride, img.Height, e.X, e.Y)
+			for _, v := range img.Pix {
+				fmt.Fprintf(&b, "%02X", v)
+			}
+			b.WriteString("\r\n")
+		case LabelBox:
+			if e.Rotation == 90 || e.Rotation == 270 {
+				e.Width, e.Height = e.Height, e.Width
+			}
+			line("BOX %d %d %d %d %d", e.X, e.Y, e.X+e.Width, e.Y+e.Height, e.Thickness)
+		case LabelLine:
+			w, h := e.Width, e.Height
+			if e.Rotation == 90 || e.Rotation == 270 {
+				w, h = h, w
+			}
+			// CPCL LINE draws from (x0,y0) to (x1,y1) with a thickness.
+			if h > w {
+				line("LINE %d %d %d %d %d", e.X, e.Y, e.X, e.Y+h, max(w, e.Thickness))
+			} else {
+				line("LINE %d %d %d %d %d", e.X, e.Y, e.X+w, e.Y, max(h, e.Thickness))
+			}
+		case LabelCircle:
+			return nil, unsupported(i, e, "CPCL has no circle command")
+		}
+	}
+
+	switch l.Sensor {
+	case "mark":
+		line("BAR-SENSE")
+		line("FORM")
+	case "gap":
+		line("GAP-SENSE")
+		line("FORM")
+	}
+	line("PRINT")
+	return b.Bytes(), nil
+}
@@ -0,0 +1,933 @@
+package printing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TextStyle is the character formatting in effect for a run of text.
+type TextStyle struct {
+	Font       Font `json:"font"`
+	Bold       bool `json:"bold,omitempty"`
+	Underline  int  `json:"underline,omitempty"` // 0 off, 1 thin, 2 thick
+	Invert     bool `json:"invert,omitempty"`
+	Width      int  `json:"width"`  // 1..8
+	Height     int  `json:"height"` // 1..8
+	UpsideDown bool `json:"upside_down,omitempty"`
+}
+
+// TextRun is text printed with a single style.
+type TextRun struct {
+	Text  string    `json:"text"`
+	Style TextStyle `json:"style"`
+}
+
+// ElementKind identifies what a page element prints.
+type ElementKind string
+
+const (
+	ElementText    ElementKind = "text"
+	ElementImage   ElementKind = "image"
+	ElementBarcode ElementKind = "barcode"
+	ElementQRCode  ElementKind = "qrcode"
+	ElementFeed    ElementKind = "feed"
+	ElementCut     ElementKind = "cut"
+)
+
+// Element is one printed item on the page, in paper order.
+type Element struct {
+	Kind   ElementKind `json:"kind"`
+	Offset int         `json:"offset"`
+	Align  Align       `json:"align"`
+
+	// Text lines.
+	Runs        []TextRun `json:"runs,omitempty"`
+	LineSpacing int       `json:"line_spacing,omitempty"`
+
+	// Raster images.
+	Image *Bitmap `json:"-"`
+
+	// Barcodes and QR codes.
+	Symbology   string `json:"symbology,omitempty"`
+	Data        string `json:"data,omitempty"`
+	ModuleWidth int    `json:"module_width,omitempty"`
+	BarHeight   int    `json:"bar_height,omitempty"`
+	HRI         int    `json:"hri,omitempty"` // 0 none, 1 above, 2 below, 3 both
+	ECC         byte   `json:"ecc,omitempty"`
+
+	// Feeds (in dots) and cuts.
+	Dots    int  `json:"dots,omitempty"`
+	Partial bool `json:"partial,omitempty"`
+}
+
+// DecodeWarning reports a command the decoder skipped or could not model.
+type DecodeWarning struct {
+	Offset  int    `json:"offset"`
+	Command string `json:"command"`
+	Message string `json:"message"`
+}
+
+// Page is the decoded content of an ESC/POS stream.
+type Page struct {
+	Elements []Element       `json:"elements"`
+	Warnings []DecodeWarning `json:"warnings"`
+}
+
+const defaultLineSpacing = 30
+
+// maxHeadDots is wider than any receipt head. Images are cropped to it, as
+// a printer drops dots past the end of its head.
+const maxHeadDots = 2048
+
+var barcodeSymbologies = map[byte]string{
+	0: "UPC-A", 1: "UPC-E", 2: "EAN13", 3: "EAN8", 4: "CODE39", 5: "ITF", 6: "CODABAR",
+	65: "UPC-A", 66: "UPC-E", 67: "EAN13", 68: "EAN8", 69: "CODE39", 70: "ITF", 71: "CODABAR",
+	72: "CODE93", 73: "CODE128",
+}
+
+// DecodeESCPOS parses an ESC/POS byte stream into a page model. It never
+// fails: unknown or unsupported commands are skipped and reported in
+// Page.Warnings.
+func DecodeESCPOS(data []byte) *Page {
+	d := &escposDecoder{data: data, page: &Page{Elements: []Element{}, Warnings: []DecodeWarning{}}}
+	d.reset()
+	d.run()
+	if d.pageMode != nil {
+		d.warn(d.pageMode.start, "ESC L", "page mode data never printed (missing FF)")
+		d.pageMode = nil
+	}
+	d.flushLine(false)
+	return d.page
+}
+
+type escposDecoder struct {
+	data []byte
+	pos  int
+	page *Page
+
+	style       TextStyle
+	align       Align
+	lineSpacing int
+	codePage    CodePage
+
+	runs      []TextRun
+	text      strings.Builder
+	lineStart int
+	lineImage *Bitmap
+
+	barcodeHeight int
+	barcodeWidth  int
+	hri           int
+
+	qrData   string
+	qrModule int
+	qrECC    byte
+
+	graphics *Bitmap
+
+	// Page mode: the print area (x, y, width, height as set by ESC W), the
+	// ESC T print direction and, between ESC L and FF, the page buffer.
+	area      [4]int
+	direction int
+	pageMode  *pageMode
+
+	// commands records the span of every control sequence for ScanESCPOS,
+	// and cutOff is set while the current one ran past the end of the
+	// data, so its span is a guess.
+	commands []Command
+	cutOff   bool
+	// spansOnly is set by ScanESCPOS: commands are parsed for their length
+	// but no images are built and page mode is not rendered.
+	spansOnly bool
+}
+
+func (d *escposDecoder) reset() {
+	d.flushRun()
+	d.style = TextStyle{Width: 1, Height: 1}
+	d.align = AlignLeft
+	d.lineSpacing = defaultLineSpacing
+	d.codePage = DefaultCodePage
+	d.barcodeHeight = 162
+	d.barcodeWidth = 3
+	d.hri = 0
+	d.qrModule = 3
+	d.qrECC = 48
+	d.area = [4]int{}
+	d.direction = 0
+	d.pageMode = nil
+}
+
+func (d *escposDecoder) warn(offset int, command, format string, args ...any) {
+	d.page.Warnings = append(d.page.Warnings, DecodeWarning{
+		Offset:  offset,
+		Command: command,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// args returns the n bytes following the command at start, or false (with a
+// warning) if the stream ends early.
+func (d *escposDecoder) args(start, cmdLen, n int, name string) ([]byte, bool) {
+	from := start + cmdLen
+	if from+n > len(d.data) {
+		d.warn(start, name, "truncated command")
+		d.pos = len(d.data)
+		d.cutOff = true
+		return nil, false
+	}
+	d.pos = from + n
+	return d.data[from : from+n], true
+}
+
+func (d *escposDecoder) setStyle(update func(*TextStyle)) {
+	next := d.style
+	update(&next)
+	if next != d.style {
+		d.flushRun()
+		d.style = next
+	}
+}
+
+func (d *escposDecoder) flushRun() {
+	if d.text.Len() == 0 {
+		return
+	}
+	d.runs = append(d.runs, TextRun{Text: d.text.String(), Style: d.style})
+	d.text.Reset()
+}
+
+// flushLine ends the current print line. With lineFeed set an empty line
+// still advances the paper, matching a bare LF.
+func (d *escposDecoder) flushLine(lineFeed bool) {
+	d.flushRun()
+	if d.pageMode != nil {
+		d.placeLine(lineFeed)
+		return
+	}
+	if d.lineImage != nil {
+		d.page.Elements = append(d.page.Elements, Element{Kind: ElementImage, Offset: d.lineStart, Align: d.align, Image: d.lineImage})
+		d.lineImage = nil
+		if len(d.runs) == 0 {
+			d.lineStart = d.pos
+			return
+		}
+	}
+	if len(d.runs) > 0 || lineFeed {
+		d.page.Elements = append(d.page.Elements, Element{
+			Kind:        ElementText,
+			Offset:      d.lineStart,
+			Align:       d.align,
+			Runs:        d.runs,
+			LineSpacing: d.lineSpacing,
+		})
+	}
+	d.runs = nil
+	d.lineStart = d.pos
+}
+
+func (d *escposDecoder) add(e Element) {
+	d.flushLine(false)
+	if d.pageMode != nil {
+		d.placeElement(e)
+		return
+	}
+	if e.Align == AlignLeft {
+		e.Align = d.align
+	}
+	d.page.Elements = append(d.page.Elements, e)
+}
+
+func (d *escposDecoder) feed(offset, dots int) {
+	d.flushLine(false)
+	if d.pageMode != nil {
+		d.pageMode.x = 0
+		d.pageMode.y += dots
+		return
+	}
+	if dots > 0 {
+		d.page.Elements = append(d.page.Elements, Element{Kind: ElementFeed, Offset: offset, Dots: dots})
+	}
+}
+
+func (d *escposDecoder) run() {
+	for d.pos < len(d.data) {
+		start := d.pos
+		b := d.data[d.pos]
+		switch {
+		case b == 0x1b:
+			d.escCommand(start)
+			d.record(start)
+		case b == 0x1d:
+			d.gsCommand(start)
+			d.record(start)
+		case b == 0x1c:
+			d.fsCommand(start)
+			d.record(start)
+		case b == 0x10:
+			d.dleCommand(start)
+			d.record(start)
+		case b == 0x1f:
+			// US prefixes vendor setup commands on many clone printers.
+			d.pos++
+			d.record(start)
+		case b == '\n':
+			d.pos++
+			d.flushLine(true)
+		case b == '\f':
+			d.pos++
+			if d.pageMode != nil {
+				d.printPage(true)
+			} else {
+				d.flushLine(false)
+			}
+		case b == 0x18 && d.pageMode != nil:
+			// CAN clears the page buffer.
+			d.pos++
+			d.flushLine(false)
+			d.pageMode.items = nil
+		case b == '\t':
+			d.pos++
+			n := 8 - d.lineChars()%8
+			d.text.WriteString(strings.Repeat(" ", n))
+		case b < 0x20:
+			// CR, CAN and other control bytes have no visible effect.
+			d.pos++
+		default:
+			d.pos++
+			if d.text.Len() == 0 && len(d.runs) == 0 && d.lineImage == nil {
+				d.lineStart = start
+			}
+			d.text.WriteRune(d.codePage.Decode(b))
+		}
+	}
+}
+
+func (d *escposDecoder) lineChars() int {
+	n := len([]rune(d.text.String()))
+	for _, r := range d.runs {
+		n += len([]rune(r.Text))
+	}
+	return n
+}
+
+func (d *escposDecoder) escCommand(start int) {
+	if start+1 >= len(d.data) {
+		d.warn(start, "ESC", "truncated command")
+		d.pos = len(d.data)
+		d.cutOff = true
+		return
+	}
+	c := d.data[start+1]
+	name := "ESC " + commandChar(c)
+	arg := func(n int) ([]byte, bool) { return d.args(start, 2, n, name) }
+
+	switch c {
+	case '@':
+		d.pos = start + 2
+		d.reset()
+	case '!':
+		if a, ok := arg(1); ok {
+			n := a[0]
+			d.setStyle(func(s *TextStyle) {
+				s.Font = FontA
+				if n&0x01 != 0 {
+					s.Font = FontB
+				}
+				s.Bold = n&0x08 != 0
+				s.Height = 1 + int(n>>4&1)
+				s.Width = 1 + int(n>>5&1)
+				s.Underline = int(n >> 7 & 1)
+			})
+		}
+	case 'E', 'G':
+		if a, ok := arg(1); ok {
+			d.setStyle(func(s *TextStyle) { s.Bold = a[0]&1 != 0 })
+		}
+	case '-':
+		if a, ok := arg(1); ok {
+			d.setStyle(func(s *TextStyle) { s.Underline = int(a[0]&0x0f) % 3 })
+		}
+	case 'M':
+		if a, ok := arg(1); ok {
+			d.setStyle(func(s *TextStyle) {
+				s.Font = FontA
+				if a[0]&0x0f == 1 {
+					s.Font = FontB
+				}
+			})
+		}
+	case '{':
+		if a, ok := arg(1); ok {
+			d.setStyle(func(s *TextStyle) { s.UpsideDown = a[0]&1 != 0 })
+		}
+	case 'a':
+		if a, ok := arg(1); ok {
+			d.align = Align(clampInt(int(a[0]&0x0f), 0, 2))
+		}
+	case 'd':
+		if a, ok := arg(1); ok {
+			d.feed(start, int(a[0])*d.lineSpacing)
+		}
+	case 'J':
+		if a, ok := arg(1); ok {
+			d.feed(start, int(a[0]))
+		}
+	case '2':
+		d.pos = start + 2
+		d.lineSpacing = defaultLineSpacing
+	case '3':
+		if a, ok := arg(1); ok {
+			d.lineSpacing = int(a[0])
+		}
+	case 't':
+		if a, ok := arg(1); ok {
+			if cp, found := CodePageByNumber(a[0]); found {
+				d.flushRun()
+				d.codePage = cp
+			} else {
+				d.warn(start, name, "code page %d has no preview mapping; using %s", a[0], d.codePage.Name)
+			}
+		}
+	case 'i', 'm':
+		d.pos = start + 2
+		d.add(Element{Kind: ElementCut, Offset: start, Partial: true})
+	case 'p':
+		// Cash drawer kick: nothing to print.
+		arg(3)
+	case '*':
+		d.bitImage(start)
+	case 'R', ' ', 'U', '=', 'r', 'K', 'e', 'u', 'v', '%', '?', 'V', 'c':
+		n := 1
+		if c == 'v' {
+			n = 0
+		} else if c == 'c' {
+			n = 2
+		}
+		if c == 'V' {
+			d.warn(start, name, "print rotation is not rendered in preview")
+		}
+		arg(n)
+	case '$', '\\':
+		a, ok := arg(2)
+		if !ok {
+			return
+		}
+		if d.pageMode == nil {
+			d.warn(start, name, "horizontal positioning is not rendered in preview")
+			return
+		}
+		n := int(a[0]) | int(a[1])<<8
+		d.pagePosition(func(p *pageMode) {
+			if c == '$' {
+				p.x = n
+			} else {
+				p.x += int(int16(n))
+			}
+		})
+	case 'T':
+		if a, ok := arg(1); ok {
+			d.flushLine(false)
+			d.direction = int(a[0]&0x0f) % 4
+		}
+	case 'D':
+		// Tab positions: at most 32 increasing columns, ended by NUL. The
+		// printer ends the list early at the first column that does not
+		// increase and takes that byte as data, so it is scanned as such.
+		end, prev := start+2, -1
+		for end < len(d.data) && end-start-2 < 32 {
+			col := int(d.data[end])
+			if col == 0 {
+				end++
+				break
+			}
+			if col <= prev {
+				break
+			}
+			prev = col
+			end++
+		}
+		d.pos = end
+	case '7':
+		arg(3)
+	case 'L':
+		d.pos = start + 2
+		if d.pageMode == nil {
+			d.enterPageMode(start)
+		}
+	case 'S':
+		// Leaving page mode discards whatever it buffered.
+		d.pos = start + 2
+		if d.pageMode != nil {
+			d.flushLine(false)
+			d.pageMode = nil
+		}
+	case 'W':
+		if a, ok := arg(8); ok {
+			d.flushLine(false)
+			var v [4]int
+			for i := range v {
+				v[i] = int(a[2*i]) | int(a[2*i+1])<<8
+			}
+			// Keep the area on the head and within the page buffer.
+			x, y := min(v[0], maxHeadDots), min(v[1], maxPageModeDots)
+			d.area = [4]int{x, y, min(v[2], maxHeadDots-x), min(v[3], maxPageModeDots-y)}
+		}
+	case 0x0c:
+		d.pos = start + 2
+		if d.pageMode != nil {
+			d.printPage(false)
+		}
+	case '&':
+		d.userChars(start)
+	default:
+		d.pos = start + 2
+		d.warn(start, name, "unknown command")
+	}
+}
+
+func (d *escposDecoder) gsCommand(start int) {
+	if start+1 >= len(d.data) {
+		d.warn(start, "GS", "truncated command")
+		d.pos = len(d.data)
+		d.cutOff = true
+		return
+	}
+	c := d.data[start+1]
+	name := "GS " + commandChar(c)
+	arg := func(n int) ([]byte, bool) { return d.args(start, 2, n, name) }
+
+	switch c {
+	case '!':
+		if a, ok := arg(1); ok {
+			d.setStyle(func(s *TextStyle) {
+				s.Width = 1 + int(a[0]>>4&0x07)
+				s.Height = 1 + int(a[0]&0x07)
+			})
+		}
+	case 'B':
+		if a, ok := arg(1); ok {
+			d.setStyle(func(s *TextStyle) { s.Invert = a[0]&1 != 0 })
+		}
+	case 'V':
+		a, ok := arg(1)
+		if !ok {
+			return
+		}
+		m := a[0]
+		feed := 0
+		if m >= 65 {
+			fa, ok := d.args(start, 3, 1, name)
+			if !ok {
+				return
+			}
+			feed = int(fa[0])
+		}
+		if feed > 0 {
+			d.feed(start, feed)
+		}
+		partial := m == 1 || m == 49 || m == 66 || m == 98 || m == 104
+		d.add(Element{Kind: ElementCut, Offset: start, Partial: partial})
+	case 'v':
+		d.rasterImage(start)
+	case 'k':
+		d.barcode(start)
+	case 'H':
+		if a, ok := arg(1); ok {
+			d.hri = int(a[0]&0x0f) % 4
+		}
+	case 'h':
+		if a, ok := arg(1); ok {
+			d.barcodeHeight = int(a[0])
+		}
+	case 'w':
+		if a, ok := arg(1); ok {
+			d.barcodeWidth = clampInt(int(a[0]), 1, 6)
+		}
+	case 'f', 'a', 'r', 'I', 'b', 'T':
+		arg(1)
+	case 'L', 'W', 'P':
+		arg(2)
+	case '$', '\\':
+		// Vertical positions only apply in page mode.
+		a, ok := arg(2)
+		if !ok || d.pageMode == nil {
+			return
+		}
+		n := int(a[0]) | int(a[1])<<8
+		d.pagePosition(func(p *pageMode) {
+			if c == '$' {
+				p.y = n
+			} else {
+				p.y += int(int16(n))
+			}
+		})
+	case '(':
+		d.gsParenCommand(start)
+	case '8':
+		d.gsEightCommand(start)
+	case '*':
+		if a, ok := arg(2); ok {
+			n := int(a[0]) * int(a[1]) * 8
+			d.args(start, 4, n, name)
+			d.warn(start, name, "downloaded bit images are not stored in preview")
+		}
+	case '/':
+		arg(1)
+		d.warn(start, name, "downloaded bit image print is not rendered in preview")
+	case ':':
+		d.pos = start + 2
+	default:
+		d.pos = start + 2
+		d.warn(start, name, "unknown command")
+	}
+}
+
+func (d *escposDecoder) gsParenCommand(start int) {
+	hdr, ok := d.args(start, 2, 3, "GS (")
+	if !ok {
+		return
+	}
+	fn := hdr[0]
+	name := "GS ( " + commandChar(fn)
+	n := int(hdr[1]) | int(hdr[2])<<8
+	payload, ok := d.args(start, 5, n, name)
+	if !ok {
+		return
+	}
+	switch fn {
+	case 'k':
+		d.symbol(start, payload)
+	case 'L':
+		d.graphicsCommand(start, name, payload)
+	case 'K', 'N', 'D':
+		// Print density, character colour and real-time enable: no visible effect.
+	default:
+		d.warn(start, name, "command ignored in preview")
+	}
+}
+
+func (d *escposDecoder) gsEightCommand(start int) {
+	hdr, ok := d.args(start, 2, 5, "GS 8")
+	if !ok {
+		return
+	}
+	name := "GS 8 " + commandChar(hdr[0])
+	n := int(hdr[1]) | int(hdr[2])<<8 | int(hdr[3])<<16 | int(hdr[4])<<24
+	payload, ok := d.args(start, 7, n, name)
+	if !ok {
+		return
+	}
+	if hdr[0] == 'L' {
+		d.graphicsCommand(start, name, payload)
+		return
+	}
+	d.warn(start, name, "command ignored in preview")
+}
+
+// graphicsCommand handles GS ( L / GS 8 L payloads (m fn ...).
+func (d *escposDecoder) graphicsCommand(start int, name string, p []byte) {
+	if len(p) < 2 {
+		d.warn(start, name, "truncated graphics command")
+		return
+	}
+	switch p[1] {
+	case 112:
+		// m fn a bx by c xL xH yL yH data
+		if len(p) < 10 {
+			d.warn(start, name, "truncated raster graphics")
+			return
+		}
+		w := int(p[6]) | int(p[7])<<8
+		h := int(p[8]) | int(p[9])<<8
+		if d.spansOnly || w == 0 || h == 0 {
+			return
+		}
+		// The header sizes are not checked against the data that follows:
+		// keep to the rows actually sent and to the head width.
+		rowBytes := (w + 7) / 8
+		data := p[10:]
+		h = min(h, (len(data)+rowBytes-1)/rowBytes)
+		sx, sy := clampInt(int(p[3]), 1, 2), clampInt(int(p[4]), 1, 2)
+		w = min(w, maxHeadDots/sx)
+		img := NewBitmap(w, h)
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				i := y*rowBytes + x/8
+				if i < len(data) && data[i]&(0x80>>uint(x%8)) != 0 {
+					img.Set(x, y, true)
+				}
+			}
+		}
+		d.graphics = scaleBitmap(img, sx, sy)
+	case 50, 2:
+		if d.graphics != nil {
+			d.add(Element{Kind: ElementImage, Offset: start, Image: d.graphics})
+			d.graphics = nil
+		}
+	case 67, 83:
+		d.warn(start, name, "graphics definition ignored in preview")
+	case 69, 85:
+		d.warn(start, name, "NV graphics are not available to the preview")
+	case 48, 49:
+		// Graphics density and capacity queries.
+	default:
+		d.warn(start, name, "graphics function %d ignored in preview", p[1])
+	}
+}
+
+// symbol handles GS ( k two-dimensional code payloads (cn fn ...).
+func (d *escposDecoder) symbol(start int, p []byte) {
+	if len(p) < 2 {
+		d.warn(start, "GS ( k", "truncated symbol command")
+		return
+	}
+	cn, fn := p[0], p[1]
+	if cn != 49 {
+		if fn == 81 {
+			d.warn(start, "GS ( k", "2D symbol type %d is not rendered in preview", cn)
+		}
+		return
+	}
+	switch fn {
+	case 67:
+		if len(p) >= 3 {
+			d.qrModule = clampInt(int(p[2]), 1, 16)
+		}
+	case 69:
+		if len(p) >= 3 {
+			d.qrECC = p[2]
+		}
+	case 80:
+		if len(p) >= 3 {
+			d.qrData = string(p[3:])
+		}
+	case 81:
+		d.add(Element{Kind: ElementQRCode, Offset: start, Data: d.qrData, ModuleWidth: d.qrModule, ECC: d.qrECC})
+	}
+}
+
+func (d *escposDecoder) barcode(start int) {
+	a, ok := d.args(start, 2, 1, "GS k")
+	if !ok {
+		return
+	}
+	m := a[0]
+	var data []byte
+	if m <= 6 {
+		end := d.pos
+		for end < len(d.data) && d.data[end] != 0 {
+			end++
+		}
+		data = d.data[d.pos:end]
+		d.pos = end + 1
+		if d.pos > len(d.data) {
+			d.pos = len(d.data)
+			d.cutOff = true
+		}
+	} else {
+		n, ok := d.args(start, 3, 1, "GS k")
+		if !ok {
+			return
+		}
+		if data, ok = d.args(start, 4, int(n[0]), "GS k"); !ok {
+			return
+		}
+	}
+	sym, known := barcodeSymbologies[m]
+	if !known {
+		d.warn(start, "GS k", "unknown barcode system %d", m)
+		return
+	}
+	d.add(Element{
+		Kind:        ElementBarcode,
+		Offset:      start,
+		Symbology:   sym,
+		Data:        string(data),
+		ModuleWidth: d.barcodeWidth,
+		BarHeight:   d.barcodeHeight,
+		HRI:         d.hri,
+	})
+}
+
+// rasterImage handles GS v 0 m xL xH yL yH data.
+func (d *escposDecoder) rasterImage(start int) {
+	hdr, ok := d.args(start, 2, 6, "GS v 0")
+	if !ok {
+		return
+	}
+	m := hdr[1] & 0x0f
+	wBytes := int(hdr[2]) | int(hdr[3])<<8
+	h := int(hdr[4]) | int(hdr[5])<<8
+	data, ok := d.args(start, 8, wBytes*h, "GS v 0")
+	if !ok || d.spansOnly {
+		return
+	}
+	img := bitmapFromPacked(wBytes, h, data)
+	d.add(Element{Kind: ElementImage, Offset: start, Image: scaleBitmap(img, 1+int(m&1), 1+int(m>>1&1))})
+}
+
+// bitImage handles ESC * m nL nH data (column format, printed in-line).
+func (d *escposDecoder) bitImage(start int) {
+	hdr, ok := d.args(start, 2, 3, "ESC *")
+	if !ok {
+		return
+	}
+	m := hdr[0]
+	cols := int(hdr[1]) | int(hdr[2])<<8
+	rowsBytes := 1
+	if m == 32 || m == 33 {
+		rowsBytes = 3
+	}
+	data, ok := d.args(start, 5, cols*rowsBytes, "ESC *")
+	if !ok || d.spansOnly {
+		return
+	}
+	if d.lineImage != nil && d.lineImage.Width >= maxHeadDots {
+		d.warn(start, "ESC *", "bit image past the end of the line dropped")
+		return
+	}
+	img := NewBitmap(cols, rowsBytes*8)
+	for x := 0; x < cols; x++ {
+		for k := 0; k < rowsBytes; k++ {
+			v := data[x*rowsBytes+k]
+			for bit := 0; bit < 8; bit++ {
+				if v&(0x80>>uint(bit)) != 0 {
+					img.Set(x, k*8+bit, true)
+				}
+			}
+		}
+	}
+	if m == 0 || m == 32 {
+		img = scaleBitmap(img, 2, 1)
+	}
+	d.flushRun()
+	if len(d.runs) == 0 && d.lineImage == nil {
+		d.lineStart = start
+	}
+	d.lineImage = appendHorizontal(d.lineImage, img)
+}
+
+// userChars skips ESC & y c1 c2 [x d1...d(y*x)]...
+func (d *escposDecoder) userChars(start int) {
+	hdr, ok := d.args(start, 2, 3, "ESC &")
+	if !ok {
+		return
+	}
+	y := int(hdr[0])
+	count := int(hdr[2]) - int(hdr[1]) + 1
+	for i := 0; i < count && d.pos < len(d.data); i++ {
+		x := int(d.data[d.pos])
+		d.pos += 1 + x*y
+	}
+	if d.pos > len(d.data) {
+		d.pos = len(d.data)
+		d.cutOff = true
+	}
+	d.warn(start, "ESC &", "user-defined characters are not rendered in preview")
+}
+
+func (d *escposDecoder) fsCommand(start int) {
+	if start+1 >= len(d.data) {
+		d.warn(start, "FS", "truncated command")
+		d.pos = len(d.data)
+		d.cutOff = true
+		return
+	}
+	c := d.data[start+1]
+	name := "FS " + commandChar(c)
+	switch c {
+	case '&', '.':
+		d.pos = start + 2
+	case '!', '-', 'C', 'W':
+		d.args(start, 2, 1, name)
+	case 'S':
+		d.args(start, 2, 2, name)
+	case '(':
+		hdr, ok := d.args(start, 2, 3, "FS (")
+		if !ok {
+			return
+		}
+		name = "FS ( " + commandChar(hdr[0])
+		d.args(start, 5, int(hdr[1])|int(hdr[2])<<8, name)
+		d.warn(start, name, "command ignored in preview")
+	case 'p':
+		d.args(start, 2, 2, name)
+		d.warn(start, name, "NV bit images are not available to the preview")
+	case 'q':
+		a, ok := d.args(start, 2, 1, name)
+		if !ok {
+			return
+		}
+		for i := 0; i < int(a[0]) && d.pos+4 <= len(d.data); i++ {
+			x := int(d.data[d.pos]) | int(d.data[d.pos+1])<<8
+			y := int(d.data[d.pos+2]) | int(d.data[d.pos+3])<<8
+			d.pos += 4 + x*y*8
+		}
+		if d.pos > len(d.data) {
+			d.pos = len(d.data)
+			d.cutOff = true
+		}
+		d.warn(start, name, "NV bit image definition ignored in preview")
+	default:
+		d.pos = start + 2
+		d.warn(start, name, "unknown command")
+	}
+}
+
+func (d *escposDecoder) dleCommand(start int) {
+	if start+1 >= len(d.data) {
+		d.pos = len(d.data)
+		d.cutOff = true
+		return
+	}
+	switch d.data[start+1] {
+	case 0x04, 0x05:
+		d.args(start, 2, 1, "DLE")
+	case 0x14:
+		d.args(start, 2, 3, "DLE DC4")
+	default:
+		d.pos = start + 1
+	}
+}
+
+func commandChar(c byte) string {
+	if c > 0x20 && c < 0x7f {
+		return string(rune(c))
+	}
+	return fmt.Sprintf("0x%02X", c)
+}
+
+func scaleBitmap(src *Bitmap, sx, sy int) *Bitmap {
+	if sx <= 1 && sy <= 1 {
+		return src
+	}
+	if sx < 1 {
+		sx = 1
+	}
+	if sy < 1 {
+		sy = 1
+	}
+	out := NewBitmap(src.Width*sx, src.Height*sy)
+	for y := 0; y < out.Height; y++ {
+		for x := 0; x < out.Width; x++ {
+			if src.At(x/sx, y/sy) {
+				out.Set(x, y, true)
+			}
+		}
+	}
+	return out
+}
+
+func appendHorizontal(left, right *Bitmap) *Bitmap {
+	if left == nil {
+		return right
+	}
+	h := left.Height
+	if right.Height > h {
+		h = right.Height
+	}
+	out := NewBitmap(left.Width+right.Width, h)
+	out.Draw(left, 0, 0)
+	out.Draw(right, left.Width, 0)
+	return out
+}
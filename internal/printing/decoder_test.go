@@ -0,0 +1,119 @@
+package printing
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecodeTextAndStyles(t *testing.T) {
+	var data []byte
+	data = append(data, 0x1b, '@')
+	data = append(data, cmdAlign(AlignCenter)...)
+	data = append(data, cmdBold(true)...)
+	data = append(data, "Title"...)
+	data = append(data, cmdBold(false)...)
+	data = append(data, " x\n\n"...)
+	data = append(data, cmdSize(2, 2)...)
+	data = append(data, "Big\n"...)
+	data = append(data, 0x1d, 'V', 66, 3)
+
+	page := DecodeESCPOS(data)
+	if len(page.Warnings) != 0 {
+		t.Fatalf("unexpected warnings: %+v", page.Warnings)
+	}
+	kinds := []ElementKind{ElementText, ElementText, ElementText, ElementFeed, ElementCut}
+	if len(page.Elements) != len(kinds) {
+		t.Fatalf("got %d elements, want %d: %+v", len(page.Elements), len(kinds), page.Elements)
+	}
+	for i, k := range kinds {
+		if page.Elements[i].Kind != k {
+			t.Fatalf("element %d kind = %s, want %s", i, page.Elements[i].Kind, k)
+		}
+	}
+	first := page.Elements[0]
+	if first.Align != AlignCenter || len(first.Runs) != 2 || !first.Runs[0].Style.Bold || first.Runs[1].Style.Bold {
+		t.Fatalf("unexpected first line: %+v", first)
+	}
+	if got := page.Elements[2].Runs[0].Style; got.Width != 2 || got.Height != 2 {
+		t.Fatalf("expected double size, got %+v", got)
+	}
+	if cut := page.Elements[4]; !cut.Partial {
+		t.Fatalf("GS V 66 should be a partial cut")
+	}
+}
+
+func TestDecodeCodePage(t *testing.T) {
+	data := []byte{0x1b, 't', 16, 0x80, '\n'} // WPC1252 euro sign
+	page := DecodeESCPOS(data)
+	if got := page.Elements[0].Runs[0].Text; got != "€" {
+		t.Fatalf("decoded text = %q, want euro sign", got)
+	}
+}
+
+func TestDecodeRasterBarcodeAndQR(t *testing.T) {
+	data := []byte{0x1d, 'v', '0', 0, 1, 0, 2, 0, 0xff, 0x81}
+	data = append(data, 0x1d, 'k', 73, 4, '{', 'B', '1', '2')
+	qrData := "hi"
+	n := len(qrData) + 3
+	data = append(data, 0x1d, '(', 'k', byte(n), 0, 49, 80, 48)
+	data = append(data, qrData...)
+	data = append(data, 0x1d, '(', 'k', 3, 0, 49, 81, 48)
+
+	page := DecodeESCPOS(data)
+	if len(page.Elements) != 3 {
+		t.Fatalf("got %d elements: %+v", len(page.Elements), page.Elements)
+	}
+	img := page.Elements[0].Image
+	if img == nil || img.Width != 8 || img.Height != 2 || !img.At(0, 1) || img.At(1, 1) {
+		t.Fatalf("unexpected raster image: %+v", img)
+	}
+	if bc := page.Elements[1]; bc.Symbology != "CODE128" || bc.Data != "{B12" {
+		t.Fatalf("unexpected barcode: %+v", bc)
+	}
+	if q := page.Elements[2]; q.Kind != ElementQRCode || q.Data != "hi" {
+		t.Fatalf("unexpected QR element: %+v", q)
+	}
+
+	rendered := RenderPage(page, 384)
+	if rendered.Width != 384 || rendered.Height == 0 {
+		t.Fatalf("unexpected render size %dx%d", rendered.Width, rendered.Height)
+	}
+}
+
+func TestDecodeUnknownCommandWarns(t *testing.T) {
+	data := []byte("a\x1b\xf0b\n\x1c\x70\x01\x00")
+	page := DecodeESCPOS(data)
+	if len(page.Warnings) != 2 {
+		t.Fatalf("expected 2 warnings, got %+v", page.Warnings)
+	}
+	if page.Warnings[0].Offset != 1 || page.Warnings[1].Command != "FS p" {
+		t.Fatalf("unexpected warnings: %+v", page.Warnings)
+	}
+	if got := page.Elements[0].Runs[0].Text; got != "ab" {
+		t.Fatalf("text = %q, want %q", got, "ab")
+	}
+}
+
+func TestDecodeGraphicsSizeFromData(t *testing.T) {
+	// GS ( L fn 112 declaring 65535x65535 dots at 255x255 scale with one
+	// data byte, then fn 50 to print it.
+	data := []byte{0x1d, '(', 'L', 11, 0, 48, 112, 48, 255, 255, 49, 0xff, 0xff, 0xff, 0xff, 0x80}
+	data = append(data, 0x1d, '(', 'L', 2, 0, 48, 50)
+
+	page := DecodeESCPOS(data)
+	if len(page.Elements) != 1 {
+		t.Fatalf("got %d elements: %+v", len(page.Elements), page.Elements)
+	}
+	img := page.Elements[0].Image
+	if img.Width > maxHeadDots || img.Height != 2 || !img.At(0, 0) || img.At(2, 0) {
+		t.Fatalf("image is %dx%d, want one row scaled by 2 within the head", img.Width, img.Height)
+	}
+	if rendered := RenderPage(page, 384); rendered.Height != 2 {
+		t.Fatalf("rendered height = %d, want 2", rendered.Height)
+	}
+
+	feeds := bytes.Repeat([]byte{0x1b, 'd', 255}, 100)
+	if rendered := RenderPage(DecodeESCPOS(feeds), 384); rendered.Height > maxPageDots {
+		t.Fatalf("rendered height = %d, want at most %d", rendered.Height, maxPageDots)
+	}
+}
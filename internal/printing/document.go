@@ -0,0 +1,365 @@
+package printing
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"strings"
+)
+
+// Document block types.
+const (
+	BlockText    = "text"
+	BlockRow     = "row"
+	BlockDivider = "divider"
+	BlockImage   = "image"
+	BlockBarcode = "barcode"
+	BlockQRCode  = "qrcode"
+	BlockFeed    = "feed"
+	BlockCut     = "cut"
+	BlockLogo    = "logo"
+	BlockPage    = "page"
+)
+
+// Document is a printer-independent receipt made of blocks printed top to
+// bottom. It is encoded for a printer by a Driver.
+type Document struct {
+	Blocks []Block `json:"blocks"`
+	// Cut ends the document with a full cut (default true).
+	Cut *bool `json:"cut,omitempty"`
+}
+
+// Block is one item of a document.
+type Block struct {
+	Type  string `json:"type"`
+	Align string `json:"align,omitempty"`
+
+	// text, row, divider
+	Text      string `json:"text,omitempty"`
+	Font      string `json:"font,omitempty"`
+	Bold      bool   `json:"bold,omitempty"`
+	Underline bool   `json:"underline,omitempty"`
+	Invert    bool   `json:"invert,omitempty"`
+	// Width and Height are character multipliers (1-8) for text and row
+	// blocks, the target width in dots for images, the bar height in dots
+	// for barcodes and the size of the region in dots for pages.
+	Width  int   `json:"width,omitempty"`
+	Height int   `json:"height,omitempty"`
+	Wrap   *bool `json:"wrap,omitempty"`
+
+	// row: a column spec as accepted by ParseTableSpec, and its cells
+	Columns string   `json:"columns,omitempty"`
+	Cells   []string `json:"cells,omitempty"`
+
+	// divider
+	Char string `json:"char,omitempty"`
+
+	// image: base64 PNG, JPEG or GIF
+	Image  string `json:"image,omitempty"`
+	Dither bool   `json:"dither,omitempty"`
+
+	// barcode, qrcode
+	Symbology   string `json:"symbology,omitempty"`
+	Data        string `json:"data,omitempty"`
+	ModuleWidth int    `json:"module_width,omitempty"`
+	HRI         bool   `json:"hri,omitempty"`
+	ECC         string `json:"ecc,omitempty"`
+
+	// feed
+	Lines int `json:"lines,omitempty"`
+
+	// cut
+	Partial bool `json:"partial,omitempty"`
+
+	// logo: the key code of a stored logo
+	Key string `json:"key,omitempty"`
+
+	// page: a page mode region whose text, image, barcode and qrcode items
+	// sit at X, Y (their top-left corner, in dots from the region's
+	// top-left). The region is printed turned clockwise by Rotation
+	// degrees; Raster prints it as an image even where page mode exists.
+	Items    []Block `json:"items,omitempty"`
+	X        int     `json:"x,omitempty"`
+	Y        int     `json:"y,omitempty"`
+	Rotation int     `json:"rotation,omitempty"`
+	Raster   bool    `json:"raster,omitempty"`
+}
+
+// style returns the text style a text or row block asks for, using font
+// when the block does not set one.
+func (b Block) style(font Font) TextStyle {
+	s := PlainStyle
+	s.Font = font
+	if b.Font != "" {
+		s.Font = ParseFont(b.Font)
+	}
+	s.Bold = b.Bold
+	s.Invert = b.Invert
+	if b.Underline {
+		s.Underline = 1
+	}
+	if b.Width > 0 {
+		s.Width = clampInt(b.Width, 1, 8)
+	}
+	if b.Height > 0 {
+		s.Height = clampInt(b.Height, 1, 8)
+	}
+	return s
+}
+
+// TextOptions controls how EncodeText prints plain text.
+type TextOptions struct {
+	// CodePage is the printer table text is encoded in. When nil the text
+	// is sent verbatim.
+	CodePage *CodePage
+	// Fonts rasterizes lines the code page cannot represent and lines
+	// with right-to-left text. When nil those lines are encoded with '?'
+	// in place of unknown characters.
+	Fonts *FontSet
+	// WidthDots is the width raster lines are drawn at.
+	WidthDots int
+	// Header is printed before the text of each copy, e.g. a logo.
+	Header []byte
+
+	Finish
+}
+
+// Finish controls how each copy of a receipt job starts and ends. The zero
+// value resets the printer, prints one copy and cuts fully.
+type Finish struct {
+	// NoReset skips the initialize command that starts each copy.
+	NoReset bool
+	// FeedLines are fed before the cut.
+	FeedLines int
+	Cut       CutMode
+	Copies    int
+	// Drawer, when set, is pulsed once at the start of the job.
+	Drawer *DrawerKick
+	// Head is applied after the reset of each copy, which would otherwise
+	// return the printer to its stored density and speed.
+	Head HeadSettings
+}
+
+// Apply wraps an encoded receipt body in the reset, drawer kick, feed and
+// cut commands of f, once per copy.
+func (f Finish) Apply(d Driver, body []byte) []byte {
+	cut := f.Cut
+	if cut == "" {
+		cut = CutFull
+	}
+	var b bytes.Buffer
+	for i := 0; i < max(f.Copies, 1); i++ {
+		if !f.NoReset {
+			b.Write(d.Init())
+		}
+		b.Write(EncodeHead(d.Language(), f.Head))
+		if i == 0 && f.Drawer != nil {
+			b.Write(d.Drawer(*f.Drawer))
+		}
+		b.Write(body)
+		if f.FeedLines > 0 {
+			b.Write(d.Feed(f.FeedLines))
+		}
+		b.Write(d.Cut(cut))
+	}
+	return b.Bytes()
+}
+
+// EncodeText encodes plain text as a receipt finished as opts.Finish
+// says. With a code page each line is printed natively when the table can
+// represent it and otherwise as raster stripes, wrapped to
+// opts.WidthDots; text no font of opts.Fonts can draw is an ErrNoGlyph
+// error. Without a code page the text is sent verbatim.
+func EncodeText(d Driver, text string, opts TextOptions) ([]byte, error) {
+	var b bytes.Buffer
+	b.Write(opts.Header)
+	if opts.CodePage == nil {
+		b.WriteString(text)
+		if len(text) == 0 || text[len(text)-1] != '\n' {
+			b.WriteByte('\n')
+		}
+	} else {
+		cp := *opts.CodePage
+		selectable := true
+		if cmd, err := d.CodePage(cp); err == nil {
+			b.Write(cmd)
+		} else {
+			selectable = false
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+			native := !HasRTL(line) && (cp.CanEncode(line) && selectable || isASCII(line))
+			if !native && opts.Fonts != nil {
+				raster, err := rasterText(d, opts, line)
+				if err != nil {
+					return nil, err
+				}
+				b.Write(raster)
+				continue
+			}
+			b.Write(cp.EncodeString(line))
+			b.WriteByte('\n')
+		}
+	}
+	return opts.Finish.Apply(d, b.Bytes()), nil
+}
+
+// rasterText draws line with opts.Fonts as raster stripes, one for each
+// line it wraps to at opts.WidthDots.
+func rasterText(d Driver, opts TextOptions, line string) ([]byte, error) {
+	if err := opts.Fonts.Check(line); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	for _, l := range opts.Fonts.WrapLine(line, opts.WidthDots) {
+		b.Write(d.Raster(opts.Fonts.RenderLine(l, opts.WidthDots, AlignLeft)))
+	}
+	return b.Bytes(), nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// EncodeDocument encodes doc for driver d on paper of the given width.
+// font is the default font for text blocks that do not set one; logo prints
+// logo blocks and may be nil when the document has none.
+func EncodeDocument(d Driver, doc *Document, paperWidthMM int, font Font, logo LogoFunc) ([]byte, error) {
+	var b bytes.Buffer
+	b.Write(d.Init())
+	current := PlainStyle
+	setStyle := func(s TextStyle) {
+		b.Write(d.Style(current, s))
+		current = s
+	}
+
+	for i, blk := range doc.Blocks {
+		b.Write(d.Align(ParseAlign(blk.Align)))
+		switch blk.Type {
+		case BlockText:
+			style := blk.style(font)
+			l := NewLayout(paperWidthMM, style.Font).Scaled(style.Width)
+			text := strings.ReplaceAll(blk.Text, "\r\n", "\n")
+			if blk.Wrap == nil || *blk.Wrap {
+				text = l.WrapText(text)
+			}
+			setStyle(style)
+			b.WriteString(text)
+			b.WriteByte('\n')
+		case BlockRow:
+			table, err := ParseTableSpec(blk.Columns)
+			if err != nil {
+				return nil, fmt.Errorf("block %d: %w", i, err)
+			}
+			style := blk.style(font)
+			l := NewLayout(paperWidthMM, style.Font).Scaled(style.Width)
+			setStyle(style)
+			for _, line := range l.Row(table, blk.Cells...) {
+				b.WriteString(line)
+				b.WriteByte('\n')
+			}
+		case BlockDivider:
+			style := blk.style(font)
+			ch := '-'
+			if blk.Char != "" {
+				ch = []rune(blk.Char)[0]
+			}
+			setStyle(style)
+			b.WriteString(NewLayout(paperWidthMM, style.Font).Scaled(style.Width).Divider(ch))
+			b.WriteByte('\n')
+		case BlockImage:
+			img, err := decodeImage(blk.Image, blk.Width, blk.Dither, PrintableDots(paperWidthMM))
+			if err != nil {
+				return nil, fmt.Errorf("block %d: %w", i, err)
+			}
+			setStyle(PlainStyle)
+			b.Write(d.Raster(img))
+		case BlockBarcode:
+			data, err := d.Barcode(Barcode{Symbology: blk.Symbology, Data: blk.Data, Height: blk.Height, ModuleWidth: blk.ModuleWidth, HRI: blk.HRI})
+			if err != nil {
+				return nil, fmt.Errorf("block %d: %w", i, err)
+			}
+			setStyle(PlainStyle)
+			b.Write(data)
+		case BlockQRCode:
+			data, err := d.QRCode(QRCode{Data: blk.Data, ModuleSize: blk.ModuleWidth, ECC: blk.ECC})
+			if err != nil {
+				return nil, fmt.Errorf("block %d: %w", i, err)
+			}
+			setStyle(PlainStyle)
+			b.Write(data)
+		case BlockLogo:
+			if logo == nil {
+				return nil, fmt.Errorf("block %d: logos are not available", i)
+			}
+			data, err := logo(blk.Key)
+			if err != nil {
+				return nil, fmt.Errorf("block %d: %w", i, err)
+			}
+			setStyle(PlainStyle)
+			b.Write(data)
+		case BlockPage:
+			setStyle(PlainStyle)
+			if e, ok := d.(ESCPOSDriver); ok && !e.NoPageMode && !blk.Raster {
+				paperDots := PrintableDots(paperWidthMM)
+				w, h, err := blk.pageSize(paperDots)
+				if err != nil {
+					return nil, fmt.Errorf("block %d: %w", i, err)
+				}
+				if blk.Rotation == 90 || blk.Rotation == 270 {
+					w = h
+				}
+				data, err := encodePageMode(blk, font, paperDots, alignOffset(w, paperDots, ParseAlign(blk.Align)))
+				if err != nil {
+					return nil, fmt.Errorf("block %d: %w", i, err)
+				}
+				b.Write(data)
+				break
+			}
+			img, err := RenderPageBlock(blk, font, paperWidthMM)
+			if err != nil {
+				return nil, fmt.Errorf("block %d: %w", i, err)
+			}
+			b.Write(d.Raster(img))
+		case BlockFeed:
+			b.Write(d.Feed(max(blk.Lines, 1)))
+		case BlockCut:
+			mode := CutFull
+			if blk.Partial {
+				mode = CutPartial
+			}
+			b.Write(d.Cut(mode))
+		default:
+			return nil, fmt.Errorf("block %d: unknown type %q", i, blk.Type)
+		}
+	}
+
+	setStyle(PlainStyle)
+	b.Write(d.Align(AlignLeft))
+	if doc.Cut == nil || *doc.Cut {
+		b.Write(d.Cut(CutFull))
+	}
+	return b.Bytes(), nil
+}
+
+// decodeImage decodes a base64 image and converts it to a bitmap no wider
+// than maxWidth dots.
+func decodeImage(data string, width int, dither bool, maxWidth int) (*Bitmap, error) {
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 image: %w", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %w", err)
+	}
+	if width <= 0 || width > maxWidth {
+		width = min(img.Bounds().Dx(), maxWidth)
+	}
+	return BitmapFromImage(img, width, dither), nil
+}
@@ -0,0 +1,142 @@
+package printing
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Driver encodes receipt content in one printer command dialect. Every
+// method returns the bytes for a single operation so callers can
+// interleave them with text.
+type Driver interface {
+	Language() Language
+	// Init resets the printer to its power-on state.
+	Init() []byte
+	// Style switches from one text style to another, emitting only the
+	// attributes that differ.
+	Style(from, to TextStyle) []byte
+	Align(a Align) []byte
+	// CodePage selects the character table text is printed with. It fails
+	// when the dialect has no number for the table.
+	CodePage(cp CodePage) ([]byte, error)
+	// Feed advances the paper by n lines.
+	Feed(lines int) []byte
+	// Cut cuts the paper; CutNone returns nil.
+	Cut(mode CutMode) []byte
+	// Drawer pulses a cash drawer kick-out connector.
+	Drawer(k DrawerKick) []byte
+	// Raster prints a bitmap at the current alignment.
+	Raster(img *Bitmap) []byte
+	Barcode(b Barcode) ([]byte, error)
+	QRCode(q QRCode) ([]byte, error)
+}
+
+// CutMode is how a job ends.
+type CutMode string
+
+const (
+	CutNone    CutMode = "none"
+	CutPartial CutMode = "partial"
+	CutFull    CutMode = "full"
+	// CutFeedAndCut feeds the last line past the cutter, then cuts
+	// partially.
+	CutFeedAndCut CutMode = "feed-and-cut"
+)
+
+// ParseCutMode validates a cut setting; empty means a full cut.
+func ParseCutMode(name string) (CutMode, error) {
+	switch m := CutMode(strings.ToLower(strings.TrimSpace(name))); m {
+	case "":
+		return CutFull, nil
+	case CutNone, CutPartial, CutFull, CutFeedAndCut:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown cut %q (want none, partial, full or feed-and-cut)", name)
+	}
+}
+
+// DrawerKick is a cash drawer pulse.
+type DrawerKick struct {
+	// Pin is the connector pin the drawer is wired to: 2 or 5.
+	Pin   int
+	OnMS  int
+	OffMS int
+}
+
+// Barcode is a one-dimensional barcode printed by the printer's firmware.
+type Barcode struct {
+	Symbology   string `json:"symbology"` // CODE128, EAN13, ... (see labelSymbologies)
+	Data        string `json:"data"`
+	Height      int    `json:"height"`
+	ModuleWidth int    `json:"module_width"`
+	HRI         bool   `json:"hri"`
+}
+
+// QRCode is a QR symbol printed by the printer's firmware.
+type QRCode struct {
+	Data       string `json:"data"`
+	ModuleSize int    `json:"module_size"`
+	ECC        string `json:"ecc"` // L, M, Q, H
+}
+
+// PlainStyle is the style in effect after Init.
+var PlainStyle = TextStyle{Width: 1, Height: 1}
+
+// IsReceipt reports whether the language prints receipt-style content
+// (text, documents, templates). Cat printers qualify because receipts are
+// rendered to raster for them.
+func (l Language) IsReceipt() bool {
+	switch l {
+	case LanguageESCPOS, LanguageStarLine, LanguageStarPRNT, LanguageCat:
+		return true
+	}
+	return false
+}
+
+// NewDriver returns the receipt driver for a command language. Cat
+// printers use the ESC/POS driver and are rasterized afterwards.
+func NewDriver(lang Language) (Driver, error) {
+	switch lang {
+	case LanguageESCPOS, LanguageCat:
+		return ESCPOSDriver{}, nil
+	case LanguageStarLine:
+		return StarDriver{}, nil
+	case LanguageStarPRNT:
+		return StarDriver{PRNT: true}, nil
+	default:
+		return nil, fmt.Errorf("%s printers have no receipt driver", lang)
+	}
+}
+
+func normalizeBarcode(b Barcode) (Barcode, error) {
+	sym, ok := labelSymbologies[strings.ToLower(b.Symbology)]
+	if !ok {
+		return b, fmt.Errorf("unknown symbology %q", b.Symbology)
+	}
+	b.Symbology = sym
+	if b.Height <= 0 {
+		b.Height = 80
+	}
+	if b.ModuleWidth <= 0 {
+		b.ModuleWidth = 2
+	}
+	return b, nil
+}
+
+func normalizeQRCode(q QRCode) (QRCode, error) {
+	if q.Data == "" {
+		return q, fmt.Errorf("qrcode data is empty")
+	}
+	if q.ModuleSize <= 0 {
+		q.ModuleSize = 6
+	}
+	q.ECC = strings.ToUpper(q.ECC)
+	switch q.ECC {
+	case "":
+		q.ECC = "M"
+	case "L", "M", "Q", "H":
+	default:
+		return q, fmt.Errorf("ecc must be L, M, Q or H")
+	}
+	return q, nil
+}
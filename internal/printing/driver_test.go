@@ -0,0 +1,141 @@
+package printing
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeDocumentESCPOSRoundTrip(t *testing.T) {
+	doc := &Document{Blocks: []Block{
+		{Type: BlockText, Text: "Cafe", Align: "center", Bold: true, Width: 2, Height: 2},
+		{Type: BlockRow, Columns: "*,6r", Cells: []string{"Coffee", "3.50"}},
+		{Type: BlockDivider},
+		{Type: BlockBarcode, Symbology: "code128", Data: "A-1", HRI: true},
+		{Type: BlockQRCode, Data: "https://example.com", ECC: "q"},
+	}}
+	data, err := EncodeDocument(ESCPOSDriver{}, doc, 58, FontA, nil)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	page := DecodeESCPOS(data)
+	if len(page.Warnings) != 0 {
+		t.Fatalf("unexpected warnings: %+v", page.Warnings)
+	}
+	kinds := []ElementKind{ElementText, ElementText, ElementText, ElementBarcode, ElementQRCode, ElementCut}
+	var got []ElementKind
+	for _, e := range page.Elements {
+		got = append(got, e.Kind)
+	}
+	if len(got) != len(kinds) {
+		t.Fatalf("element kinds = %v, want %v", got, kinds)
+	}
+	for i := range kinds {
+		if got[i] != kinds[i] {
+			t.Fatalf("element kinds = %v, want %v", got, kinds)
+		}
+	}
+	title := page.Elements[0]
+	if title.Align != AlignCenter || !title.Runs[0].Style.Bold || title.Runs[0].Style.Width != 2 {
+		t.Fatalf("unexpected title: %+v", title)
+	}
+	if row := page.Elements[1].Runs[0]; row.Text != "Coffee                      3.50" || row.Style.Bold {
+		t.Fatalf("unexpected row: %+v", row)
+	}
+	if bc := page.Elements[3]; bc.Symbology != "CODE128" || bc.HRI != 2 {
+		t.Fatalf("unexpected barcode: %+v", bc)
+	}
+	if qr := page.Elements[4]; qr.Data != "https://example.com" {
+		t.Fatalf("unexpected qr code: %+v", qr)
+	}
+}
+
+func TestStarDriverCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		got  []byte
+		want []byte
+	}{
+		{"bold on", StarDriver{}.Style(PlainStyle, TextStyle{Width: 1, Height: 1, Bold: true}), []byte{0x1b, 'E'}},
+		{"double", StarDriver{}.Style(PlainStyle, TextStyle{Width: 2, Height: 2}), []byte{0x1b, 'i', 1, 1}},
+		{"align", StarDriver{}.Align(AlignRight), []byte{0x1b, 0x1d, 'a', 2}},
+		{"feed and cut", StarDriver{}.Cut(CutFeedAndCut), []byte{0x1b, 'd', 3}},
+		{"drawer", StarDriver{}.Drawer(DrawerKick{Pin: 5, OnMS: 100, OffMS: 200}), []byte{0x1b, 0x07, 10, 20, 0x1a}},
+		{"line mode raster", StarDriver{}.Raster(bitmapFromPacked(1, 1, []byte{0xf0})),
+			[]byte{0x1b, '*', 'r', 'A', 'b', 1, 0, 0xf0, 0x1b, '*', 'r', 'B'}},
+		{"starprnt raster", StarDriver{PRNT: true}.Raster(bitmapFromPacked(1, 1, []byte{0xf0})),
+			[]byte{0x1b, 0x1d, 'S', 1, 1, 0, 1, 0, 0, 0xf0}},
+	}
+	for _, tt := range tests {
+		if !bytes.Equal(tt.got, tt.want) {
+			t.Errorf("%s = % x, want % x", tt.name, tt.got, tt.want)
+		}
+	}
+
+	bc, err := StarDriver{}.Barcode(Barcode{Symbology: "ean13", Data: "4006381333931", Height: 50})
+	if err != nil {
+		t.Fatalf("barcode: %v", err)
+	}
+	want := append([]byte{0x1b, 'b', '3', '1', '2', 50}, "4006381333931\x1e"...)
+	if !bytes.Equal(bc, want) {
+		t.Fatalf("barcode = % x, want % x", bc, want)
+	}
+}
+
+func TestNewDriver(t *testing.T) {
+	for lang, want := range map[Language]Language{
+		LanguageESCPOS:   LanguageESCPOS,
+		LanguageCat:      LanguageESCPOS,
+		LanguageStarLine: LanguageStarLine,
+		LanguageStarPRNT: LanguageStarPRNT,
+	} {
+		d, err := NewDriver(lang)
+		if err != nil || d.Language() != want {
+			t.Errorf("NewDriver(%s) = %v, %v; want %s driver", lang, d, err, want)
+		}
+	}
+	if _, err := NewDriver(LanguageTSPL); err == nil {
+		t.Errorf("NewDriver(tspl) should fail")
+	}
+}
+
+func TestFinishApply(t *testing.T) {
+	f := Finish{FeedLines: 3, Cut: CutFeedAndCut, Copies: 2, Drawer: &DrawerKick{Pin: 2, OnMS: 100, OffMS: 200}}
+	got := f.Apply(ESCPOSDriver{}, []byte("hi\n"))
+	reset := []byte{0x1b, '@'}
+	var want []byte
+	want = append(want, reset...)
+	want = append(want, 0x1b, 'p', 0, 50, 100)
+	want = append(want, "hi\n\x1bd\x03\x1dVB\x00"...)
+	want = append(want, reset...)
+	want = append(want, "hi\n\x1bd\x03\x1dVB\x00"...)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Apply = % x\nwant    % x", got, want)
+	}
+
+	none := Finish{NoReset: true, Cut: CutNone}.Apply(ESCPOSDriver{}, []byte("x"))
+	if string(none) != "x" {
+		t.Fatalf("NoReset/CutNone = %q, want body only", none)
+	}
+}
+
+func TestParsePaperStatus(t *testing.T) {
+	tests := []struct {
+		answer []byte
+		want   string
+		ok     bool
+	}{
+		{[]byte{0x12}, PaperOK, true},
+		{[]byte{0x1e}, PaperNearEnd, true},
+		{[]byte{0x72}, PaperOut, true},
+		{[]byte{0x7e}, PaperOut, true},
+		{[]byte{0x00}, "", false},
+		{[]byte{0x12, 0x12}, "", false},
+		{nil, "", false},
+	}
+	for _, tt := range tests {
+		got, ok := ParsePaperStatus(tt.answer)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParsePaperStatus(% x) = %q, %v, want %q, %v", tt.answer, got, ok, tt.want, tt.ok)
+		}
+	}
+}
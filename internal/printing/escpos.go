@@ -1,15 +1,206 @@
 package printing
 
-import "bytes"
+import (
+	"fmt"
+	"strings"
+)
 
-// TextReceipt: ESC/POS init + text + newline + partial cut.
+const (
+	esc = 0x1b
+	gs  = 0x1d
+)
+
+// TextReceipt: ESC/POS init + text + newline + cut.
 func TextReceipt(text string) []byte {
-	var b bytes.Buffer
-	b.Write([]byte{0x1b, 0x40}) // ESC @
-	b.WriteString(text)
-	if len(text) == 0 || text[len(text)-1] != '\n' {
-		b.WriteByte('\n')
-	}
-	b.Write([]byte{0x1d, 0x56, 0x00}) // GS V 0
-	return b.Bytes()
+	data, _ := EncodeText(ESCPOSDriver{}, text, TextOptions{})
+	return data
+}
+
+func boolByte(on bool) byte {
+	if on {
+		return 1
+	}
+	return 0
+}
+
+// cmdBold returns ESC E n.
+func cmdBold(on bool) []byte { return []byte{esc, 'E', boolByte(on)} }
+
+// cmdUnderline returns ESC - n (0 off, 1 thin, 2 thick).
+func cmdUnderline(n byte) []byte { return []byte{esc, '-', n} }
+
+// cmdInvert returns GS B n (white on black).
+func cmdInvert(on bool) []byte { return []byte{gs, 'B', boolByte(on)} }
+
+// cmdSize returns GS ! n for width/height multipliers in 1..8.
+func cmdSize(width, height int) []byte {
+	return []byte{gs, '!', byte((clampInt(width, 1, 8)-1)<<4 | (clampInt(height, 1, 8) - 1))}
+}
+
+// cmdAlign returns ESC a n.
+func cmdAlign(a Align) []byte { return []byte{esc, 'a', byte(a)} }
+
+// cmdFeedLines returns ESC d n.
+func cmdFeedLines(n int) []byte { return []byte{esc, 'd', byte(clampInt(n, 0, 255))} }
+
+func clampInt(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// escposBarcodeSystems maps canonical symbologies to GS k function B
+// system numbers.
+var escposBarcodeSystems = map[string]byte{
+	"UPC-A": 65, "UPC-E": 66, "EAN13": 67, "EAN8": 68, "CODE39": 69,
+	"ITF": 70, "CODABAR": 71, "CODE93": 72, "CODE128": 73,
+}
+
+// ESCPOSDriver encodes for Epson-compatible ESC/POS printers.
+type ESCPOSDriver struct {
+	// NoPageMode prints page blocks as raster images, for clones that
+	// lack page mode.
+	NoPageMode bool
+}
+
+func (ESCPOSDriver) Language() Language { return LanguageESCPOS }
+
+func (ESCPOSDriver) Init() []byte { return []byte{esc, '@'} }
+
+func (ESCPOSDriver) Style(from, to TextStyle) []byte {
+	var b []byte
+	if from.Font != to.Font {
+		b = append(b, esc, 'M', byte(to.Font))
+	}
+	if from.Bold != to.Bold {
+		b = append(b, cmdBold(to.Bold)...)
+	}
+	if from.Underline != to.Underline {
+		b = append(b, cmdUnderline(byte(clampInt(to.Underline, 0, 2)))...)
+	}
+	if from.Invert != to.Invert {
+		b = append(b, cmdInvert(to.Invert)...)
+	}
+	if from.Width != to.Width || from.Height != to.Height {
+		b = append(b, cmdSize(to.Width, to.Height)...)
+	}
+	if from.UpsideDown != to.UpsideDown {
+		b = append(b, esc, '{', boolByte(to.UpsideDown))
+	}
+	return b
+}
+
+func (ESCPOSDriver) Align(a Align) []byte { return cmdAlign(a) }
+
+// CodePage returns ESC t n.
+func (ESCPOSDriver) CodePage(cp CodePage) ([]byte, error) { return []byte{esc, 't', cp.Number}, nil }
+
+func (ESCPOSDriver) Feed(lines int) []byte { return cmdFeedLines(lines) }
+
+// Cut returns GS V 0 (full), GS V 1 (partial) or GS V 66 0 (feed to the
+// cutter, then partial).
+func (ESCPOSDriver) Cut(mode CutMode) []byte {
+	switch mode {
+	case CutNone:
+		return nil
+	case CutPartial:
+		return []byte{gs, 'V', 1}
+	case CutFeedAndCut:
+		return []byte{gs, 'V', 66, 0}
+	default:
+		return []byte{gs, 'V', 0}
+	}
+}
+
+// Drawer returns ESC p m t1 t2, with times in units of 2 ms.
+func (ESCPOSDriver) Drawer(k DrawerKick) []byte {
+	m := byte(0)
+	if k.Pin == 5 {
+		m = 1
+	}
+	return []byte{esc, 'p', m, byte(clampInt(k.OnMS/2, 1, 255)), byte(clampInt(k.OffMS/2, 1, 255))}
+}
+
+// Raster returns GS v 0 with the bitmap's packed rows.
+func (ESCPOSDriver) Raster(img *Bitmap) []byte {
+	b := []byte{gs, 'v', '0', 0,
+		byte(img.Stride), byte(img.Stride >> 8),
+		byte(img.Height), byte(img.Height >> 8)}
+	return append(b, img.Pix...)
+}
+
+func (ESCPOSDriver) Barcode(bc Barcode) ([]byte, error) {
+	bc, err := normalizeBarcode(bc)
+	if err != nil {
+		return nil, err
+	}
+	data := bc.Data
+	if bc.Symbology == "CODE128" && !strings.HasPrefix(data, "{") {
+		data = "{B" + data
+	}
+	if len(data) > 255 {
+		return nil, fmt.Errorf("barcode data is too long")
+	}
+	hri := byte(0)
+	if bc.HRI {
+		hri = 2
+	}
+	b := []byte{
+		gs, 'H', hri,
+		gs, 'h', byte(clampInt(bc.Height, 1, 255)),
+		gs, 'w', byte(clampInt(bc.ModuleWidth, 1, 6)),
+		gs, 'k', escposBarcodeSystems[bc.Symbology], byte(len(data)),
+	}
+	return append(b, data...), nil
+}
+
+func (ESCPOSDriver) QRCode(q QRCode) ([]byte, error) {
+	q, err := normalizeQRCode(q)
+	if err != nil {
+		return nil, err
+	}
+	store := len(q.Data) + 3
+	if store > 0xFFFF {
+		return nil, fmt.Errorf("qrcode data is too long")
+	}
+	ecc := map[string]byte{"L": 48, "M": 49, "Q": 50, "H": 51}[q.ECC]
+	b := []byte{
+		gs, '(', 'k', 4, 0, 49, 65, 50, 0, // model 2
+		gs, '(', 'k', 3, 0, 49, 67, byte(clampInt(q.ModuleSize, 1, 16)),
+		gs, '(', 'k', 3, 0, 49, 69, ecc,
+		gs, '(', 'k', byte(store), byte(store >> 8), 49, 80, 48,
+	}
+	b = append(b, q.Data...)
+	return append(b, gs, '(', 'k', 3, 0, 49, 81, 48), nil
+}
+
+// PaperStatusQuery is DLE EOT 4, which asks an ESC/POS printer for its
+// roll paper sensor status.
+var PaperStatusQuery = []byte{0x10, 0x04, 0x04}
+
+// Paper sensor states.
+const (
+	PaperOK      = "ok"
+	PaperNearEnd = "near_end"
+	PaperOut     = "out"
+)
+
+// ParsePaperStatus reads the answer to PaperStatusQuery. The second result
+// is false if the answer is not a status byte.
+func ParsePaperStatus(answer []byte) (string, bool) {
+	if len(answer) != 1 || answer[0]&0x93 != 0x12 {
+		return "", false
+	}
+	switch b := answer[0]; {
+	case b&0x60 != 0:
+		return PaperOut, true
+	case b&0x0c != 0:
+		return PaperNearEnd, true
+	default:
+		return PaperOK, true
+	}
 }
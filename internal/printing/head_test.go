@@ -0,0 +1,49 @@
+package printing
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeHead(t *testing.T) {
+	dark, light := 2, -5
+	tests := []struct {
+		name string
+		lang Language
+		head HeadSettings
+		want []byte
+	}{
+		{"escpos density", LanguageESCPOS, HeadSettings{Density: &light}, []byte{0x1d, '(', 'K', 2, 0, 49, 0xfb}},
+		{"escpos speed and heat", LanguageESCPOS, HeadSettings{Speed: 3, HeatTime: 80},
+			[]byte{0x1d, '(', 'K', 2, 0, 50, 3, 0x1b, '7', 7, 80, 2}},
+		{"star", LanguageStarLine, HeadSettings{Density: &dark, Speed: 1, HeatTime: 80},
+			[]byte{0x1b, 0x1e, 'd', 1, 0x1b, 0x1e, 'r', 2}},
+		{"tspl", LanguageTSPL, HeadSettings{Density: &dark, Speed: 4}, []byte("DENSITY 10\r\nSPEED 4\r\n")},
+		{"cpcl", LanguageCPCL, HeadSettings{Density: &light}, []byte("! U1 setvar \"print.tone\" \"-80\"\r\n")},
+		{"unset", LanguageESCPOS, HeadSettings{}, nil},
+	}
+	for _, tt := range tests {
+		if got := EncodeHead(tt.lang, tt.head); !bytes.Equal(got, tt.want) {
+			t.Errorf("%s = % x, want % x", tt.name, got, tt.want)
+		}
+	}
+
+	merged := HeadSettings{Density: &dark, Speed: 5}.Merge(HeadSettings{Density: &light})
+	if *merged.Density != light || merged.Speed != 5 {
+		t.Errorf("Merge = %+v", merged)
+	}
+	if err := (HeadSettings{Density: new(int), Speed: 10}).Validate(); err == nil {
+		t.Errorf("speed 10 should be rejected")
+	}
+
+	// A reset returns the head to its stored settings, so each copy
+	// reapplies them after ESC @.
+	got := Finish{Copies: 2, Cut: CutNone, Head: HeadSettings{Speed: 2}}.Apply(ESCPOSDriver{}, []byte("x"))
+	each := "\x1b@\x1d(K\x02\x002\x02x"
+	if string(got) != each+each {
+		t.Errorf("Apply with head = %q", got)
+	}
+	if page := DecodeESCPOS(got); len(page.Warnings) != 0 {
+		t.Errorf("head commands should decode cleanly: %+v", page.Warnings)
+	}
+}
@@ -0,0 +1,369 @@
+package printing
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // register GIF decoding for label bitmaps
+	_ "image/jpeg" // register JPEG decoding for label bitmaps
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/boombuler/barcode/qr"
+)
+
+// Language is the command language a printer understands.
+type Language string
+
+const (
+	LanguageESCPOS Language = "escpos"
+	// LanguageStarLine is Star Line Mode; LanguageStarPRNT is StarPRNT.
+	LanguageStarLine Language = "star"
+	LanguageStarPRNT Language = "starprnt"
+	LanguageTSPL     Language = "tspl"
+	LanguageCPCL     Language = "cpcl"
+	// LanguageCat is the framed raster protocol of GB/MX "cat" printers.
+	LanguageCat Language = "cat"
+)
+
+// ParseLanguage validates a command_language config value.
+func ParseLanguage(name string) (Language, error) {
+	switch l := Language(strings.ToLower(strings.TrimSpace(name))); l {
+	case "", LanguageESCPOS:
+		return LanguageESCPOS, nil
+	case LanguageStarLine, LanguageStarPRNT, LanguageTSPL, LanguageCPCL, LanguageCat:
+		return l, nil
+	case "star-line", "starline":
+		return LanguageStarLine, nil
+	default:
+		return "", fmt.Errorf("unknown command language %q (want escpos, star, starprnt, tspl, cpcl or cat)", name)
+	}
+}
+
+// DotsPerMM is the resolution assumed for label geometry (203 dpi).
+const DotsPerMM = 8
+
+// Label size limits: the widest head the bridge models and the longest
+// ZPL label.
+const (
+	maxLabelWidthMM  = maxHeadDots / DotsPerMM
+	maxLabelHeightMM = zplMaxLength / DotsPerMM
+	maxModuleWidth   = 20
+)
+
+// Label element types.
+const (
+	LabelText    = "text"
+	LabelBarcode = "barcode"
+	LabelQRCode  = "qrcode"
+	LabelBitmap  = "bitmap"
+	LabelBox     = "box"
+	LabelLine    = "line"
+	LabelCircle  = "circle"
+)
+
+// Label is a printer-independent label description. Positions and sizes
+// of elements are in dots from the top-left corner.
+type Label struct {
+	WidthMM  float64 `json:"width_mm"`
+	HeightMM float64 `json:"height_mm"`
+	// GapMM is the gap (or black mark height) between labels.
+	GapMM float64 `json:"gap_mm"`
+	// Sensor is "gap" (default), "mark" for black-mark stock, or
+	// "continuous" for receipt-style media.
+	Sensor       string         `json:"sensor"`
+	MarkOffsetMM float64        `json:"mark_offset_mm"`
+	Copies       int            `json:"copies"`
+	Elements     []LabelElement `json:"elements"`
+}
+
+// LabelElement is one item placed on a label.
+type LabelElement struct {
+	Type     string `json:"type"`
+	X        int    `json:"x"`
+	Y        int    `json:"y"`
+	Rotation int    `json:"rotation"`
+
+	// text
+	Text string `json:"text,omitempty"`
+	// Font overrides the printer's built-in font name/number for text.
+	Font string `json:"font,omitempty"`
+	// Size is the character height in dots for text (default 24).
+	Size int `json:"size,omitempty"`
+
+	// barcode / qrcode
+	Symbology   string `json:"symbology,omitempty"`
+	Data        string `json:"data,omitempty"`
+	ModuleWidth int    `json:"module_width,omitempty"`
+	HRI         bool   `json:"hri,omitempty"`
+	ECC         string `json:"ecc,omitempty"`
+
+	// bitmap: base64 PNG, JPEG or GIF
+	Image  string `json:"image,omitempty"`
+	Dither bool   `json:"dither,omitempty"`
+
+	// box, line, circle, bitmap target width, barcode height
+	Width     int `json:"width,omitempty"`
+	Height    int `json:"height,omitempty"`
+	Thickness int `json:"thickness,omitempty"`
+
+	// bitmap is an already converted image for bitmap elements built by
+	// the bridge rather than decoded from JSON.
+	bitmap *Bitmap
+}
+
+// UnsupportedError reports a label element the target language cannot express.
+type UnsupportedError struct {
+	Language Language
+	Index    int
+	Type     string
+	Reason   string
+}
+
+func (e *UnsupportedError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("%s: %s", e.Language, e.Reason)
+	}
+	return fmt.Sprintf("%s: element %d (%s): %s", e.Language, e.Index, e.Type, e.Reason)
+}
+
+// labelSymbologies maps the label JSON symbology names to their canonical form.
+var labelSymbologies = map[string]string{
+	"code128": "CODE128", "128": "CODE128",
+	"code39": "CODE39", "39": "CODE39",
+	"code93": "CODE93", "93": "CODE93",
+	"ean13": "EAN13", "ean8": "EAN8",
+	"upca": "UPC-A", "upc-a": "UPC-A",
+	"upce": "UPC-E", "upc-e": "UPC-E",
+	"itf": "ITF", "i2of5": "ITF",
+	"codabar": "CODABAR",
+}
+
+// Validate checks geometry and element fields and fills in defaults.
+func (l *Label) Validate() error {
+	if l.WidthMM <= 0 || l.HeightMM <= 0 {
+		return errors.New("label width_mm and height_mm must be positive")
+	}
+	if l.WidthMM > maxLabelWidthMM || l.HeightMM > maxLabelHeightMM {
+		return fmt.Errorf("label must be at most %d mm wide and %d mm long", maxLabelWidthMM, maxLabelHeightMM)
+	}
+	switch l.Sensor {
+	case "", "gap":
+		l.Sensor = "gap"
+	case "mark", "bline", "black-mark":
+		l.Sensor = "mark"
+	case "continuous":
+	default:
+		return fmt.Errorf("unknown sensor %q (want gap, mark or continuous)", l.Sensor)
+	}
+	if l.Copies <= 0 {
+		l.Copies = 1
+	}
+	for i := range l.Elements {
+		e := &l.Elements[i]
+		if e.Rotation%90 != 0 {
+			return fmt.Errorf("element %d: rotation must be 0, 90, 180 or 270", i)
+		}
+		e.Rotation = ((e.Rotation % 360) + 360) % 360
+		// Fields are written into printer command lines, where a line
+		// break would start a command of its own.
+		for _, f := range [][2]string{{"text", e.Text}, {"font", e.Font}, {"data", e.Data}, {"symbology", e.Symbology}, {"ecc", e.ECC}} {
+			if strings.ContainsFunc(f[1], unicode.IsControl) {
+				return fmt.Errorf("element %d: %s must not contain control characters", i, f[0])
+			}
+		}
+		if side := max(l.WidthDots(), l.HeightDots()); e.Width > side || e.Height > side || e.Thickness > side {
+			return fmt.Errorf("element %d: width, height and thickness must fit on the label", i)
+		}
+		if e.ModuleWidth > maxModuleWidth {
+			return fmt.Errorf("element %d: module_width must be at most %d", i, maxModuleWidth)
+		}
+		switch e.Type {
+		case LabelText:
+			if e.Size <= 0 {
+				e.Size = 24
+			}
+		case LabelBarcode:
+			sym, ok := labelSymbologies[strings.ToLower(e.Symbology)]
+			if !ok {
+				return fmt.Errorf("element %d: unknown symbology %q", i, e.Symbology)
+			}
+			e.Symbology = sym
+			if e.Height <= 0 {
+				e.Height = 60
+			}
+			if e.ModuleWidth <= 0 {
+				e.ModuleWidth = 2
+			}
+		case LabelQRCode:
+			e.ECC = strings.ToUpper(e.ECC)
+			if e.ECC == "" {
+				e.ECC = "M"
+			}
+			if !strings.Contains("LMQH", e.ECC) || len(e.ECC) != 1 {
+				return fmt.Errorf("element %d: ecc must be L, M, Q or H", i)
+			}
+			if e.ModuleWidth <= 0 {
+				e.ModuleWidth = 4
+			}
+		case LabelBitmap:
+			if e.Image == "" && e.bitmap == nil {
+				return fmt.Errorf("element %d: bitmap needs a base64 image", i)
+			}
+		case LabelBox, LabelLine, LabelCircle:
+			if e.Thickness <= 0 {
+				e.Thickness = 2
+			}
+		default:
+			return fmt.Errorf("element %d: unknown type %q", i, e.Type)
+		}
+	}
+	return nil
+}
+
+// WidthDots and HeightDots return the label size at DotsPerMM.
+func (l *Label) WidthDots() int  { return mmToDots(l.WidthMM) }
+func (l *Label) HeightDots() int { return mmToDots(l.HeightMM) }
+
+func mmToDots(mm float64) int { return int(math.Round(mm * DotsPerMM)) }
+
+// decodeBitmap decodes and converts a bitmap element's image.
+func (e *LabelElement) decodeBitmap() (*Bitmap, error) {
+	if e.bitmap != nil {
+		return e.bitmap, nil
+	}
+	raw, err := base64.StdEncoding.DecodeString(e.Image)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64 image: %w", err)
+	}
+	img, _, err := image.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image: %w", err)
+	}
+	return BitmapFromImage(img, e.Width, e.Dither), nil
+}
+
+// EncodeLabel validates l and encodes it for the given command language.
+func EncodeLabel(lang Language, l *Label) ([]byte, error) {
+	if err := l.Validate(); err != nil {
+		return nil, err
+	}
+	switch lang {
+	case LanguageTSPL:
+		return encodeTSPL(l)
+	case LanguageCPCL:
+		return encodeCPCL(l)
+	default:
+		return nil, &UnsupportedError{Language: lang, Index: -1, Reason: "labels need a printer configured for tspl or cpcl"}
+	}
+}
+
+// EncodeLabelBitmap encodes a pre-rendered page as a TSPL or CPCL label
+// job. l supplies the media settings and copies; the label size is taken
+// from img.
+func EncodeLabelBitmap(lang Language, l Label, img *Bitmap) ([]byte, error) {
+	l.WidthMM = float64(img.Width) / DotsPerMM
+	l.HeightMM = float64(img.Height) / DotsPerMM
+	l.Elements = []LabelElement{{Type: LabelBitmap, bitmap: img}}
+	return EncodeLabel(lang, &l)
+}
+
+// RenderLabel draws a validated label as a bitmap, approximating the
+// printer's built-in fonts with the preview font.
+func RenderLabel(l *Label) (*Bitmap, error) {
+	out := NewBitmap(l.WidthDots(), l.HeightDots())
+	for i, e := range l.Elements {
+		var img *Bitmap
+		switch e.Type {
+		case LabelText:
+			mul := textMultiplier(e.Size)
+			style := TextStyle{Font: FontA, Width: mul, Height: mul}
+			cw, ch := cellSize(style)
+			img = NewBitmap(cw*len([]rune(e.Text)), ch)
+			drawString(img, 0, 0, e.Text, style)
+		case LabelBarcode:
+			bc, err := encodeBarcode(e.Symbology, e.Data)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			img = scaleBitmap(barcodeBitmap(bc, e.Height), e.ModuleWidth, 1)
+			if e.HRI {
+				style := TextStyle{Font: FontB, Width: 1, Height: 1}
+				cw, ch := cellSize(style)
+				withText := NewBitmap(max(img.Width, cw*len(e.Data)), img.Height+ch+2)
+				withText.Draw(img, 0, 0)
+				drawString(withText, alignOffset(cw*len(e.Data), withText.Width, AlignCenter), img.Height+2, e.Data, style)
+				img = withText
+			}
+		case LabelQRCode:
+			code, err := qr.Encode(e.Data, qrLevelName(e.ECC), qr.Auto)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			img = scaleBitmap(barcodeBitmap(code, 1), e.ModuleWidth, e.ModuleWidth)
+		case LabelBitmap:
+			bm, err := e.decodeBitmap()
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			img = bm
+		case LabelBox:
+			img = NewBitmap(e.Width, e.Height)
+			t := e.Thickness
+			img.FillRect(0, 0, e.Width, t, true)
+			img.FillRect(0, e.Height-t, e.Width, t, true)
+			img.FillRect(0, 0, t, e.Height, true)
+			img.FillRect(e.Width-t, 0, t, e.Height, true)
+		case LabelLine:
+			w, h := e.Width, e.Height
+			if w <= 0 {
+				w = e.Thickness
+			}
+			if h <= 0 {
+				h = e.Thickness
+			}
+			img = NewBitmap(w, h)
+			img.FillRect(0, 0, w, h, true)
+		case LabelCircle:
+			img = circleBitmap(e.Width, e.Thickness)
+		}
+		out.Draw(img.Rotate(e.Rotation), e.X, e.Y)
+	}
+	return out, nil
+}
+
+// textMultiplier picks the integer magnification of a 24-dot font that best
+// matches a requested character height.
+func textMultiplier(size int) int {
+	return clampInt(int(math.Round(float64(size)/24)), 1, 8)
+}
+
+func qrLevelName(ecc string) qr.ErrorCorrectionLevel {
+	switch ecc {
+	case "M":
+		return qr.M
+	case "Q":
+		return qr.Q
+	case "H":
+		return qr.H
+	default:
+		return qr.L
+	}
+}
+
+func circleBitmap(diameter, thickness int) *Bitmap {
+	img := NewBitmap(diameter, diameter)
+	r := float64(diameter) / 2
+	for y := 0; y < diameter; y++ {
+		for x := 0; x < diameter; x++ {
+			d := math.Hypot(float64(x)+0.5-r, float64(y)+0.5-r)
+			if d <= r && d >= r-float64(thickness) {
+				img.Set(x, y, true)
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,130 @@
+package printing
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func sampleLabel() *Label {
+	return &Label{
+		WidthMM:  50,
+		HeightMM: 30,
+		GapMM:    2,
+		Copies:   2,
+		Elements: []LabelElement{
+			{Type: LabelText, X: 10, Y: 10, Text: `Say "hi"`, Size: 48},
+			{Type: LabelBarcode, X: 10, Y: 80, Symbology: "code128", Data: "12345", HRI: true},
+			{Type: LabelQRCode, X: 250, Y: 10, Data: "https://example.com"},
+			{Type: LabelBox, X: 0, Y: 0, Width: 400, Height: 240},
+		},
+	}
+}
+
+func TestEncodeTSPL(t *testing.T) {
+	data, err := EncodeLabel(LanguageTSPL, sampleLabel())
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	want := strings.Join([]string{
+		"SIZE 50 mm,30 mm",
+		"GAP 2 mm,0 mm",
+		"CLS",
+		`TEXT 10,10,"3",0,2,2,"Say \["]hi\["]"`,
+		`BARCODE 10,80,"128",60,1,0,2,4,"12345"`,
+		`QRCODE 250,10,M,4,A,0,"https://example.com"`,
+		"BOX 0,0,400,240,2",
+		"PRINT 1,2",
+		"",
+	}, "\r\n")
+	if string(data) != want {
+		t.Fatalf("TSPL =\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestEncodeCPCL(t *testing.T) {
+	data, err := EncodeLabel(LanguageCPCL, sampleLabel())
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	want := strings.Join([]string{
+		"! 0 200 200 240 2",
+		"PAGE-WIDTH 400",
+		"SETMAG 2 2",
+		`TEXT 7 0 10 10 Say "hi"`,
+		"SETMAG 0 0",
+		"BARCODE-TEXT 7 0 5",
+		"BARCODE 128 2 1 60 10 80 12345",
+		"BARCODE-TEXT OFF",
+		"BARCODE QR 250 10 M 2 U 4",
+		"MA,https://example.com",
+		"ENDQR",
+		"BOX 0 0 400 240 2",
+		"GAP-SENSE",
+		"FORM",
+		"PRINT",
+		"",
+	}, "\r\n")
+	if string(data) != want {
+		t.Fatalf("CPCL =\n%s\nwant\n%s", data, want)
+	}
+}
+
+func TestEncodeLabelRejectsInexpressibleElements(t *testing.T) {
+	label := sampleLabel()
+	label.Elements = append(label.Elements, LabelElement{Type: LabelBarcode, Symbology: "ean13", Data: "590123412345", Rotation: 180})
+	_, err := EncodeLabel(LanguageCPCL, label)
+	var unsupported *UnsupportedError
+	if !errors.As(err, &unsupported) || unsupported.Index != 4 {
+		t.Fatalf("expected UnsupportedError for element 4, got %v", err)
+	}
+
+	if _, err := EncodeLabel(LanguageESCPOS, sampleLabel()); !errors.As(err, &unsupported) {
+		t.Fatalf("expected UnsupportedError for escpos, got %v", err)
+	}
+}
+
+func TestLabelValidateRejectsUnsafeFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(l *Label)
+	}{
+		{"text line break", func(l *Label) { l.Elements[0].Text = "a\r\nPRINT" }},
+		{"barcode data", func(l *Label) {
+			l.Elements = append(l.Elements, LabelElement{Type: LabelBarcode, Symbology: "code128", Data: "1\nFORM"})
+		}},
+		{"qr data", func(l *Label) { l.Elements = append(l.Elements, LabelElement{Type: LabelQRCode, Data: "x\r\nENDQR"}) }},
+		{"font", func(l *Label) { l.Elements[0].Font = "3\"\nCLS" }},
+		{"too wide", func(l *Label) { l.WidthMM = 1e6 }},
+		{"too long", func(l *Label) { l.HeightMM = 1e6 }},
+		{"box larger than label", func(l *Label) {
+			l.Elements = append(l.Elements, LabelElement{Type: LabelBox, Width: 65535, Height: 65535})
+		}},
+		{"module width", func(l *Label) {
+			l.Elements = append(l.Elements, LabelElement{Type: LabelQRCode, Data: "x", ModuleWidth: 10000})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := sampleLabel()
+			tt.modify(l)
+			if err := l.Validate(); err == nil {
+				t.Fatal("Validate accepted the label")
+			}
+		})
+	}
+}
+
+func TestRenderLabel(t *testing.T) {
+	label := sampleLabel()
+	if err := label.Validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	img, err := RenderLabel(label)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if img.Width != 400 || img.Height != 240 || !img.At(0, 0) {
+		t.Fatalf("unexpected render %dx%d", img.Width, img.Height)
+	}
+}
@@ -0,0 +1,408 @@
+package printing
+
+import (
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Font selects one of the printer's built-in ESC/POS character fonts.
+type Font int
+
+const (
+	FontA Font = iota // 12x24 dots
+	FontB             // 9x17 dots
+)
+
+// ParseFont maps a config value ("A", "B") to a Font. Unknown values fall back to FontA.
+func ParseFont(name string) Font {
+	switch strings.ToUpper(strings.TrimSpace(name)) {
+	case "B":
+		return FontB
+	default:
+		return FontA
+	}
+}
+
+// CharWidthDots is the horizontal size of a single-width character cell.
+func (f Font) CharWidthDots() int {
+	if f == FontB {
+		return 9
+	}
+	return 12
+}
+
+// PrintableDots returns the printable head width for a paper roll width.
+// 58 mm and 80 mm rolls use the common 384/576 dot heads; other widths
+// assume 8 dots/mm with a 5 mm margin on each side.
+func PrintableDots(paperWidthMM int) int {
+	switch paperWidthMM {
+	case 0, 58:
+		return 384
+	case 80:
+		return 576
+	}
+	dots := (paperWidthMM - 10) * 8
+	if dots < 8 {
+		dots = 8
+	}
+	return dots
+}
+
+// CharsPerLine returns the number of single-width characters that fit on a line.
+func CharsPerLine(paperWidthMM int, font Font) int {
+	return PrintableDots(paperWidthMM) / font.CharWidthDots()
+}
+
+// Align is the horizontal alignment of text within a line or column.
+type Align int
+
+const (
+	AlignLeft Align = iota
+	AlignCenter
+	AlignRight
+)
+
+// ParseAlign maps "left", "center"/"centre" and "right" to an Align.
+func ParseAlign(name string) Align {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "center", "centre":
+		return AlignCenter
+	case "right":
+		return AlignRight
+	default:
+		return AlignLeft
+	}
+}
+
+// Layout wraps and aligns text for a fixed number of character columns.
+type Layout struct {
+	Width int
+}
+
+// NewLayout returns a layout sized for the paper width and font.
+func NewLayout(paperWidthMM int, font Font) Layout {
+	return Layout{Width: CharsPerLine(paperWidthMM, font)}
+}
+
+// Scaled returns the layout for text printed at the given width
+// multiplier (GS ! width bits), e.g. 2 for double-width text.
+func (l Layout) Scaled(widthMultiplier int) Layout {
+	if widthMultiplier <= 1 {
+		return l
+	}
+	w := l.Width / widthMultiplier
+	if w < 1 {
+		w = 1
+	}
+	return Layout{Width: w}
+}
+
+// WrapText word-wraps every line of text, keeping existing line breaks.
+// A trailing newline is preserved.
+func (l Layout) WrapText(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	trailing := strings.HasSuffix(text, "\n")
+	text = strings.TrimSuffix(text, "\n")
+
+	var out []string
+	for _, line := range strings.Split(text, "\n") {
+		out = append(out, l.Wrap(line)...)
+	}
+	result := strings.Join(out, "\n")
+	if trailing {
+		result += "\n"
+	}
+	return result
+}
+
+// Wrap breaks a single line into lines no wider than the layout. Words are
+// kept whole where possible; tokens longer than a line are hyphenated.
+// An empty input yields a single empty line.
+func (l Layout) Wrap(line string) []string {
+	return wrapWidth(line, l.Width)
+}
+
+// Align pads text to the layout width. Text wider than the layout is
+// returned unchanged.
+func (l Layout) Align(text string, align Align) string {
+	return pad(text, l.Width, align)
+}
+
+// Divider returns a full-width line made of ch.
+func (l Layout) Divider(ch rune) string {
+	return strings.Repeat(string(ch), l.Width)
+}
+
+// Column describes one column of a Table. A column with Width > 0 is fixed;
+// otherwise it shares the remaining width with the other flexible columns
+// in proportion to Flex (0 counts as 1).
+type Column struct {
+	Width int
+	Flex  int
+	Align Align
+}
+
+// Table is a set of columns separated by Gap spaces.
+type Table struct {
+	Columns []Column
+	Gap     int
+}
+
+// ColumnWidths resolves the character width of every column for this layout.
+func (l Layout) ColumnWidths(t Table) []int {
+	widths := make([]int, len(t.Columns))
+	if len(t.Columns) == 0 {
+		return widths
+	}
+	remaining := l.Width - t.Gap*(len(t.Columns)-1)
+	flexTotal := 0
+	for i, c := range t.Columns {
+		if c.Width > 0 {
+			widths[i] = c.Width
+			remaining -= c.Width
+			continue
+		}
+		flexTotal += flexWeight(c)
+	}
+	if flexTotal == 0 {
+		return widths
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	assigned := 0
+	lastFlex := -1
+	for i, c := range t.Columns {
+		if c.Width > 0 {
+			continue
+		}
+		widths[i] = remaining * flexWeight(c) / flexTotal
+		assigned += widths[i]
+		lastFlex = i
+	}
+	// Give rounding leftovers to the last flexible column.
+	widths[lastFlex] += remaining - assigned
+	for i := range widths {
+		if widths[i] < 1 {
+			widths[i] = 1
+		}
+	}
+	return widths
+}
+
+// Row formats one table row. Cells wrap within their column, so a row may
+// produce several lines; missing cells are treated as empty.
+func (l Layout) Row(t Table, cells ...string) []string {
+	return l.row(t, styleCodes{}, cells)
+}
+
+// row is Row for cells that may contain codes. A cell's leading and
+// trailing codes are repeated around each line it wraps to; a cell with
+// codes inside its text is not wrapped.
+func (l Layout) row(t Table, codes styleCodes, cells []string) []string {
+	widths := l.ColumnWidths(t)
+	wrapped := make([][]string, len(widths))
+	visible := make([][]int, len(widths))
+	height := 1
+	for i, w := range widths {
+		cell := ""
+		if i < len(cells) {
+			cell = cells[i]
+		}
+		prefix, text, suffix := codes.trim(cell)
+		if plain := codes.strip(text); plain != text {
+			wrapped[i], visible[i] = []string{cell}, []int{textWidth(plain)}
+		} else {
+			for _, line := range wrapWidth(text, w) {
+				wrapped[i] = append(wrapped[i], prefix+line+suffix)
+				visible[i] = append(visible[i], textWidth(line))
+			}
+		}
+		if len(wrapped[i]) > height {
+			height = len(wrapped[i])
+		}
+	}
+
+	gap := strings.Repeat(" ", t.Gap)
+	lines := make([]string, height)
+	for row := 0; row < height; row++ {
+		var b strings.Builder
+		for i, w := range widths {
+			if i > 0 {
+				b.WriteString(gap)
+			}
+			part, width := "", 0
+			if row < len(wrapped[i]) {
+				part, width = wrapped[i][row], visible[i][row]
+			}
+			b.WriteString(padVisible(part, width, w, t.Columns[i].Align))
+		}
+		lines[row] = strings.TrimRight(b.String(), " ")
+	}
+	return lines
+}
+
+func flexWeight(c Column) int {
+	if c.Flex <= 0 {
+		return 1
+	}
+	return c.Flex
+}
+
+func textWidth(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+func pad(text string, width int, align Align) string {
+	return padVisible(text, textWidth(text), width, align)
+}
+
+// padVisible pads text, which takes visible columns on paper, to width.
+func padVisible(text string, visible, width int, align Align) string {
+	gap := width - visible
+	if gap <= 0 {
+		return text
+	}
+	switch align {
+	case AlignRight:
+		return strings.Repeat(" ", gap) + text
+	case AlignCenter:
+		left := gap / 2
+		return strings.Repeat(" ", left) + text + strings.Repeat(" ", gap-left)
+	default:
+		return text + strings.Repeat(" ", gap)
+	}
+}
+
+func wrapWidth(line string, width int) []string {
+	if width < 1 {
+		width = 1
+	}
+	line = strings.TrimRight(line, " \t")
+	if textWidth(line) <= width {
+		return []string{line}
+	}
+
+	// Keep leading indentation on the first line when it leaves room for text.
+	var cur []rune
+	if indent := len(line) - len(strings.TrimLeft(line, " ")); indent < width/2 {
+		cur = []rune(line[:indent])
+	}
+	var lines []string
+	hasWord := false
+	flush := func() {
+		lines = append(lines, string(cur))
+		cur = cur[:0:0]
+		hasWord = false
+	}
+
+	for _, word := range strings.Fields(line) {
+		w := []rune(word)
+		for len(w) > 0 {
+			space := 0
+			if hasWord {
+				space = 1
+			}
+			if len(cur)+space+len(w) <= width {
+				if hasWord {
+					cur = append(cur, ' ')
+				}
+				cur = append(cur, w...)
+				hasWord = true
+				break
+			}
+			if hasWord && len(w) <= width {
+				flush()
+				continue
+			}
+			// Token longer than a line: hyphenate, but only start it on a
+			// partially filled line if at least two characters fit.
+			room := width - len(cur) - space - 1
+			if hasWord && room < 2 {
+				flush()
+				continue
+			}
+			if room < 1 {
+				room = width - len(cur)
+				cur = append(cur, w[:room]...)
+				w = w[room:]
+				flush()
+				continue
+			}
+			if hasWord {
+				cur = append(cur, ' ')
+			}
+			cur = append(cur, w[:room]...)
+			cur = append(cur, '-')
+			w = w[room:]
+			flush()
+		}
+	}
+	if len(cur) > 0 || len(lines) == 0 {
+		flush()
+	}
+	return lines
+}
+
+// styleCodes are the commands a driver embeds in text to style it, such
+// as the bold on and off around {{bold .name}} in a template. They take no
+// room on paper, so padding and wrapping skip them.
+type styleCodes struct {
+	codes []string
+	r     *strings.Replacer
+}
+
+// newStyleCodes returns the codes of d's styles, longest first so that
+// each is matched whole.
+func newStyleCodes(d Driver, styles ...TextStyle) styleCodes {
+	var codes []string
+	add := func(b []byte) {
+		if len(b) > 0 && !slices.Contains(codes, string(b)) {
+			codes = append(codes, string(b))
+		}
+	}
+	for _, s := range styles {
+		add(d.Style(PlainStyle, s))
+		add(d.Style(s, PlainStyle))
+	}
+	for _, a := range []Align{AlignLeft, AlignCenter, AlignRight} {
+		add(d.Align(a))
+	}
+	slices.SortStableFunc(codes, func(a, b string) int { return len(b) - len(a) })
+	var pairs []string
+	for _, c := range codes {
+		pairs = append(pairs, c, "")
+	}
+	return styleCodes{codes: codes, r: strings.NewReplacer(pairs...)}
+}
+
+// strip returns s without its codes.
+func (c styleCodes) strip(s string) string {
+	if c.r == nil {
+		return s
+	}
+	return c.r.Replace(s)
+}
+
+// trim splits s into its leading codes, its text and its trailing codes.
+func (c styleCodes) trim(s string) (prefix, text, suffix string) {
+	text = s
+	for found := true; found; {
+		found = false
+		for _, code := range c.codes {
+			if rest, ok := strings.CutPrefix(text, code); ok {
+				prefix, text, found = prefix+code, rest, true
+			}
+		}
+	}
+	for found := true; found; {
+		found = false
+		for _, code := range c.codes {
+			if rest, ok := strings.CutSuffix(text, code); ok {
+				suffix, text, found = code+suffix, rest, true
+			}
+		}
+	}
+	return prefix, text, suffix
+}
@@ -0,0 +1,91 @@
+package printing
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCharsPerLine(t *testing.T) {
+	tests := []struct {
+		width int
+		font  Font
+		want  int
+	}{
+		{58, FontA, 32},
+		{58, FontB, 42},
+		{80, FontA, 48},
+		{80, FontB, 64},
+	}
+	for _, tt := range tests {
+		if got := CharsPerLine(tt.width, tt.font); got != tt.want {
+			t.Fatalf("CharsPerLine(%d, %v) = %d, want %d", tt.width, tt.font, got, tt.want)
+		}
+	}
+	if got := NewLayout(58, FontA).Scaled(2).Width; got != 16 {
+		t.Fatalf("double-width layout = %d columns, want 16", got)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	tests := []struct {
+		name  string
+		width int
+		input string
+		want  []string
+	}{
+		{name: "fits", width: 10, input: "short", want: []string{"short"}},
+		{name: "empty", width: 10, input: "", want: []string{""}},
+		{name: "word boundary", width: 10, input: "one two three four", want: []string{"one two", "three four"}},
+		{name: "long token hyphenated", width: 6, input: "abcdefghijkl", want: []string{"abcde-", "fghij-", "kl"}},
+		{name: "long token after word", width: 8, input: "ab cdefghijkl", want: []string{"ab cdef-", "ghijkl"}},
+		{name: "keeps indent", width: 8, input: "  aaa bbb ccc", want: []string{"  aaa", "bbb ccc"}},
+		{name: "runes count once", width: 5, input: "ñañá ñu", want: []string{"ñañá", "ñu"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Layout{Width: tt.width}.Wrap(tt.input)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Wrap(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWrapTextKeepsLineBreaks(t *testing.T) {
+	got := Layout{Width: 5}.WrapText("ab cd ef\r\n\nxy\n")
+	want := "ab cd\nef\n\nxy\n"
+	if got != want {
+		t.Fatalf("WrapText = %q, want %q", got, want)
+	}
+}
+
+func TestRow(t *testing.T) {
+	l := Layout{Width: 20}
+	table := Table{
+		Columns: []Column{{Width: 3, Align: AlignRight}, {Flex: 1}, {Width: 6, Align: AlignRight}},
+		Gap:     1,
+	}
+	if got := l.ColumnWidths(table); !reflect.DeepEqual(got, []int{3, 9, 6}) {
+		t.Fatalf("ColumnWidths = %v", got)
+	}
+
+	got := l.Row(table, "2", "Cheeseburger deluxe", "12.50")
+	want := []string{
+		"  2 Cheesebu-  12.50",
+		"    rger",
+		"    deluxe",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Row = %q, want %q", got, want)
+	}
+}
+
+func TestRowCenterAndFlexWeights(t *testing.T) {
+	l := Layout{Width: 12}
+	table := Table{Columns: []Column{{Flex: 2}, {Flex: 1, Align: AlignCenter}}}
+	got := l.Row(table, "left", "c")
+	want := []string{"left     c"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("Row = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,478 @@
+package printing
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// EncodeMarkdown prints a small Markdown subset as a receipt finished as
+// opts.Finish says:
+//
+//   - # headings print double size and bold (## double height, deeper
+//     levels bold)
+//   - **strong** prints bold and *emphasis* underlined; `code` spans and
+//     backslash escapes print literally, and [text](url) as "text (url)"
+//   - -, * and + lists print as indented bullets, numbered lists keep
+//     their numbers; nesting follows the indentation
+//   - pipe tables print as aligned columns with a bold header
+//   - ---, *** and ___ print as dividers, > quotes with a bar
+//   - fenced code blocks print verbatim, cut at the line width
+//
+// Paragraphs are word-wrapped for the paper width and font; each line is
+// encoded in opts.CodePage and rasterized with opts.Fonts when the table
+// cannot represent it, as EncodeText does, with the same ErrNoGlyph error.
+func EncodeMarkdown(d Driver, src string, paperWidthMM int, font Font, opts TextOptions) ([]byte, error) {
+	m := &mdWriter{d: d, paper: paperWidthMM, font: font, opts: opts, current: PlainStyle, blank: true}
+	m.b.Write(opts.Header)
+	if opts.CodePage != nil {
+		if cmd, err := d.CodePage(*opts.CodePage); err == nil {
+			m.b.Write(cmd)
+		} else {
+			m.unselectable = true
+		}
+	}
+	m.render(src)
+	if m.err != nil {
+		return nil, m.err
+	}
+	m.setStyle(PlainStyle)
+	return opts.Finish.Apply(d, m.b.Bytes()), nil
+}
+
+var (
+	mdHeading  = regexp.MustCompile(`^(#{1,6})\s+(.*?)(\s+#+)?\s*$`)
+	mdRule     = regexp.MustCompile(`^(-\s*){3,}$|^(\*\s*){3,}$|^(_\s*){3,}$`)
+	mdListItem = regexp.MustCompile(`^(\s*)([-*+]|\d{1,9}[.)])\s+(.*)$`)
+	mdTableSep = regexp.MustCompile(`^\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+)
+
+// mdChar is one printed character and its inline emphasis.
+type mdChar struct {
+	r         rune
+	bold      bool
+	underline bool
+}
+
+type mdWriter struct {
+	d     Driver
+	paper int
+	font  Font
+	opts  TextOptions
+	b     bytes.Buffer
+
+	current      TextStyle
+	unselectable bool
+	// blank is set at the start and after an empty line, so leading blank
+	// lines are dropped and runs of them print one.
+	blank bool
+	// err is the first line that could not be rasterized.
+	err error
+}
+
+func (m *mdWriter) render(src string) {
+	src = strings.TrimRight(strings.ReplaceAll(src, "\r\n", "\n"), "\n ")
+	lines := strings.Split(src, "\n")
+	var para []string
+	flush := func() {
+		if len(para) > 0 {
+			m.wrapped(parseInline(strings.Join(para, " ")), PlainStyle, "", "")
+			para = nil
+		}
+	}
+	for i := 0; i < len(lines); i++ {
+		line := strings.ReplaceAll(lines[i], "\t", "    ")
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+			flush()
+			fence := trimmed[:3]
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), fence); i++ {
+				m.code(strings.ReplaceAll(lines[i], "\t", "    "))
+			}
+		case trimmed == "":
+			flush()
+			if !m.blank {
+				m.line(nil, PlainStyle)
+				m.blank = true
+			}
+		case mdRule.MatchString(trimmed):
+			flush()
+			m.setStyle(PlainStyle)
+			m.text(NewLayout(m.paper, m.font).Divider('-'))
+		case mdHeading.MatchString(trimmed):
+			flush()
+			g := mdHeading.FindStringSubmatch(trimmed)
+			style := PlainStyle
+			style.Bold = true
+			switch len(g[1]) {
+			case 1:
+				style.Width, style.Height = 2, 2
+			case 2:
+				style.Height = 2
+			}
+			m.wrapped(parseInline(g[2]), style, "", "")
+		case mdListItem.MatchString(line):
+			flush()
+			g := mdListItem.FindStringSubmatch(line)
+			indent := strings.Repeat("  ", len(g[1])/2+1)
+			marker := g[2]
+			if !unicode.IsDigit(rune(marker[0])) {
+				marker = m.bullet()
+			}
+			m.wrapped(parseInline(g[3]), PlainStyle, indent+marker+" ", indent+strings.Repeat(" ", len([]rune(marker))+1))
+		case strings.HasPrefix(trimmed, "|") && i+1 < len(lines) && mdTableSep.MatchString(strings.TrimSpace(lines[i+1])):
+			flush()
+			header := splitTableRow(trimmed)
+			aligns := splitTableRow(strings.TrimSpace(lines[i+1]))
+			var rows [][]string
+			for i += 2; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), "|"); i++ {
+				rows = append(rows, splitTableRow(strings.TrimSpace(lines[i])))
+			}
+			i--
+			m.table(header, aligns, rows)
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			quote := strings.TrimSpace(strings.TrimPrefix(trimmed, ">"))
+			for i+1 < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i+1]), ">") {
+				i++
+				quote += " " + strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(lines[i]), ">"))
+			}
+			m.wrapped(parseInline(quote), PlainStyle, "| ", "| ")
+		default:
+			para = append(para, trimmed)
+		}
+	}
+	flush()
+}
+
+// bullet is the list marker: a bullet where the code page has one.
+func (m *mdWriter) bullet() string {
+	if m.opts.CodePage != nil && !m.unselectable && m.opts.CodePage.CanEncode("•") {
+		return "•"
+	}
+	return "*"
+}
+
+// wrapped word-wraps inline text printed in base, starting the first line
+// with first and the others with rest.
+func (m *mdWriter) wrapped(chars []mdChar, base TextStyle, first, rest string) {
+	width := NewLayout(m.paper, m.font).Scaled(base.Width).Width
+	prefix := plainChars(first)
+	var line []mdChar
+	hasWord := false
+	emit := func() {
+		m.line(line, base)
+		prefix = plainChars(rest)
+		line, hasWord = nil, false
+	}
+	for _, word := range splitWords(chars) {
+		for len(word) > 0 {
+			space := 0
+			if hasWord {
+				space = 1
+			}
+			if len(prefix)+len(line)+space+len(word) <= width {
+				if line == nil {
+					line = append(line, prefix...)
+				}
+				if hasWord {
+					line = append(line, mdChar{r: ' '})
+				}
+				line = append(line, word...)
+				hasWord = true
+				break
+			}
+			if hasWord {
+				emit()
+				continue
+			}
+			// A word longer than a line is cut where the line ends.
+			n := max(width-len(prefix), 1)
+			line = append(append([]mdChar{}, prefix...), word[:min(n, len(word))]...)
+			word = word[min(n, len(word)):]
+			emit()
+		}
+	}
+	if hasWord || first != "" {
+		if line == nil {
+			line = prefix
+		}
+		emit()
+	}
+}
+
+func (m *mdWriter) code(line string) {
+	m.setStyle(PlainStyle)
+	width := NewLayout(m.paper, m.font).Width
+	r := []rune(strings.TrimRight(line, " "))
+	for len(r) > width {
+		m.text(string(r[:width]))
+		r = r[width:]
+	}
+	m.text(string(r))
+}
+
+func (m *mdWriter) table(header, aligns []string, rows [][]string) {
+	t := Table{Gap: 1}
+	for i := range header {
+		col := Column{Align: AlignLeft}
+		if i < len(aligns) {
+			a := aligns[i]
+			switch {
+			case strings.HasPrefix(a, ":") && strings.HasSuffix(a, ":"):
+				col.Align = AlignCenter
+			case strings.HasSuffix(a, ":"):
+				col.Align = AlignRight
+			}
+		}
+		t.Columns = append(t.Columns, col)
+	}
+	l := NewLayout(m.paper, m.font)
+	cells := func(row []string) []string {
+		out := make([]string, len(row))
+		for i, c := range row {
+			out[i] = charsText(parseInline(c))
+		}
+		return out
+	}
+	// Columns after the first are as wide as their content and the first
+	// takes the rest, unless that does not fit.
+	widths := make([]int, len(t.Columns))
+	for _, row := range append([][]string{header}, rows...) {
+		for i, c := range cells(row) {
+			if i < len(widths) {
+				widths[i] = max(widths[i], textWidth(c))
+			}
+		}
+	}
+	total := t.Gap * (len(widths) - 1)
+	for _, w := range widths {
+		total += w
+	}
+	if total <= l.Width {
+		for i := 1; i < len(widths); i++ {
+			t.Columns[i].Width = widths[i]
+		}
+	}
+	bold := PlainStyle
+	bold.Bold = true
+	m.setStyle(bold)
+	for _, line := range l.Row(t, cells(header)...) {
+		m.text(line)
+	}
+	m.setStyle(PlainStyle)
+	m.text(l.Divider('-'))
+	for _, row := range rows {
+		for _, line := range l.Row(t, cells(row)...) {
+			m.text(line)
+		}
+	}
+}
+
+// line prints one line of characters in base plus their emphasis.
+func (m *mdWriter) line(chars []mdChar, base TextStyle) {
+	m.blank = false
+	text := charsText(chars)
+	if !m.native(text) && m.opts.Fonts != nil {
+		m.setStyle(PlainStyle)
+		m.raster(text)
+		return
+	}
+	for _, c := range chars {
+		style := base
+		style.Bold = base.Bold || c.bold
+		if c.underline {
+			style.Underline = 1
+		}
+		m.setStyle(style)
+		m.b.Write(m.encode(string(c.r)))
+	}
+	m.b.WriteByte('\n')
+}
+
+// text prints a line in the current style.
+func (m *mdWriter) text(s string) {
+	m.blank = false
+	if !m.native(s) && m.opts.Fonts != nil {
+		m.raster(s)
+		return
+	}
+	m.b.Write(m.encode(s))
+	m.b.WriteByte('\n')
+}
+
+// raster prints a line the code page cannot represent as raster stripes.
+func (m *mdWriter) raster(s string) {
+	data, err := rasterText(m.d, m.opts, s)
+	if err != nil {
+		if m.err == nil {
+			m.err = err
+		}
+		return
+	}
+	m.b.Write(data)
+}
+
+func (m *mdWriter) native(s string) bool {
+	if m.opts.CodePage == nil {
+		return true
+	}
+	return !HasRTL(s) && (m.opts.CodePage.CanEncode(s) && !m.unselectable || isASCII(s))
+}
+
+func (m *mdWriter) encode(s string) []byte {
+	if m.opts.CodePage == nil {
+		return []byte(s)
+	}
+	return m.opts.CodePage.EncodeString(s)
+}
+
+// setStyle switches to s in the document font.
+func (m *mdWriter) setStyle(s TextStyle) {
+	s.Font = m.font
+	m.b.Write(m.d.Style(m.current, s))
+	m.current = s
+}
+
+// parseInline resolves emphasis, code spans, links and escapes. Markers
+// without a closing partner print literally.
+func parseInline(s string) []mdChar {
+	r := []rune(s)
+	out := make([]mdChar, 0, len(r))
+	bold, underline := false, false
+
+	// A backward pass records where the next closing bracket, backtick and
+	// end of a link target are, and a forward pass where each emphasis
+	// marker last starts, so no marker rescans the rest of the line.
+	nextBracket, nextTick, nextStop := make([]int, len(r)+1), make([]int, len(r)+1), make([]int, len(r)+1)
+	nextBracket[len(r)], nextTick[len(r)], nextStop[len(r)] = -1, -1, -1
+	for i := len(r) - 1; i >= 0; i-- {
+		nextBracket[i], nextTick[i], nextStop[i] = nextBracket[i+1], nextTick[i+1], nextStop[i+1]
+		switch {
+		case r[i] == ']':
+			nextBracket[i] = i
+		case r[i] == '`':
+			nextTick[i] = i
+		case r[i] == ')' || strings.ContainsRune("\t\n\f\r ", r[i]):
+			nextStop[i] = i
+		}
+	}
+	last := map[string]int{}
+	for i, c := range r {
+		if c == '*' || c == '_' {
+			last[string(c)] = i
+			if i+1 < len(r) && r[i+1] == c {
+				last[string([]rune{c, c})] = i
+			}
+		}
+	}
+	closes := func(i int, marker string) bool {
+		at, ok := last[marker]
+		return ok && at >= i+len([]rune(marker))
+	}
+
+	for i := 0; i < len(r); i++ {
+		c := r[i]
+		switch {
+		case c == '\\' && i+1 < len(r) && strings.ContainsRune("\\`*_[]()#+-.!|>~", r[i+1]):
+			i++
+			out = append(out, mdChar{r: r[i], bold: bold, underline: underline})
+		case c == '`':
+			end := nextTick[i+1]
+			if end < 0 {
+				out = append(out, mdChar{r: c, bold: bold, underline: underline})
+				continue
+			}
+			for _, cr := range r[i+1 : end] {
+				out = append(out, mdChar{r: cr, bold: bold, underline: underline})
+			}
+			i = end
+		case (c == '*' || c == '_') && i+1 < len(r) && r[i+1] == c:
+			marker := string([]rune{c, c})
+			if bold || closes(i, marker) {
+				bold = !bold
+				i++
+				continue
+			}
+			out = append(out, mdChar{r: c, bold: bold, underline: underline}, mdChar{r: c, bold: bold, underline: underline})
+			i++
+		case c == '*' || c == '_':
+			// An underscore inside a word (snake_case) is literal.
+			intraword := c == '_' && i > 0 && i+1 < len(r) && isWordRune(r[i-1]) && isWordRune(r[i+1])
+			if !intraword && (underline || closes(i, string(c))) {
+				underline = !underline
+				continue
+			}
+			out = append(out, mdChar{r: c, bold: bold, underline: underline})
+		case c == '[':
+			// [text](target), with no blank in the target.
+			if j := nextBracket[i+1]; j >= 0 && j+1 < len(r) && r[j+1] == '(' {
+				if k := nextStop[j+2]; k >= 0 && r[k] == ')' {
+					text, target := r[i+1:j], string(r[j+2:k])
+					if target != "" && target != string(text) {
+						text = append(text[:len(text):len(text)], []rune(" ("+target+")")...)
+					}
+					for _, lr := range text {
+						out = append(out, mdChar{r: lr, bold: bold, underline: underline})
+					}
+					i = k
+					continue
+				}
+			}
+			out = append(out, mdChar{r: c, bold: bold, underline: underline})
+		default:
+			out = append(out, mdChar{r: c, bold: bold, underline: underline})
+		}
+	}
+	return out
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func splitWords(chars []mdChar) [][]mdChar {
+	var words [][]mdChar
+	var cur []mdChar
+	for _, c := range chars {
+		if unicode.IsSpace(c.r) {
+			if len(cur) > 0 {
+				words = append(words, cur)
+				cur = nil
+			}
+			continue
+		}
+		cur = append(cur, c)
+	}
+	if len(cur) > 0 {
+		words = append(words, cur)
+	}
+	return words
+}
+
+func splitTableRow(row string) []string {
+	row = strings.TrimSuffix(strings.TrimPrefix(row, "|"), "|")
+	cells := strings.Split(row, "|")
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+	return cells
+}
+
+func plainChars(s string) []mdChar {
+	out := make([]mdChar, 0, len(s))
+	for _, r := range s {
+		out = append(out, mdChar{r: r})
+	}
+	return out
+}
+
+func charsText(chars []mdChar) string {
+	r := make([]rune, len(chars))
+	for i, c := range chars {
+		r[i] = c.r
+	}
+	return string(r)
+}
@@ -0,0 +1,111 @@
+package printing
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEncodeMarkdown(t *testing.T) {
+	cp, _ := LookupCodePage("cp437")
+	src := "# Kitchen\n\nTable **12** needs *fast* service, order_id kept.\n\n" +
+		"- Burger\n  - no onions\n\n" +
+		"| Item | Qty |\n|---|--:|\n| Coffee | 2 |\n\n" +
+		"---\n\n```\n  indented  *code*\n```\n"
+	data, err := EncodeMarkdown(ESCPOSDriver{}, src, 58, FontA, TextOptions{CodePage: &cp})
+	if err != nil {
+		t.Fatalf("EncodeMarkdown: %v", err)
+	}
+	page := DecodeESCPOS(data)
+
+	var lines []string
+	styles := map[string]TextStyle{}
+	for _, e := range page.Elements {
+		if e.Kind != ElementText {
+			continue
+		}
+		var line strings.Builder
+		for _, run := range e.Runs {
+			line.WriteString(run.Text)
+			if words := strings.Fields(run.Text); len(words) > 0 {
+				styles[words[0]] = run.Style
+			}
+		}
+		lines = append(lines, line.String())
+	}
+	want := []string{
+		"Kitchen",
+		"",
+		"Table 12 needs fast service,",
+		"order_id kept.",
+		"",
+		"  * Burger",
+		"    * no onions",
+		"",
+		"Item                         Qty",
+		strings.Repeat("-", 32),
+		"Coffee                         2",
+		"",
+		strings.Repeat("-", 32),
+		"",
+		"  indented  *code*",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d:\n%s", len(lines), len(want), strings.Join(lines, "\n"))
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+
+	tests := []struct {
+		run  string
+		want TextStyle
+	}{
+		{"Kitchen", TextStyle{Width: 2, Height: 2, Bold: true}},
+		{"12", TextStyle{Width: 1, Height: 1, Bold: true}},
+		{"fast", TextStyle{Width: 1, Height: 1, Underline: 1}},
+		{"Item", TextStyle{Width: 1, Height: 1, Bold: true}},
+		{"Coffee", PlainStyle},
+	}
+	for _, tt := range tests {
+		if got := styles[tt.run]; got != tt.want {
+			t.Errorf("%q style = %+v, want %+v", tt.run, got, tt.want)
+		}
+	}
+}
+
+func TestParseInline(t *testing.T) {
+	tests := []struct {
+		in, want string
+		bold     []int // indexes of bold characters in want
+	}{
+		{"a **b** c", "a b c", []int{2}},
+		{"a ** b", "a ** b", nil},
+		{"`*x*` y", "*x* y", nil},
+		{"`open", "`open", nil},
+		{"see [docs](https://x.io) now", "see docs (https://x.io) now", nil},
+		{"[x](x)", "x", nil},
+		{"[a](b c)", "[a](b c)", nil},
+		{"[[a](b)", "[a (b)", nil},
+		{`\*lit\*`, "*lit*", nil},
+	}
+	for _, tt := range tests {
+		chars := parseInline(tt.in)
+		if got := charsText(chars); got != tt.want {
+			t.Errorf("parseInline(%q) = %q, want %q", tt.in, got, tt.want)
+			continue
+		}
+		for _, i := range tt.bold {
+			if !chars[i].bold {
+				t.Errorf("parseInline(%q): character %d is not bold", tt.in, i)
+			}
+		}
+	}
+
+	// Unclosed markers must not rescan the rest of the line for each one.
+	long := strings.Repeat("*a", 200000) + strings.Repeat("[`", 100000)
+	if got := len(parseInline(long)); got == 0 {
+		t.Fatal("no output for a long line")
+	}
+}
@@ -0,0 +1,93 @@
+package printing
+
+import "fmt"
+
+// Logos are kept in the printer's non-volatile memory so receipts can
+// print them with a few bytes instead of a raster image. Current Epson
+// firmware stores them as NV graphics under a two-character key code
+// (GS ( L); older models only have NV bit images, numbered 1..n and all
+// redefined at once (FS q).
+
+// LogoFunc returns the commands that print the stored logo key.
+type LogoFunc func(key string) ([]byte, error)
+
+// ValidateLogoKey checks a key code: two printable ASCII characters.
+func ValidateLogoKey(key string) error {
+	if len(key) != 2 || key[0] < 33 || key[0] > 126 || key[1] < 33 || key[1] > 126 {
+		return fmt.Errorf("invalid logo key %q: use two printable ASCII characters", key)
+	}
+	return nil
+}
+
+// maxNVGraphicsWidth and maxNVGraphicsHeight are the GS ( L fn 67 limits.
+const (
+	maxNVGraphicsWidth  = 8192
+	maxNVGraphicsHeight = 2304
+)
+
+// DefineNVGraphics returns GS ( L fn 67 (GS 8 L when the image needs more
+// than 64 KB), storing img as a monochrome raster under key.
+func DefineNVGraphics(key string, img *Bitmap) ([]byte, error) {
+	if err := ValidateLogoKey(key); err != nil {
+		return nil, err
+	}
+	if img.Width < 1 || img.Width > maxNVGraphicsWidth || img.Height < 1 || img.Height > maxNVGraphicsHeight {
+		return nil, fmt.Errorf("logo is %dx%d dots; NV graphics are at most %dx%d", img.Width, img.Height, maxNVGraphicsWidth, maxNVGraphicsHeight)
+	}
+	// m fn a kc1 kc2 b xL xH yL yH c: raster format, one colour.
+	params := []byte{48, 67, 48, key[0], key[1], 1,
+		byte(img.Width), byte(img.Width >> 8), byte(img.Height), byte(img.Height >> 8), 49}
+	n := len(params) + len(img.Pix)
+	var b []byte
+	if n <= 0xFFFF {
+		b = []byte{gs, '(', 'L', byte(n), byte(n >> 8)}
+	} else {
+		b = []byte{gs, '8', 'L', byte(n), byte(n >> 8), byte(n >> 16), byte(n >> 24)}
+	}
+	b = append(b, params...)
+	return append(b, img.Pix...), nil
+}
+
+// PrintNVGraphics returns GS ( L fn 69, printing the graphics stored under
+// key at normal size.
+func PrintNVGraphics(key string) []byte {
+	return []byte{gs, '(', 'L', 6, 0, 48, 69, key[0], key[1], 1, 1}
+}
+
+// DeleteNVGraphics returns GS ( L fn 66 for key.
+func DeleteNVGraphics(key string) []byte {
+	return []byte{gs, '(', 'L', 4, 0, 48, 66, key[0], key[1]}
+}
+
+// DefineNVBitImages returns FS q, replacing every NV bit image with imgs;
+// image i is printed with PrintNVBitImage(i+1). Images are padded to a
+// multiple of 8 dots in both directions.
+func DefineNVBitImages(imgs []*Bitmap) ([]byte, error) {
+	if len(imgs) < 1 || len(imgs) > 255 {
+		return nil, fmt.Errorf("FS q stores 1 to 255 images, not %d", len(imgs))
+	}
+	b := []byte{0x1c, 'q', byte(len(imgs))}
+	for i, img := range imgs {
+		x, y := (img.Width+7)/8, (img.Height+7)/8
+		if x < 1 || x > 1023 || y < 1 || y > 288 {
+			return nil, fmt.Errorf("image %d is %dx%d dots; NV bit images are at most 8184x2304", i+1, img.Width, img.Height)
+		}
+		b = append(b, byte(x), byte(x>>8), byte(y), byte(y>>8))
+		// Column format: each column of dots is y bytes, MSB at the top.
+		for col := 0; col < x*8; col++ {
+			for row := 0; row < y*8; row += 8 {
+				var v byte
+				for bit := 0; bit < 8; bit++ {
+					if img.At(col, row+bit) {
+						v |= 0x80 >> uint(bit)
+					}
+				}
+				b = append(b, v)
+			}
+		}
+	}
+	return b, nil
+}
+
+// PrintNVBitImage returns FS p n 0, printing NV bit image n at normal size.
+func PrintNVBitImage(n int) []byte { return []byte{0x1c, 'p', byte(n), 0} }
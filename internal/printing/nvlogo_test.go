@@ -0,0 +1,76 @@
+package printing
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNVLogoCommands(t *testing.T) {
+	img := NewBitmap(9, 2)
+	img.Set(0, 0, true)
+	img.Set(8, 1, true)
+
+	define, err := DefineNVGraphics("LG", img)
+	if err != nil {
+		t.Fatalf("define: %v", err)
+	}
+	want := []byte{0x1d, '(', 'L', 15, 0, 48, 67, 48, 'L', 'G', 1, 9, 0, 2, 0, 49, 0x80, 0, 0, 0x80}
+	if !bytes.Equal(define, want) {
+		t.Fatalf("define = % x, want % x", define, want)
+	}
+	if _, err := DefineNVGraphics("L", img); err == nil {
+		t.Fatal("expected an error for a one-character key")
+	}
+
+	bitImages, err := DefineNVBitImages([]*Bitmap{img})
+	if err != nil {
+		t.Fatalf("bit images: %v", err)
+	}
+	// 16x8 dots: x=2, y=1, one byte per column, top dot in the MSB.
+	if len(bitImages) != 7+16 || !bytes.Equal(bitImages[:7], []byte{0x1c, 'q', 1, 2, 0, 1, 0}) {
+		t.Fatalf("bit images header = % x (len %d)", bitImages[:7], len(bitImages))
+	}
+	if bitImages[7] != 0x80 || bitImages[7+8] != 0x40 {
+		t.Fatalf("bit image columns = % x", bitImages[7:])
+	}
+
+	tests := []struct {
+		name string
+		got  []byte
+		want []byte
+	}{
+		{"print graphics", PrintNVGraphics("LG"), []byte{0x1d, '(', 'L', 6, 0, 48, 69, 'L', 'G', 1, 1}},
+		{"delete graphics", DeleteNVGraphics("LG"), []byte{0x1d, '(', 'L', 4, 0, 48, 66, 'L', 'G'}},
+		{"print bit image", PrintNVBitImage(2), []byte{0x1c, 'p', 2, 0}},
+	}
+	for _, tt := range tests {
+		if !bytes.Equal(tt.got, tt.want) {
+			t.Errorf("%s = % x, want % x", tt.name, tt.got, tt.want)
+		}
+	}
+
+	// The decoder and the raw filter both understand the definitions.
+	page := DecodeESCPOS(append(define, PrintNVGraphics("LG")...))
+	if len(page.Warnings) != 2 || page.Warnings[1].Offset != len(define) {
+		t.Fatalf("warnings = %+v, want one per command", page.Warnings)
+	}
+	cmds := ScanESCPOS(append(bitImages, define...))
+	if len(cmds) != 2 || cmds[0].Family != FamilyNV || cmds[1].Family != FamilyNV {
+		t.Fatalf("scan = %+v", cmds)
+	}
+}
+
+func TestDocumentLogoBlock(t *testing.T) {
+	doc := &Document{Blocks: []Block{{Type: BlockLogo, Key: "LG", Align: "center"}}}
+	if _, err := EncodeDocument(ESCPOSDriver{}, doc, 58, FontA, nil); err == nil {
+		t.Fatal("expected an error without a logo function")
+	}
+	logo := func(key string) ([]byte, error) { return PrintNVGraphics(key), nil }
+	data, err := EncodeDocument(ESCPOSDriver{}, doc, 58, FontA, logo)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if !bytes.Contains(data, append(cmdAlign(AlignCenter), PrintNVGraphics("LG")...)) {
+		t.Fatalf("logo not printed centered: % x", data)
+	}
+}
@@ -0,0 +1,266 @@
+package printing
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// pageDirections maps a page block's clockwise rotation to the ESC T print
+// direction that produces it.
+var pageDirections = map[int]byte{0: 0, 90: 3, 180: 2, 270: 1}
+
+// pageModeItems are the block types a page region can place.
+var pageModeItems = map[string]bool{BlockText: true, BlockImage: true, BlockBarcode: true, BlockQRCode: true}
+
+// pageSize returns the region of a page block in its items' reading frame,
+// defaulting the side that runs across the paper to the printable width.
+func (b Block) pageSize(paperDots int) (width, height int, err error) {
+	if _, ok := pageDirections[b.Rotation]; !ok {
+		return 0, 0, fmt.Errorf("page rotation must be 0, 90, 180 or 270")
+	}
+	width, height = b.Width, b.Height
+	across := &width
+	if b.Rotation == 90 || b.Rotation == 270 {
+		across = &height
+	}
+	if *across <= 0 {
+		*across = paperDots
+	}
+	if width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("page width and height must be set in dots")
+	}
+	if *across > paperDots {
+		return 0, 0, fmt.Errorf("page region is %d dots across; the paper has %d", *across, paperDots)
+	}
+	if width > 0xffff || height > 0xffff {
+		return 0, 0, fmt.Errorf("page region is too large")
+	}
+	return width, height, nil
+}
+
+// encodePageMode encodes a page block as an ESC/POS page mode job: the
+// print area (ESC W) originX dots from the left margin, the direction
+// (ESC T), each item at its absolute position (ESC $, GS $) and FF to print
+// the page and return to standard mode. Item positions are the top-left
+// corner of the item; page mode prints from the item's bottom edge, so the
+// vertical position sent is offset by the item's height.
+func encodePageMode(blk Block, font Font, paperDots, originX int) ([]byte, error) {
+	width, height, err := blk.pageSize(paperDots)
+	if err != nil {
+		return nil, err
+	}
+	areaW, areaH := width, height
+	if blk.Rotation == 90 || blk.Rotation == 270 {
+		areaW, areaH = height, width
+	}
+	d := ESCPOSDriver{}
+	var b bytes.Buffer
+	b.Write([]byte{esc, 'L'})
+	b.Write([]byte{esc, 'W',
+		byte(originX), byte(originX >> 8), 0, 0,
+		byte(areaW), byte(areaW >> 8), byte(areaH), byte(areaH >> 8)})
+	b.Write([]byte{esc, 'T', pageDirections[blk.Rotation]})
+
+	position := func(x, y int) {
+		x, y = clampInt(x, 0, 0xffff), clampInt(y, 0, 0xffff)
+		b.Write([]byte{esc, '$', byte(x), byte(x >> 8)})
+		b.Write([]byte{gs, '$', byte(y), byte(y >> 8)})
+	}
+	for i, item := range blk.Items {
+		if !pageModeItems[item.Type] {
+			return nil, fmt.Errorf("page item %d: type %q cannot be placed in a page region", i, item.Type)
+		}
+		if item.X < 0 || item.Y < 0 || item.X >= width || item.Y >= height {
+			return nil, fmt.Errorf("page item %d: position %d,%d is outside the %dx%d region", i, item.X, item.Y, width, height)
+		}
+		if item.Type == BlockText {
+			style := item.style(font)
+			_, lineHeight := cellSize(style)
+			b.Write(d.Style(PlainStyle, style))
+			for n, line := range strings.Split(strings.ReplaceAll(item.Text, "\r\n", "\n"), "\n") {
+				position(item.X, item.Y+(n+1)*lineHeight)
+				b.WriteString(line)
+			}
+			b.Write(d.Style(style, PlainStyle))
+			continue
+		}
+
+		var data []byte
+		switch item.Type {
+		case BlockImage:
+			img, err := decodeImage(item.Image, item.Width, item.Dither, width-item.X)
+			if err != nil {
+				return nil, fmt.Errorf("page item %d: %w", i, err)
+			}
+			data = d.Raster(img)
+		case BlockBarcode:
+			data, err = d.Barcode(Barcode{Symbology: item.Symbology, Data: item.Data, Height: item.Height, ModuleWidth: item.ModuleWidth, HRI: item.HRI})
+		case BlockQRCode:
+			data, err = d.QRCode(QRCode{Data: item.Data, ModuleSize: item.ModuleWidth, ECC: item.ECC})
+		}
+		if err != nil {
+			return nil, fmt.Errorf("page item %d: %w", i, err)
+		}
+		// Measure the item the way the preview draws it, so the printed
+		// page and the preview agree on where its top edge lands.
+		position(item.X, item.Y+RenderPage(DecodeESCPOS(data), width).Height)
+		b.Write(data)
+	}
+	b.WriteByte('\f')
+	return b.Bytes(), nil
+}
+
+// RenderPageBlock draws a page block as a bitmap the size of its region on
+// paper, for printers without page mode. It renders the same commands
+// encodePageMode sends, so both print the same layout.
+func RenderPageBlock(blk Block, font Font, paperWidthMM int) (*Bitmap, error) {
+	data, err := encodePageMode(blk, font, PrintableDots(paperWidthMM), 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range DecodeESCPOS(data).Elements {
+		if e.Kind == ElementImage {
+			return e.Image, nil
+		}
+	}
+	return nil, fmt.Errorf("page region is empty")
+}
+
+// maxPageModeDots is the longest page mode area modelled; printers buffer
+// less than this.
+const maxPageModeDots = 4096
+
+// pageMode is the decoder's page mode buffer: the elements placed since
+// ESC L, drawn in the reading frame of the print direction.
+type pageMode struct {
+	start int
+	x, y  int
+	items []placedBitmap
+}
+
+type placedBitmap struct {
+	img  *Bitmap
+	x, y int
+}
+
+// pageFrame returns the size of the print area in the reading frame of the
+// print direction.
+func (d *escposDecoder) pageFrame() (int, int) {
+	w, h := d.area[2], d.area[3]
+	if d.direction == 1 || d.direction == 3 {
+		w, h = h, w
+	}
+	return w, h
+}
+
+func (d *escposDecoder) enterPageMode(start int) {
+	d.flushLine(false)
+	d.pageMode = &pageMode{start: start}
+}
+
+// pagePosition moves the page mode print position, ending the text placed
+// at the old one.
+func (d *escposDecoder) pagePosition(update func(p *pageMode)) {
+	d.flushLine(false)
+	update(d.pageMode)
+}
+
+// placePage draws strips stacked downwards so that the bottom of the first
+// strip sits on the current print position, as page mode prints.
+func (d *escposDecoder) placePage(strips []*Bitmap, baseline int) {
+	p := d.pageMode
+	y := p.y - baseline
+	for _, s := range strips {
+		p.items = append(p.items, placedBitmap{img: s, x: p.x, y: y})
+		y += s.Height
+	}
+}
+
+// pageWidth is the width an element placed at the current position may
+// use.
+func (d *escposDecoder) pageWidth() int {
+	w, _ := d.pageFrame()
+	if w <= 0 {
+		w = maxHeadDots
+	}
+	return max(w-d.pageMode.x, 1)
+}
+
+// placeLine places the pending text line in the page buffer; with lineFeed
+// set the print position then moves to the start of the next line.
+func (d *escposDecoder) placeLine(lineFeed bool) {
+	if d.lineImage != nil {
+		d.placePage([]*Bitmap{d.lineImage}, d.lineImage.Height)
+		d.lineImage = nil
+	}
+	if len(d.runs) > 0 && !d.spansOnly {
+		strips := renderTextLine(Element{Kind: ElementText, Runs: d.runs}, d.pageWidth())
+		d.placePage(strips, strips[0].Height)
+	}
+	if lineFeed {
+		d.pageMode.x = 0
+		d.pageMode.y += d.lineSpacing
+	}
+	d.runs = nil
+	d.lineStart = d.pos
+}
+
+// placeElement places an image, barcode or QR code in the page buffer.
+func (d *escposDecoder) placeElement(e Element) {
+	switch e.Kind {
+	case ElementFeed:
+		d.pageMode.x = 0
+		d.pageMode.y += e.Dots
+		return
+	case ElementCut:
+		d.warn(e.Offset, "GS V", "cut ignored in page mode")
+		return
+	}
+	if d.spansOnly {
+		return
+	}
+	e.Align = AlignLeft
+	strips := renderElement(d.page, e, d.pageWidth())
+	height := 0
+	for _, s := range strips {
+		height += s.Height
+	}
+	d.placePage(strips, height)
+}
+
+// printPage renders the page buffer as one image in the paper flow. With
+// leave set the decoder returns to standard mode, as FF does; ESC FF keeps
+// page mode and clears the buffer.
+func (d *escposDecoder) printPage(leave bool) {
+	d.flushLine(false)
+	p := d.pageMode
+	w, h := d.pageFrame()
+	if w <= 0 || h <= 0 {
+		for _, it := range p.items {
+			w = max(w, it.x+it.img.Width)
+			h = max(h, it.y+it.img.Height)
+		}
+		// Positions set by ESC $ and GS $ are not bounded by an area.
+		maxW, maxH := maxHeadDots, maxPageModeDots
+		if d.direction == 1 || d.direction == 3 {
+			maxW, maxH = maxH, maxW
+		}
+		w, h = min(w, maxW), min(h, maxH)
+	}
+	if w > 0 && h > 0 && !d.spansOnly {
+		frame := NewBitmap(w, h)
+		for _, it := range p.items {
+			frame.Draw(it.img, it.x, it.y)
+		}
+		frame = frame.Rotate(map[int]int{0: 0, 1: 270, 2: 180, 3: 90}[d.direction])
+		out := NewBitmap(min(d.area[0]+frame.Width, maxHeadDots), min(d.area[1]+frame.Height, maxPageModeDots))
+		out.Draw(frame, d.area[0], d.area[1])
+		d.page.Elements = append(d.page.Elements, Element{Kind: ElementImage, Offset: p.start, Align: AlignLeft, Image: out})
+	}
+	if leave {
+		d.pageMode = nil
+	} else {
+		d.pageMode = &pageMode{start: d.pos}
+	}
+}
@@ -0,0 +1,121 @@
+package printing
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPageModeBlock(t *testing.T) {
+	page := Block{Type: BlockPage, Height: 120, Items: []Block{
+		{Type: BlockText, Text: "OFF", X: 40, Y: 20},
+		{Type: BlockBarcode, Symbology: "code128", Data: "A1", X: 200, Y: 30, Height: 40},
+	}}
+	data, err := EncodeDocument(ESCPOSDriver{}, &Document{Blocks: []Block{page}}, 58, FontA, nil)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	for _, want := range [][]byte{
+		{esc, 'L'},
+		{esc, 'W', 0, 0, 0, 0, 0x80, 1, 120, 0},
+		{esc, 'T', 0},
+		{esc, '$', 40, 0, gs, '$', 44, 0},
+		{esc, '$', 200, 0, gs, '$', 70, 0},
+	} {
+		if !bytes.Contains(data, want) {
+			t.Errorf("page mode job lacks % x", want)
+		}
+	}
+
+	p := DecodeESCPOS(data)
+	if len(p.Warnings) != 0 {
+		t.Fatalf("unexpected warnings: %+v", p.Warnings)
+	}
+	if len(p.Elements) != 2 || p.Elements[0].Kind != ElementImage {
+		t.Fatalf("elements = %+v, want page image then cut", p.Elements)
+	}
+	img := p.Elements[0].Image
+	if img.Width != 384 || img.Height != 120 {
+		t.Fatalf("page image is %dx%d, want 384x120", img.Width, img.Height)
+	}
+	// The text cell's top-left is at the item position and the barcode's
+	// bars start at its own.
+	if !blackIn(img, 40, 20, 36, 24) || blackIn(img, 0, 0, 40, 120) {
+		t.Errorf("text not drawn at 40,20")
+	}
+	if !img.At(200+22, 30) || img.At(200+22, 29) {
+		t.Errorf("barcode not drawn from 200,30")
+	}
+
+	fallback, err := RenderPageBlock(page, FontA, 58)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if !bytes.Equal(fallback.Pix, img.Pix) {
+		t.Errorf("raster fallback differs from the page mode preview")
+	}
+	star, err := EncodeDocument(StarDriver{}, &Document{Blocks: []Block{page}}, 58, FontA, nil)
+	if err != nil || !bytes.Contains(star, StarDriver{}.Raster(fallback)) {
+		t.Errorf("Star document should print the region as raster (err %v)", err)
+	}
+	if raw, _ := EncodeDocument(ESCPOSDriver{NoPageMode: true}, &Document{Blocks: []Block{page}}, 58, FontA, nil); bytes.Contains(raw, []byte{esc, 'L'}) {
+		t.Errorf("NoPageMode should not enter page mode")
+	}
+
+	rotated := Block{Type: BlockPage, Rotation: 90, Width: 100, Items: []Block{{Type: BlockText, Text: "A", X: 0, Y: 0}}}
+	img, err = RenderPageBlock(rotated, FontA, 58)
+	if err != nil {
+		t.Fatalf("render rotated: %v", err)
+	}
+	// 100 dots along the paper, the full width across; the first line
+	// reads downwards along the right edge.
+	if img.Width != 384 || img.Height != 100 || !blackIn(img, 384-24, 0, 24, 12) || blackIn(img, 0, 0, 300, 100) {
+		t.Errorf("rotated page drawn wrongly: %dx%d", img.Width, img.Height)
+	}
+
+	for _, bad := range []Block{
+		{Type: BlockPage, Rotation: 45, Height: 10},
+		{Type: BlockPage},
+		{Type: BlockPage, Width: 1000, Height: 10},
+		{Type: BlockPage, Height: 10, Items: []Block{{Type: BlockCut}}},
+		{Type: BlockPage, Height: 10, Items: []Block{{Type: BlockText, Text: "x", Y: 10}}},
+	} {
+		if _, err := EncodeDocument(ESCPOSDriver{}, &Document{Blocks: []Block{bad}}, 58, FontA, nil); err == nil {
+			t.Errorf("page %+v should be rejected", bad)
+		}
+	}
+}
+
+func blackIn(img *Bitmap, x, y, w, h int) bool {
+	for yy := y; yy < y+h; yy++ {
+		for xx := x; xx < x+w; xx++ {
+			if img.At(xx, yy) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestPageModeAreaClamped(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"huge area", []byte{0x1b, 'L', 0x1b, 'W', 0, 0x40, 0, 0x40, 0, 0x40, 0, 0x40, 'x', 0x0c}},
+		{"huge area at origin", []byte{0x1b, 'L', 0x1b, 'W', 0, 0, 0, 0, 0xff, 0xff, 0xff, 0xff, 'x', 0x0c}},
+		{"far position", []byte{0x1b, 'L', 0x1d, '$', 0xff, 0xff, 'x', 0x0c}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := DecodeESCPOS(tt.data)
+			for _, e := range page.Elements {
+				if e.Image != nil && (e.Image.Width > maxHeadDots || e.Image.Height > maxPageModeDots) {
+					t.Fatalf("page image is %dx%d", e.Image.Width, e.Image.Height)
+				}
+			}
+			if cmds := ScanESCPOS(tt.data); len(cmds) == 0 || cmds[0].Name != "ESC L" {
+				t.Fatalf("scan = %+v", cmds)
+			}
+		})
+	}
+}
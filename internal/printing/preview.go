@@ -0,0 +1,355 @@
+package printing
+
+import (
+	"image"
+	"image/draw"
+	"strings"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/codabar"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/code39"
+	"github.com/boombuler/barcode/code93"
+	"github.com/boombuler/barcode/ean"
+	"github.com/boombuler/barcode/qr"
+	"github.com/boombuler/barcode/twooffive"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Preview is a rendered ESC/POS stream.
+type Preview struct {
+	Page   *Page
+	Bitmap *Bitmap
+}
+
+// PreviewESCPOS decodes data and renders it at the printer's dot width.
+func PreviewESCPOS(data []byte, widthDots int) *Preview {
+	page := DecodeESCPOS(data)
+	return &Preview{Page: page, Bitmap: RenderPage(page, widthDots)}
+}
+
+// PNG encodes the rendered preview.
+func (p *Preview) PNG() ([]byte, error) {
+	return p.Bitmap.PNG()
+}
+
+// maxPageDots caps the rendered length of a page, about 8 m of paper.
+const maxPageDots = 1 << 16
+
+// RenderPage draws a decoded page onto a bitmap widthDots wide. Elements
+// past maxPageDots are left out with a warning.
+func RenderPage(p *Page, widthDots int) *Bitmap {
+	if widthDots <= 0 {
+		widthDots = PrintableDots(0)
+	}
+	var strips []*Bitmap
+	height := 0
+	for _, e := range p.Elements {
+		if height >= maxPageDots || e.Kind == ElementFeed && height+e.Dots > maxPageDots {
+			p.Warnings = append(p.Warnings, DecodeWarning{Offset: e.Offset, Message: "page too long to preview; rest not rendered"})
+			break
+		}
+		for _, s := range renderElement(p, e, widthDots) {
+			strips = append(strips, s)
+			height += s.Height
+		}
+	}
+	height = min(height, maxPageDots)
+	out := NewBitmap(widthDots, height)
+	y := 0
+	for _, s := range strips {
+		out.Draw(s, 0, y)
+		y += s.Height
+	}
+	return out
+}
+
+func renderElement(p *Page, e Element, width int) []*Bitmap {
+	switch e.Kind {
+	case ElementText:
+		return renderTextLine(e, width)
+	case ElementImage:
+		return []*Bitmap{placeAligned(e.Image, width, e.Align)}
+	case ElementFeed:
+		return []*Bitmap{NewBitmap(width, e.Dots)}
+	case ElementCut:
+		strip := NewBitmap(width, 16)
+		step := 8
+		if e.Partial {
+			step = 4
+		}
+		for x := 0; x < width; x += step * 2 {
+			strip.FillRect(x, 8, step, 1, true)
+		}
+		return []*Bitmap{strip}
+	case ElementBarcode:
+		img, err := encodeBarcode(e.Symbology, e.Data)
+		if err != nil {
+			p.Warnings = append(p.Warnings, DecodeWarning{Offset: e.Offset, Command: "GS k", Message: "barcode not rendered: " + err.Error()})
+			return renderPlaceholder(e.Symbology+": "+e.Data, width)
+		}
+		return renderBarcode(e, img, width)
+	case ElementQRCode:
+		img, err := qr.Encode(e.Data, qrLevel(e.ECC), qr.Auto)
+		if err != nil {
+			p.Warnings = append(p.Warnings, DecodeWarning{Offset: e.Offset, Command: "GS ( k", Message: "QR code not rendered: " + err.Error()})
+			return renderPlaceholder("QR: "+e.Data, width)
+		}
+		return []*Bitmap{placeAligned(scaleBitmap(barcodeBitmap(img, 1), e.ModuleWidth, e.ModuleWidth), width, e.Align)}
+	}
+	return nil
+}
+
+func renderTextLine(e Element, width int) []*Bitmap {
+	type cell struct {
+		r     rune
+		style TextStyle
+	}
+	var cells []cell
+	for _, run := range e.Runs {
+		for _, r := range run.Text {
+			cells = append(cells, cell{r: r, style: run.Style})
+		}
+	}
+	if len(cells) == 0 {
+		return []*Bitmap{NewBitmap(width, e.LineSpacing)}
+	}
+
+	// Split into physical rows the way the printer wraps an over-long line.
+	var rows [][]cell
+	var row []cell
+	rowWidth := 0
+	for _, c := range cells {
+		w, _ := cellSize(c.style)
+		if rowWidth+w > width && len(row) > 0 {
+			rows = append(rows, row)
+			row, rowWidth = nil, 0
+		}
+		row = append(row, c)
+		rowWidth += w
+	}
+	rows = append(rows, row)
+
+	var out []*Bitmap
+	for _, row := range rows {
+		rowWidth, rowHeight := 0, 0
+		for _, c := range row {
+			w, h := cellSize(c.style)
+			rowWidth += w
+			if h > rowHeight {
+				rowHeight = h
+			}
+		}
+		height := rowHeight
+		if e.LineSpacing > height {
+			height = e.LineSpacing
+		}
+		strip := NewBitmap(width, height)
+		x := alignOffset(rowWidth, width, e.Align)
+		for _, c := range row {
+			w, h := cellSize(c.style)
+			drawGlyph(strip, x, rowHeight-h, c.r, c.style)
+			x += w
+		}
+		out = append(out, strip)
+	}
+	return out
+}
+
+func cellSize(s TextStyle) (int, int) {
+	w, h := 12, 24
+	if s.Font == FontB {
+		w, h = 9, 17
+	}
+	return w * max(s.Width, 1), h * max(s.Height, 1)
+}
+
+var glyphFace font.Face = basicfont.Face7x13
+
+// glyphMask renders r from the built-in bitmap face into a 7x13 bitmap.
+func glyphMask(r rune) *Bitmap {
+	const gw, gh, ascent = 7, 13, 11
+	rgba := image.NewAlpha(image.Rect(0, 0, gw, gh))
+	drawer := &font.Drawer{Dst: rgba, Src: image.Opaque, Face: glyphFace, Dot: fixed.P(0, ascent)}
+	if _, ok := glyphFace.GlyphAdvance(r); !ok {
+		r = '?'
+	}
+	drawer.DrawString(string(r))
+	b := NewBitmap(gw, gh)
+	for y := 0; y < gh; y++ {
+		for x := 0; x < gw; x++ {
+			if rgba.AlphaAt(x, y).A > 0x7f {
+				b.Set(x, y, true)
+			}
+		}
+	}
+	return b
+}
+
+func drawGlyph(dst *Bitmap, x, y int, r rune, s TextStyle) {
+	w, h := cellSize(s)
+	if s.Invert {
+		dst.FillRect(x, y, w, h, true)
+	}
+	if r != ' ' {
+		mask := glyphMask(r)
+		// Leave a one-dot margin around the glyph inside the cell.
+		gw, gh := w-max(s.Width, 1)*2, h-max(s.Height, 1)*2
+		for yy := 0; yy < gh; yy++ {
+			for xx := 0; xx < gw; xx++ {
+				if !mask.At(xx*mask.Width/gw, yy*mask.Height/gh) {
+					continue
+				}
+				px, py := x+xx+max(s.Width, 1), y+yy+max(s.Height, 1)
+				if s.UpsideDown {
+					py = y + h - 1 - (yy + max(s.Height, 1))
+				}
+				dst.Set(px, py, !s.Invert)
+				if s.Bold {
+					dst.Set(px+1, py, !s.Invert)
+				}
+			}
+		}
+	}
+	if s.Underline > 0 {
+		dst.FillRect(x, y+h-s.Underline, w, s.Underline, !s.Invert)
+	}
+}
+
+// drawString renders plain text with the given style starting at (x, y).
+func drawString(dst *Bitmap, x, y int, text string, s TextStyle) {
+	for _, r := range text {
+		drawGlyph(dst, x, y, r, s)
+		w, _ := cellSize(s)
+		x += w
+	}
+}
+
+func renderPlaceholder(label string, width int) []*Bitmap {
+	style := TextStyle{Font: FontB, Width: 1, Height: 1}
+	cw, ch := cellSize(style)
+	maxChars := (width - 8) / cw
+	if len([]rune(label)) > maxChars {
+		label = string([]rune(label)[:max(maxChars-3, 0)]) + "..."
+	}
+	strip := NewBitmap(width, ch+8)
+	strip.FillRect(0, 0, width, 1, true)
+	strip.FillRect(0, ch+7, width, 1, true)
+	strip.FillRect(0, 0, 1, ch+8, true)
+	strip.FillRect(width-1, 0, 1, ch+8, true)
+	drawString(strip, 4, 4, label, style)
+	return []*Bitmap{strip}
+}
+
+func renderBarcode(e Element, img barcode.Barcode, width int) []*Bitmap {
+	bars := barcodeBitmap(img, max(e.BarHeight, 1))
+	bars = scaleBitmap(bars, max(e.ModuleWidth, 1), 1)
+	hri := func() *Bitmap {
+		style := TextStyle{Font: FontA, Width: 1, Height: 1}
+		cw, ch := cellSize(style)
+		strip := NewBitmap(width, ch)
+		text := hriText(e.Symbology, e.Data)
+		drawString(strip, alignOffset(cw*len([]rune(text)), width, AlignCenter), 0, text, style)
+		return strip
+	}
+	var out []*Bitmap
+	if e.HRI == 1 || e.HRI == 3 {
+		out = append(out, hri())
+	}
+	out = append(out, placeAligned(bars, width, e.Align))
+	if e.HRI == 2 || e.HRI == 3 {
+		out = append(out, hri())
+	}
+	return out
+}
+
+// barcodeBitmap converts a barcode image (one pixel per module) into a
+// bitmap; 1D codes are stretched to height dots.
+func barcodeBitmap(img barcode.Barcode, height int) *Bitmap {
+	bounds := img.Bounds()
+	h := bounds.Dy()
+	if bounds.Dy() == 1 {
+		h = height
+	}
+	out := NewBitmap(bounds.Dx(), h)
+	gray := image.NewGray(bounds)
+	draw.Draw(gray, bounds, img, bounds.Min, draw.Src)
+	for y := 0; y < h; y++ {
+		sy := y
+		if bounds.Dy() == 1 {
+			sy = 0
+		}
+		for x := 0; x < bounds.Dx(); x++ {
+			if gray.GrayAt(bounds.Min.X+x, bounds.Min.Y+sy).Y < 0x80 {
+				out.Set(x, y, true)
+			}
+		}
+	}
+	return out
+}
+
+func encodeBarcode(symbology, data string) (barcode.Barcode, error) {
+	switch symbology {
+	case "CODE128":
+		return code128.Encode(hriText(symbology, data))
+	case "EAN13", "EAN8":
+		return ean.Encode(data)
+	case "UPC-A":
+		return ean.Encode("0" + data)
+	case "CODE39":
+		return code39.Encode(strings.Trim(data, "*"), false, true)
+	case "CODE93":
+		return code93.Encode(data, true, true)
+	case "ITF":
+		return twooffive.Encode(data, true)
+	case "CODABAR":
+		return codabar.Encode(data)
+	}
+	return nil, errUnsupportedSymbology(symbology)
+}
+
+// hriText strips the ESC/POS CODE128 code set selector ("{A", "{B", "{C").
+func hriText(symbology, data string) string {
+	if symbology == "CODE128" && len(data) >= 2 && data[0] == '{' {
+		return data[2:]
+	}
+	return data
+}
+
+type errUnsupportedSymbology string
+
+func (e errUnsupportedSymbology) Error() string {
+	return "unsupported symbology " + string(e)
+}
+
+func qrLevel(ecc byte) qr.ErrorCorrectionLevel {
+	switch ecc {
+	case 49:
+		return qr.M
+	case 50:
+		return qr.Q
+	case 51:
+		return qr.H
+	default:
+		return qr.L
+	}
+}
+
+func placeAligned(img *Bitmap, width int, align Align) *Bitmap {
+	strip := NewBitmap(width, img.Height)
+	strip.Draw(img, alignOffset(img.Width, width, align), 0)
+	return strip
+}
+
+func alignOffset(contentWidth, width int, align Align) int {
+	switch align {
+	case AlignCenter:
+		return max((width-contentWidth)/2, 0)
+	case AlignRight:
+		return max(width-contentWidth, 0)
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,326 @@
+package printing
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Command families used by raw payload policies.
+const (
+	FamilyInit     = "init"      // ESC @
+	FamilyText     = "text"      // character styles, alignment, spacing, code pages
+	FamilyFeed     = "feed"      // paper feeds
+	FamilyCut      = "cut"       // paper cuts
+	FamilyImage    = "image"     // bit images and raster graphics printed directly
+	FamilyBarcode  = "barcode"   // 1D barcodes and 2D symbols
+	FamilyPageMode = "page_mode" // page mode and absolute positioning
+	FamilyDrawer   = "drawer"    // cash drawer pulses
+	FamilyStatus   = "status"    // status requests and test prints
+	FamilyRealtime = "realtime"  // DLE real-time commands (buffer clear, power off)
+	FamilySettings = "settings"  // user setup, memory switches, print control
+	FamilyNV       = "nv"        // writes to NV memory and downloaded graphics
+	FamilyVendor   = "vendor"    // US-prefixed vendor setup commands
+	FamilyUnknown  = "unknown"   // commands the scanner does not recognize
+)
+
+// CommandFamilies lists every family a policy can name.
+var CommandFamilies = []string{
+	FamilyInit, FamilyText, FamilyFeed, FamilyCut, FamilyImage, FamilyBarcode,
+	FamilyPageMode, FamilyDrawer, FamilyStatus, FamilyRealtime, FamilySettings,
+	FamilyNV, FamilyVendor, FamilyUnknown,
+}
+
+// Command is one control sequence found in an ESC/POS stream.
+type Command struct {
+	Offset int    `json:"offset"`
+	Length int    `json:"length"`
+	Name   string `json:"command"`
+	Family string `json:"family"`
+	// RasterHeight is the height in dots printed by image commands.
+	RasterHeight int `json:"raster_height,omitempty"`
+	// Ambiguous is set for unknown commands and commands cut off by the
+	// end of the data: the printer may take the bytes after them
+	// differently than the scan does.
+	Ambiguous bool `json:"ambiguous,omitempty"`
+}
+
+// ScanESCPOS lists the control sequences of an ESC/POS stream with their
+// family. Command lengths come from the same parser the preview uses.
+func ScanESCPOS(data []byte) []Command {
+	d := &escposDecoder{data: data, page: &Page{}, spansOnly: true}
+	d.reset()
+	d.run()
+	return d.commands
+}
+
+// record classifies the command that started at start and ended at d.pos.
+func (d *escposDecoder) record(start int) {
+	raw := d.data[start:d.pos]
+	name, family, height := classifyCommand(raw)
+	d.commands = append(d.commands, Command{
+		Offset:       start,
+		Length:       len(raw),
+		Name:         name,
+		Family:       family,
+		RasterHeight: height,
+		Ambiguous:    d.cutOff || family == FamilyUnknown,
+	})
+	d.cutOff = false
+}
+
+func classifyCommand(raw []byte) (name, family string, height int) {
+	at := func(i int) byte {
+		if i < len(raw) {
+			return raw[i]
+		}
+		return 0
+	}
+	u16 := func(i int) int { return int(at(i)) | int(at(i+1))<<8 }
+
+	switch raw[0] {
+	case 0x1f:
+		return "US", FamilyVendor, 0
+	case 0x10:
+		switch at(1) {
+		case 0x04:
+			return "DLE EOT", FamilyStatus, 0
+		case 0x05:
+			return "DLE ENQ", FamilyRealtime, 0
+		case 0x14:
+			name = fmt.Sprintf("DLE DC4 fn %d", at(2))
+			if at(2) == 1 {
+				return name, FamilyDrawer, 0
+			}
+			return name, FamilyRealtime, 0
+		}
+		return "DLE", FamilyUnknown, 0
+	case 0x1b:
+		c := at(1)
+		name = "ESC " + commandChar(c)
+		switch c {
+		case '@':
+			return name, FamilyInit, 0
+		case '!', 'E', 'G', '-', 'M', '{', 'a', '2', '3', 't', 'R', ' ', 'V', 'T', 'D', '&', '%', '?', 'U', 'r':
+			return name, FamilyText, 0
+		case '$', '\\', 'L', 'S', 'W', 0x0c:
+			return name, FamilyPageMode, 0
+		case 'd', 'J', 'e', 'K':
+			return name, FamilyFeed, 0
+		case 'i', 'm':
+			return name, FamilyCut, 0
+		case 'p':
+			return name, FamilyDrawer, 0
+		case '*':
+			if m := at(2); m == 32 || m == 33 {
+				return name, FamilyImage, 24
+			}
+			return name, FamilyImage, 8
+		case 'u', 'v':
+			return name, FamilyStatus, 0
+		case '=', 'c', '7':
+			return name, FamilySettings, 0
+		}
+		return name, FamilyUnknown, 0
+	case 0x1d:
+		c := at(1)
+		name = "GS " + commandChar(c)
+		switch c {
+		case '!', 'B', 'b', 'L', 'W', 'P', ':':
+			return name, FamilyText, 0
+		case 'H', 'h', 'w', 'f', 'k':
+			return name, FamilyBarcode, 0
+		case 'V':
+			return name, FamilyCut, 0
+		case 'v':
+			return name, FamilyImage, u16(6)
+		case '$', '\\':
+			return name, FamilyPageMode, 0
+		case 'r', 'a', 'I':
+			return name, FamilyStatus, 0
+		case '*':
+			return name, FamilyNV, 0
+		case '/':
+			return name, FamilyImage, 0
+		case '(':
+			fn := at(2)
+			name = "GS ( " + commandChar(fn)
+			switch fn {
+			case 'k':
+				return name, FamilyBarcode, 0
+			case 'L':
+				return graphicsFamily(name, at(6), at(9), u16(13))
+			case 'N':
+				return name, FamilyText, 0
+			case 'A', 'H':
+				return name, FamilyStatus, 0
+			case 'C':
+				return name, FamilyNV, 0
+			case 'D', 'E', 'K', 'M':
+				return name, FamilySettings, 0
+			}
+			return name, FamilyUnknown, 0
+		case '8':
+			name = "GS 8 " + commandChar(at(2))
+			if at(2) == 'L' {
+				return graphicsFamily(name, at(8), at(11), u16(15))
+			}
+			return name, FamilyUnknown, 0
+		}
+		return name, FamilyUnknown, 0
+	case 0x1c:
+		c := at(1)
+		name = "FS " + commandChar(c)
+		switch c {
+		case '&', '.', '!', '-', 'C', 'W', 'S':
+			return name, FamilyText, 0
+		case 'p':
+			return name, FamilyImage, 0
+		case 'q':
+			return name, FamilyNV, 0
+		case '(':
+			name = "FS ( " + commandChar(at(2))
+			switch at(2) {
+			case 'A', 'C':
+				return name, FamilyText, 0
+			case 'e':
+				return name, FamilyStatus, 0
+			}
+			return name, FamilySettings, 0
+		}
+		return name, FamilyUnknown, 0
+	}
+	return commandChar(raw[0]), FamilyUnknown, 0
+}
+
+// graphicsFamily classifies GS ( L / GS 8 L by function code. by and y are
+// the vertical scale and height of raster data stored for printing.
+func graphicsFamily(name string, fn, by byte, y int) (string, string, int) {
+	name = fmt.Sprintf("%s fn %d", name, fn)
+	switch {
+	case fn == 112 || fn == 113:
+		return name, FamilyImage, y * max(int(by), 1)
+	case fn == 2 || fn == 49 || fn == 50 || fn == 69 || fn == 85:
+		// Printing stored graphics does not change them.
+		return name, FamilyImage, 0
+	case fn == 48 || fn == 51 || fn == 52 || fn == 64 || fn == 80:
+		return name, FamilyStatus, 0
+	case fn >= 65 && fn <= 68, fn >= 81 && fn <= 84:
+		return name, FamilyNV, 0
+	}
+	return name, FamilyUnknown, 0
+}
+
+// RawPolicy limits what a raw ESC/POS payload may contain.
+type RawPolicy struct {
+	// Mode is "denylist" (Families are refused) or "allowlist" (only
+	// Families are accepted).
+	Mode     string
+	Families []string
+	// MaxBytes and MaxRasterHeight (total dots of raster printed) are
+	// ignored when zero.
+	MaxBytes        int
+	MaxRasterHeight int
+	// Action is "reject" (refuse the whole payload) or "strip" (drop the
+	// offending commands and print the rest).
+	Action string
+}
+
+// Validate checks the policy's mode, action and family names.
+func (p RawPolicy) Validate() error {
+	if p.Mode != "allowlist" && p.Mode != "denylist" {
+		return fmt.Errorf("raw policy mode must be allowlist or denylist, not %q", p.Mode)
+	}
+	if p.Action != "reject" && p.Action != "strip" {
+		return fmt.Errorf("raw policy action must be reject or strip, not %q", p.Action)
+	}
+	for _, f := range p.Families {
+		if !slices.Contains(CommandFamilies, f) {
+			return fmt.Errorf("unknown command family %q (want %s)", f, strings.Join(CommandFamilies, ", "))
+		}
+	}
+	return nil
+}
+
+// RawViolation is a part of a payload a policy refused.
+type RawViolation struct {
+	Offset  int    `json:"offset"`
+	Length  int    `json:"length"`
+	Command string `json:"command,omitempty"`
+	Family  string `json:"family,omitempty"`
+	Reason  string `json:"reason"`
+}
+
+// RawFilterResult is the outcome of applying a policy to a payload.
+type RawFilterResult struct {
+	// Data is the payload to print: unchanged, stripped, or nil when
+	// Rejected.
+	Data       []byte
+	Violations []RawViolation
+	Rejected   bool
+}
+
+// Apply checks data, written for a printer speaking lang, against the
+// policy. Oversized payloads are always rejected. Only ESC/POS is parsed:
+// payloads in other languages, whose bytes mean something else, are
+// passed through untouched. In ESC/POS, payloads with ambiguous commands,
+// whose spans cannot be trusted, are always rejected; other violations
+// are rejected or stripped per Action.
+func (p RawPolicy) Apply(lang Language, data []byte) RawFilterResult {
+	if p.MaxBytes > 0 && len(data) > p.MaxBytes {
+		return RawFilterResult{Rejected: true, Violations: []RawViolation{{
+			Offset: p.MaxBytes,
+			Length: len(data) - p.MaxBytes,
+			Reason: fmt.Sprintf("payload is %d bytes; the limit is %d", len(data), p.MaxBytes),
+		}}}
+	}
+	if lang != LanguageESCPOS {
+		return RawFilterResult{Data: data}
+	}
+
+	commands := ScanESCPOS(data)
+	var ambiguous []RawViolation
+	for _, c := range commands {
+		if c.Ambiguous {
+			ambiguous = append(ambiguous, RawViolation{Offset: c.Offset, Length: c.Length, Command: c.Name, Family: c.Family,
+				Reason: "command is unknown or cut off, so what the printer does with the bytes after it cannot be checked"})
+		}
+	}
+	if len(ambiguous) > 0 {
+		return RawFilterResult{Rejected: true, Violations: ambiguous}
+	}
+
+	var violations []RawViolation
+	raster := 0
+	for _, c := range commands {
+		listed := slices.Contains(p.Families, c.Family)
+		reason := ""
+		switch {
+		case p.Mode == "allowlist" && !listed:
+			reason = c.Family + " commands are not allowed"
+		case p.Mode == "denylist" && listed:
+			reason = c.Family + " commands are denied"
+		case p.MaxRasterHeight > 0 && c.RasterHeight > 0 && raster+c.RasterHeight > p.MaxRasterHeight:
+			reason = fmt.Sprintf("raster height would reach %d dots; the limit is %d", raster+c.RasterHeight, p.MaxRasterHeight)
+		default:
+			raster += c.RasterHeight
+			continue
+		}
+		violations = append(violations, RawViolation{Offset: c.Offset, Length: c.Length, Command: c.Name, Family: c.Family, Reason: reason})
+	}
+
+	if len(violations) == 0 {
+		return RawFilterResult{Data: data}
+	}
+	if p.Action != "strip" {
+		return RawFilterResult{Rejected: true, Violations: violations}
+	}
+	out := make([]byte, 0, len(data))
+	pos := 0
+	for _, v := range violations {
+		out = append(out, data[pos:v.Offset]...)
+		pos = v.Offset + v.Length
+	}
+	out = append(out, data[pos:]...)
+	return RawFilterResult{Data: out, Violations: violations}
+}
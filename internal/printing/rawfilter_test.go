@@ -0,0 +1,135 @@
+package printing
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+)
+
+func TestScanESCPOSFamilies(t *testing.T) {
+	var data []byte
+	data = append(data, 0x1b, '@')
+	data = append(data, "hi\n"...)
+	data = append(data, 0x1d, 'v', '0', 0, 1, 0, 2, 0, 0xff, 0xff) // 2-row raster
+	data = append(data, 0x1c, 'q', 1, 1, 0, 1, 0)                  // FS q: define one 8x8 NV image
+	data = append(data, make([]byte, 8)...)
+	data = append(data, 0x1d, '(', 'E', 3, 0, 1, 0x49, 0x4e)          // enter user setup mode
+	data = append(data, 0x1d, '(', 'L', 6, 0, 48, 69, 'L', 'G', 1, 1) // print NV graphics "LG"
+	data = append(data, 0x1d, 'V', 0)
+
+	want := []struct {
+		name, family string
+		height       int
+	}{
+		{"ESC @", FamilyInit, 0},
+		{"GS v", FamilyImage, 2},
+		{"FS q", FamilyNV, 0},
+		{"GS ( E", FamilySettings, 0},
+		{"GS ( L fn 69", FamilyImage, 0},
+		{"GS V", FamilyCut, 0},
+	}
+	got := ScanESCPOS(data)
+	if len(got) != len(want) {
+		t.Fatalf("got %d commands, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if got[i].Name != w.name || got[i].Family != w.family || got[i].RasterHeight != w.height {
+			t.Errorf("command %d = %+v, want %s/%s/%d", i, got[i], w.name, w.family, w.height)
+		}
+	}
+}
+
+func TestRawPolicyApply(t *testing.T) {
+	data := []byte("ok\n")
+	data = append(data, 0x1c, 'q', 0) // FS q with no images: offset 3, length 3
+	data = append(data, "done\n"...)
+
+	deny := RawPolicy{Mode: "denylist", Families: []string{FamilyNV}, Action: "reject"}
+	res := deny.Apply(LanguageESCPOS, data)
+	if !res.Rejected || len(res.Violations) != 1 || res.Violations[0].Offset != 3 || res.Violations[0].Length != 3 {
+		t.Fatalf("reject result = %+v", res)
+	}
+
+	deny.Action = "strip"
+	res = deny.Apply(LanguageESCPOS, data)
+	if res.Rejected || !bytes.Equal(res.Data, []byte("ok\ndone\n")) {
+		t.Fatalf("strip result = %+v", res)
+	}
+
+	allow := RawPolicy{Mode: "allowlist", Families: []string{FamilyText}, Action: "reject"}
+	if res := allow.Apply(LanguageESCPOS, []byte("plain\n")); res.Rejected {
+		t.Fatalf("plain text should pass an allowlist: %+v", res)
+	}
+
+	raster := []byte{0x1d, 'v', '0', 0, 1, 0, 3, 0, 1, 2, 3}
+	limited := RawPolicy{Mode: "denylist", MaxRasterHeight: 2, Action: "reject"}
+	if res := limited.Apply(LanguageESCPOS, raster); !res.Rejected {
+		t.Fatalf("raster over the height limit should be rejected")
+	}
+	sized := RawPolicy{Mode: "denylist", MaxBytes: 4, Action: "strip"}
+	if res := sized.Apply(LanguageESCPOS, raster); !res.Rejected {
+		t.Fatalf("oversized payload should be rejected even when stripping")
+	}
+
+	// Other languages are not parsed, so ESC bytes in them are not
+	// commands; only the size limit applies.
+	tspl := []byte("BITMAP 0,0,1,1,0,\x1b\x1c\nPRINT 1\r\n")
+	if res := deny.Apply(LanguageTSPL, tspl); res.Rejected || !bytes.Equal(res.Data, tspl) {
+		t.Fatalf("TSPL payload should pass through: %+v", res)
+	}
+	if res := sized.Apply(LanguageCat, raster); !res.Rejected {
+		t.Fatalf("oversized cat payload should be rejected")
+	}
+}
+
+func TestScanESCPOSTabStops(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want []string
+	}{
+		{"nul ends the list", []byte{0x1b, 'D', 8, 16, 0, 'a', 0x1b, '@'}, []string{"ESC D", "ESC @"}},
+		{"decreasing column ends the list", []byte{0x1b, 'D', 80, 0x1d, '(', 'C', 5, 0, 0, 0, ' ', ' ', 'x'}, []string{"ESC D", "GS ( C"}},
+		{"at most 32 columns", append(append([]byte{0x1b, 'D'}, seq(1, 32)...), 0x1b, '@'), []string{"ESC D", "ESC @"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, c := range ScanESCPOS(tt.data) {
+				got = append(got, c.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("commands = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func seq(from, to int) []byte {
+	var b []byte
+	for i := from; i <= to; i++ {
+		b = append(b, byte(i))
+	}
+	return b
+}
+
+func TestRawPolicyRejectsAmbiguous(t *testing.T) {
+	deny := RawPolicy{Mode: "denylist", Families: []string{FamilyNV, FamilySettings, FamilyRealtime, FamilyVendor, FamilyUnknown}, Action: "reject"}
+	allowAll := RawPolicy{Mode: "denylist", Action: "strip"}
+	tests := []struct {
+		name   string
+		policy RawPolicy
+		data   []byte
+	}{
+		{"tab stops hiding an NV write", deny, []byte{0x1b, 0x44, 0x50, 0x1d, 0x28, 0x43, 0x05, 0x00, 0x00, 0x00, 0x20, 0x20, 'x'}},
+		{"truncated command", allowAll, []byte{'a', 0x1d, 'v', '0', 0, 10, 0}},
+		{"unknown command", allowAll, []byte{0x1b, 0x01, 'a'}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if res := tt.policy.Apply(LanguageESCPOS, tt.data); !res.Rejected || len(res.Violations) == 0 {
+				t.Fatalf("result = %+v, want rejected", res)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package printing
+
+import "fmt"
+
+const rs = 0x1e
+
+// starBarcodeTypes maps canonical symbologies to the ESC b n1 barcode type.
+var starBarcodeTypes = map[string]byte{
+	"UPC-E": '0', "UPC-A": '1', "EAN8": '2', "EAN13": '3', "CODE39": '4',
+	"ITF": '5', "CODE128": '6', "CODE93": '7', "CODABAR": '8',
+}
+
+// starCodePages maps code page names to their ESC GS t n numbers, which
+// differ from Epson's.
+var starCodePages = map[string]byte{
+	"cp437": 1, "cp858": 4, "cp852": 5, "cp860": 6, "cp863": 8, "cp865": 9,
+	"cp866": 10, "cp1252": 32, "cp1251": 34,
+}
+
+// StarDriver encodes for Star Micronics printers. With PRNT unset it
+// targets Star Line Mode (SM, TSP100/650, mC-Print in line mode) and
+// prints images through raster graphics mode; with PRNT set it targets
+// StarPRNT, which prints images with ESC GS S.
+type StarDriver struct {
+	PRNT bool
+}
+
+func (d StarDriver) Language() Language {
+	if d.PRNT {
+		return LanguageStarPRNT
+	}
+	return LanguageStarLine
+}
+
+func (StarDriver) Init() []byte { return []byte{esc, '@'} }
+
+func (StarDriver) Style(from, to TextStyle) []byte {
+	var b []byte
+	if from.Font != to.Font {
+		b = append(b, esc, rs, 'F', byte(to.Font)) // ESC RS F n
+	}
+	if from.Bold != to.Bold {
+		if to.Bold {
+			b = append(b, esc, 'E')
+		} else {
+			b = append(b, esc, 'F')
+		}
+	}
+	if from.Underline != to.Underline {
+		b = append(b, esc, '-', boolByte(to.Underline > 0))
+	}
+	if from.Invert != to.Invert {
+		if to.Invert {
+			b = append(b, esc, '4')
+		} else {
+			b = append(b, esc, '5')
+		}
+	}
+	if from.Width != to.Width || from.Height != to.Height {
+		// ESC i n1 n2: height then width expansion, 0-based.
+		b = append(b, esc, 'i', byte(clampInt(to.Height, 1, 6)-1), byte(clampInt(to.Width, 1, 6)-1))
+	}
+	if from.UpsideDown != to.UpsideDown {
+		if to.UpsideDown {
+			b = append(b, 0x0f) // SI
+		} else {
+			b = append(b, 0x12) // DC2
+		}
+	}
+	return b
+}
+
+// Align returns ESC GS a n.
+func (StarDriver) Align(a Align) []byte { return []byte{esc, gs, 'a', byte(a)} }
+
+// CodePage returns ESC GS t n.
+func (StarDriver) CodePage(cp CodePage) ([]byte, error) {
+	n, ok := starCodePages[cp.Name]
+	if !ok {
+		return nil, fmt.Errorf("code page %s is not available on Star printers", cp.Name)
+	}
+	return []byte{esc, gs, 't', n}, nil
+}
+
+// Feed returns ESC a n.
+func (StarDriver) Feed(lines int) []byte { return []byte{esc, 'a', byte(clampInt(lines, 0, 127))} }
+
+// Cut returns ESC d n: 0 full and 1 partial at the current position, 3
+// partial after feeding to the cutter.
+func (StarDriver) Cut(mode CutMode) []byte {
+	switch mode {
+	case CutNone:
+		return nil
+	case CutPartial:
+		return []byte{esc, 'd', 1}
+	case CutFeedAndCut:
+		return []byte{esc, 'd', 3}
+	default:
+		return []byte{esc, 'd', 0}
+	}
+}
+
+// Drawer sets the pulse width with ESC BEL n1 n2 (units of 10 ms), then
+// fires drawer 1 (BEL, pin 2) or drawer 2 (SUB, pin 5).
+func (StarDriver) Drawer(k DrawerKick) []byte {
+	fire := byte(0x07)
+	if k.Pin == 5 {
+		fire = 0x1a
+	}
+	return []byte{esc, 0x07, byte(clampInt(k.OnMS/10, 1, 127)), byte(clampInt(k.OffMS/10, 1, 127)), fire}
+}
+
+func (d StarDriver) Raster(img *Bitmap) []byte {
+	if d.PRNT {
+		// ESC GS S m xL xH yL yH n: m=1 single tone, n=0 monochrome.
+		b := []byte{esc, gs, 'S', 1,
+			byte(img.Stride), byte(img.Stride >> 8),
+			byte(img.Height), byte(img.Height >> 8), 0}
+		return append(b, img.Pix...)
+	}
+	// Raster graphics mode: ESC * r A, one "b n1 n2 data" per row, ESC * r B.
+	b := []byte{esc, '*', 'r', 'A'}
+	for y := 0; y < img.Height; y++ {
+		row := img.Row(y)
+		b = append(b, 'b', byte(len(row)), byte(len(row)>>8))
+		b = append(b, row...)
+	}
+	return append(b, esc, '*', 'r', 'B')
+}
+
+// Barcode returns ESC b n1 n2 n3 n4 data RS.
+func (StarDriver) Barcode(bc Barcode) ([]byte, error) {
+	bc, err := normalizeBarcode(bc)
+	if err != nil {
+		return nil, err
+	}
+	hri := byte('1')
+	if bc.HRI {
+		hri = '2'
+	}
+	// n3 selects the narrow bar width in dots for most symbologies.
+	mode := byte('0' + clampInt(bc.ModuleWidth, 1, 3))
+	b := []byte{esc, 'b', starBarcodeTypes[bc.Symbology], hri, mode, byte(clampInt(bc.Height, 1, 255))}
+	b = append(b, bc.Data...)
+	return append(b, rs), nil
+}
+
+// QRCode stores the symbol with ESC GS y D 1 and prints it with ESC GS y P.
+func (StarDriver) QRCode(q QRCode) ([]byte, error) {
+	q, err := normalizeQRCode(q)
+	if err != nil {
+		return nil, err
+	}
+	if len(q.Data) > 0xFFFF {
+		return nil, fmt.Errorf("qrcode data is too long")
+	}
+	ecc := map[string]byte{"L": 0, "M": 1, "Q": 2, "H": 3}[q.ECC]
+	b := []byte{
+		esc, gs, 'y', 'S', '0', 2, // model 2
+		esc, gs, 'y', 'S', '1', ecc,
+		esc, gs, 'y', 'S', '2', byte(clampInt(q.ModuleSize, 1, 8)),
+		esc, gs, 'y', 'D', '1', 0, byte(len(q.Data)), byte(len(q.Data) >> 8),
+	}
+	b = append(b, q.Data...)
+	return append(b, esc, gs, 'y', 'P'), nil
+}
@@ -0,0 +1,256 @@
+package printing
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// TemplateFuncs returns the helper functions available to receipt
+// templates. The styling helpers emit commands for driver d; when d is nil
+// they return their text unchanged, which gives a plain-text preview of the
+// same template. logo prints stored logos; it may be nil.
+//
+// Helpers:
+//
+//	money v [symbol]          12.5 -> "12.50", with an optional currency prefix
+//	date layout v             format an RFC 3339 string, unix seconds or time.Time
+//	now                       current local time
+//	left/right/center n s     pad s to n columns (0 = full line)
+//	wrap s                    word-wrap s to the line width
+//	row spec cells...         multi-column row, e.g. row "*,8r" .name .price
+//	divider [ch]              full-width rule (default "-")
+//	width                     characters per line
+//	bold/underline/invert s   styled text
+//	double/wide/tall s        enlarged text
+//	align a                   switch alignment: "left", "center", "right"
+//	feed n                    feed n lines
+//	logo key                  print a logo stored in the printer
+//
+// Padding and rows count the columns text takes on paper, leaving out the
+// commands of the styling helpers, so styled text can be aligned.
+func TemplateFuncs(l Layout, d Driver, logo LogoFunc) template.FuncMap {
+	styled := func(set func(*TextStyle)) TextStyle {
+		on := PlainStyle
+		set(&on)
+		return on
+	}
+	bold := styled(func(s *TextStyle) { s.Bold = true })
+	underline := styled(func(s *TextStyle) { s.Underline = 1 })
+	invert := styled(func(s *TextStyle) { s.Invert = true })
+	double := styled(func(s *TextStyle) { s.Width, s.Height = 2, 2 })
+	wide := styled(func(s *TextStyle) { s.Width = 2 })
+	tall := styled(func(s *TextStyle) { s.Height = 2 })
+	var codes styleCodes
+	if d != nil {
+		codes = newStyleCodes(d, bold, underline, invert, double, wide, tall)
+	}
+	style := func(on TextStyle) func(any) string {
+		return func(v any) string {
+			s := toString(v)
+			if d == nil {
+				return s
+			}
+			return string(d.Style(PlainStyle, on)) + s + string(d.Style(on, PlainStyle))
+		}
+	}
+	padded := func(align Align) func(int, any) string {
+		return func(n int, v any) string {
+			s := toString(v)
+			return padVisible(s, textWidth(codes.strip(s)), widthOr(n, l), align)
+		}
+	}
+	return template.FuncMap{
+		"money":  money,
+		"date":   formatDate,
+		"now":    time.Now,
+		"left":   padded(AlignLeft),
+		"right":  padded(AlignRight),
+		"center": padded(AlignCenter),
+		"wrap": func(v any) string {
+			return strings.Join(l.Wrap(toString(v)), "\n")
+		},
+		"row": func(spec string, cells ...any) (string, error) {
+			table, err := ParseTableSpec(spec)
+			if err != nil {
+				return "", err
+			}
+			strs := make([]string, len(cells))
+			for i, c := range cells {
+				strs[i] = toString(c)
+			}
+			return strings.Join(l.row(table, codes, strs), "\n"), nil
+		},
+		"divider": func(ch ...string) string {
+			r := '-'
+			if len(ch) > 0 && ch[0] != "" {
+				r = []rune(ch[0])[0]
+			}
+			return l.Divider(r)
+		},
+		"width":     func() int { return l.Width },
+		"bold":      style(bold),
+		"underline": style(underline),
+		"invert":    style(invert),
+		"double":    style(double),
+		"wide":      style(wide),
+		"tall":      style(tall),
+		"align": func(a string) string {
+			if d == nil {
+				return ""
+			}
+			return string(d.Align(ParseAlign(a)))
+		},
+		"feed": func(n int) string {
+			if d == nil {
+				return strings.Repeat("\n", clampInt(n, 0, 255))
+			}
+			return string(d.Feed(n))
+		},
+		"logo": func(key string) (string, error) {
+			if d == nil {
+				return "", nil
+			}
+			if logo == nil {
+				return "", fmt.Errorf("logos are not available")
+			}
+			data, err := logo(key)
+			return string(data), err
+		},
+	}
+}
+
+// ParseTableSpec parses a compact column spec such as "*,8r" or "4r,2*,10c".
+// Each comma-separated entry is either a fixed width ("8") or a flexible
+// weight ("*", "2*"), optionally followed by l, c or r for alignment. An
+// optional "|n" suffix on the whole spec sets the gap between columns
+// (default 1).
+func ParseTableSpec(spec string) (Table, error) {
+	table := Table{Gap: 1}
+	if i := strings.LastIndex(spec, "|"); i >= 0 {
+		gap, err := strconv.Atoi(strings.TrimSpace(spec[i+1:]))
+		if err != nil || gap < 0 {
+			return Table{}, fmt.Errorf("invalid column gap in %q", spec)
+		}
+		table.Gap = gap
+		spec = spec[:i]
+	}
+	for _, raw := range strings.Split(spec, ",") {
+		part := strings.ToLower(strings.TrimSpace(raw))
+		if part == "" {
+			return Table{}, fmt.Errorf("empty column in %q", spec)
+		}
+		var col Column
+		switch part[len(part)-1] {
+		case 'l':
+			part = part[:len(part)-1]
+		case 'c':
+			col.Align = AlignCenter
+			part = part[:len(part)-1]
+		case 'r':
+			col.Align = AlignRight
+			part = part[:len(part)-1]
+		}
+		if strings.HasSuffix(part, "*") {
+			col.Flex = 1
+			if weight := strings.TrimSuffix(part, "*"); weight != "" {
+				n, err := strconv.Atoi(weight)
+				if err != nil || n <= 0 {
+					return Table{}, fmt.Errorf("invalid column %q", raw)
+				}
+				col.Flex = n
+			}
+		} else {
+			n, err := strconv.Atoi(part)
+			if err != nil || n <= 0 {
+				return Table{}, fmt.Errorf("invalid column %q", raw)
+			}
+			col.Width = n
+		}
+		table.Columns = append(table.Columns, col)
+	}
+	return table, nil
+}
+
+func widthOr(n int, l Layout) int {
+	if n <= 0 {
+		return l.Width
+	}
+	return n
+}
+
+func toString(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case fmt.Stringer:
+		return x.String()
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+func toFloat(v any) (float64, error) {
+	switch x := v.(type) {
+	case float64:
+		return x, nil
+	case float32:
+		return float64(x), nil
+	case int:
+		return float64(x), nil
+	case int64:
+		return float64(x), nil
+	case json.Number:
+		return x.Float64()
+	case string:
+		return strconv.ParseFloat(strings.TrimSpace(x), 64)
+	case nil:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("not a number: %v", v)
+	}
+}
+
+func money(v any, symbol ...string) (string, error) {
+	f, err := toFloat(v)
+	if err != nil {
+		return "", err
+	}
+	s := strconv.FormatFloat(math.Abs(f), 'f', 2, 64)
+	if len(symbol) > 0 {
+		s = symbol[0] + s
+	}
+	if f < 0 && s != "0.00" {
+		s = "-" + s
+	}
+	return s, nil
+}
+
+func formatDate(layout string, v any) (string, error) {
+	var t time.Time
+	switch x := v.(type) {
+	case time.Time:
+		t = x
+	case string:
+		parsed, err := time.Parse(time.RFC3339, x)
+		if err != nil {
+			return "", err
+		}
+		t = parsed
+	default:
+		f, err := toFloat(v)
+		if err != nil {
+			return "", err
+		}
+		t = time.Unix(int64(f), 0)
+	}
+	return t.Format(layout), nil
+}
@@ -0,0 +1,316 @@
+package printing
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/text/unicode/bidi"
+)
+
+// DefaultRasterFontSize is the raster text size in pixels per em; it
+// matches the 24-dot line of font A.
+const DefaultRasterFontSize = 24
+
+// FontSet rasterizes text with a chain of TrueType/OpenType faces. Each
+// rune is drawn with the first face that has a glyph for it; the bundled
+// Go Regular font (Latin, Greek, Cyrillic) is always last. A FontSet is
+// safe for concurrent use.
+type FontSet struct {
+	mu    sync.Mutex
+	fonts []*opentype.Font
+	faces []font.Face
+}
+
+// LoadFontSet loads the TTF, OTF or TTC files at paths, in order of
+// preference, at the given size in pixels per em.
+func LoadFontSet(size float64, paths ...string) (*FontSet, error) {
+	var fonts []*opentype.Font
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("font %s: %w", path, err)
+		}
+		parsed, err := parseFonts(data)
+		if err != nil {
+			return nil, fmt.Errorf("font %s: %w", path, err)
+		}
+		fonts = append(fonts, parsed...)
+	}
+	bundled, err := opentype.Parse(goregular.TTF)
+	if err != nil {
+		return nil, err
+	}
+	return newFontSet(append(fonts, bundled), size)
+}
+
+// Sized returns a set with the same fonts at another size.
+func (fs *FontSet) Sized(size float64) (*FontSet, error) {
+	return newFontSet(fs.fonts, size)
+}
+
+func newFontSet(fonts []*opentype.Font, size float64) (*FontSet, error) {
+	if size <= 0 {
+		size = DefaultRasterFontSize
+	}
+	opts := &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull}
+	fs := &FontSet{fonts: fonts}
+	for _, f := range fonts {
+		face, err := opentype.NewFace(f, opts)
+		if err != nil {
+			return nil, err
+		}
+		fs.faces = append(fs.faces, face)
+	}
+	return fs, nil
+}
+
+// parseFonts parses a single font or every font of a collection.
+func parseFonts(data []byte) ([]*opentype.Font, error) {
+	if len(data) >= 4 && string(data[:4]) == "ttcf" {
+		c, err := opentype.ParseCollection(data)
+		if err != nil {
+			return nil, err
+		}
+		fonts := make([]*opentype.Font, 0, c.NumFonts())
+		for i := 0; i < c.NumFonts(); i++ {
+			f, err := c.Font(i)
+			if err != nil {
+				return nil, err
+			}
+			fonts = append(fonts, f)
+		}
+		return fonts, nil
+	}
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return []*opentype.Font{f}, nil
+}
+
+// faceFor returns the first face with a glyph for r, falling back to the
+// primary face (which draws its .notdef box).
+func (fs *FontSet) faceFor(r rune) font.Face {
+	for _, f := range fs.faces {
+		if _, ok := f.GlyphAdvance(r); ok {
+			return f
+		}
+	}
+	return fs.faces[0]
+}
+
+// ErrNoGlyph is returned for text that no font of a set can draw. The
+// bundled font covers Latin, Greek and Cyrillic only; Chinese, Japanese,
+// Korean, Thai, Arabic, Hebrew and other scripts need a font that has them.
+var ErrNoGlyph = errors.New("no raster font has a glyph for")
+
+// Covers reports whether some face of the set has a glyph for every
+// printable rune of s.
+func (fs *FontSet) Covers(s string) bool {
+	return fs.Check(s) == nil
+}
+
+// Check returns an ErrNoGlyph error naming the first printable rune of s
+// that no face of the set has a glyph for.
+func (fs *FontSet) Check(s string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for _, r := range s {
+		if unicode.IsSpace(r) || !unicode.IsGraphic(r) {
+			continue
+		}
+		found := false
+		for _, f := range fs.faces {
+			if _, ok := f.GlyphAdvance(r); ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w %q (U+%04X); add a font that covers it", ErrNoGlyph, r, r)
+		}
+	}
+	return nil
+}
+
+// WrapLine breaks text into lines that fit width dots when drawn: at
+// spaces where it can, and between characters where a word is wider than
+// the line. Widths are the glyphs' advances, so wide scripts such as
+// Chinese get fewer characters a line than Latin text. A width of 0 keeps
+// the text on one line.
+func (fs *FontSet) WrapLine(text string, width int) []string {
+	if width <= 0 {
+		return []string{text}
+	}
+	fs.mu.Lock()
+	runes := []rune(text)
+	adv := make([]fixed.Int26_6, len(runes))
+	for i, r := range runes {
+		adv[i], _ = fs.faceFor(r).GlyphAdvance(r)
+	}
+	fs.mu.Unlock()
+
+	limit := fixed.I(width)
+	var lines []string
+	start, space := 0, -1
+	var cur fixed.Int26_6
+	for i, r := range runes {
+		if cur+adv[i] > limit && i > start && r != ' ' {
+			end := i
+			if space > start {
+				end = space
+			}
+			lines = append(lines, strings.TrimRight(string(runes[start:end]), " "))
+			start = end
+			for start < i && runes[start] == ' ' {
+				start++
+			}
+			cur = 0
+			for _, a := range adv[start:i] {
+				cur += a
+			}
+			space = -1
+		}
+		cur += adv[i]
+		if r == ' ' {
+			space = i
+		}
+	}
+	return append(lines, strings.TrimRight(string(runes[start:]), " "))
+}
+
+// RenderLine draws one line of text as a bitmap width dots wide, or as
+// wide as the text when width is 0. Arabic is shaped and right-to-left
+// runs are put in visual order; right-to-left paragraphs are right-aligned,
+// others use align. Text wider than the line is clipped; WrapLine breaks it
+// into lines that fit first.
+func (fs *FontSet) RenderLine(text string, width int, align Align) *Bitmap {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	visual, rtl := VisualOrder(ShapeArabic(text))
+	if rtl {
+		align = AlignRight
+	}
+
+	var ascent, descent fixed.Int26_6
+	var advance fixed.Int26_6
+	for _, r := range visual {
+		f := fs.faceFor(r)
+		m := f.Metrics()
+		ascent = max(ascent, m.Ascent)
+		descent = max(descent, m.Descent)
+		a, _ := f.GlyphAdvance(r)
+		advance += a
+	}
+	if ascent == 0 {
+		m := fs.faces[0].Metrics()
+		ascent, descent = m.Ascent, m.Descent
+	}
+
+	if width <= 0 {
+		width = max(advance.Ceil(), 1)
+	}
+	height := (ascent + descent).Ceil()
+	canvas := image.NewGray(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), image.White, image.Point{}, draw.Src)
+	dot := fixed.Point26_6{X: fixed.I(alignOffset(advance.Ceil(), width, align)), Y: ascent}
+	for _, r := range visual {
+		f := fs.faceFor(r)
+		d := font.Drawer{Dst: canvas, Src: image.Black, Face: f, Dot: dot}
+		d.DrawString(string(r))
+		dot = d.Dot
+	}
+	return BitmapFromImage(canvas, 0, false)
+}
+
+// VisualOrder reorders a line from logical to display order using the
+// Unicode bidirectional algorithm. Only the levels that occur in plain
+// text are handled: right-to-left runs, and numbers embedded in them. It
+// reports whether the paragraph is right-to-left.
+func VisualOrder(s string) (string, bool) {
+	var p bidi.Paragraph
+	if _, err := p.SetString(s); err != nil {
+		return s, false
+	}
+	o, err := p.Order()
+	if err != nil || o.NumRuns() == 0 {
+		return s, false
+	}
+	type run struct {
+		text string
+		rtl  bool
+	}
+	runs := make([]run, o.NumRuns())
+	hasRTL := false
+	for i := range runs {
+		r := o.Run(i)
+		runs[i] = run{text: r.String(), rtl: r.Direction() == bidi.RightToLeft}
+		hasRTL = hasRTL || runs[i].rtl
+	}
+	if !hasRTL {
+		return s, false
+	}
+
+	// reverse puts runs[from:to] (one right-to-left embedding) in display
+	// order: run order is reversed and so are the characters of the
+	// right-to-left runs.
+	var out strings.Builder
+	reverse := func(from, to int) {
+		for i := to - 1; i >= from; i-- {
+			if runs[i].rtl {
+				out.WriteString(bidi.ReverseString(runs[i].text))
+			} else {
+				out.WriteString(runs[i].text)
+			}
+		}
+	}
+	if !p.IsLeftToRight() {
+		reverse(0, len(runs))
+		return out.String(), true
+	}
+	// In a left-to-right paragraph, numbers that follow right-to-left text
+	// belong to its embedding.
+	for i := 0; i < len(runs); {
+		if !runs[i].rtl {
+			out.WriteString(runs[i].text)
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(runs) && (runs[j].rtl || startsWithDigit(runs[j].text) && runs[j-1].rtl) {
+			j++
+		}
+		reverse(i, j)
+		i = j
+	}
+	return out.String(), false
+}
+
+func startsWithDigit(s string) bool {
+	for _, r := range s {
+		return unicode.IsDigit(r)
+	}
+	return false
+}
+
+// HasRTL reports whether s contains right-to-left characters, which
+// printers cannot order themselves.
+func HasRTL(s string) bool {
+	for _, r := range s {
+		if unicode.In(r, unicode.Hebrew, unicode.Arabic, unicode.Syriac, unicode.Thaana, unicode.Nko) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+package printing
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestShapeArabic(t *testing.T) {
+	tests := []struct{ in, want string }{
+		// meem initial, reh final, hah initial, beh medial, alef final
+		{"مرحبا", "ﻣﺮﺣﺒﺎ"},
+		// alef, lam-alef ligature (isolated), then lam-alef after a joining letter
+		{"الا", "ﺍﻻ"},
+		{"سلا", "ﺳﻼ"},
+		{"abc", "abc"},
+	}
+	for _, tt := range tests {
+		if got := ShapeArabic(tt.in); got != tt.want {
+			t.Errorf("ShapeArabic(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestVisualOrder(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		rtl  bool
+	}{
+		{"abc 12", "abc 12", false},
+		{"שלום world", "world םולש", true},
+		{"hello שלום 123", "hello 123 םולש", false},
+		{"مرحبا 42", "42 ابحرم", true},
+	}
+	for _, tt := range tests {
+		got, rtl := VisualOrder(tt.in)
+		if got != tt.want || rtl != tt.rtl {
+			t.Errorf("VisualOrder(%q) = %q, %v; want %q, %v", tt.in, got, rtl, tt.want, tt.rtl)
+		}
+	}
+}
+
+func TestEncodeTextRastersUnencodableLines(t *testing.T) {
+	fonts, err := LoadFontSet(DefaultRasterFontSize)
+	if err != nil {
+		t.Fatalf("load fonts: %v", err)
+	}
+	cp, _ := LookupCodePage("cp858")
+	data, err := EncodeText(ESCPOSDriver{}, "Total 9,50 €\nПривет\nΚαλημέρα\n", TextOptions{CodePage: &cp, Fonts: fonts, WidthDots: 384})
+	if err != nil {
+		t.Fatalf("EncodeText: %v", err)
+	}
+
+	page := DecodeESCPOS(data)
+	kinds := []ElementKind{ElementText, ElementImage, ElementImage, ElementCut}
+	if len(page.Elements) != len(kinds) {
+		t.Fatalf("got %d elements, want %d: %+v", len(page.Elements), len(kinds), page.Elements)
+	}
+	for i, k := range kinds {
+		if page.Elements[i].Kind != k {
+			t.Fatalf("element %d kind = %s, want %s", i, page.Elements[i].Kind, k)
+		}
+	}
+	if got := page.Elements[0].Runs[0].Text; got != "Total 9,50 €" {
+		t.Fatalf("native line = %q", got)
+	}
+	if img := page.Elements[1].Image; img.Width != 384 {
+		t.Fatalf("raster line width = %d, want 384", img.Width)
+	}
+}
+
+func TestEncodeTextWrapsRasterLines(t *testing.T) {
+	fonts, err := LoadFontSet(DefaultRasterFontSize)
+	if err != nil {
+		t.Fatalf("load fonts: %v", err)
+	}
+	cp, _ := LookupCodePage("cp858")
+	// 48 characters fit 48 columns of font A, but not 384 dots at 24 px.
+	line := strings.Repeat("Жж ", 16)
+	data, err := EncodeText(ESCPOSDriver{}, line, TextOptions{CodePage: &cp, Fonts: fonts, WidthDots: 384})
+	if err != nil {
+		t.Fatalf("EncodeText: %v", err)
+	}
+	images := 0
+	for _, e := range DecodeESCPOS(data).Elements {
+		if e.Kind == ElementImage {
+			images++
+			if e.Image.Width != 384 {
+				t.Fatalf("raster line width = %d, want 384", e.Image.Width)
+			}
+		}
+	}
+	if images < 2 {
+		t.Fatalf("got %d raster lines, want the line wrapped", images)
+	}
+}
+
+func TestEncodeTextReportsMissingGlyphs(t *testing.T) {
+	fonts, err := LoadFontSet(DefaultRasterFontSize)
+	if err != nil {
+		t.Fatalf("load fonts: %v", err)
+	}
+	cp, _ := LookupCodePage("cp858")
+	for _, text := range []string{"你好", "สวัสดี", "مرحبا", "שלום"} {
+		_, err := EncodeText(ESCPOSDriver{}, text, TextOptions{CodePage: &cp, Fonts: fonts, WidthDots: 384})
+		if !errors.Is(err, ErrNoGlyph) {
+			t.Errorf("EncodeText(%q) = %v, want ErrNoGlyph", text, err)
+		}
+	}
+}
+
+func TestFontSetWrapLine(t *testing.T) {
+	fonts, err := LoadFontSet(DefaultRasterFontSize)
+	if err != nil {
+		t.Fatalf("load fonts: %v", err)
+	}
+	adv := func(s string) int {
+		w := 0
+		for _, r := range s {
+			a, _ := fonts.faces[0].GlyphAdvance(r)
+			w += a.Ceil()
+		}
+		return w
+	}
+	tests := []struct {
+		name  string
+		text  string
+		width int
+		want  []string
+	}{
+		{name: "fits", text: "one two", width: adv("one two") + 1, want: []string{"one two"}},
+		{name: "at spaces", text: "one two three", width: adv("one two") + 2, want: []string{"one two", "three"}},
+		{name: "long word", text: "abcdefgh", width: adv("abcd") + 1, want: []string{"abcd", "efgh"}},
+		{name: "no width", text: "one two three", width: 0, want: []string{"one two three"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fonts.WrapLine(tt.text, tt.width)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Fatalf("WrapLine(%q, %d) = %q, want %q", tt.text, tt.width, got, tt.want)
+			}
+		})
+	}
+}
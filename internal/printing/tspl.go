@@ -0,0 +1,109 @@
+package printing
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+var tsplSymbologies = map[string]string{
+	"CODE128": "128",
+	"CODE39":  "39",
+	"CODE93":  "93",
+	"EAN13":   "EAN13",
+	"EAN8":    "EAN8",
+	"UPC-A":   "UPCA",
+	"UPC-E":   "UPCE",
+	"ITF":     "25",
+	"CODABAR": "CODA",
+}
+
+// encodeTSPL encodes a validated label as TSPL/TSPL2 commands.
+func encodeTSPL(l *Label) ([]byte, error) {
+	var b bytes.Buffer
+	line := func(format string, args ...any) {
+		fmt.Fprintf(&b, format, args...)
+		b.WriteString("\r\n")
+	}
+
+	line("SIZE %s mm,%s mm", formatMM(l.WidthMM), formatMM(l.HeightMM))
+	switch l.Sensor {
+	case "mark":
+		line("BLINE %s mm,%s mm", formatMM(l.GapMM), formatMM(l.MarkOffsetMM))
+	case "continuous":
+		line("GAP 0,0")
+	default:
+		line("GAP %s mm,0 mm", formatMM(l.GapMM))
+	}
+	line("CLS")
+
+	for i, e := range l.Elements {
+		switch e.Type {
+		case LabelText:
+			font, mul := e.Font, 1
+			if font == "" {
+				font, mul = "3", textMultiplier(e.Size)
+			}
+			line("TEXT %d,%d,%s,%d,%d,%d,%s", e.X, e.Y, tsplQuote(font), e.Rotation, mul, mul, tsplQuote(e.Text))
+		case LabelBarcode:
+			sym, ok := tsplSymbologies[e.Symbology]
+			if !ok {
+				return nil, &UnsupportedError{Language: LanguageTSPL, Index: i, Type: e.Type, Reason: "symbology " + e.Symbology + " is not available"}
+			}
+			line("BARCODE %d,%d,%s,%d,%d,%d,%d,%d,%s", e.X, e.Y, tsplQuote(sym), e.Height, boolInt(e.HRI), e.Rotation, e.ModuleWidth, e.ModuleWidth*2, tsplQuote(e.Data))
+		case LabelQRCode:
+			line("QRCODE %d,%d,%s,%d,A,%d,%s", e.X, e.Y, e.ECC, e.ModuleWidth, e.Rotation, tsplQuote(e.Data))
+		case LabelBitmap:
+			img, err := e.decodeBitmap()
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			if e.Rotation != 0 {
+				img = img.Rotate(e.Rotation)
+			}
+			fmt.Fprintf(&b, "BITMAP %d,%d,%d,%d,0,", e.X, e.Y, img.Stride, img.Height)
+			// TSPL bitmaps use 0 for a printed dot.
+			for _, v := range img.Pix {
+				b.WriteByte(^v)
+			}
+			b.WriteString("\r\n")
+		case LabelBox:
+			if e.Rotation != 0 && e.Rotation != 180 {
+				e.Width, e.Height = e.Height, e.Width
+			}
+			line("BOX %d,%d,%d,%d,%d", e.X, e.Y, e.X+e.Width, e.Y+e.Height, e.Thickness)
+		case LabelLine:
+			w, h := e.Width, e.Height
+			if w <= 0 {
+				w = e.Thickness
+			}
+			if h <= 0 {
+				h = e.Thickness
+			}
+			if e.Rotation == 90 || e.Rotation == 270 {
+				w, h = h, w
+			}
+			line("BAR %d,%d,%d,%d", e.X, e.Y, w, h)
+		case LabelCircle:
+			line("CIRCLE %d,%d,%d,%d", e.X, e.Y, e.Width, e.Thickness)
+		}
+	}
+	line("PRINT 1,%d", l.Copies)
+	return b.Bytes(), nil
+}
+
+// tsplQuote wraps s in double quotes, escaping embedded quotes as \["].
+func tsplQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `\["]`) + `"`
+}
+
+func formatMM(v float64) string {
+	return strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.2f", v), "0"), ".")
+}
+
+func boolInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,593 @@
+package printing
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/boombuler/barcode/qr"
+)
+
+// ZPL II is interpreted, not forwarded: each ^XA..^XZ format is drawn as a
+// bitmap that any raster-capable printer can print. The supported subset:
+//
+//	^XA ^XZ              format start and end
+//	^FO ^FT ^LH          field origin (top-left, bottom-left) and label home
+//	^A ^CF               field font and default font (drawn with TrueType)
+//	^FD ^FV ^FS ^FR ^FX  field data, end, reverse print and comments
+//	^BY ^BC ^BQ          barcode defaults, Code 128 and QR code
+//	^GB ^GF              boxes and lines, graphic fields (hex, Z64, B64)
+//	^PQ ^LL ^PW          quantity, label length and print width
+//
+// Every other command is skipped and reported as a warning.
+
+// zplDefaultFontHeight is the text height in dots before any ^A or ^CF.
+const zplDefaultFontHeight = 18
+
+// zplMaxLength is the longest label ^LL accepts, in dots.
+const zplMaxLength = 32000
+
+// ZPLOptions controls how ZPL is rendered.
+type ZPLOptions struct {
+	// WidthDots is the label width when a format has no ^PW; HeightDots
+	// is the length when it has no ^LL, and 0 fits the content.
+	WidthDots  int
+	HeightDots int
+	// Fonts draws text; nil uses the bundled font.
+	Fonts *FontSet
+}
+
+// ZPLLabel is one rendered format.
+type ZPLLabel struct {
+	Bitmap *Bitmap
+	Copies int
+}
+
+// ZPLResult is the outcome of rendering a ZPL stream. Warnings list the
+// commands that were not interpreted, by byte offset.
+type ZPLResult struct {
+	Labels   []ZPLLabel
+	Warnings []DecodeWarning
+}
+
+// zplField is the field being assembled between ^FO and ^FS.
+type zplField struct {
+	x, y    int
+	bottom  bool // ^FT: y is the bottom of the field
+	kind    string
+	data    string
+	reverse bool
+
+	fontH, fontW int
+	orient       int
+
+	// ^BC, ^BQ
+	height    int
+	hri       bool
+	hriAbove  bool
+	magnify   int
+	graphic   *Bitmap // ^GB, ^GF
+	whiteDraw bool    // ^GB with colour W
+}
+
+type zplOp struct {
+	img     *Bitmap
+	x, y    int
+	reverse bool
+	white   bool
+}
+
+type zplRenderer struct {
+	opts  ZPLOptions
+	fonts map[int]*FontSet
+	res   *ZPLResult
+
+	inFormat     bool
+	homeX, homeY int
+	width        int
+	length       int
+	copies       int
+	fontH, fontW int
+	fontOrient   int
+	byModule     int
+	byHeight     int
+	field        zplField
+	ops          []zplOp
+}
+
+// RenderZPL interprets a ZPL II stream.
+func RenderZPL(src string, opts ZPLOptions) (*ZPLResult, error) {
+	r := &zplRenderer{
+		opts:     opts,
+		fonts:    map[int]*FontSet{},
+		res:      &ZPLResult{},
+		fontH:    zplDefaultFontHeight,
+		byModule: 2,
+		byHeight: 10,
+	}
+	r.resetField()
+	for i := 0; i < len(src); {
+		if src[i] != '^' && src[i] != '~' {
+			i++
+			continue
+		}
+		start := i
+		prefix := src[i]
+		i++
+		name := ""
+		switch {
+		case i < len(src) && (src[i] == 'A' || src[i] == 'a'):
+			// ^A takes the font name as its first parameter character.
+			name = "A"
+			i++
+		case i+2 <= len(src):
+			name = strings.ToUpper(src[i : i+2])
+			i += 2
+		default:
+			name = src[i:]
+			i = len(src)
+		}
+		end := i
+		for end < len(src) && src[end] != '^' && src[end] != '~' {
+			end++
+		}
+		params := src[i:end]
+		i = end
+		if err := r.command(start, string(prefix)+name, params); err != nil {
+			return nil, err
+		}
+	}
+	if r.inFormat {
+		r.warn(len(src), "^XZ", "format is not terminated; rendered anyway")
+		if err := r.finishFormat(); err != nil {
+			return nil, err
+		}
+	}
+	return r.res, nil
+}
+
+func (r *zplRenderer) warn(offset int, cmd, format string, args ...any) {
+	r.res.Warnings = append(r.res.Warnings, DecodeWarning{Offset: offset, Command: cmd, Message: fmt.Sprintf(format, args...)})
+}
+
+// maxWidth is the head width; ^PW cannot print wider.
+func (r *zplRenderer) maxWidth() int {
+	if r.opts.WidthDots > 0 {
+		return r.opts.WidthDots
+	}
+	return maxHeadDots
+}
+
+// labelWidth and labelLength bound the fields of the current format.
+func (r *zplRenderer) labelWidth() int {
+	if r.width > 0 {
+		return r.width
+	}
+	return r.maxWidth()
+}
+
+func (r *zplRenderer) labelLength() int {
+	if r.length > 0 {
+		return r.length
+	}
+	if r.opts.HeightDots > 0 {
+		return r.opts.HeightDots
+	}
+	return zplMaxLength
+}
+
+func (r *zplRenderer) resetField() {
+	r.field = zplField{fontH: r.fontH, fontW: r.fontW, orient: r.fontOrient}
+}
+
+// zplParams splits comma-separated parameters; missing ones are "".
+func zplParams(params string, n int) []string {
+	parts := strings.SplitN(strings.TrimSpace(params), ",", n)
+	for len(parts) < n {
+		parts = append(parts, "")
+	}
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
+
+// zplInt parses a numeric parameter, returning def when it is empty or
+// invalid.
+func zplInt(s string, def int) int {
+	if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+		return n
+	}
+	return def
+}
+
+// zplOrientation maps N, R, I and B to clockwise rotations.
+func zplOrientation(s string, def int) int {
+	if s == "" {
+		return def
+	}
+	switch s[0] {
+	case 'N', 'n':
+		return 0
+	case 'R', 'r':
+		return 90
+	case 'I', 'i':
+		return 180
+	case 'B', 'b':
+		return 270
+	}
+	return def
+}
+
+func zplYes(s string, def bool) bool {
+	if s == "" {
+		return def
+	}
+	return s[0] == 'Y' || s[0] == 'y'
+}
+
+func (r *zplRenderer) command(offset int, cmd, params string) error {
+	if !r.inFormat && cmd != "^XA" {
+		r.warn(offset, cmd, "command outside ^XA..^XZ ignored")
+		return nil
+	}
+	f := &r.field
+	switch cmd {
+	case "^XA":
+		r.inFormat = true
+		r.homeX, r.homeY = 0, 0
+		r.copies = 1
+		r.ops = nil
+		r.resetField()
+	case "^XZ":
+		return r.finishFormat()
+	case "^FO", "^FT":
+		p := zplParams(params, 3)
+		f.x = r.homeX + zplInt(p[0], 0)
+		f.y = r.homeY + zplInt(p[1], 0)
+		f.bottom = cmd == "^FT"
+	case "^LH":
+		p := zplParams(params, 2)
+		r.homeX, r.homeY = zplInt(p[0], 0), zplInt(p[1], 0)
+	case "^A":
+		// ^Afo,h,w: font name, orientation, height, width.
+		if len(params) > 0 && params[0] == '@' {
+			r.warn(offset, cmd, "fonts by name are drawn with the default font")
+			params = strings.TrimLeft(params[1:], ",")
+		} else if len(params) > 0 {
+			params = params[1:]
+		}
+		p := zplParams(params, 3)
+		f.orient = zplOrientation(p[0], f.orient)
+		f.fontH = zplInt(p[1], f.fontH)
+		f.fontW = zplInt(p[2], f.fontH)
+	case "^CF":
+		p := zplParams(params, 3)
+		r.fontH = zplInt(p[1], r.fontH)
+		r.fontW = zplInt(p[2], r.fontH)
+		f.fontH, f.fontW = r.fontH, r.fontW
+	case "^BY":
+		p := zplParams(params, 3)
+		r.byModule = clampInt(zplInt(p[0], r.byModule), 1, 10)
+		r.byHeight = clampInt(zplInt(p[2], r.byHeight), 1, zplMaxLength)
+	case "^BC":
+		p := zplParams(params, 6)
+		f.kind = "barcode"
+		f.orient = zplOrientation(p[0], f.orient)
+		f.height = clampInt(zplInt(p[1], r.byHeight), 1, zplMaxLength)
+		f.hri = zplYes(p[2], true)
+		f.hriAbove = zplYes(p[3], false)
+	case "^BQ":
+		p := zplParams(params, 5)
+		f.kind = "qrcode"
+		f.magnify = clampInt(zplInt(p[2], 2), 1, 10)
+	case "^GB":
+		p := zplParams(params, 5)
+		t := max(zplInt(p[2], 1), 1)
+		w := clampInt(max(zplInt(p[0], t), t), 1, r.labelWidth())
+		h := clampInt(max(zplInt(p[1], t), t), 1, r.labelLength())
+		t = min(t, w, h)
+		box := NewBitmap(w, h)
+		box.FillRect(0, 0, w, t, true)
+		box.FillRect(0, h-t, w, t, true)
+		box.FillRect(0, 0, t, h, true)
+		box.FillRect(w-t, 0, t, h, true)
+		f.kind = "graphic"
+		f.graphic = box
+		f.whiteDraw = p[3] == "W" || p[3] == "w"
+	case "^GF":
+		img, err := zplGraphicField(params, (r.maxWidth()+7)/8)
+		if err != nil {
+			r.warn(offset, cmd, "%v", err)
+			return nil
+		}
+		f.kind = "graphic"
+		f.graphic = img
+	case "^FD", "^FV":
+		f.data += strings.TrimRight(params, "\r\n")
+	case "^FR":
+		f.reverse = true
+	case "^FS":
+		err := r.finishField(offset)
+		r.resetField()
+		return err
+	case "^FX":
+		// Comment.
+	case "^PQ":
+		p := zplParams(params, 4)
+		r.copies = max(zplInt(p[0], 1), 1)
+	case "^LL":
+		r.length = clampInt(zplInt(zplParams(params, 1)[0], r.length), 0, zplMaxLength)
+	case "^PW":
+		r.width = clampInt(zplInt(zplParams(params, 1)[0], r.width), 0, r.maxWidth())
+	default:
+		r.warn(offset, cmd, "unsupported command ignored")
+	}
+	return nil
+}
+
+// finishField draws the field assembled since the last ^FS.
+func (r *zplRenderer) finishField(offset int) error {
+	f := r.field
+	var img *Bitmap
+	switch f.kind {
+	case "":
+		if f.data == "" {
+			return nil
+		}
+		text, err := r.text(f.data, f.fontH)
+		if err != nil {
+			return err
+		}
+		img = text.Rotate(f.orient)
+	case "barcode":
+		data := zplCode128Data(f.data)
+		bc, err := encodeBarcode("CODE128", data)
+		if err != nil {
+			r.warn(offset, "^BC", "%v", err)
+			return nil
+		}
+		bars := scaleBitmap(barcodeBitmap(bc, max(f.height, 1)), r.byModule, 1)
+		if f.hri {
+			label, err := r.text(data, max(f.fontH, zplDefaultFontHeight))
+			if err != nil {
+				return err
+			}
+			withText := NewBitmap(max(bars.Width, label.Width), bars.Height+label.Height+2)
+			textX := alignOffset(label.Width, withText.Width, AlignCenter)
+			if f.hriAbove {
+				withText.Draw(label, textX, 0)
+				withText.Draw(bars, 0, label.Height+2)
+			} else {
+				withText.Draw(bars, 0, 0)
+				withText.Draw(label, textX, bars.Height+2)
+			}
+			bars = withText
+		}
+		img = bars.Rotate(f.orient)
+	case "qrcode":
+		ecc, data := zplQRData(f.data)
+		code, err := qr.Encode(data, qrLevelName(ecc), qr.Auto)
+		if err != nil {
+			r.warn(offset, "^BQ", "%v", err)
+			return nil
+		}
+		img = scaleBitmap(barcodeBitmap(code, 1), f.magnify, f.magnify)
+	case "graphic":
+		img = f.graphic
+	}
+	y := f.y
+	if f.bottom {
+		y -= img.Height
+	}
+	r.ops = append(r.ops, zplOp{img: img, x: f.x, y: y, reverse: f.reverse, white: f.whiteDraw})
+	return nil
+}
+
+// text draws one line at a height in dots.
+func (r *zplRenderer) text(s string, height int) (*Bitmap, error) {
+	height = clampInt(height, 6, 1000)
+	fs, ok := r.fonts[height]
+	if !ok {
+		var err error
+		if r.opts.Fonts != nil {
+			fs, err = r.opts.Fonts.Sized(float64(height))
+		} else {
+			fs, err = LoadFontSet(float64(height))
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.fonts[height] = fs
+	}
+	return fs.RenderLine(s, 0, AlignLeft), nil
+}
+
+// finishFormat composes the fields of the current format into a label.
+func (r *zplRenderer) finishFormat() error {
+	if r.field.kind != "" || r.field.data != "" {
+		if err := r.finishField(-1); err != nil {
+			return err
+		}
+	}
+	width := r.width
+	if width <= 0 {
+		width = r.opts.WidthDots
+	}
+	height := r.length
+	if height <= 0 {
+		height = r.opts.HeightDots
+	}
+	if height <= 0 {
+		for _, op := range r.ops {
+			height = max(height, op.y+op.img.Height)
+		}
+		height = min(height, zplMaxLength)
+	}
+	out := NewBitmap(clampInt(width, 1, r.maxWidth()), max(height, 1))
+	for _, op := range r.ops {
+		for sy := 0; sy < op.img.Height; sy++ {
+			for sx := 0; sx < op.img.Width; sx++ {
+				if !op.img.At(sx, sy) {
+					continue
+				}
+				x, y := op.x+sx, op.y+sy
+				switch {
+				case op.reverse:
+					out.Set(x, y, !out.At(x, y))
+				case op.white:
+					out.Set(x, y, false)
+				default:
+					out.Set(x, y, true)
+				}
+			}
+		}
+	}
+	r.res.Labels = append(r.res.Labels, ZPLLabel{Bitmap: out, Copies: r.copies})
+	r.inFormat = false
+	r.ops = nil
+	return nil
+}
+
+// zplCode128Data strips ^BC subset invocation codes (">:" and friends);
+// ">>" is a literal '>'.
+func zplCode128Data(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '>' && i+1 < len(s) {
+			i++
+			if s[i] == '>' {
+				b.WriteByte('>')
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// zplQRData splits ^BQ field data ("QA,data": error correction, input
+// mode, data) into the ECC level and the data.
+func zplQRData(s string) (string, string) {
+	if len(s) >= 3 && s[2] == ',' && strings.ContainsRune("HQML", rune(s[0])) {
+		ecc, mode, data := string(s[0]), s[1], s[3:]
+		if mode == 'M' && len(data) > 0 {
+			// Manual mode names the character mode (N, A, B, K) first.
+			data = data[1:]
+		}
+		return ecc, data
+	}
+	return "Q", s
+}
+
+// zplGraphicField decodes ^GFa,b,c,d,data: ASCII hex with ZPL run-length
+// compression, or :Z64: / :B64: encoded binary. Rows are at most
+// maxRowBytes wide, and the image has only the rows the data holds.
+func zplGraphicField(params string, maxRowBytes int) (*Bitmap, error) {
+	p := strings.SplitN(params, ",", 5)
+	if len(p) < 5 {
+		return nil, fmt.Errorf("graphic field needs format, sizes and data")
+	}
+	total := zplInt(p[1], 0)
+	rowBytes := zplInt(p[3], 0)
+	if total <= 0 || rowBytes <= 0 {
+		return nil, fmt.Errorf("invalid graphic field size %q,%q", p[1], p[3])
+	}
+	if rowBytes > maxRowBytes || total > rowBytes*zplMaxLength {
+		return nil, fmt.Errorf("graphic field %d bytes of %d per row is larger than the label", total, rowBytes)
+	}
+	data := strings.Join(strings.Fields(p[4]), "")
+
+	var raw []byte
+	switch format := strings.ToUpper(strings.TrimSpace(p[0])); {
+	case format == "A" && (strings.HasPrefix(data, ":Z64:") || strings.HasPrefix(data, ":B64:")):
+		encoded := data[5:]
+		if i := strings.IndexByte(encoded, ':'); i >= 0 {
+			encoded = encoded[:i] // drop the CRC
+		}
+		dec, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("graphic field: %w", err)
+		}
+		if strings.HasPrefix(data, ":Z64:") {
+			zr, err := zlib.NewReader(bytes.NewReader(dec))
+			if err != nil {
+				return nil, fmt.Errorf("graphic field: %w", err)
+			}
+			if dec, err = io.ReadAll(io.LimitReader(zr, int64(total))); err != nil {
+				return nil, fmt.Errorf("graphic field: %w", err)
+			}
+		}
+		raw = dec
+	case format == "A":
+		h, err := zplExpandHex(data, rowBytes*2, total*2)
+		if err != nil {
+			return nil, err
+		}
+		if raw, err = hex.DecodeString(h); err != nil {
+			return nil, fmt.Errorf("graphic field: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("graphic field format %q is not supported (use A)", p[0])
+	}
+	raw = raw[:min(len(raw), total)]
+	return bitmapFromPacked(rowBytes, (len(raw)+rowBytes-1)/rowBytes, raw), nil
+}
+
+// zplExpandHex undoes ZPL's ASCII compression: G-Y repeat the next digit
+// 1-19 times and g-z 20-400 times, ',' fills the row with 0, '!' with F,
+// and ':' repeats the previous row. Expansion stops at limit digits.
+func zplExpandHex(data string, rowLen, limit int) (string, error) {
+	var out strings.Builder
+	var row []byte
+	prev := strings.Repeat("0", rowLen)
+	flush := func() {
+		for len(row) >= rowLen {
+			prev = string(row[:rowLen])
+			out.WriteString(prev)
+			row = row[rowLen:]
+		}
+	}
+	count := 0
+	for i := 0; i < len(data) && out.Len() < limit; i++ {
+		c := data[i]
+		switch {
+		case c >= 'G' && c <= 'Y':
+			count += int(c-'G') + 1
+		case c >= 'g' && c <= 'z':
+			count += (int(c-'g') + 1) * 20
+		case c == ',' || c == '!':
+			fill := byte('0')
+			if c == '!' {
+				fill = 'F'
+			}
+			for len(row) < rowLen {
+				row = append(row, fill)
+			}
+			flush()
+		case c == ':':
+			row = append(row, prev...)
+			flush()
+		case strings.IndexByte("0123456789ABCDEFabcdef", c) >= 0:
+			for n := min(max(count, 1), limit); n > 0; n-- {
+				row = append(row, c)
+			}
+			count = 0
+			flush()
+		default:
+			return "", fmt.Errorf("graphic field: invalid character %q", c)
+		}
+	}
+	if len(row) > 0 {
+		for len(row) < rowLen {
+			row = append(row, '0')
+		}
+		flush()
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,130 @@
+package printing
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"testing"
+)
+
+func TestRenderZPL(t *testing.T) {
+	src := "~SD15\n^XA^PW200^LL120^PQ3\n" +
+		"^FO10,10^A0N,20,20^FDBox 7^FS\n" +
+		"^FO0,40^GB200,4,4^FS\n" +
+		"^FO10,50^BY2^BCN,30,N^FD>:AB12^FS\n" +
+		"^FO150,50^BQN,2,2^FDQA,hi^FS\n" +
+		"^FO0,100^GFA,4,4,2,FF,:^FS\n" +
+		"^FO0,0^FR^GB8,8,8^FS\n" +
+		"^FB100,2^XZ"
+	res, err := RenderZPL(src, ZPLOptions{WidthDots: 384})
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if len(res.Labels) != 1 {
+		t.Fatalf("labels = %d, want 1", len(res.Labels))
+	}
+	l := res.Labels[0]
+	img := l.Bitmap
+	if img.Width != 200 || img.Height != 120 || l.Copies != 3 {
+		t.Fatalf("label %dx%d copies %d, want 200x120 copies 3", img.Width, img.Height, l.Copies)
+	}
+	// ~SD before ^XA and ^FB are reported, nothing else.
+	if len(res.Warnings) != 2 || res.Warnings[0].Command != "~SD" || res.Warnings[1].Command != "^FB" {
+		t.Fatalf("warnings = %+v", res.Warnings)
+	}
+
+	checks := []struct {
+		name  string
+		x, y  int
+		black bool
+	}{
+		{"rule", 100, 42, true},
+		{"below rule", 100, 46, false},
+		{"barcode quiet start", 9, 60, false},
+		{"graphic field row 1", 0, 100, true},
+		{"graphic field padding", 8, 100, false},
+		{"graphic field repeated row", 7, 101, true},
+		{"reverse field", 0, 0, true},
+	}
+	for _, c := range checks {
+		if img.At(c.x, c.y) != c.black {
+			t.Errorf("%s: dot (%d,%d) black = %v", c.name, c.x, c.y, !c.black)
+		}
+	}
+	dark := func(x0, y0, x1, y1 int) int {
+		n := 0
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				if img.At(x, y) {
+					n++
+				}
+			}
+		}
+		return n
+	}
+	if dark(10, 10, 80, 34) == 0 {
+		t.Error("text was not drawn")
+	}
+	if dark(10, 50, 140, 80) == 0 || dark(150, 50, 200, 100) == 0 {
+		t.Error("barcode or QR code was not drawn")
+	}
+}
+
+func TestZPLGraphicFieldEncodings(t *testing.T) {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write([]byte{0xF0, 0x0F})
+	zw.Close()
+
+	tests := []struct {
+		name   string
+		params string
+		want   []byte
+	}{
+		{"hex", "A,2,2,1,F0\n0F", []byte{0xF0, 0x0F}},
+		{"repeat counts", "A,4,4,4,I0FF,", []byte{0x00, 0x0F, 0xF0, 0x00}},
+		{"fill ones", "A,2,2,2,0!", []byte{0x0F, 0xFF}},
+		{"z64", "A,2,2,1,:Z64:" + base64.StdEncoding.EncodeToString(z.Bytes()) + ":abcd", []byte{0xF0, 0x0F}},
+		{"rows from data", "A,8000,8000,1,FF", []byte{0xFF}},
+		{"expansion stops at total", "A,2,2,1,zzzzzzzzF", []byte{0xFF, 0xFF}},
+		{"z64 read up to total", "A,1,1,1,:Z64:" + base64.StdEncoding.EncodeToString(z.Bytes()), []byte{0xF0}},
+	}
+	for _, tt := range tests {
+		img, err := zplGraphicField(tt.params, 48)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !bytes.Equal(img.Pix, tt.want) {
+			t.Errorf("%s: pix = % x, want % x", tt.name, img.Pix, tt.want)
+		}
+	}
+	if _, err := zplGraphicField("B,2,2,1,xx", 48); err == nil {
+		t.Error("expected binary graphic fields to be rejected")
+	}
+	if _, err := zplGraphicField("A,4900,4900,49,F", 48); err == nil {
+		t.Error("expected a graphic field wider than the head to be rejected")
+	}
+}
+
+func TestRenderZPLClampsSizes(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		w, h int
+	}{
+		{"box", "^XA^GB40000,40000,1^FS^XZ", 384, zplMaxLength},
+		{"box on a short label", "^XA^LL100^GB40000,40000,1^FS^XZ", 384, 100},
+		{"width and length", "^XA^PW99999^LL99999^FO0,0^GB10,10,1^FS^XZ", 384, zplMaxLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := RenderZPL(tt.src, ZPLOptions{WidthDots: 384})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if img := res.Labels[0].Bitmap; img.Width != tt.w || img.Height != tt.h {
+				t.Fatalf("label is %dx%d, want %dx%d", img.Width, img.Height, tt.w, tt.h)
+			}
+		})
+	}
+}
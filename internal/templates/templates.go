@@ -0,0 +1,180 @@
+package templates
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"ble-printer-bridge/internal/printing"
+)
+
+const fileExt = ".tmpl"
+
+var (
+	ErrNotFound    = errors.New("template not found")
+	ErrInvalidName = errors.New("invalid template name: use letters, digits, '-' and '_'")
+
+	validName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+// CompileError reports a template that failed to parse.
+type CompileError struct {
+	Name string
+	Err  error
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("template %q: %v", e.Name, e.Err)
+}
+
+func (e *CompileError) Unwrap() error { return e.Err }
+
+// Info describes a stored template.
+type Info struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// Store keeps receipt templates as <name>.tmpl files in a directory.
+type Store struct {
+	dir string
+	mu  sync.RWMutex
+}
+
+func NewStore(dir string) *Store {
+	return &Store{dir: dir}
+}
+
+func (s *Store) Dir() string { return s.dir }
+
+func (s *Store) List() ([]Info, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Info{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Info, 0, len(entries))
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), fileExt)
+		if e.IsDir() || name == e.Name() || !validName.MatchString(name) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		out = append(out, Info{Name: name, Size: fi.Size(), Modified: fi.ModTime()})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+func (s *Store) Get(name string) (string, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return "", err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	body, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
+
+// Save compiles body and, if it parses, writes it under name. Compile
+// failures are returned as *CompileError and leave any existing template
+// untouched.
+func (s *Store) Save(name, body string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if _, err := compile(name, body, printing.Layout{Width: 32}, nil, nil); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(body), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *Store) Delete(name string) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := os.Remove(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Render executes the named template with data. With a driver the output
+// contains that driver's styling commands and logo prints stored logos;
+// with a nil driver it is plain text suitable for previews.
+func (s *Store) Render(name string, data any, l printing.Layout, d printing.Driver, logo printing.LogoFunc) (string, error) {
+	body, err := s.Get(name)
+	if err != nil {
+		return "", err
+	}
+	return RenderString(name, body, data, l, d, logo)
+}
+
+// RenderString compiles and executes a template body without storing it.
+func RenderString(name, body string, data any, l printing.Layout, d printing.Driver, logo printing.LogoFunc) (string, error) {
+	t, err := compile(name, body, l, d, logo)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := t.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+func compile(name, body string, l printing.Layout, d printing.Driver, logo printing.LogoFunc) (*template.Template, error) {
+	t, err := template.New(name).Option("missingkey=zero").Funcs(printing.TemplateFuncs(l, d, logo)).Parse(body)
+	if err != nil {
+		return nil, &CompileError{Name: name, Err: err}
+	}
+	return t, nil
+}
+
+func (s *Store) path(name string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", ErrInvalidName
+	}
+	return filepath.Join(s.dir, name+fileExt), nil
+}
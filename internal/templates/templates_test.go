@@ -0,0 +1,111 @@
+package templates
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"ble-printer-bridge/internal/printing"
+)
+
+func TestSaveRejectsCompileErrors(t *testing.T) {
+	s := NewStore(t.TempDir())
+	err := s.Save("broken", "{{ .total ")
+	var compileErr *CompileError
+	if !errors.As(err, &compileErr) {
+		t.Fatalf("expected CompileError, got %v", err)
+	}
+	if _, err := s.Get("broken"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("broken template should not be stored, got %v", err)
+	}
+	if err := s.Save("../escape", "x"); !errors.Is(err, ErrInvalidName) {
+		t.Fatalf("expected ErrInvalidName, got %v", err)
+	}
+}
+
+func TestRenderPlainAndStyled(t *testing.T) {
+	s := NewStore(t.TempDir())
+	body := `{{center 0 (bold .store)}}
+{{row "*,8r" "Coffee" (money .price "$")}}
+{{divider}}`
+	if err := s.Save("receipt", body); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	list, err := s.List()
+	if err != nil || len(list) != 1 || list[0].Name != "receipt" {
+		t.Fatalf("List = %v, %v", list, err)
+	}
+
+	data := map[string]any{"store": "Cafe", "price": 3.5}
+	layout := printing.Layout{Width: 20}
+	plain, err := s.Render("receipt", data, layout, nil, nil)
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	want := "        Cafe        \nCoffee         $3.50\n--------------------"
+	if plain != want {
+		t.Fatalf("plain render = %q, want %q", plain, want)
+	}
+
+	styled, err := s.Render("receipt", data, layout, printing.ESCPOSDriver{}, nil)
+	if err != nil {
+		t.Fatalf("render styled: %v", err)
+	}
+	if !strings.Contains(styled, "\x1bE\x01Cafe\x1bE\x00") {
+		t.Fatalf("styled render missing bold sequence: %q", styled)
+	}
+
+	if err := s.Delete("receipt"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err := s.Delete("receipt"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("second delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestRenderPadsStyledText(t *testing.T) {
+	layout := printing.Layout{Width: 20}
+	data := map[string]any{"store": "Cafe", "item": "Espresso doppio", "price": 3.5}
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "center",
+			body: `{{center 0 (bold .store)}}`,
+			want: "        \x1bE\x01Cafe\x1bE\x00        ",
+		},
+		{
+			name: "right",
+			body: `{{right 10 (underline .store)}}`,
+			want: "      \x1b-\x01Cafe\x1b-\x00",
+		},
+		{
+			name: "row",
+			body: `{{row "*,8r" (bold "Coffee") (invert (money .price "$"))}}`,
+			want: "\x1bE\x01Coffee\x1bE\x00         \x1dB\x01$3.50\x1dB\x00",
+		},
+		{
+			name: "wrapped row",
+			body: `{{row "10,*r" (bold .item) (money .price)}}`,
+			want: "\x1bE\x01Espresso\x1bE\x00        3.50\n\x1bE\x01doppio\x1bE\x00",
+		},
+		{
+			name: "nested styles",
+			body: `{{row "*,6r" (bold (underline "Tea")) "1.00"}}`,
+			want: "\x1bE\x01\x1b-\x01Tea\x1b-\x00\x1bE\x00             1.00",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RenderString(tt.name, tt.body, data, layout, printing.ESCPOSDriver{}, nil)
+			if err != nil {
+				t.Fatalf("render: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("render = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
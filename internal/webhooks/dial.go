@@ -0,0 +1,92 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrBlockedHost is returned for URLs whose host is on a loopback,
+// private or link-local network and not in Options.AllowHosts.
+var ErrBlockedHost = errors.New("host is on a loopback, private or link-local network")
+
+// CheckURL accepts absolute http and https URLs whose host, when it is an
+// IP address, is a public one or is listed in allowHosts. Names are checked
+// when a delivery connects, against the addresses they resolve to then.
+func CheckURL(raw string, allowHosts []string) error {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callback URL %q: want an http or https URL", raw)
+	}
+	if allowed(u.Host, allowHosts) {
+		return nil
+	}
+	if ip, err := netip.ParseAddr(strings.Trim(u.Hostname(), "[]")); err == nil && blocked(ip) {
+		return fmt.Errorf("callback URL %q: %w", raw, ErrBlockedHost)
+	}
+	return nil
+}
+
+// allowed reports whether hostport ("host:port") matches an entry of
+// allowHosts, given as a host or as host:port.
+func allowed(hostport string, allowHosts []string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	return slices.ContainsFunc(allowHosts, func(a string) bool {
+		return strings.EqualFold(a, hostport) || strings.EqualFold(strings.Trim(a, "[]"), host)
+	})
+}
+
+// blocked reports whether ip is one an endpoint outside the bridge's own
+// networks cannot have.
+func blocked(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// newClient returns the client deliveries are posted with: it connects
+// only to public addresses, or to the hosts in allowHosts, resolving names
+// itself so that the address checked is the one dialled, and does not
+// follow redirects.
+func newClient(timeout time.Duration, allowHosts []string) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				if allowed(addr, allowHosts) {
+					return dialer.DialContext(ctx, network, addr)
+				}
+				host, port, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+				ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+				if err != nil {
+					return nil, err
+				}
+				for _, ip := range ips {
+					if !blocked(ip) {
+						return dialer.DialContext(ctx, network, net.JoinHostPort(ip.Unmap().String(), port))
+					}
+				}
+				return nil, fmt.Errorf("%s: %w", host, ErrBlockedHost)
+			},
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConnsPerHost: 2,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
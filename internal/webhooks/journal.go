@@ -0,0 +1,76 @@
+package webhooks
+
+import (
+	"sort"
+
+	"ble-printer-bridge/internal/jsonl"
+)
+
+const journalFile = "outbox.jsonl"
+
+// journal is the outbox's jsonl.Log of deliveries, each record replacing
+// the previous record of its delivery.
+type journal struct {
+	*jsonl.Log
+}
+
+// record is one journal line: a delivery, or the ID of one to forget.
+type record struct {
+	Delivery *Delivery `json:"delivery,omitempty"`
+	Forget   string    `json:"forget,omitempty"`
+}
+
+// openJournal replays the journal in dir. The caller rewrites it once it
+// has dropped what it no longer needs.
+func openJournal(dir string) (*journal, []*Delivery, error) {
+	log, err := jsonl.Open(dir, journalFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	j := &journal{log}
+	deliveries, err := j.replay()
+	if err != nil {
+		return nil, nil, err
+	}
+	return j, deliveries, nil
+}
+
+func (j *journal) replay() ([]*Delivery, error) {
+	byID := map[string]*Delivery{}
+	err := jsonl.Replay(j.Log, func(rec record) {
+		switch {
+		case rec.Forget != "":
+			delete(byID, rec.Forget)
+		case rec.Delivery != nil:
+			byID[rec.Delivery.ID] = rec.Delivery
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*Delivery, 0, len(byID))
+	for _, d := range byID {
+		out = append(out, d)
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].CreatedAt.Before(out[b].CreatedAt) })
+	return out, nil
+}
+
+// put records d.
+func (j *journal) put(d *Delivery) error {
+	return j.Append(record{Delivery: d})
+}
+
+// forget records that the delivery with id is gone.
+func (j *journal) forget(id string) error {
+	return j.Append(record{Forget: id})
+}
+
+// rewrite replaces the journal with one record per delivery.
+func (j *journal) rewrite(deliveries []*Delivery) error {
+	recs := make([]any, len(deliveries))
+	for i, d := range deliveries {
+		recs[i] = record{Delivery: d}
+	}
+	return j.Rewrite(recs)
+}
@@ -0,0 +1,515 @@
+// Package webhooks posts the bridge's events to HTTP endpoints as signed
+// JSON. Deliveries wait in an outbox, journaled on disk when it is opened
+// on a directory, and are retried with exponential backoff until the
+// endpoint accepts them or they run out of attempts.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	JobDone             = "job.done"
+	JobFailed           = "job.failed"
+	JobCancelled        = "job.cancelled"
+	PrinterConnected    = "printer.connected"
+	PrinterDisconnected = "printer.disconnected"
+	PrinterPaperOut     = "printer.paper_out"
+)
+
+// Types lists the event types the bridge sends.
+var Types = []string{JobDone, JobFailed, JobCancelled, PrinterConnected, PrinterDisconnected, PrinterPaperOut}
+
+// State is where a delivery is in its life.
+type State string
+
+const (
+	Pending   State = "pending"
+	Delivered State = "delivered"
+	// Failed deliveries ran out of attempts; Retry sends them again.
+	Failed State = "failed"
+)
+
+var (
+	ErrNotFound = errors.New("delivery not found")
+	ErrState    = errors.New("delivery cannot be retried in its state")
+)
+
+// Event is what happened, posted as the request body.
+type Event struct {
+	ID   string          `json:"id"`
+	Type string          `json:"type"`
+	At   time.Time       `json:"at"`
+	Data json.RawMessage `json:"data"`
+}
+
+// Delivery is one event for one URL, with every attempt to post it.
+type Delivery struct {
+	ID       string    `json:"id"`
+	URL      string    `json:"url"`
+	Event    Event     `json:"event"`
+	State    State     `json:"state"`
+	Attempts []Attempt `json:"attempts"`
+	// NextAt is when a pending delivery is next tried.
+	NextAt     *time.Time `json:"next_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Attempt is one POST of a delivery: the endpoint's status, or the error
+// that kept it from answering.
+type Attempt struct {
+	At       time.Time `json:"at"`
+	Status   int       `json:"status,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Duration string    `json:"duration"`
+}
+
+// Options tune an Outbox.
+type Options struct {
+	// Secret signs every delivery; without one deliveries are unsigned.
+	Secret string
+	// MaxAttempts is how often a delivery is tried before it fails.
+	MaxAttempts int
+	// RetryDelay is the wait after the first failed attempt; it doubles
+	// with each further one, up to MaxRetryDelay.
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	// Timeout bounds one attempt.
+	Timeout time.Duration
+	// Concurrency is how many deliveries are posted at once. Each URL gets
+	// one at a time, in the order they are due, so a slow endpoint only
+	// holds up its own deliveries.
+	Concurrency int
+	// KeepFinished is how many delivered and failed deliveries are kept
+	// for inspection.
+	KeepFinished int
+	// AllowHosts lists hosts, as host or host:port, that may be posted to
+	// although they are on a loopback, private or link-local network.
+	AllowHosts []string
+	// Client posts the deliveries; nil uses a client with Timeout that
+	// only connects to public addresses and AllowHosts and does not
+	// follow redirects.
+	Client *http.Client
+	// Logf reports failed attempts and journal write failures.
+	Logf func(format string, args ...any)
+}
+
+// Outbox holds the deliveries and posts them in the order they are due,
+// up to Concurrency at once and one at a time per URL.
+type Outbox struct {
+	opts    Options
+	journal *journal
+	wake    chan struct{}
+	stop    chan struct{}
+
+	mu         sync.Mutex
+	deliveries map[string]*Delivery
+	finished   []string
+	// posting holds the URLs with a delivery being posted.
+	posting map[string]bool
+	closed  bool
+}
+
+// New returns an outbox that keeps its deliveries in memory.
+func New(opts Options) *Outbox {
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = time.Second
+	}
+	if opts.MaxRetryDelay < opts.RetryDelay {
+		opts.MaxRetryDelay = opts.RetryDelay
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	if opts.KeepFinished < 1 {
+		opts.KeepFinished = 200
+	}
+	if opts.Concurrency < 1 {
+		opts.Concurrency = 4
+	}
+	if opts.Client == nil {
+		opts.Client = newClient(opts.Timeout, opts.AllowHosts)
+	}
+	if opts.Logf == nil {
+		opts.Logf = func(string, ...any) {}
+	}
+	o := &Outbox{
+		opts:       opts,
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		deliveries: map[string]*Delivery{},
+		posting:    map[string]bool{},
+	}
+	go o.run()
+	return o
+}
+
+// Open returns an outbox journaled in dir, with the deliveries it held when
+// the bridge last stopped; pending ones are sent again.
+func Open(dir string, opts Options) (*Outbox, error) {
+	j, deliveries, err := openJournal(dir)
+	if err != nil {
+		return nil, fmt.Errorf("webhook outbox: %w", err)
+	}
+	o := New(opts)
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.journal = j
+	var finished []*Delivery
+	for _, d := range deliveries {
+		o.deliveries[d.ID] = d
+		if d.State != Pending {
+			finished = append(finished, d)
+		}
+	}
+	sort.Slice(finished, func(a, b int) bool { return finished[a].FinishedAt.Before(*finished[b].FinishedAt) })
+	for _, d := range finished {
+		o.finished = append(o.finished, d.ID)
+	}
+	o.prune()
+	if err := o.rewrite(); err != nil {
+		o.closed = true
+		close(o.stop)
+		return nil, fmt.Errorf("webhook outbox: %w", err)
+	}
+	wakeUp(o.wake)
+	return o, nil
+}
+
+// Close stops sending and closes the journal. Pending deliveries stay in
+// the journal for the next Open.
+func (o *Outbox) Close() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+	close(o.stop)
+	if o.journal == nil {
+		return nil
+	}
+	return o.journal.Close()
+}
+
+// Add queues an event of type typ carrying data, as JSON, for url.
+func (o *Outbox) Add(url, typ string, data any) (Delivery, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Delivery{}, err
+	}
+	now := time.Now()
+	d := &Delivery{
+		ID:        newID(),
+		URL:       url,
+		Event:     Event{ID: newID(), Type: typ, At: now, Data: raw},
+		State:     Pending,
+		Attempts:  []Attempt{},
+		NextAt:    &now,
+		CreatedAt: now,
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.journal != nil {
+		if err := o.journal.put(d); err != nil {
+			return Delivery{}, fmt.Errorf("webhook outbox: %w", err)
+		}
+	}
+	o.deliveries[d.ID] = d
+	wakeUp(o.wake)
+	return *d, nil
+}
+
+// Get returns the delivery with the given id.
+func (o *Outbox) Get(id string) (Delivery, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	d, ok := o.deliveries[id]
+	if !ok {
+		return Delivery{}, ErrNotFound
+	}
+	return copyDelivery(d), nil
+}
+
+// List returns the deliveries in state, or all of them when state is
+// empty, oldest first; limit, when positive, keeps the most recent.
+func (o *Outbox) List(state State, limit int) []Delivery {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	out := []Delivery{}
+	for _, d := range o.deliveries {
+		if state == "" || d.State == state {
+			out = append(out, copyDelivery(d))
+		}
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].CreatedAt.Before(out[b].CreatedAt) })
+	if limit > 0 && len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out
+}
+
+// Retry sends a failed delivery again, with a fresh set of attempts; the
+// failed ones are dropped.
+func (o *Outbox) Retry(id string) (Delivery, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	d, ok := o.deliveries[id]
+	if !ok {
+		return Delivery{}, ErrNotFound
+	}
+	if d.State != Failed {
+		return copyDelivery(d), fmt.Errorf("%w: %s", ErrState, d.State)
+	}
+	now := time.Now()
+	d.State = Pending
+	d.NextAt = &now
+	d.FinishedAt = nil
+	d.Attempts = []Attempt{}
+	if i := slices.Index(o.finished, id); i >= 0 {
+		o.finished = slices.Delete(o.finished, i, i+1)
+	}
+	o.persist(d)
+	wakeUp(o.wake)
+	return copyDelivery(d), nil
+}
+
+// run starts posting deliveries as they come due until the outbox is
+// closed.
+func (o *Outbox) run() {
+	for {
+		o.mu.Lock()
+		var d *Delivery
+		var wait time.Duration
+		if len(o.posting) < o.opts.Concurrency {
+			d, wait = o.due()
+		}
+		if d != nil {
+			o.posting[d.URL] = true
+			go o.deliver(d, copyDelivery(d))
+		}
+		o.mu.Unlock()
+		if d != nil {
+			continue
+		}
+		timer := time.NewTimer(wait)
+		if wait == 0 {
+			timer.Stop()
+		}
+		select {
+		case <-o.stop:
+			timer.Stop()
+			return
+		case <-o.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// deliver posts one attempt of d, a copy of which is next, and records it.
+func (o *Outbox) deliver(d *Delivery, next Delivery) {
+	attempt := o.post(next)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.posting, d.URL)
+	if o.closed {
+		return
+	}
+	o.record(d, attempt)
+	wakeUp(o.wake)
+}
+
+// due returns the pending delivery that has been due longest among those
+// for URLs not being posted to, or how long until the next one is; zero
+// when there is none. o.mu must be held.
+func (o *Outbox) due() (*Delivery, time.Duration) {
+	now := time.Now()
+	var best *Delivery
+	var wait time.Duration
+	for _, d := range o.deliveries {
+		if d.State != Pending || o.posting[d.URL] {
+			continue
+		}
+		if until := d.NextAt.Sub(now); until > 0 {
+			if wait == 0 || until < wait {
+				wait = until
+			}
+			continue
+		}
+		if best == nil || d.NextAt.Before(*best.NextAt) {
+			best = d
+		}
+	}
+	return best, wait
+}
+
+// post sends one attempt of d.
+func (o *Outbox) post(d Delivery) Attempt {
+	start := time.Now()
+	a := Attempt{At: start}
+	body, err := json.Marshal(d.Event)
+	if err == nil {
+		var req *http.Request
+		ctx, cancel := context.WithTimeout(context.Background(), o.opts.Timeout)
+		defer cancel()
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("content-type", "application/json")
+			req.Header.Set("user-agent", "ble-printer-bridge")
+			req.Header.Set("x-bridge-event", d.Event.Type)
+			req.Header.Set("x-bridge-delivery", d.ID)
+			if o.opts.Secret != "" {
+				req.Header.Set("x-bridge-signature", Sign(o.opts.Secret, start, body))
+			}
+			var resp *http.Response
+			if resp, err = o.opts.Client.Do(req); err == nil {
+				io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+				resp.Body.Close()
+				a.Status = resp.StatusCode
+				if resp.StatusCode < 200 || resp.StatusCode > 299 {
+					a.Error = resp.Status
+				}
+			}
+		}
+	}
+	if err != nil {
+		a.Error = err.Error()
+	}
+	a.Duration = time.Since(start).Round(time.Millisecond).String()
+	return a
+}
+
+// record adds an attempt to d and delivers, fails or reschedules it.
+// o.mu must be held.
+func (o *Outbox) record(d *Delivery, a Attempt) {
+	d.Attempts = append(d.Attempts, a)
+	switch {
+	case a.Error == "":
+		o.finish(d, Delivered)
+	case len(d.Attempts) >= o.opts.MaxAttempts:
+		o.opts.Logf("webhook %s to %s failed after %d attempts: %s", d.Event.Type, d.URL, len(d.Attempts), a.Error)
+		o.finish(d, Failed)
+	default:
+		next := time.Now().Add(o.backoff(len(d.Attempts)))
+		d.NextAt = &next
+		o.opts.Logf("webhook %s to %s: attempt %d: %s; retrying at %s", d.Event.Type, d.URL, len(d.Attempts), a.Error, next.Format(time.RFC3339))
+		o.persist(d)
+	}
+}
+
+// backoff is the wait after the n-th failed attempt.
+func (o *Outbox) backoff(n int) time.Duration {
+	delay := o.opts.RetryDelay
+	for i := 1; i < n && delay < o.opts.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, o.opts.MaxRetryDelay)
+}
+
+// finish records d's final state and forgets old finished deliveries.
+// o.mu must be held.
+func (o *Outbox) finish(d *Delivery, state State) {
+	now := time.Now()
+	d.State = state
+	d.NextAt = nil
+	d.FinishedAt = &now
+	o.persist(d)
+	o.finished = append(o.finished, d.ID)
+	o.prune()
+}
+
+// prune forgets the oldest finished deliveries beyond KeepFinished and
+// compacts the journal once it has grown. o.mu must be held.
+func (o *Outbox) prune() {
+	for len(o.finished) > o.opts.KeepFinished {
+		id := o.finished[0]
+		o.finished = o.finished[1:]
+		delete(o.deliveries, id)
+		if o.journal != nil {
+			if err := o.journal.forget(id); err != nil {
+				o.opts.Logf("webhook outbox: %v", err)
+			}
+		}
+	}
+	if o.journal == nil || !o.journal.Grown(len(o.deliveries)) {
+		return
+	}
+	if err := o.rewrite(); err != nil {
+		o.opts.Logf("webhook outbox: %v", err)
+	}
+}
+
+// rewrite compacts the journal to the current deliveries. o.mu must be
+// held.
+func (o *Outbox) rewrite() error {
+	all := make([]*Delivery, 0, len(o.deliveries))
+	for _, d := range o.deliveries {
+		all = append(all, d)
+	}
+	sort.Slice(all, func(a, b int) bool { return all[a].CreatedAt.Before(all[b].CreatedAt) })
+	return o.journal.rewrite(all)
+}
+
+// persist journals d's current state. o.mu must be held.
+func (o *Outbox) persist(d *Delivery) {
+	if o.journal == nil {
+		return
+	}
+	if err := o.journal.put(d); err != nil {
+		o.opts.Logf("webhook outbox: delivery=%s %v", d.ID, err)
+	}
+}
+
+// Sign returns the x-bridge-signature header of a body sent at t:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>">". A
+// receiver recomputes it with the shared secret and rejects old times.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	io.WriteString(mac, ts+".")
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// copyDelivery returns d with its own attempts, so it can be read while
+// the outbox adds to them.
+func copyDelivery(d *Delivery) Delivery {
+	c := *d
+	c.Attempts = append([]Attempt{}, d.Attempts...)
+	return c
+}
+
+func wakeUp(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+func newID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
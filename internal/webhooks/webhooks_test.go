@@ -0,0 +1,269 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// local lets the outboxes under test post to httptest servers.
+var local = []string{"127.0.0.1"}
+
+// waitFor polls the delivery until it leaves Pending.
+func waitFor(t *testing.T, o *Outbox, id string) Delivery {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		d, err := o.Get(id)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		if d.State != Pending {
+			return d
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("delivery %s still pending", id)
+	return Delivery{}
+}
+
+func TestOutboxSignsDeliveries(t *testing.T) {
+	type got struct {
+		header http.Header
+		body   []byte
+	}
+	received := make(chan got, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- got{r.Header.Clone(), body}
+	}))
+	defer srv.Close()
+
+	o := New(Options{Secret: "s3cret", AllowHosts: local})
+	defer o.Close()
+	d, err := o.Add(srv.URL, JobDone, map[string]any{"job_id": "abc"})
+	if err != nil {
+		t.Fatalf("add: %v", err)
+	}
+	if d := waitFor(t, o, d.ID); d.State != Delivered || len(d.Attempts) != 1 || d.Attempts[0].Status != 200 {
+		t.Fatalf("delivery = %+v", d)
+	}
+
+	req := <-received
+	var ev Event
+	if err := json.Unmarshal(req.body, &ev); err != nil || ev.Type != JobDone || string(ev.Data) != `{"job_id":"abc"}` {
+		t.Fatalf("body = %s, %v", req.body, err)
+	}
+	if req.header.Get("x-bridge-event") != JobDone || req.header.Get("x-bridge-delivery") != d.ID {
+		t.Fatalf("headers = %v", req.header)
+	}
+	sig := req.header.Get("x-bridge-signature")
+	ts, _, _ := strings.Cut(strings.TrimPrefix(sig, "t="), ",")
+	sec, _ := strconv.ParseInt(ts, 10, 64)
+	if want := Sign("s3cret", time.Unix(sec, 0), req.body); sig != want {
+		t.Fatalf("signature = %q, want %q", sig, want)
+	}
+}
+
+func TestOutboxRetries(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int32
+		wantState State
+		wantTries int
+	}{
+		{name: "delivered after failures", failures: 2, wantState: Delivered, wantTries: 3},
+		{name: "fails after max attempts", failures: 10, wantState: Failed, wantTries: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) <= tt.failures {
+					http.Error(w, "busy", http.StatusServiceUnavailable)
+				}
+			}))
+			defer srv.Close()
+
+			o := New(Options{MaxAttempts: 4, RetryDelay: time.Millisecond, MaxRetryDelay: 4 * time.Millisecond, AllowHosts: local})
+			defer o.Close()
+			d, _ := o.Add(srv.URL, PrinterConnected, nil)
+			d = waitFor(t, o, d.ID)
+			if d.State != tt.wantState || len(d.Attempts) != tt.wantTries {
+				t.Fatalf("state=%s attempts=%d, want %s after %d", d.State, len(d.Attempts), tt.wantState, tt.wantTries)
+			}
+			if d.Attempts[0].Status != http.StatusServiceUnavailable || d.Attempts[0].Error == "" {
+				t.Fatalf("first attempt = %+v", d.Attempts[0])
+			}
+		})
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	o := &Outbox{opts: Options{RetryDelay: time.Second, MaxRetryDelay: 5 * time.Second}}
+	for n, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second, 9: 5 * time.Second} {
+		if got := o.backoff(n); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestOutboxSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	opts := Options{MaxAttempts: 2, RetryDelay: time.Hour, AllowHosts: local}
+	o, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	pending, _ := o.Add(srv.URL, JobFailed, map[string]any{"job_id": "x"})
+	for {
+		if d, _ := o.Get(pending.ID); len(d.Attempts) == 1 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	o.Close()
+
+	// The retry an hour away is kept across the restart.
+	o, err = Open(dir, opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer o.Close()
+	d, err := o.Get(pending.ID)
+	if err != nil || d.State != Pending || d.Event.Type != JobFailed {
+		t.Fatalf("delivery after restart = %+v, %v", d, err)
+	}
+	if _, err := o.Retry(pending.ID); !errors.Is(err, ErrState) {
+		t.Fatalf("retry of a pending delivery = %v, want ErrState", err)
+	}
+	if list := o.List(Pending, 0); len(list) != 1 {
+		t.Fatalf("List(pending) = %+v", list)
+	}
+}
+
+func TestOutboxRetry(t *testing.T) {
+	var up atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			http.Error(w, "down", http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	o := New(Options{MaxAttempts: 1, AllowHosts: local})
+	defer o.Close()
+	d, _ := o.Add(srv.URL, JobDone, nil)
+	if d = waitFor(t, o, d.ID); d.State != Failed {
+		t.Fatalf("delivery = %+v", d)
+	}
+	up.Store(true)
+	if _, err := o.Retry(d.ID); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if d = waitFor(t, o, d.ID); d.State != Delivered || len(d.Attempts) != 1 {
+		t.Fatalf("retried delivery = %+v", d)
+	}
+	if _, err := o.Retry("missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("retry missing = %v, want ErrNotFound", err)
+	}
+}
+
+func TestOutboxRefusesPrivateHostsAndRedirects(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer srv.Close()
+
+	o := New(Options{MaxAttempts: 1})
+	defer o.Close()
+	d, _ := o.Add(srv.URL, JobDone, nil)
+	if d = waitFor(t, o, d.ID); d.State != Failed || !strings.Contains(d.Attempts[0].Error, ErrBlockedHost.Error()) || hits.Load() != 0 {
+		t.Fatalf("delivery to a loopback host = %+v, hits %d", d, hits.Load())
+	}
+
+	o = New(Options{MaxAttempts: 1, AllowHosts: local})
+	defer o.Close()
+	d, _ = o.Add(srv.URL, JobDone, nil)
+	if d = waitFor(t, o, d.ID); d.State != Failed || d.Attempts[0].Status != http.StatusFound || hits.Load() != 1 {
+		t.Fatalf("redirected delivery = %+v, hits %d", d, hits.Load())
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		allow   []string
+		wantErr bool
+	}{
+		{"https://hooks.example.com/x", nil, false},
+		{"https://93.184.216.34/x", nil, false},
+		{"ftp://example.com/x", nil, true},
+		{"/relative", nil, true},
+		{"http://127.0.0.1:8080/x", nil, true},
+		{"http://10.0.0.5/x", nil, true},
+		{"http://[::1]/x", nil, true},
+		{"http://169.254.169.254/latest", nil, true},
+		{"http://[::ffff:192.168.1.1]/x", nil, true},
+		{"http://127.0.0.1:8080/x", []string{"127.0.0.1"}, false},
+		{"http://10.0.0.5:9000/x", []string{"10.0.0.5:9000"}, false},
+		{"http://10.0.0.5:9001/x", []string{"10.0.0.5:9000"}, true},
+	}
+	for _, tt := range tests {
+		if err := CheckURL(tt.url, tt.allow); (err != nil) != tt.wantErr {
+			t.Errorf("CheckURL(%q, %v) = %v, want error %v", tt.url, tt.allow, err, tt.wantErr)
+		}
+	}
+}
+
+func TestOutboxSlowEndpointDoesNotHoldOthers(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	defer close(release)
+	var inFlight, most atomic.Int32
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+		}
+		time.Sleep(5 * time.Millisecond)
+	}))
+	defer fast.Close()
+
+	o := New(Options{AllowHosts: local, Timeout: 5 * time.Second})
+	defer o.Close()
+	stuck, _ := o.Add(slow.URL, JobDone, nil)
+	var ids []string
+	for range 5 {
+		d, _ := o.Add(fast.URL, JobDone, nil)
+		ids = append(ids, d.ID)
+	}
+	for _, id := range ids {
+		if d := waitFor(t, o, id); d.State != Delivered {
+			t.Fatalf("delivery = %+v", d)
+		}
+	}
+	if d, _ := o.Get(stuck.ID); d.State != Pending {
+		t.Fatalf("slow delivery = %+v, want still pending", d)
+	}
+	if most.Load() != 1 {
+		t.Fatalf("%d deliveries posted to one URL at once, want 1", most.Load())
+	}
+}